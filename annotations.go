@@ -0,0 +1,102 @@
+package monoscope
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// requestAnnotations collects what handlers attach to the request being
+// reported, such as data subjects, until its payload is built.
+type requestAnnotations struct {
+	mu           sync.Mutex
+	dataSubjects []string
+	cacheStats   map[string]CacheStats
+	segments     []SegmentTiming
+	stages       []StageTiming
+	shadow       *ShadowComparison
+	signature    *SignatureVerification
+	featureFlags map[string]string
+	experiments  map[string]string
+	forceSampled bool
+}
+
+var annotationsCtxKey = ctxKey("request-annotations")
+
+// ContextWithAnnotations returns a copy of ctx that collects the annotations
+// handlers attach with helpers such as TagDataSubject. Middlewares call it
+// once per request. Nested Monoscope middlewares share the annotations of the
+// outermost one, so both payloads carry them.
+func ContextWithAnnotations(ctx context.Context) context.Context {
+	if annotationsFromContext(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, annotationsCtxKey, &requestAnnotations{})
+}
+
+func annotationsFromContext(ctx context.Context) *requestAnnotations {
+	annotations, _ := ctx.Value(annotationsCtxKey).(*requestAnnotations)
+	return annotations
+}
+
+// ApplyAnnotations copies the annotations collected in ctx onto payload.
+// BuildPayload applies them from the request context; middlewares building
+// payloads without one, such as fiber's, call it themselves.
+func ApplyAnnotations(ctx context.Context, payload *Payload) {
+	payload.ForceSampled = forceSampled(ctx)
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if len(annotations.dataSubjects) > 0 {
+		payload.DataSubjects = slices.Clone(annotations.dataSubjects)
+	}
+	if len(annotations.cacheStats) > 0 {
+		payload.CacheStats = maps.Clone(annotations.cacheStats)
+	}
+	if len(annotations.segments) > 0 {
+		payload.Segments = slices.Clone(annotations.segments)
+	}
+	if len(annotations.stages) > 0 {
+		payload.Stages = slices.Clone(annotations.stages)
+	}
+	if len(annotations.featureFlags) > 0 {
+		payload.FeatureFlags = maps.Clone(annotations.featureFlags)
+	}
+	if len(annotations.experiments) > 0 {
+		payload.Experiments = maps.Clone(annotations.experiments)
+	}
+	if annotations.shadow != nil {
+		shadow := *annotations.shadow
+		shadow.Diffs = slices.Clone(shadow.Diffs)
+		payload.Shadow = &shadow
+	}
+	if annotations.signature != nil {
+		signature := *annotations.signature
+		payload.Signature = &signature
+		if !signature.Valid && !slices.Contains(payload.Tags, SignatureFailureTag) {
+			payload.Tags = append(slices.Clone(payload.Tags), SignatureFailureTag)
+		}
+	}
+}
+
+// TagDataSubject records that the request being handled in ctx concerns the
+// data subject identified by subjectID, such as a user or customer ID, so
+// right-to-be-forgotten workflows can find every payload captured about them.
+// A request may be tagged with several subjects; repeated IDs are recorded
+// once. It does nothing for contexts that didn't pass through a Monoscope
+// middleware.
+func TagDataSubject(ctx context.Context, subjectID string) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil || subjectID == "" {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if !slices.Contains(annotations.dataSubjects, subjectID) {
+		annotations.dataSubjects = append(annotations.dataSubjects, subjectID)
+	}
+}
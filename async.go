@@ -0,0 +1,53 @@
+package monoscope
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartLinkedSpan starts a new root span linked to the request span in ctx.
+// Use it for fire-and-forget goroutines and queued jobs spawned by a request,
+// so the work stays discoverable from the request without extending its trace.
+// The caller must end the returned span.
+func StartLinkedSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent := linkedSpan(ctx)
+	tp := otel.GetTracerProvider()
+	if parent.SpanContext().IsValid() {
+		tp = parent.TracerProvider()
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: parent.SpanContext()}))
+	}
+	if msgID, ok := MessageIDFrom(ctx); ok {
+		opts = append(opts, trace.WithAttributes(attribute.String("apitoolkit.parent_msg_id", msgID.String())))
+	}
+	opts = append(opts, trace.WithNewRoot())
+	return tp.Tracer("monoscope").Start(ctx, name, opts...)
+}
+
+// detachedSpanCtxKey holds the request span a detached context was created from.
+var detachedSpanCtxKey = ctxKey("detached-span")
+
+// DetachContext returns a copy of ctx for background work that outlives the
+// request: it is never cancelled, keeps the Monoscope message ID and other
+// values, but no longer carries the request span or its error list. Spans
+// started from it begin new traces, StartLinkedSpan links back to the request
+// span, and ReportError exports errors on their own span linked to the
+// request, since the request payload may already have been sent.
+func DetachContext(ctx context.Context) context.Context {
+	reqSpan := linkedSpan(ctx)
+	detached := context.WithoutCancel(ctx)
+	detached = context.WithValue(detached, detachedSpanCtxKey, reqSpan)
+	detached = WithErrorList(detached, nil)
+	return trace.ContextWithSpanContext(detached, trace.SpanContext{})
+}
+
+// linkedSpan returns the span background work in ctx should link to: the
+// request span of a detached context, or else the span active in ctx.
+func linkedSpan(ctx context.Context) trace.Span {
+	if span, ok := ctx.Value(detachedSpanCtxKey).(trace.Span); ok {
+		return span
+	}
+	return trace.SpanFromContext(ctx)
+}
@@ -0,0 +1,13 @@
+package monoscope
+
+// redactionAudit counts the values each redaction rule replaced in a payload,
+// keyed by "<target>:<rule>", and becomes Payload.Redactions. Writes to a nil
+// audit are discarded, for the exported helpers that don't audit.
+type redactionAudit map[string]int
+
+// fired records that rule replaced one value in target.
+func (a redactionAudit) fired(target, rule string) {
+	if a != nil {
+		a[target+":"+rule]++
+	}
+}
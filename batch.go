@@ -0,0 +1,178 @@
+package monoscope
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultBatchMaxRecords is the number of records per batch span when
+// BatchExport.MaxRecords is zero.
+const DefaultBatchMaxRecords = 1000
+
+// BatchExport configures batch mode for very high-throughput services: the
+// payloads of successful requests are reduced to BatchRecords and exported
+// together on a single monoscope.batch span per Interval, instead of a span
+// each. Failed requests (5xx, reported errors or panics), force-sampled ones
+// and a SampleRate fraction of the rest are still exported in full. The zero
+// value disables it.
+type BatchExport struct {
+	// Interval is how often batches are exported. Batch mode is enabled
+	// when it is positive.
+	Interval time.Duration
+	// MaxRecords caps the records per batch span; a full batch is exported
+	// early. DefaultBatchMaxRecords when zero.
+	MaxRecords int
+	// SampleRate is the fraction, from 0 to 1, of successful requests that
+	// are still exported as full spans.
+	SampleRate float64
+}
+
+// BatchRecord is the lightweight form of a payload exported in batch mode.
+type BatchRecord struct {
+	Method     string        `json:"method"`
+	Route      string        `json:"route"`
+	StatusCode int           `json:"status_code"`
+	Duration   time.Duration `json:"duration_ns"`
+	MsgID      string        `json:"msg_id"`
+}
+
+// ShouldExport decides whether a middleware exports payload on its request
+// span: it drops CORS preflights when config.DropPreflight is set, applies
+// config.PayloadHook and config.TenantBudget, then, in batch mode, adds the
+// payloads that needn't be exported in full to the current batch. duration
+// is how long the request took; it is also checked against config.SLOs, see
+// ApplySLOs. When it returns false the span must be marked with DropSpan,
+// then ended as usual, so NewDropFilter drops it along with its children.
+func ShouldExport(config Config, payload *Payload, duration time.Duration) bool {
+	if config.DropPreflight && payload.RequestType == RequestTypePreflight && !payload.ForceSampled {
+		return false
+	}
+	ApplySLOs(config, payload, duration)
+	if !ApplyPayloadHook(config, payload) {
+		return false
+	}
+	if !applyTenantBudget(config, payload) {
+		return false
+	}
+	if config.BatchExport.Interval <= 0 || exportInFull(config.BatchExport, *payload) {
+		return true
+	}
+	batcherFor(config).add(BatchRecord{
+		Method:     payload.Method,
+		Route:      payload.URLPath,
+		StatusCode: payload.StatusCode,
+		Duration:   duration,
+		MsgID:      payload.MsgID,
+	})
+	selfMetrics.payloadsBatched.Add(1)
+	return false
+}
+
+// exportInFull reports whether payload keeps its own span in batch mode:
+// failures, SLO violations and force-sampled requests always do.
+func exportInFull(batch BatchExport, payload Payload) bool {
+	return payload.StatusCode >= 500 || len(payload.Errors) > 0 || payload.Panic != nil ||
+		payload.ForceSampled || (payload.SLOViolated != nil && *payload.SLOViolated) ||
+		(batch.SampleRate > 0 && rand.Float64() < batch.SampleRate)
+}
+
+type batcherKey struct {
+	provider trace.TracerProvider
+	apiKey   string
+}
+
+// batchers holds a batcher per destination, so middlewares building a Config
+// per request share their batches.
+var batchers sync.Map
+
+func batcherFor(config Config) *batcher {
+	key := batcherKey{config.TracerProvider, config.APIKey}
+	if b, ok := batchers.Load(key); ok {
+		return b.(*batcher)
+	}
+	maxRecords := config.BatchExport.MaxRecords
+	if maxRecords <= 0 {
+		maxRecords = DefaultBatchMaxRecords
+	}
+	b, _ := batchers.LoadOrStore(key, &batcher{
+		tracer:     Tracer(config),
+		interval:   config.BatchExport.Interval,
+		maxRecords: maxRecords,
+	})
+	return b.(*batcher)
+}
+
+// batcher collects the records of one destination until they are exported.
+type batcher struct {
+	tracer     trace.Tracer
+	interval   time.Duration
+	maxRecords int
+
+	mu      sync.Mutex
+	records []BatchRecord
+	timer   *time.Timer
+}
+
+// add appends record to the batch, exporting it when full. The first record
+// of a batch schedules its export after the interval.
+func (b *batcher) add(record BatchRecord) {
+	b.mu.Lock()
+	b.records = append(b.records, record)
+	if len(b.records) < b.maxRecords {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, b.flush)
+		}
+		b.mu.Unlock()
+		return
+	}
+	records := b.take()
+	b.mu.Unlock()
+	b.export(records)
+}
+
+// take empties the batch, returning its records. b.mu must be held.
+func (b *batcher) take() []BatchRecord {
+	records := b.records
+	b.records = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return records
+}
+
+func (b *batcher) flush() {
+	b.mu.Lock()
+	records := b.take()
+	b.mu.Unlock()
+	b.export(records)
+}
+
+// export records records on a monoscope.batch span.
+func (b *batcher) export(records []BatchRecord) {
+	if len(records) == 0 {
+		return
+	}
+	encoded, _ := json.Marshal(records)
+	_, span := b.tracer.Start(context.Background(), "monoscope.batch", trace.WithSpanKind(trace.SpanKindInternal))
+	span.SetAttributes(
+		attribute.Int("apitoolkit.batch.count", len(records)),
+		attribute.String("apitoolkit.batch.records", string(encoded)),
+	)
+	span.End()
+}
+
+// FlushBatches exports the pending batch-mode records right away. Call it
+// before shutting down the tracer provider so they aren't lost.
+func FlushBatches() {
+	batchers.Range(func(_, b any) bool {
+		b.(*batcher).flush()
+		return true
+	})
+}
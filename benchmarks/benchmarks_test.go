@@ -0,0 +1,271 @@
+package benchmarks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gorilla/mux"
+	"github.com/labstack/echo/v4"
+	"github.com/valyala/fasthttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	monoscopechi "github.com/monoscope-tech/monoscope-go/chi"
+	monoscopeecho "github.com/monoscope-tech/monoscope-go/echo"
+	monoscopefiber "github.com/monoscope-tech/monoscope-go/fiber"
+	monoscopegin "github.com/monoscope-tech/monoscope-go/gin"
+	monoscopegorilla "github.com/monoscope-tech/monoscope-go/gorilla"
+	monoscopenative "github.com/monoscope-tech/monoscope-go/native"
+)
+
+const (
+	requestBody  = `{"username":"jane","password":"hunter2","card":{"number":"4111111111111111","cvv":"123"},"items":[{"id":1,"secret":"a"},{"id":2,"secret":"b"}]}`
+	responseBody = `{"id":42,"token":"eyJhbGciOiJIUzI1NiJ9","user":{"email":"jane@example.com","ssn":"000-00-0000"}}`
+)
+
+// scenario describes a middleware configuration under benchmark.
+type scenario struct {
+	name    string
+	capture bool
+	redact  bool
+}
+
+var scenarios = []scenario{
+	{name: "capture-off"},
+	{name: "capture-on", capture: true},
+	{name: "redaction-heavy", capture: true, redact: true},
+}
+
+func (s scenario) redactHeaders() []string {
+	if !s.redact {
+		return nil
+	}
+	return []string{"X-Api-Key", "X-Session", "Cookie", "Set-Cookie"}
+}
+
+func (s scenario) redactRequestBody() []string {
+	if !s.redact {
+		return nil
+	}
+	return []string{"$.password", "$.card.number", "$.card.cvv", "$.items[*].secret"}
+}
+
+func (s scenario) redactResponseBody() []string {
+	if !s.redact {
+		return nil
+	}
+	return []string{"$.token", "$.user.email", "$.user.ssn"}
+}
+
+// target builds a function serving one request through a framework with the
+// Monoscope middleware configured for s. A nil scenario means no middleware.
+type target struct {
+	name  string
+	build func(s *scenario, tp trace.TracerProvider) func()
+}
+
+func newRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/users/42", bytes.NewBufferString(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "secret")
+	return req
+}
+
+func serve(h http.Handler) func() {
+	return func() {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest())
+	}
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(responseBody))
+}
+
+var targets = []target{
+	{"native", func(s *scenario, tp trace.TracerProvider) func() {
+		var h http.Handler = http.HandlerFunc(writeResponse)
+		if s != nil {
+			h = monoscopenative.Middleware(monoscopenative.Config{
+				TracerProvider: tp, CaptureRequestBody: s.capture, CaptureResponseBody: s.capture,
+				RedactHeaders: s.redactHeaders(), RedactRequestBody: s.redactRequestBody(), RedactResponseBody: s.redactResponseBody(),
+			})(h)
+		}
+		return serve(h)
+	}},
+	{"gorilla", func(s *scenario, tp trace.TracerProvider) func() {
+		router := mux.NewRouter()
+		if s != nil {
+			router.Use(monoscopegorilla.Middleware(monoscopegorilla.Config{
+				TracerProvider: tp, CaptureRequestBody: s.capture, CaptureResponseBody: s.capture,
+				RedactHeaders: s.redactHeaders(), RedactRequestBody: s.redactRequestBody(), RedactResponseBody: s.redactResponseBody(),
+			}))
+		}
+		router.HandleFunc("/users/{id}", writeResponse)
+		return serve(router)
+	}},
+	{"chi", func(s *scenario, tp trace.TracerProvider) func() {
+		router := chi.NewRouter()
+		if s != nil {
+			router.Use(monoscopechi.Middleware(monoscopechi.Config{
+				TracerProvider: tp, CaptureRequestBody: s.capture, CaptureResponseBody: s.capture,
+				RedactHeaders: s.redactHeaders(), RedactRequestBody: s.redactRequestBody(), RedactResponseBody: s.redactResponseBody(),
+			}))
+		}
+		router.Post("/users/{id}", writeResponse)
+		return serve(router)
+	}},
+	{"gin", func(s *scenario, tp trace.TracerProvider) func() {
+		router := gin.New()
+		if s != nil {
+			router.Use(monoscopegin.Middleware(monoscopegin.Config{
+				TracerProvider: tp, CaptureRequestBody: s.capture, CaptureResponseBody: s.capture,
+				RedactHeaders: s.redactHeaders(), RedactRequestBody: s.redactRequestBody(), RedactResponseBody: s.redactResponseBody(),
+			}))
+		}
+		router.POST("/users/:id", func(c *gin.Context) { writeResponse(c.Writer, c.Request) })
+		return serve(router)
+	}},
+	{"echo", func(s *scenario, tp trace.TracerProvider) func() {
+		e := echo.New()
+		if s != nil {
+			e.Use(monoscopeecho.Middleware(monoscopeecho.Config{
+				TracerProvider: tp, CaptureRequestBody: s.capture, CaptureResponseBody: s.capture,
+				RedactHeaders: s.redactHeaders(), RedactRequestBody: s.redactRequestBody(), RedactResponseBody: s.redactResponseBody(),
+			}))
+		}
+		e.POST("/users/:id", func(c echo.Context) error {
+			writeResponse(c.Response(), c.Request())
+			return nil
+		})
+		return serve(e)
+	}},
+	{"fiber", func(s *scenario, tp trace.TracerProvider) func() {
+		app := fiber.New()
+		if s != nil {
+			app.Use(monoscopefiber.Middleware(monoscopefiber.Config{
+				TracerProvider: tp, CaptureRequestBody: s.capture, CaptureResponseBody: s.capture,
+				RedactHeaders: s.redactHeaders(), RedactRequestBody: s.redactRequestBody(), RedactResponseBody: s.redactResponseBody(),
+			}))
+		}
+		app.Post("/users/:id", func(c *fiber.Ctx) error {
+			c.Set("Content-Type", "application/json")
+			return c.SendString(responseBody)
+		})
+		handler := app.Handler()
+		return func() {
+			var fctx fasthttp.RequestCtx
+			fctx.Request.Header.SetMethod(http.MethodPost)
+			fctx.Request.SetRequestURI("/users/42")
+			fctx.Request.Header.SetContentType("application/json")
+			fctx.Request.Header.Set("X-Api-Key", "secret")
+			fctx.Request.SetBodyString(requestBody)
+			handler(&fctx)
+		}
+	}},
+}
+
+func newTracerProvider() *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+}
+
+func BenchmarkMiddleware(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	tp := newTracerProvider()
+	defer tp.Shutdown(context.Background())
+
+	for _, tgt := range targets {
+		b.Run(tgt.name+"/baseline", func(b *testing.B) {
+			do := tgt.build(nil, tp)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				do()
+			}
+		})
+		for _, s := range scenarios {
+			s := s
+			b.Run(tgt.name+"/"+s.name, func(b *testing.B) {
+				do := tgt.build(&s, tp)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					do()
+				}
+			})
+		}
+	}
+}
+
+// allocBudgets is the maximum number of extra allocations per request the
+// middleware may add over the uninstrumented baseline, per scenario. They sit
+// well above current measurements (roughly 120-160 for capture off/on and 350
+// with heavy redaction) so they catch regressions rather than noise.
+var allocBudgets = map[string]int64{
+	"capture-off":     250,
+	"capture-on":      300,
+	"redaction-heavy": 550,
+}
+
+// TestOverheadBudget enforces allocBudgets for every framework integration.
+func TestOverheadBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping overhead budget in short mode")
+	}
+	if raceEnabled {
+		t.Skip("allocation counts are not representative under the race detector")
+	}
+	gin.SetMode(gin.ReleaseMode)
+	tp := newTracerProvider()
+	defer tp.Shutdown(context.Background())
+
+	allocs := func(do func()) int64 {
+		return int64(testing.AllocsPerRun(200, do))
+	}
+
+	for _, tgt := range targets {
+		baseline := allocs(tgt.build(nil, tp))
+		for _, s := range scenarios {
+			s := s
+			overhead := allocs(tgt.build(&s, tp)) - baseline
+			budget := allocBudgets[s.name]
+			t.Logf("%s/%s: %d extra allocs/op (budget %d)", tgt.name, s.name, overhead, budget)
+			if overhead > budget {
+				t.Errorf("%s/%s: %d extra allocs/op exceeds budget of %d", tgt.name, s.name, overhead, budget)
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load run in short mode")
+	}
+	tp := newTracerProvider()
+	defer tp.Shutdown(context.Background())
+
+	handler := monoscopenative.Middleware(monoscopenative.Config{TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true})(http.HandlerFunc(writeResponse))
+	result := Load(context.Background(), handler, LoadOptions{
+		Concurrency: 4,
+		Duration:    200 * time.Millisecond,
+		Method:      http.MethodPost,
+		Path:        "/users/42",
+		Body:        requestBody,
+	})
+	if result.Requests == 0 {
+		t.Fatal("Expected the load run to complete requests")
+	}
+	if result.Errors != 0 {
+		t.Errorf("Expected no request errors, got %d", result.Errors)
+	}
+	t.Logf("%d requests, %.0f req/s, p50=%s p99=%s", result.Requests, result.Throughput, result.P50, result.P99)
+}
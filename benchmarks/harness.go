@@ -0,0 +1,117 @@
+// Package benchmarks measures the per-request overhead of the Monoscope
+// middlewares. The Go benchmarks in this package compare each framework
+// integration against an uninstrumented baseline with body capture off, on,
+// and with heavy redaction, and TestOverheadBudget fails when allocations per
+// request regress past the budgets below. Load offers a small wrk-style
+// harness for measuring throughput and latency of a handler end to end.
+package benchmarks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadOptions configures a Load run.
+type LoadOptions struct {
+	// Concurrency is the number of connections issuing requests in parallel.
+	Concurrency int
+	// Duration is how long requests are issued for.
+	Duration time.Duration
+	// Method, Path and Body describe the request sent on every iteration.
+	Method string
+	Path   string
+	Body   string
+}
+
+// LoadResult summarizes a Load run.
+type LoadResult struct {
+	Requests   int
+	Errors     int
+	Throughput float64 // requests per second
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// Load serves handler on a local test server and drives it with
+// opts.Concurrency workers for opts.Duration, in the style of wrk.
+func Load(ctx context.Context, handler http.Handler, opts LoadOptions) LoadResult {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	transport := &http.Transport{MaxIdleConnsPerHost: opts.Concurrency}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		errCount  int
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			localErrs := 0
+			for ctx.Err() == nil {
+				req, _ := http.NewRequestWithContext(ctx, opts.Method, srv.URL+opts.Path, strings.NewReader(opts.Body))
+				began := time.Now()
+				resp, err := client.Do(req)
+				if err != nil {
+					if ctx.Err() == nil {
+						localErrs++
+					}
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				local = append(local, time.Since(began))
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			errCount += localErrs
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result := LoadResult{
+		Requests:   len(latencies),
+		Errors:     errCount,
+		Throughput: float64(len(latencies)) / elapsed.Seconds(),
+	}
+	if len(latencies) > 0 {
+		result.P50 = percentile(latencies, 0.50)
+		result.P90 = percentile(latencies, 0.90)
+		result.P99 = percentile(latencies, 0.99)
+		result.Max = latencies[len(latencies)-1]
+	}
+	return result
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
@@ -0,0 +1,142 @@
+package benchmarks
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/mux"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	monoscopechi "github.com/monoscope-tech/monoscope-go/chi"
+	monoscopeecho "github.com/monoscope-tech/monoscope-go/echo"
+	monoscopegin "github.com/monoscope-tech/monoscope-go/gin"
+	monoscopegorilla "github.com/monoscope-tech/monoscope-go/gorilla"
+	monoscopenative "github.com/monoscope-tech/monoscope-go/native"
+)
+
+var soakDuration = flag.Duration("soak", time.Second, "how long TestSoak drives each framework")
+
+// soakTargets serve the benchmark route through each framework whose
+// middleware supports DetectLeaks, with capture and redaction on.
+var soakTargets = []struct {
+	name    string
+	handler func(tp trace.TracerProvider, handle http.HandlerFunc) http.Handler
+}{
+	{"native", func(tp trace.TracerProvider, handle http.HandlerFunc) http.Handler {
+		return monoscopenative.Middleware(monoscopenative.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true, DetectLeaks: true,
+			RedactRequestBody: []string{"$.password"}, RedactResponseBody: []string{"$.token"},
+		})(handle)
+	}},
+	{"gorilla", func(tp trace.TracerProvider, handle http.HandlerFunc) http.Handler {
+		router := mux.NewRouter()
+		router.Use(monoscopegorilla.Middleware(monoscopegorilla.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true, DetectLeaks: true,
+			RedactRequestBody: []string{"$.password"}, RedactResponseBody: []string{"$.token"},
+		}))
+		router.HandleFunc("/users/{id}", handle)
+		return router
+	}},
+	{"chi", func(tp trace.TracerProvider, handle http.HandlerFunc) http.Handler {
+		router := chi.NewRouter()
+		router.Use(monoscopechi.Middleware(monoscopechi.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true, DetectLeaks: true,
+			RedactRequestBody: []string{"$.password"}, RedactResponseBody: []string{"$.token"},
+		}))
+		router.Post("/users/{id}", handle)
+		return router
+	}},
+	{"gin", func(tp trace.TracerProvider, handle http.HandlerFunc) http.Handler {
+		router := gin.New()
+		router.Use(monoscopegin.Middleware(monoscopegin.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true, DetectLeaks: true,
+			RedactRequestBody: []string{"$.password"}, RedactResponseBody: []string{"$.token"},
+		}))
+		router.POST("/users/:id", gin.WrapF(handle))
+		return router
+	}},
+	{"echo", func(tp trace.TracerProvider, handle http.HandlerFunc) http.Handler {
+		e := echo.New()
+		e.Use(monoscopeecho.Middleware(monoscopeecho.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true, DetectLeaks: true,
+			RedactRequestBody: []string{"$.password"}, RedactResponseBody: []string{"$.token"},
+		}))
+		e.POST("/users/:id", echo.WrapHandler(handle))
+		return e
+	}},
+}
+
+// collectLeaks runs enough GC cycles for every request that has ended to be
+// checked and returns the leaks found.
+func collectLeaks() []string {
+	for range 4 {
+		runtime.GC()
+	}
+	return apt.CheckLeaks()
+}
+
+// retained is where the leaky handler in TestSoak keeps request contexts.
+var retained []context.Context
+
+// TestSoak drives each framework under sustained load, which CI runs with
+// -race and a longer -soak, then checks that no request left its context or
+// capture buffers referenced and that no goroutines were left behind.
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping soak run in short mode")
+	}
+	gin.SetMode(gin.ReleaseMode)
+	tp := newTracerProvider()
+	defer tp.Shutdown(context.Background())
+	goroutines := runtime.NumGoroutine()
+
+	for _, tgt := range soakTargets {
+		t.Run(tgt.name, func(t *testing.T) {
+			result := Load(context.Background(), tgt.handler(tp, writeResponse), LoadOptions{
+				Concurrency: 8,
+				Duration:    *soakDuration,
+				Method:      http.MethodPost,
+				Path:        "/users/42",
+				Body:        requestBody,
+			})
+			if result.Requests == 0 || result.Errors != 0 {
+				t.Fatalf("Expected requests without errors, got %d requests and %d errors", result.Requests, result.Errors)
+			}
+			if leaks := collectLeaks(); len(leaks) > 0 {
+				t.Errorf("%d requests left %d values referenced, e.g. %s", result.Requests, len(leaks), leaks[0])
+			}
+		})
+	}
+
+	// A handler keeping its request context must be caught, or the checks
+	// above prove nothing.
+	leaky := soakTargets[1].handler(tp, func(w http.ResponseWriter, r *http.Request) {
+		retained = append(retained, r.Context())
+		writeResponse(w, r)
+	})
+	leaky.ServeHTTP(httptest.NewRecorder(), newRequest())
+	leaks := collectLeaks()
+	retained = nil
+	if len(leaks) != 1 || !strings.HasPrefix(leaks[0], "request context of POST /users/42 ") {
+		t.Errorf("Expected the retained request context to be reported, got %q", leaks)
+	}
+
+	// The leak sweeper's goroutine may still be waiting to exit.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines+1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines+1 {
+		t.Errorf("Expected goroutines to settle back to %d, got %d", goroutines, n)
+	}
+}
@@ -0,0 +1,70 @@
+package monoscope
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Binary body formats decoded to JSON for capture.
+const (
+	binaryFormatMsgpack = "msgpack"
+	binaryFormatCBOR    = "cbor"
+)
+
+// binaryBodyFormats are the binary body formats decoded to JSON for capture,
+// by media type.
+var binaryBodyFormats = map[string]string{
+	"application/msgpack":     binaryFormatMsgpack,
+	"application/x-msgpack":   binaryFormatMsgpack,
+	"application/vnd.msgpack": binaryFormatMsgpack,
+	"application/cbor":        binaryFormatCBOR,
+}
+
+// binaryBodyFormat returns the format of a MessagePack or CBOR body, going by
+// its Content-Type header, or "" for other bodies.
+func binaryBodyFormat(header map[string][]string) string {
+	mediaType, _, err := mime.ParseMediaType(http.Header(header).Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	if strings.HasSuffix(mediaType, "+cbor") {
+		mediaType = "application/cbor"
+	}
+	return binaryBodyFormats[mediaType]
+}
+
+// IsBinaryBodyContent reports whether the Content-Type header describes a
+// MessagePack or CBOR body, which is captured decoded to JSON.
+func IsBinaryBodyContent(header map[string][]string) bool {
+	return binaryBodyFormat(header) != ""
+}
+
+// decodeBinaryBody decodes a MessagePack or CBOR body, or the messages of a
+// gRPC, gRPC-Web or Connect body, see decodeFramedBody, to JSON, so it can be
+// redacted and exported like JSON bodies. Other bodies are returned as they
+// are. A body that fails to decode isn't captured: nil is returned with its
+// size, for a size-only capture.
+func decodeBinaryBody(body []byte, header map[string][]string) (decoded []byte, undecodedSize int) {
+	if framed, text, jsonMessages := framedBodyFormat(header); framed && len(body) > 0 {
+		decoded, err := decodeFramedBody(body, text, jsonMessages)
+		if err != nil {
+			return nil, len(body)
+		}
+		return decoded, 0
+	}
+	format := binaryBodyFormat(header)
+	if format == "" || len(body) == 0 {
+		return body, 0
+	}
+	value, err := decodeBinaryValue(body, format)
+	if err != nil {
+		return nil, len(body)
+	}
+	decoded, err = json.Marshal(value)
+	if err != nil {
+		return nil, len(body)
+	}
+	return decoded, 0
+}
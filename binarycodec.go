@@ -0,0 +1,37 @@
+//go:build !monoscope_core_only
+
+package monoscope
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// binaryCodecHandles are the codecs of the binary body formats.
+var binaryCodecHandles = map[string]codec.Handle{}
+
+func init() {
+	mapType := reflect.TypeOf(map[string]any(nil))
+	msgpack := &codec.MsgpackHandle{}
+	msgpack.RawToString = true
+	msgpack.MapType = mapType
+	cbor := &codec.CborHandle{}
+	cbor.MapType = mapType
+	binaryCodecHandles[binaryFormatMsgpack] = msgpack
+	binaryCodecHandles[binaryFormatCBOR] = cbor
+}
+
+// decodeBinaryValue decodes a whole MessagePack or CBOR body.
+func decodeBinaryValue(body []byte, format string) (any, error) {
+	var value any
+	dec := codec.NewDecoderBytes(body, binaryCodecHandles[format])
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.NumBytesRead() != len(body) {
+		return nil, errors.New("trailing data")
+	}
+	return value, nil
+}
@@ -0,0 +1,11 @@
+//go:build monoscope_core_only
+
+package monoscope
+
+import "errors"
+
+// decodeBinaryValue fails: core-only builds have no MessagePack or CBOR
+// codec, so these bodies are captured by size only.
+func decodeBinaryValue(body []byte, format string) (any, error) {
+	return nil, errors.New("monoscope: " + format + " decoding is not available in core-only builds")
+}
@@ -0,0 +1,95 @@
+package monoscope
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Cache is the lookup side of an in-process cache. ristretto's *Cache[K, V]
+// implements it as is; other caches, such as bigcache or a map guarded by a
+// mutex, can be adapted with CacheFunc.
+type Cache[K comparable, V any] interface {
+	Get(key K) (V, bool)
+}
+
+// CacheFunc adapts a lookup function to Cache, e.g. for bigcache:
+//
+//	monoscope.CacheFunc[string, []byte](func(key string) ([]byte, bool) {
+//		entry, err := bc.Get(key)
+//		return entry, err == nil
+//	})
+type CacheFunc[K comparable, V any] func(key K) (V, bool)
+
+// Get calls f(key).
+func (f CacheFunc[K, V]) Get(key K) (V, bool) {
+	return f(key)
+}
+
+// CacheStats counts the lookups of one cache made while handling a request.
+type CacheStats struct {
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
+
+// InstrumentedCache records the lookups of a Cache, see WrapCache.
+type InstrumentedCache[K comparable, V any] struct {
+	name  string
+	cache Cache[K, V]
+}
+
+// WrapCache instruments lookups in cache, identified as name in telemetry.
+// Writes are not instrumented and go to the cache directly.
+func WrapCache[K comparable, V any](name string, cache Cache[K, V]) *InstrumentedCache[K, V] {
+	return &InstrumentedCache[K, V]{name: name, cache: cache}
+}
+
+// Get looks key up, recording the lookup as a "monoscope.cache" child span of
+// the span in ctx, with its hit or miss and latency. Lookups made while
+// handling a request also count toward the cache's hit rate on its payload,
+// see Payload.CacheStats. Keys are not recorded.
+func (c *InstrumentedCache[K, V]) Get(ctx context.Context, key K) (V, bool) {
+	_, span := Tracer(configFromContext(ctx)).Start(ctx, "monoscope.cache", trace.WithSpanKind(trace.SpanKindInternal))
+	value, hit := c.cache.Get(key)
+	span.SetAttributes(
+		attribute.String("cache.name", c.name),
+		attribute.Bool("cache.hit", hit),
+	)
+	span.End()
+	recordCacheLookup(ctx, c.name, hit)
+	return value, hit
+}
+
+// recordCacheLookup counts a lookup in the annotations of ctx's request.
+func recordCacheLookup(ctx context.Context, name string, hit bool) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if annotations.cacheStats == nil {
+		annotations.cacheStats = map[string]CacheStats{}
+	}
+	stats := annotations.cacheStats[name]
+	if hit {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+	annotations.cacheStats[name] = stats
+}
+
+// cacheHitRate returns the share of lookups across stats that were hits.
+func cacheHitRate(stats map[string]CacheStats) float64 {
+	var hits, lookups int
+	for _, s := range stats {
+		hits += s.Hits
+		lookups += s.Hits + s.Misses
+	}
+	if lookups == 0 {
+		return 0
+	}
+	return float64(hits) / float64(lookups)
+}
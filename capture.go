@@ -0,0 +1,135 @@
+package monoscope
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxCaptureContentLength is the declared response size above which a body is
+// treated as a download and not captured, to avoid buffering large files.
+const MaxCaptureContentLength = 1 << 20
+
+// binaryContentTypes lists media types whose bodies are never useful to capture.
+var binaryContentTypes = []string{
+	"application/octet-stream",
+	"application/pdf",
+	"application/zip",
+	"application/gzip",
+	"application/x-tar",
+	"application/x-7z-compressed",
+	"application/vnd.ms-excel",
+	"application/wasm",
+}
+
+// binaryContentTypePrefixes lists top-level media types that are always binary.
+var binaryContentTypePrefixes = []string{"image/", "audio/", "video/", "font/"}
+
+// IsFileResponse reports whether the response headers describe a file download
+// or binary asset: an attachment Content-Disposition, a Content-Length above
+// MaxCaptureContentLength, or a binary Content-Type. Bodies of such responses
+// are skipped and only their metadata is recorded.
+func IsFileResponse(header map[string][]string) bool {
+	h := http.Header(header)
+	if disposition := h.Get("Content-Disposition"); disposition != "" {
+		if dispType, _, err := mime.ParseMediaType(disposition); err == nil && dispType == "attachment" {
+			return true
+		}
+	}
+	if contentLength := h.Get("Content-Length"); contentLength != "" {
+		if n, err := strconv.ParseInt(contentLength, 10, 64); err == nil && n > MaxCaptureContentLength {
+			return true
+		}
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range binaryContentTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	for _, prefix := range binaryContentTypePrefixes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cache validation outcomes recorded in Payload.CacheValidation.
+const (
+	// CacheValidationHit marks a conditional request answered with
+	// 304 Not Modified: the client's cached copy was still valid.
+	CacheValidationHit = "hit"
+	// CacheValidationMiss marks a conditional request that got a full
+	// response because its validators no longer matched.
+	CacheValidationMiss = "miss"
+)
+
+// ResponseHasBody reports whether a response with status to a method request
+// can carry a body. HEAD requests and 1xx, 204 and 304 responses never do, so
+// their bodies aren't captured whatever the handler wrote or declared.
+func ResponseHasBody(method string, status int) bool {
+	if method == http.MethodHead {
+		return false
+	}
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// cacheValidation returns the cache validation outcome of a request carrying
+// If-None-Match or If-Modified-Since, or an empty string for unconditional
+// requests and responses other than 304 and 2xx.
+func cacheValidation(reqHeader map[string][]string, status int) string {
+	h := http.Header(reqHeader)
+	if h.Get("If-None-Match") == "" && h.Get("If-Modified-Since") == "" {
+		return ""
+	}
+	switch {
+	case status == http.StatusNotModified:
+		return CacheValidationHit
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		return CacheValidationMiss
+	}
+	return ""
+}
+
+// ReadRequestBody reads up to MaxCaptureContentLength bytes of body for
+// capture, whatever the method, Content-Length or Transfer-Encoding. It
+// returns the captured bytes, a replacement body that replays them followed by
+// the rest of the stream, so handlers still see the whole body, and whether
+// the stream continued past the limit.
+func ReadRequestBody(body io.ReadCloser) (captured []byte, replay io.ReadCloser, incomplete bool, err error) {
+	if body == nil || body == http.NoBody {
+		return nil, body, false, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(body, MaxCaptureContentLength+1))
+	replay = replayBody{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}
+	if len(buf) > MaxCaptureContentLength {
+		return buf[:MaxCaptureContentLength], replay, true, err
+	}
+	return buf, replay, false, err
+}
+
+// TruncateBody limits an already buffered body to MaxCaptureContentLength,
+// reporting whether it was cut.
+func TruncateBody(body []byte) ([]byte, bool) {
+	if len(body) > MaxCaptureContentLength {
+		return body[:MaxCaptureContentLength], true
+	}
+	return body, false
+}
+
+// replayBody is a request body that reads from Reader and closes Closer.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
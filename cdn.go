@@ -0,0 +1,92 @@
+package monoscope
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CacheInfo is what a response's cache headers say about how it was cached:
+// its Cache-Control policy, its Age, and the CDN cache status from
+// CF-Cache-Status, X-Cache or X-Cache-Status, so hit ratios and stale
+// responses can be analyzed per route.
+type CacheInfo struct {
+	CacheControl string `json:"cache_control,omitempty"`
+	// MaxAgeSeconds is the shared s-maxage, or max-age, of Cache-Control.
+	MaxAgeSeconds *int64 `json:"max_age_seconds,omitempty"`
+	AgeSeconds    *int64 `json:"age_seconds,omitempty"`
+	// Status is the lower-cased CDN cache status, e.g. "hit", "miss" or
+	// "expired". X-Cache values such as "Hit from cloudfront" keep their
+	// first word.
+	Status string `json:"status,omitempty"`
+	// Stale is set when the response was older than its max age, or the CDN
+	// said it served a stale copy.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// parseCacheInfo reads the cache headers of a response, or returns nil when
+// it has none.
+func parseCacheInfo(header http.Header) *CacheInfo {
+	info := CacheInfo{CacheControl: header.Get("Cache-Control")}
+	info.MaxAgeSeconds = cacheControlMaxAge(info.CacheControl)
+	if age, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64); err == nil {
+		info.AgeSeconds = &age
+	}
+	for _, name := range []string{"CF-Cache-Status", "X-Cache", "X-Cache-Status"} {
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			status, _, _ := strings.Cut(value, ",")
+			status, _, _ = strings.Cut(strings.TrimSpace(status), " ")
+			info.Status = strings.ToLower(status)
+			break
+		}
+	}
+	if info == (CacheInfo{}) {
+		return nil
+	}
+	info.Stale = info.Status == "stale" || info.Status == "updating" ||
+		(info.AgeSeconds != nil && info.MaxAgeSeconds != nil && *info.AgeSeconds > *info.MaxAgeSeconds)
+	return &info
+}
+
+// cacheControlMaxAge returns the s-maxage directive of cacheControl, or its
+// max-age when there is none.
+func cacheControlMaxAge(cacheControl string) *int64 {
+	var maxAge *int64
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "s-maxage":
+			return &seconds
+		case "max-age":
+			maxAge = &seconds
+		}
+	}
+	return maxAge
+}
+
+// cacheInfoAttributes returns the attributes recording info.
+func cacheInfoAttributes(info *CacheInfo) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if info.CacheControl != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.cache.control", info.CacheControl))
+	}
+	if info.MaxAgeSeconds != nil {
+		attrs = append(attrs, attribute.Int64("apitoolkit.cache.max_age_seconds", *info.MaxAgeSeconds))
+	}
+	if info.AgeSeconds != nil {
+		attrs = append(attrs, attribute.Int64("apitoolkit.cache.age_seconds", *info.AgeSeconds))
+	}
+	if info.Status != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.cache.status", info.Status))
+	}
+	if info.Stale {
+		attrs = append(attrs, attribute.Bool("apitoolkit.cache.stale", true))
+	}
+	return attrs
+}
@@ -3,16 +3,16 @@ package monoscopechi
 import (
 	"bytes"
 	"context"
-	"io"
-	"log"
+	"crypto/rsa"
 	"net/http"
-	"net/http/httptest"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/honeycombio/otel-config-go/otelconfig"
 	apt "github.com/monoscope-tech/monoscope-go"
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -26,97 +26,468 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CaptureRequestHeaders and CaptureResponseHeaders, when set, limit the
+	// captured headers to the named ones (case-insensitive). Redaction still
+	// applies to the headers that are kept.
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+	// RouteTags adds tags to the requests of groups of endpoints, keyed by
+	// route template glob, e.g. {"/admin/**": {"admin"}}.
+	RouteTags map[string][]string
+	// SPAFallbackRoutes lists the route globs of catch-all routes serving a
+	// single-page app, reported as the single route "/*spa" without HTML,
+	// JavaScript and CSS bodies. SPAFallbackExclude exempts routes from it.
+	SPAFallbackRoutes  []string
+	SPAFallbackExclude []string
+	// RedactQueryParams lists query parameters whose values are redacted in
+	// the captured query parameters and raw URL.
+	RedactQueryParams []string
+	// TracerProvider is used to create Monoscope spans. When nil, the global
+	// provider from otel.GetTracerProvider is used.
+	TracerProvider trace.TracerProvider
+	// Propagators extracts the incoming trace context. When nil, the global
+	// propagator from otel.GetTextMapPropagator is used.
+	Propagators propagation.TextMapPropagator
+	// Now and NewMessageID replace time.Now and uuid.New, so tests and replay
+	// tooling can produce reproducible payloads.
+	Now          func() time.Time
+	NewMessageID func() uuid.UUID
+	// UseUUIDv7 generates time-ordered UUIDv7 message IDs instead of UUIDv4.
+	UseUUIDv7 bool
+	// ErrorDedupWindow collapses repeated reports of the same error within the
+	// window into the first one; the next report after it carries the count of
+	// suppressed occurrences. Zero reports every error.
+	ErrorDedupWindow time.Duration
+	// SemanticConventions additionally emits the standard OpenTelemetry HTTP
+	// attributes, so generic backends such as Tempo or Jaeger render the spans.
+	SemanticConventions bool
+	// MeterProvider, when set, receives request latencies in the
+	// http.server.request.duration histogram, with trace exemplars linking
+	// slow requests to their Monoscope traces.
+	MeterProvider metric.MeterProvider
+	// APIKey and Endpoint, when APIKey is set, report this middleware's spans
+	// to its own Monoscope project instead of the one configured globally, so
+	// one binary can host services belonging to different projects. Endpoint
+	// defaults to the Monoscope collector. Call monoscope.Shutdown on exit.
+	APIKey   string
+	Endpoint string
+	// PayloadHook, when set, is called with each payload before it is
+	// recorded, to modify it or, by returning nil, veto its export.
+	PayloadHook func(*apt.Payload) *apt.Payload
+	// StrictRedaction replaces bodies the redaction rules could not be applied
+	// to with apt.RedactionFailedMarker instead of exporting them as they are.
+	StrictRedaction bool
+	// BodyEncryptionKey, when set, encrypts captured bodies for this public
+	// key before they leave the process; see apt.EncryptedBody.
+	BodyEncryptionKey *rsa.PublicKey
+	// GeoResolver, when set, records the country, region and city of each
+	// request's client IP, see apt.GeoResolver.
+	GeoResolver apt.GeoResolver
+	// ProbesMetadataOnly captures requests classified as health probes
+	// without their bodies.
+	ProbesMetadataOnly bool
+	// PreflightMetadataOnly captures CORS preflight requests without their
+	// bodies, and DropPreflight doesn't report them at all.
+	PreflightMetadataOnly bool
+	DropPreflight         bool
+	// MaxAttributeValueLength and MaxAttributes bound the attributes each
+	// span gets, see apt.Config.MaxAttributeValueLength.
+	MaxAttributeValueLength int
+	MaxAttributes           int
+	// IdempotencyKeyHeader, e.g. "Idempotency-Key", links requests reusing
+	// an idempotency key within IdempotencyWindow as retries, see
+	// apt.Config.IdempotencyKeyHeader.
+	IdempotencyKeyHeader string
+	IdempotencyWindow    time.Duration
+	// DebugCaptureSecret lets requests with a signed apt.DebugCaptureHeader
+	// be captured in full, see apt.Config.DebugCaptureSecret.
+	DebugCaptureSecret []byte
+	// ReuseExistingSpan records payloads on the server span of otelhttp,
+	// otelmux or similar instrumentation already in place instead of
+	// nesting a second span, see apt.Config.ReuseExistingSpan.
+	ReuseExistingSpan bool
+	// PayloadSchemaVersion pins the payload schema exported, e.g.
+	// apt.PayloadSchemaV1 for older backends, see
+	// apt.Config.PayloadSchemaVersion.
+	PayloadSchemaVersion int
+	// BatchExport exports successful requests as records batched on one
+	// span per interval, see apt.BatchExport.
+	BatchExport apt.BatchExport
+	// HeartbeatInterval, when set, exports a heartbeat span linked to each
+	// request still running once per interval, see apt.StartHeartbeat.
+	HeartbeatInterval time.Duration
+	// DetectLeaks logs request contexts and capture buffers still referenced
+	// after their request ended, see apt.LeakCheck. Debug turns it on too.
+	DetectLeaks bool
+	// SLOs are the service level objectives each payload is checked against,
+	// see apt.SLO.
+	SLOs []apt.SLO
+	// NDJSONMaxLines is how many lines of newline-delimited JSON bodies are
+	// captured, apt.DefaultNDJSONMaxLines when zero.
+	NDJSONMaxLines int
+	// JWTClaims names the claims of the request's bearer JWT recorded on
+	// payloads, e.g. apt.DefaultJWTClaims. The token itself stays redacted.
+	JWTClaims []string
+	// TraceStateKeys names the members of the incoming tracestate, e.g. a
+	// vendor's sampling hints, recorded as attributes of their own.
+	TraceStateKeys []string
+	// IdentityFunc, when set, resolves the caller of each request, e.g. the
+	// customer and plan of its API key, recorded on its payload.
+	IdentityFunc func(*http.Request) apt.Identity
+	// TenantFunc, when set, returns the tenant of each request of a
+	// multi-tenant API, e.g. the organization of its API key, recorded on
+	// its payload and charged with TenantBudget.
+	TenantFunc func(*http.Request) string
+	// TenantBudget limits what each tenant's requests export per minute, so
+	// one noisy tenant can't use up the telemetry budget of the others.
+	TenantBudget apt.TenantBudget
+	// GRPCSystemCalls is how gRPC health checks and reflection calls to a
+	// gRPC server mounted behind the middleware are reported. By default
+	// they are skipped.
+	GRPCSystemCalls apt.GRPCSystemCalls
+	// ConsentFunc, when set, decides per request how much of it is captured,
+	// e.g. metadata only for EU traffic or users who haven't consented.
+	ConsentFunc func(*http.Request) apt.CaptureLevel
+	// HandlerTimeout, when set, gives handlers at most this long to respond.
+	// On expiry the client receives a 503 and the timeout is reported with the
+	// route and elapsed time. Responses are buffered until the handler returns,
+	// as with http.TimeoutHandler.
+	HandlerTimeout time.Duration
+	// ConcurrencyLimit, when MaxConcurrent is set, caps the handlers running
+	// at once. Requests queue for a slot for up to MaxQueueWait and are shed
+	// with a 503 after that; queue wait and shedding are recorded in the payload.
+	ConcurrencyLimit apt.ConcurrencyLimit
 }
 
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
+// TagDataSubject records that the request being handled in ctx concerns the
+// given data subject, see apt.TagDataSubject.
+func TagDataSubject(ctx context.Context, subjectID string) {
+	apt.TagDataSubject(ctx, subjectID)
+}
+
+// ForceSample marks the request being handled in ctx for full capture, see
+// apt.ForceSample.
+func ForceSample(ctx context.Context) {
+	apt.ForceSample(ctx)
+}
+
+// SetFeatureFlags records the flag variations active for the request being
+// handled in ctx, see apt.SetFeatureFlags.
+func SetFeatureFlags(ctx context.Context, flags map[string]string) {
+	apt.SetFeatureFlags(ctx, flags)
+}
+
+// SetExperiment records that the request being handled in ctx was assigned
+// variant of the A/B experiment name, see apt.SetExperiment.
+func SetExperiment(ctx context.Context, name, variant string) {
+	apt.SetExperiment(ctx, name, variant)
+}
+
+// Experiments returns a middleware recording the experiment assignments each
+// request carries in source's header or cookie, see apt.ExperimentSource.
+// Register it after Middleware.
+func Experiments(source apt.ExperimentSource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			apt.SetExperiments(req.Context(), apt.ExperimentAssignments(req, source))
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// Stage returns a middleware timing the layers registered after it as the
+// stage called name, e.g. "auth" or "rate_limit", so the payload shows which
+// layer of a deep chain is slow, see apt.EnterStage. Register stages after
+// Middleware.
+func Stage(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			exit := apt.EnterStage(req.Context(), name)
+			defer exit()
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
 func Middleware(config Config) func(http.Handler) http.Handler {
+	limiter := apt.NewConcurrencyLimiter(config.ConcurrencyLimit)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-			tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-			newCtx, span := tracer.Start(req.Context(), "monoscope.http", trace.WithSpanKind(trace.SpanKindServer))
-			defer span.End()
-			msgID := uuid.New()
-			newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
+			level := apt.CaptureFull
+			if config.ConsentFunc != nil {
+				level = config.ConsentFunc(req)
+			}
+			level = config.GRPCSystemCalls.Restrict(level, req.URL.Path)
+			if level == apt.CaptureNone {
+				next.ServeHTTP(res, req)
+				return
+			}
+			aptConfig := getAptConfig(config)
+			apt.ApplyCaptureLevel(&aptConfig, level)
+			start := apt.Now(aptConfig)
+			parentCtx := apt.ApplyDebugCapture(req.Context(), &aptConfig, req.Header.Get(apt.DebugCaptureHeader))
+			newCtx, span, ownSpan := apt.StartServerSpan(parentCtx, aptConfig, propagation.HeaderCarrier(req.Header))
+			// A reused span belongs to the instrumentation that started it. The span
+			// of a payload ShouldExport drops is marked with DropSpan before it ends.
+			defer func() {
+				if ownSpan {
+					span.End()
+				}
+			}()
+			// A message ID already in the context means another Monoscope layer
+			// (e.g. an HTTP middleware in front of an in-process gRPC-Gateway) is
+			// reporting this request, so this payload is recorded as its child.
+			parentID := apt.ParentMessageID(newCtx)
+			msgID := apt.NewMessageID(aptConfig)
+			newCtx = apt.WithMessageID(newCtx, msgID)
+			newCtx = apt.ContextWithConfig(newCtx, aptConfig)
+			newCtx = apt.ContextWithAnnotations(newCtx)
 			errorList := []apt.ATError{}
-			newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
+			newCtx = apt.WithErrorList(newCtx, &errorList)
+			newCtx, leaks := apt.StartLeakCheck(newCtx, aptConfig, req.Method+" "+req.URL.Path)
+			defer leaks.End()
 			req = req.WithContext(newCtx)
 
-			reqBuf, _ := io.ReadAll(req.Body)
-			req.Body.Close()
-			req.Body = io.NopCloser(bytes.NewBuffer(reqBuf))
+			reqBuf, body, reqIncomplete, _ := apt.ReadRequestBody(req.Body)
+			req.Body = body
+			span.AddEvent(apt.EventRequestBodyRead)
 
-			rec := httptest.NewRecorder()
-			next.ServeHTTP(rec, req)
-			recRes := rec.Result()
-			for k, v := range recRes.Header {
-				for _, vv := range v {
-					res.Header().Add(k, vv)
-				}
+			// Without body capture, handlers get a status-only writer with the
+			// same optional interfaces as res.
+			status := &apt.StatusRecorder{ResponseWriter: res, Span: span, Debug: aptConfig.Debug}
+			w := status.Wrap()
+			var respBuf *bytes.Buffer
+			if aptConfig.CaptureResponseBody {
+				respBuf = &bytes.Buffer{}
+				apt.TrackLeak(leaks, "response body buffer", respBuf)
+				w = &responseRecorder{StatusRecorder: status, body: respBuf, captureBody: true}
 			}
-			resBody, _ := io.ReadAll(recRes.Body)
-			res.WriteHeader(recRes.StatusCode)
-			res.Write(resBody)
-
-			aptConfig := apt.Config{
-				ServiceName:         config.ServiceName,
-				ServiceVersion:      config.ServiceVersion,
-				Tags:                config.Tags,
-				Debug:               config.Debug,
-				CaptureRequestBody:  config.CaptureRequestBody,
-				CaptureResponseBody: config.CaptureResponseBody,
-				RedactHeaders:       config.RedactHeaders,
-				RedactRequestBody:   config.RedactRequestBody,
-				RedactResponseBody:  config.RedactResponseBody,
+			stopHeartbeat := apt.StartHeartbeat(aptConfig, span, &status.Written)
+			defer stopHeartbeat()
+			timedOut, shed := false, false
+			var queueWait time.Duration
+			report := func(statusCode int, panicInfo *apt.PanicInfo) {
+				var resBody []byte
+				if respBuf != nil {
+					resBody = respBuf.Bytes()
+				}
+
+				chiCtx := chi.RouteContext(req.Context())
+				vars := map[string]string{}
+				for i, key := range chiCtx.URLParams.Keys {
+					if len(chiCtx.URLParams.Values) > i {
+						vars[key] = chiCtx.URLParams.Values[i]
+					}
+				}
+
+				payload := apt.BuildPayload(apt.GoGorillaMux,
+					req, statusCode,
+					reqBuf, resBody, status.SentHeader(), vars, chiCtx.RoutePattern(),
+					config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
+					errorList,
+					msgID,
+					parentID,
+					aptConfig,
+				)
+				payload.Panic = panicInfo
+				payload.HandlerTimedOut = timedOut
+				payload.QueueWait = queueWait
+				payload.Shed = shed
+				payload.RequestBodyIncomplete = reqIncomplete
+				apt.ApplyWriteStatus(&payload, &status.Written)
+				apt.ApplyResponseCompression(&payload, status.SentHeader(), status.Header())
+				payload.MetadataOnly = level == apt.CaptureMetadataOnly
+
+				if !apt.ShouldExport(aptConfig, &payload, apt.Now(aptConfig).Sub(start)) {
+					if ownSpan {
+						apt.DropSpan(span)
+					}
+					return
+				}
+				apt.CreateSpan(payload, aptConfig, span)
+				apt.RecordRequestDuration(newCtx, aptConfig, payload, apt.Now(aptConfig).Sub(start))
 			}
 
-			chiCtx := chi.RouteContext(req.Context())
-			vars := map[string]string{}
-			for i, key := range chiCtx.URLParams.Keys {
-				if len(chiCtx.URLParams.Values) > i {
-					vars[key] = chiCtx.URLParams.Values[i]
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					report(http.StatusInternalServerError, apt.NewPanicInfo(recovered, aptConfig))
+					panic(recovered)
 				}
+			}()
+			var route string
+			if config.ConcurrencyLimit.PerRoute {
+				route = matchRoute(req)
+			}
+			release, wait, admitted := limiter.Acquire(newCtx, route)
+			queueWait = wait
+			if !admitted {
+				shed = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				report(http.StatusServiceUnavailable, nil)
+				return
 			}
+			defer release()
 
-			payload := apt.BuildPayload(apt.GoGorillaMux,
-				req, recRes.StatusCode,
-				reqBuf, resBody, recRes.Header, vars, chiCtx.RoutePattern(),
-				config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-				errorList,
-				msgID,
-				nil,
-				aptConfig,
-			)
-			if config.Debug {
-				log.Println(payload)
+			span.AddEvent(apt.EventHandlerStart)
+			if config.HandlerTimeout > 0 {
+				handlerStart := apt.Now(aptConfig)
+				timedOut = apt.ServeWithTimeout(w, req, next, config.HandlerTimeout)
+				if timedOut {
+					apt.ReportError(newCtx, &apt.HandlerTimeoutError{
+						Method:  req.Method,
+						Route:   chi.RouteContext(req.Context()).RoutePattern(),
+						Timeout: config.HandlerTimeout,
+						Elapsed: apt.Now(aptConfig).Sub(handlerStart),
+					})
+				}
+			} else {
+				next.ServeHTTP(w, req)
 			}
+			span.AddEvent(apt.EventHandlerComplete)
+			report(status.StatusCode(), nil)
+		})
+	}
+}
 
-			apt.CreateSpan(payload, aptConfig, span)
+// matchRoute returns the pattern of the route req will be routed to. Routing
+// happens after router-level middleware runs, so the pattern is looked up on
+// the router rather than read from the request's route context.
+func matchRoute(req *http.Request) string {
+	chiCtx := chi.RouteContext(req.Context())
+	if chiCtx == nil || chiCtx.Routes == nil {
+		return ""
+	}
+	if pattern := chiCtx.RoutePattern(); pattern != "" && !strings.HasSuffix(pattern, "/*") {
+		return pattern
+	}
+	return chiCtx.Routes.Find(chi.NewRouteContext(), req.Method, req.URL.Path)
+}
 
-		})
+// responseRecorder captures the response body for telemetry reporting, on
+// top of the status and bytes written its StatusRecorder records. It is only
+// used when response bodies are captured; otherwise handlers get the
+// StatusRecorder's own writer.
+type responseRecorder struct {
+	*apt.StatusRecorder
+	body        *bytes.Buffer
+	captureBody bool
+}
+
+// WriteHeader writes the status code. Body capture stops here if the headers
+// of the final response describe a file download.
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.Wrote() && !apt.IsInformationalStatus(code) && apt.IsFileResponse(r.Header()) {
+		r.captureBody = false
+	}
+	r.StatusRecorder.WriteHeader(code)
+}
+
+// FlushError flushes the response for http.ResponseController, writing a
+// 200 status first if none was written.
+func (r *responseRecorder) FlushError() error {
+	if !r.Wrote() {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.StatusRecorder.FlushError()
+}
+
+// Write captures response body and ensures WriteHeader is called with 200 if not already.
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.Wrote() {
+		r.WriteHeader(http.StatusOK)
 	}
+	// Only buffer what the client was sent: writes to 204 and 304 responses
+	// fail with http.ErrBodyNotAllowed.
+	n, err := r.StatusRecorder.Write(b)
+	if r.captureBody {
+		r.body.Write(b[:n])
+	}
+	return n, err
+}
+
+func getAptConfig(config Config) apt.Config {
+	return apt.Config{
+		ServiceName:             config.ServiceName,
+		ServiceVersion:          config.ServiceVersion,
+		Tags:                    config.Tags,
+		Debug:                   config.Debug,
+		CaptureRequestBody:      config.CaptureRequestBody,
+		CaptureResponseBody:     config.CaptureResponseBody,
+		RedactHeaders:           config.RedactHeaders,
+		RedactRequestBody:       config.RedactRequestBody,
+		RedactResponseBody:      config.RedactResponseBody,
+		CaptureRequestHeaders:   config.CaptureRequestHeaders,
+		CaptureResponseHeaders:  config.CaptureResponseHeaders,
+		RouteTags:               config.RouteTags,
+		SPAFallbackRoutes:       config.SPAFallbackRoutes,
+		SPAFallbackExclude:      config.SPAFallbackExclude,
+		RedactQueryParams:       config.RedactQueryParams,
+		TracerProvider:          config.TracerProvider,
+		Propagators:             config.Propagators,
+		Now:                     config.Now,
+		NewMessageID:            config.NewMessageID,
+		UseUUIDv7:               config.UseUUIDv7,
+		ErrorDedupWindow:        config.ErrorDedupWindow,
+		SemanticConventions:     config.SemanticConventions,
+		MeterProvider:           config.MeterProvider,
+		APIKey:                  config.APIKey,
+		Endpoint:                config.Endpoint,
+		PayloadHook:             config.PayloadHook,
+		StrictRedaction:         config.StrictRedaction,
+		BodyEncryptionKey:       config.BodyEncryptionKey,
+		GeoResolver:             config.GeoResolver,
+		ProbesMetadataOnly:      config.ProbesMetadataOnly,
+		PreflightMetadataOnly:   config.PreflightMetadataOnly,
+		DropPreflight:           config.DropPreflight,
+		MaxAttributeValueLength: config.MaxAttributeValueLength,
+		MaxAttributes:           config.MaxAttributes,
+		IdempotencyKeyHeader:    config.IdempotencyKeyHeader,
+		IdempotencyWindow:       config.IdempotencyWindow,
+		DebugCaptureSecret:      config.DebugCaptureSecret,
+		ReuseExistingSpan:       config.ReuseExistingSpan,
+		PayloadSchemaVersion:    config.PayloadSchemaVersion,
+		BatchExport:             config.BatchExport,
+		HeartbeatInterval:       config.HeartbeatInterval,
+		DetectLeaks:             config.DetectLeaks,
+		SLOs:                    config.SLOs,
+		NDJSONMaxLines:          config.NDJSONMaxLines,
+		JWTClaims:               config.JWTClaims,
+		TraceStateKeys:          config.TraceStateKeys,
+		IdentityFunc:            config.IdentityFunc,
+		TenantFunc:              config.TenantFunc,
+		TenantBudget:            config.TenantBudget,
+	}
+}
+
+// InitOTel sets up OpenTelemetry directly on its SDK, see apt.InitOTel.
+func InitOTel(ctx context.Context, opts apt.OTelOptions) (shutdown func(context.Context) error, err error) {
+	return apt.InitOTel(ctx, opts)
 }
 
-func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+// ConfigureOpenTelemetry initializes OpenTelemetry with default options and any additional options.
+// Returns a shutdown function to flush telemetry and an error if initialization fails.
+// It is kept for compatibility, see apt.ConfigureOpenTelemetry; InitOTel offers the same options natively.
+func ConfigureOpenTelemetry(opts ...apt.OTelOption) (func(), error) {
+	return apt.ConfigureOpenTelemetry(opts...)
 }
 
 var (
-	WithServiceName            = otelconfig.WithServiceName
-	WithServiceVersion         = otelconfig.WithServiceVersion
-	WithLogLevel               = otelconfig.WithLogLevel
-	WithResourceAttributes     = otelconfig.WithResourceAttributes
-	WithResourceOption         = otelconfig.WithResourceOption
-	WithPropagators            = otelconfig.WithPropagators
-	WithErrorHandler           = otelconfig.WithErrorHandler
-	WithMetricsReportingPeriod = otelconfig.WithMetricsReportingPeriod
-	WithMetricsEnabled         = otelconfig.WithMetricsEnabled
-	WithTracesEnabled          = otelconfig.WithTracesEnabled
-	WithSpanProcessor          = otelconfig.WithSpanProcessor
-	WithSampler                = otelconfig.WithSampler
+	WithServiceName            = apt.WithServiceName
+	WithServiceVersion         = apt.WithServiceVersion
+	WithLogLevel               = apt.WithLogLevel
+	WithResourceAttributes     = apt.WithResourceAttributes
+	WithResourceOption         = apt.WithResourceOption
+	WithPropagators            = apt.WithPropagators
+	WithErrorHandler           = apt.WithErrorHandler
+	WithMetricsReportingPeriod = apt.WithMetricsReportingPeriod
+	WithMetricsEnabled         = apt.WithMetricsEnabled
+	WithTracesEnabled          = apt.WithTracesEnabled
+	WithSpanProcessor          = apt.WithSpanProcessor
+	WithSampler                = apt.WithSampler
 )
 
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
@@ -127,4 +498,6 @@ var (
 	WithRedactHeaders      = apt.WithRedactHeaders
 	WithRedactRequestBody  = apt.WithRedactRequestBody
 	WithRedactResponseBody = apt.WithRedactResponseBody
+	WithRedactQueryParams  = apt.WithRedactQueryParams
+	WithStrictRedaction    = apt.WithStrictRedaction
 )
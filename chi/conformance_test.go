@@ -0,0 +1,30 @@
+package monoscopechi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/monoscope-tech/monoscope-go/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, conformance.Adapter{
+		Serve: func(config apt.Config, routes []conformance.Route) http.Handler {
+			router := chi.NewRouter()
+			router.Use(Middleware(Config{
+				TracerProvider:      config.TracerProvider,
+				CaptureRequestBody:  config.CaptureRequestBody,
+				CaptureResponseBody: config.CaptureResponseBody,
+				RedactHeaders:       config.RedactHeaders,
+				RedactRequestBody:   config.RedactRequestBody,
+				RedactResponseBody:  config.RedactResponseBody,
+			}))
+			for _, route := range routes {
+				router.Method(route.Method, route.Pattern, route.Handler)
+			}
+			return router
+		},
+	})
+}
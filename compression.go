@@ -0,0 +1,118 @@
+package monoscope
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Where a compression middleware sits relative to the Monoscope middleware,
+// recorded in Payload.ResponseCompression.
+const (
+	// ResponseCompressionOuter marks a response compressed by a middleware
+	// wrapping the Monoscope middleware: the handler's body was captured
+	// before compression.
+	ResponseCompressionOuter = "outer"
+	// ResponseCompressionInner marks a response compressed by a middleware
+	// between the Monoscope middleware and the handler: the captured body
+	// was decompressed.
+	ResponseCompressionInner = "inner"
+)
+
+// decodeContentEncoding reconciles a captured response body with the
+// Content-Encoding of the headers sent with it. A body that is encoded, as
+// it is when the compression middleware runs inside ours, is decompressed;
+// one that isn't was captured before an outer middleware compressed it. In
+// both cases the returned headers describe the returned body, without
+// Content-Encoding and with a Content-Length only when it matches. gzip and
+// deflate bodies are decompressed up to MaxCaptureContentLength; other
+// encodings, and bodies that fail to decompress, are captured by size only:
+// nil is returned with undecodedSize set.
+func decodeContentEncoding(body []byte, header map[string][]string) (decoded []byte, captured map[string][]string, encoding, layer string, undecodedSize int) {
+	encoding = strings.ToLower(strings.TrimSpace(http.Header(header).Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || len(body) == 0 {
+		return body, header, "", "", 0
+	}
+	decoded, layer = body, ResponseCompressionOuter
+	if isEncodedBody(body, encoding) {
+		layer = ResponseCompressionInner
+		var err error
+		if decoded, err = decompress(body, encoding); err != nil {
+			decoded, undecodedSize = nil, len(body)
+		}
+	}
+	h := http.Header(header).Clone()
+	h.Del("Content-Encoding")
+	if contentLength := h.Get("Content-Length"); contentLength != "" && contentLength != strconv.Itoa(len(decoded)) {
+		h.Del("Content-Length")
+	}
+	return decoded, h, encoding, layer, undecodedSize
+}
+
+// isEncodedBody reports whether body starts like a body in encoding. Brotli
+// has no magic number, so brotli and unknown encodings are assumed encoded.
+func isEncodedBody(body []byte, encoding string) bool {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return len(body) >= 2 && body[0] == 0x1f && body[1] == 0x8b
+	case "deflate":
+		// A zlib header: the deflate method, with a checksum over both bytes.
+		return len(body) >= 2 && body[0]&0x0f == 8 && (uint16(body[0])<<8|uint16(body[1]))%31 == 0
+	case "zstd":
+		return bytes.HasPrefix(body, []byte{0x28, 0xb5, 0x2f, 0xfd})
+	}
+	return true
+}
+
+// decompress decodes a gzip or deflate body, failing for other encodings and
+// for bodies decompressing to more than MaxCaptureContentLength bytes.
+func decompress(body []byte, encoding string) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	decoded, err := io.ReadAll(io.LimitReader(r, MaxCaptureContentLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > MaxCaptureContentLength {
+		return nil, errDecompressedTooLarge
+	}
+	return decoded, nil
+}
+
+var (
+	errUnsupportedEncoding  = errors.New("unsupported content encoding")
+	errDecompressedTooLarge = errors.New("decompressed body is too large to capture")
+)
+
+// ApplyResponseCompression records on payload a compression middleware
+// wrapping the Monoscope middleware that set Content-Encoding only once the
+// status was written, as most do, so it is missing from sent, the headers
+// captured then, and present in current, the headers when the handler
+// returned. Middlewares call it after BuildPayload.
+func ApplyResponseCompression(payload *Payload, sent, current map[string][]string) {
+	if payload.ResponseCompression != "" || http.Header(sent).Get("Content-Encoding") != "" {
+		return
+	}
+	encoding := strings.ToLower(strings.TrimSpace(http.Header(current).Get("Content-Encoding")))
+	if encoding != "" && encoding != "identity" {
+		payload.ResponseContentEncoding = encoding
+		payload.ResponseCompression = ResponseCompressionOuter
+	}
+}
@@ -0,0 +1,306 @@
+// Package conformance is the contract every Monoscope middleware integration
+// is tested against. An integration's tests call Run with an Adapter serving
+// the suite's routes behind its middleware; Run then checks status codes,
+// body passthrough, redaction, panic handling and route template extraction
+// against the spans the middleware exports.
+package conformance
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+)
+
+// Route is a handler the Adapter registers. Patterns name path parameters as
+// {name}; adapters translate them to their router's syntax.
+type Route struct {
+	Method  string
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// Adapter puts one middleware integration under test.
+type Adapter struct {
+	// Serve returns a handler serving routes behind the middleware. The
+	// middleware is configured from config's TracerProvider,
+	// CaptureRequestBody, CaptureResponseBody, RedactHeaders,
+	// RedactRequestBody and RedactResponseBody. Panics must reach Serve's
+	// caller, so no recovery middleware may be installed.
+	Serve func(config apt.Config, routes []Route) http.Handler
+	// Template returns the route the integration reports for pattern, such
+	// as "/users/:id" for "/users/{id}". Nil reports patterns unchanged.
+	Template func(pattern string) string
+	// RawPaths is set for integrations without a router, which report the
+	// request path as the route and no path parameters.
+	RawPaths bool
+}
+
+// ColonParams rewrites the {name} parameters of pattern in the :name syntax
+// of routers such as gin, echo and fiber, for registering routes and as an
+// Adapter's Template.
+func ColonParams(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + segment[1:len(segment)-1]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// RedactedValue is the placeholder the SDK substitutes for redacted values.
+const RedactedValue = "[CLIENT_REDACTED]"
+
+const (
+	redactRequestBody  = `{"name":"ada","password":"hunter2"}`
+	redactResponseBody = `{"id":42,"token":"eyJhbGciOiJIUzI1NiJ9"}`
+	panicValue         = "conformance: handler panic"
+)
+
+// passthroughBody spans several 4KB reads, so it is only passed through
+// whole when integrations copy bodies to the end.
+var passthroughBody = `{"lines":[` + strings.Repeat(`"passthrough",`, 1024) + `"end"]}`
+
+// Routes are the routes Run requests; adapters register all of them.
+var Routes = []Route{
+	{http.MethodGet, "/implicit", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}},
+	{http.MethodGet, "/status/created", statusHandler(http.StatusCreated)},
+	{http.MethodGet, "/status/no-content", statusHandler(http.StatusNoContent)},
+	{http.MethodGet, "/status/bad-request", statusHandler(http.StatusBadRequest)},
+	{http.MethodGet, "/status/unavailable", statusHandler(http.StatusServiceUnavailable)},
+	{http.MethodPost, "/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		_, _ = io.Copy(w, r.Body)
+	}},
+	{http.MethodPost, "/accounts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != redactRequestBody {
+			http.Error(w, "request body was altered before the handler", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Resp-Id", "r1")
+		_, _ = io.WriteString(w, redactResponseBody)
+	}},
+	{http.MethodGet, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic(panicValue)
+	}},
+	{http.MethodGet, "/users/{id}/orders/{order}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}},
+}
+
+func statusHandler(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		if code != http.StatusNoContent {
+			_, _ = fmt.Fprintf(w, "status %d", code)
+		}
+	}
+}
+
+// exchange is one request served by the adapter and what came of it.
+type exchange struct {
+	resp *httptest.ResponseRecorder
+	// panicked is the value that escaped the handler, if any.
+	panicked any
+	// attrs are the attributes of the exported span, keyed by name.
+	attrs map[attribute.Key]attribute.Value
+}
+
+func (e exchange) str(key attribute.Key) string { return e.attrs[key].AsString() }
+
+func (e exchange) body(key attribute.Key) string {
+	b, _ := base64.StdEncoding.DecodeString(e.str(key))
+	return string(b)
+}
+
+type testCase struct {
+	name    string
+	method  string
+	path    string
+	pattern string
+	body    string
+	header  http.Header
+	check   func(t *testing.T, adapter Adapter, e exchange)
+}
+
+var cases = []testCase{
+	statusCase("implicit-ok", "/implicit", http.StatusOK, "ok"),
+	statusCase("created", "/status/created", http.StatusCreated, "status 201"),
+	statusCase("no-content", "/status/no-content", http.StatusNoContent, ""),
+	statusCase("bad-request", "/status/bad-request", http.StatusBadRequest, "status 400"),
+	statusCase("unavailable", "/status/unavailable", http.StatusServiceUnavailable, "status 503"),
+	{
+		name: "body-passthrough", method: http.MethodPost, path: "/echo", pattern: "/echo",
+		body:   passthroughBody,
+		header: http.Header{"Content-Type": {"application/json"}},
+		check: func(t *testing.T, _ Adapter, e exchange) {
+			want := passthroughBody
+			if got := e.resp.Body.String(); got != want {
+				t.Errorf("Client received %d bytes, want the %d sent", len(got), len(want))
+			}
+			if got := e.body("http.request.body"); got != want {
+				t.Errorf("Captured request body has %d bytes, want %d", len(got), len(want))
+			}
+			if got := e.body("http.response.body"); got != want {
+				t.Errorf("Captured response body has %d bytes, want %d", len(got), len(want))
+			}
+		},
+	},
+	{
+		name: "redaction", method: http.MethodPost, path: "/accounts/42", pattern: "/accounts/{id}",
+		body:   redactRequestBody,
+		header: http.Header{"Content-Type": {"application/json"}, "X-Api-Key": {"secret"}},
+		check: func(t *testing.T, _ Adapter, e exchange) {
+			if e.resp.Code != http.StatusOK || e.resp.Body.String() != redactResponseBody {
+				t.Errorf("Redaction reached the wire: %d %q", e.resp.Code, e.resp.Body.String())
+			}
+			wantFields(t, "request body", e.body("http.request.body"), map[string]any{"name": "ada", "password": RedactedValue})
+			wantFields(t, "response body", e.body("http.response.body"), map[string]any{"id": float64(42), "token": RedactedValue})
+			if got := e.attrs["http.request.header.X-Api-Key"].AsStringSlice(); !reflect.DeepEqual(got, []string{RedactedValue}) {
+				t.Errorf("Expected X-Api-Key to be redacted, got %q", got)
+			}
+			if got := e.attrs["http.response.header.X-Resp-Id"].AsStringSlice(); !reflect.DeepEqual(got, []string{"r1"}) {
+				t.Errorf("Expected X-Resp-Id response header r1, got %q", got)
+			}
+		},
+	},
+	{
+		name: "panic", method: http.MethodGet, path: "/panic", pattern: "/panic",
+		check: func(t *testing.T, _ Adapter, e exchange) {
+			if e.panicked != panicValue {
+				t.Errorf("Expected the handler's panic to propagate, got %v", e.panicked)
+			}
+			if got := e.attrs["http.response.status_code"].AsInt64(); got != http.StatusInternalServerError {
+				t.Errorf("Expected status 500 for a panic, got %d", got)
+			}
+			if got := e.str("apitoolkit.panic"); !strings.Contains(got, panicValue) {
+				t.Errorf("Expected the panic value in apitoolkit.panic, got %q", got)
+			}
+		},
+	},
+	{
+		name: "route-template", method: http.MethodGet, path: "/users/42/orders/7", pattern: "/users/{id}/orders/{order}",
+		check: func(t *testing.T, adapter Adapter, e exchange) {
+			if adapter.RawPaths {
+				return
+			}
+			var params map[string]string
+			_ = json.Unmarshal([]byte(e.str("http.request.path_params")), &params)
+			if want := map[string]string{"id": "42", "order": "7"}; !reflect.DeepEqual(params, want) {
+				t.Errorf("Expected path params %v, got %v", want, params)
+			}
+		},
+	},
+}
+
+func statusCase(name, path string, code int, body string) testCase {
+	return testCase{
+		name: name, method: http.MethodGet, path: path, pattern: path,
+		check: func(t *testing.T, _ Adapter, e exchange) {
+			if e.resp.Code != code || e.resp.Body.String() != body {
+				t.Errorf("Client received %d %q, want %d %q", e.resp.Code, e.resp.Body.String(), code, body)
+			}
+			if got := e.attrs["http.response.status_code"].AsInt64(); got != int64(code) {
+				t.Errorf("Expected status %d to be reported, got %d", code, got)
+			}
+		},
+	}
+}
+
+func wantFields(t *testing.T, what, body string, want map[string]any) {
+	t.Helper()
+	var got map[string]any
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Errorf("Captured %s %q isn't JSON: %v", what, body, err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Captured %s %v, want %v", what, got, want)
+	}
+}
+
+// Run requests each of Routes through adapter and checks the exchange and
+// the span the middleware exported for it.
+func Run(t *testing.T, adapter Adapter) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			handler := adapter.Serve(apt.Config{
+				TracerProvider:      tp,
+				CaptureRequestBody:  true,
+				CaptureResponseBody: true,
+				RedactHeaders:       []string{"X-Api-Key"},
+				RedactRequestBody:   []string{"$.password"},
+				RedactResponseBody:  []string{"$.token"},
+			}, Routes)
+
+			e := serve(handler, tc)
+			spans := exporter.GetSpans()
+			e.attrs = serverSpanAttributes(spans)
+			if e.attrs == nil {
+				t.Fatalf("No request span was exported for %s %s (%d spans)", tc.method, tc.path, len(spans))
+			}
+
+			route := tc.pattern
+			if adapter.RawPaths {
+				route = tc.path
+			} else if adapter.Template != nil {
+				route = adapter.Template(tc.pattern)
+			}
+			if got := e.str("http.route"); got != route {
+				t.Errorf("Expected route %q, got %q", route, got)
+			}
+			if got := e.str("http.request.method"); got != tc.method {
+				t.Errorf("Expected method %s, got %s", tc.method, got)
+			}
+			tc.check(t, adapter, e)
+		})
+	}
+}
+
+func serve(handler http.Handler, tc testCase) (e exchange) {
+	req := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+	for key, values := range tc.header {
+		req.Header[key] = values
+	}
+	e.resp = httptest.NewRecorder()
+	defer func() { e.panicked = recover() }()
+	handler.ServeHTTP(e.resp, req)
+	return e
+}
+
+// serverSpanAttributes returns the attributes of the span carrying the
+// request payload, nil when none was exported.
+func serverSpanAttributes(spans tracetest.SpanStubs) map[attribute.Key]attribute.Value {
+	for _, span := range spans {
+		attrs := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+		for _, kv := range span.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		if _, ok := attrs["http.response.status_code"]; ok {
+			if _, ok := attrs["http.route"]; ok {
+				return attrs
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package monoscope
+
+// CaptureLevel is how much of a request is captured, as decided per request
+// by a middleware's ConsentFunc, e.g. from a user's consent flags or the
+// region the traffic comes from.
+type CaptureLevel int
+
+const (
+	// CaptureFull captures the request as configured.
+	CaptureFull CaptureLevel = iota
+	// CaptureMetadataOnly reports the request without its request and
+	// response bodies. Headers and query parameters are still captured,
+	// subject to redaction.
+	CaptureMetadataOnly
+	// CaptureNone passes the request through without reporting it.
+	CaptureNone
+)
+
+// String returns the name of l as recorded on payloads.
+func (l CaptureLevel) String() string {
+	switch l {
+	case CaptureFull:
+		return "full"
+	case CaptureMetadataOnly:
+		return "metadata_only"
+	case CaptureNone:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+// ApplyCaptureLevel restricts config, the Config of a single request, to what
+// level allows.
+func ApplyCaptureLevel(config *Config, level CaptureLevel) {
+	if level != CaptureFull {
+		config.CaptureRequestBody = false
+		config.CaptureResponseBody = false
+	}
+}
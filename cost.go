@@ -0,0 +1,31 @@
+package monoscope
+
+import "go.opentelemetry.io/otel/attribute"
+
+// CostUnitBytes is the serialized size of one unit of export cost weight:
+// each payload weighs one unit per started CostUnitBytes.
+const CostUnitBytes = 1024
+
+// attributesSize estimates the serialized size of attrs as the length of
+// their keys and values.
+func attributesSize(attrs []attribute.KeyValue) int {
+	size := 0
+	for _, kv := range attrs {
+		size += len(kv.Key) + len(kv.Value.Emit())
+	}
+	return size
+}
+
+// costAttributes returns the size and cost weight attributes of a payload
+// exported as attrs, and counts them in the self-metrics, so telemetry spend
+// can be attributed per route and service.
+func costAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	size := attributesSize(attrs)
+	weight := (size + CostUnitBytes - 1) / CostUnitBytes
+	selfMetrics.payloadBytes.Add(int64(size))
+	selfMetrics.payloadCostUnits.Add(int64(weight))
+	return []attribute.KeyValue{
+		attribute.Int("apitoolkit.payload_size_bytes", size),
+		attribute.Int("apitoolkit.cost_weight", weight),
+	}
+}
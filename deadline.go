@@ -0,0 +1,51 @@
+package monoscope
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultDeadlineBudget is the share of the time left before its context
+// deadline an outgoing call may take before it is flagged, see
+// WithDeadlineBudget.
+const DefaultDeadlineBudget = 0.5
+
+// DeadlineBudget is how an outgoing call used the time left before its
+// context deadline, so cascading timeouts can be traced to the calls that ate
+// the budget.
+type DeadlineBudget struct {
+	// Remaining is the time left before the deadline when the call started.
+	Remaining time.Duration `json:"remaining_ns"`
+	// Used is the share of Remaining the call took until its response
+	// arrived or it failed; 1 or more means it ran into the deadline.
+	Used float64 `json:"used"`
+	// Exceeded is set when Used is above the configured budget share.
+	Exceeded bool `json:"exceeded,omitempty"`
+}
+
+// callDeadline returns the deadline bounding an outgoing call: that of its
+// request's context, or else that of the context the client was created
+// with, which is typically the incoming request's.
+func callDeadline(reqCtx, clientCtx context.Context) (time.Time, bool) {
+	if deadline, ok := reqCtx.Deadline(); ok {
+		return deadline, true
+	}
+	return clientCtx.Deadline()
+}
+
+// newDeadlineBudget returns the budget of a call started at start with
+// deadline that ended at end, flagged when it used more than share of it.
+// A share of 0 means DefaultDeadlineBudget.
+func newDeadlineBudget(deadline, start, end time.Time, share float64) *DeadlineBudget {
+	if share <= 0 {
+		share = DefaultDeadlineBudget
+	}
+	budget := &DeadlineBudget{Remaining: deadline.Sub(start)}
+	if budget.Remaining <= 0 {
+		budget.Remaining, budget.Used = 0, 1
+	} else {
+		budget.Used = float64(end.Sub(start)) / float64(budget.Remaining)
+	}
+	budget.Exceeded = budget.Used > share
+	return budget
+}
@@ -0,0 +1,130 @@
+package monoscope
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DebugCaptureHeader is the request header that turns on full capture for a
+// single request, see Config.DebugCaptureSecret and SignDebugCapture.
+const DebugCaptureHeader = "X-Monoscope-Capture"
+
+var debugCaptureCtxKey = ctxKey("debug-capture")
+
+// SignDebugCapture returns a DebugCaptureHeader value valid until expires,
+// signed with secret, the Config.DebugCaptureSecret of the service, e.g. for
+// a support engineer reproducing a problem in production:
+//
+//	curl -H "X-Monoscope-Capture: $(value)" https://api.example.com/orders
+func SignDebugCapture(secret []byte, expires time.Time) string {
+	claim := "full;exp=" + strconv.FormatInt(expires.Unix(), 10)
+	return claim + ";sig=" + debugCaptureSignature(secret, claim)
+}
+
+func debugCaptureSignature(secret []byte, claim string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(claim))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDebugCapture reports whether value is a DebugCaptureHeader value
+// signed with config.DebugCaptureSecret that hasn't expired.
+func verifyDebugCapture(config Config, value string) bool {
+	if len(config.DebugCaptureSecret) == 0 || value == "" {
+		return false
+	}
+	claim, sig, ok := strings.Cut(value, ";sig=")
+	if !ok || !hmac.Equal([]byte(sig), []byte(debugCaptureSignature(config.DebugCaptureSecret, claim))) {
+		return false
+	}
+	mode, exp, ok := strings.Cut(claim, ";exp=")
+	if !ok || mode != "full" {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	return err == nil && Now(config).Unix() < expires
+}
+
+// ApplyDebugCapture checks value, the request's DebugCaptureHeader, and when
+// it is validly signed turns on body capture in config, the Config of a
+// single request, and returns a copy of ctx that ForcedSampler always
+// samples. Spans started from the returned context, including those of
+// outgoing calls, are then exported regardless of the sampling rate.
+// Redaction still applies, and so does a ConsentFunc limiting the request
+// to its metadata.
+func ApplyDebugCapture(ctx context.Context, config *Config, value string) context.Context {
+	if !verifyDebugCapture(*config, value) {
+		return ctx
+	}
+	config.CaptureRequestBody = true
+	config.CaptureResponseBody = true
+	return context.WithValue(ctx, debugCaptureCtxKey, true)
+}
+
+// ForceSample marks the request being handled in ctx for full capture, e.g.
+// when a handler detects an anomaly mid-request: ForcedSampler samples every
+// span started in the request from then on, including outgoing calls, which
+// capture their bodies in full, and the request's payload is recorded as
+// force-sampled and exempt from ProbesMetadataOnly. The request span itself
+// was sampled, and its bodies buffered, when the request started, so to be
+// sure of those use a DebugCaptureHeader or a sampler that keeps request
+// spans. It does nothing for contexts that didn't pass through a Monoscope
+// middleware.
+func ForceSample(ctx context.Context) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	annotations.forceSampled = true
+}
+
+// forceSampled reports whether ctx belongs to a request that must be
+// sampled, see ApplyDebugCapture and ForceSample.
+func forceSampled(ctx context.Context) bool {
+	if forced, _ := ctx.Value(debugCaptureCtxKey).(bool); forced {
+		return true
+	}
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return false
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	return annotations.forceSampled
+}
+
+// ForcedSampler returns a sampler that samples the spans of requests forced
+// with a DebugCaptureHeader or ForceSample and defers to base for all others. Install it
+// on the tracer provider, e.g. with WithSampler, wrapping the sampler that
+// would otherwise be used.
+func ForcedSampler(base sdktrace.Sampler) sdktrace.Sampler {
+	return forcedSampler{base: base}
+}
+
+type forcedSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s forcedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if forceSampled(p.ParentContext) {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s forcedSampler) Description() string {
+	return "ForcedSampler{" + s.base.Description() + "}"
+}
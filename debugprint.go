@@ -0,0 +1,95 @@
+package monoscope
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// debugOutput is where Debug mode prints payload summaries.
+var debugOutput io.Writer = os.Stdout
+
+// debugColor reports whether summaries are colorized: only when printing to
+// a terminal and NO_COLOR is unset.
+var debugColor = func() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}()
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// printDebugSummary prints a one-line summary of payload, as exported, for
+// Debug mode, e.g.
+//
+//	monoscope: POST /users/{id} 201 12.4ms req=36B resp=9B redacted=request_body:$.password×1
+//
+// The duration is taken from span when it was started by the OpenTelemetry
+// SDK and omitted otherwise.
+func printDebugSummary(payload Payload, config Config, span trace.Span) {
+	paint := func(color, s string) string {
+		if !debugColor {
+			return s
+		}
+		return color + s + ansiReset
+	}
+
+	var b strings.Builder
+	b.WriteString(paint(ansiDim, "monoscope:"))
+	if payload.SdkType == GoOutgoing {
+		b.WriteString(" " + paint(ansiDim, "outgoing"))
+	}
+	fmt.Fprintf(&b, " %s %s %s", payload.Method, payload.URLPath, paint(statusColor(payload.StatusCode), fmt.Sprint(payload.StatusCode)))
+	if ro, ok := span.(sdktrace.ReadOnlySpan); ok && !ro.StartTime().IsZero() {
+		fmt.Fprintf(&b, " %s", Now(config).Sub(ro.StartTime()).Round(time.Microsecond))
+	}
+	if payload.MetadataOnly {
+		b.WriteString(" metadata-only")
+	} else {
+		fmt.Fprintf(&b, " req=%dB resp=%dB", len(payload.RequestBody), len(payload.ResponseBody))
+	}
+	if len(payload.Redactions) > 0 {
+		rules := make([]string, 0, len(payload.Redactions))
+		for rule, n := range payload.Redactions {
+			rules = append(rules, fmt.Sprintf("%s×%d", rule, n))
+		}
+		sort.Strings(rules)
+		b.WriteString(" redacted=" + strings.Join(rules, ","))
+	}
+	if len(payload.Errors) > 0 {
+		b.WriteString(" " + paint(ansiRed, fmt.Sprintf("errors=%d", len(payload.Errors))))
+	}
+	if payload.Panic != nil {
+		b.WriteString(" " + paint(ansiRed, "panic"))
+	}
+	b.WriteByte('\n')
+	io.WriteString(debugOutput, b.String())
+}
+
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return ansiRed
+	case status >= 400:
+		return ansiYellow
+	case status >= 300:
+		return ansiCyan
+	default:
+		return ansiGreen
+	}
+}
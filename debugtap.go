@@ -0,0 +1,131 @@
+package monoscope
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultDebugTapAddr is the address StartDebugTap listens on when given none.
+const DefaultDebugTapAddr = "localhost:9911"
+
+// DebugTapPath is the path StartDebugTap serves the payload stream on.
+const DebugTapPath = "/monoscope"
+
+// debugTapBuffer is how many payloads a slow tap subscriber may fall behind
+// before further payloads are dropped for it.
+const debugTapBuffer = 64
+
+var debugTap = &tapHub{subscribers: map[chan []byte]struct{}{}}
+
+// tapHub fans exported payloads out to the connected debug tap subscribers.
+type tapHub struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	count       atomic.Int32
+}
+
+func (h *tapHub) subscribe() chan []byte {
+	ch := make(chan []byte, debugTapBuffer)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.count.Store(int32(len(h.subscribers)))
+	selfMetrics.debugTapListeners.Store(int64(len(h.subscribers)))
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *tapHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.count.Store(int32(len(h.subscribers)))
+	selfMetrics.debugTapListeners.Store(int64(len(h.subscribers)))
+	h.mu.Unlock()
+}
+
+// publish sends payload to every subscriber, dropping it for those whose
+// buffer is full so a stalled browser tab never blocks a request. It is a
+// no-op without subscribers.
+func (h *tapHub) publish(payload Payload) {
+	if h.count.Load() == 0 {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- data:
+		default:
+			selfMetrics.debugTapDropped.Add(1)
+		}
+	}
+}
+
+// DebugTapHandler returns a handler that streams every payload the SDK
+// exports from then on as JSON server-sent events, after redaction, consent
+// and encryption have been applied, so what it shows is exactly what leaves
+// the process. It is meant for local development; mount it on an internal
+// router or use StartDebugTap, never on a public listener.
+func DebugTapHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ch := debugTap.subscribe()
+		defer debugTap.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, ": connected\n\n")
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case data := <-ch:
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// StartDebugTap serves DebugTapHandler on addr (DefaultDebugTapAddr when
+// empty) at DebugTapPath in the background, e.g. `curl -N
+// localhost:9911/monoscope`. The returned function shuts the server down.
+func StartDebugTap(addr string) (shutdown func(context.Context) error, err error) {
+	if addr == "" {
+		addr = DefaultDebugTapAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(DebugTapPath, DebugTapHandler())
+	// Streams never go idle, so they are ended through their base context
+	// rather than left for Shutdown to wait on.
+	streams, endStreams := context.WithCancel(context.Background())
+	server := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return streams }}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("monoscope: debug tap stopped: %v", err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		endStreams()
+		return server.Shutdown(ctx)
+	}, nil
+}
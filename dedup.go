@@ -0,0 +1,75 @@
+package monoscope
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxDedupEntries bounds the fingerprints kept by errorDedup. Once it is
+// reached the least recently reported fingerprints are forgotten, even
+// within their window, losing their suppressed counts.
+const maxDedupEntries = 4096
+
+var errorDedup = newErrorDeduper()
+
+// errorDeduper tracks recently reported errors by fingerprint so that repeats
+// within Config.ErrorDedupWindow can be counted instead of exported.
+type errorDeduper struct {
+	mu   sync.Mutex
+	seen map[string]*list.Element
+	// order holds the *dedupEntry values, least recently reported first.
+	order *list.List
+}
+
+type dedupEntry struct {
+	key        string
+	reportedAt time.Time
+	suppressed int
+}
+
+func newErrorDeduper() *errorDeduper {
+	return &errorDeduper{seen: map[string]*list.Element{}, order: list.New()}
+}
+
+// admit reports whether atError should be exported. The first occurrence of a
+// fingerprint in a window is admitted and later ones are counted; the first
+// occurrence after the window has its Occurrences set to include them.
+func (d *errorDeduper) admit(atError *ATError, window time.Duration) bool {
+	if window <= 0 {
+		return true
+	}
+	key := atError.ErrorType + "\x00" + atError.Message
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	elem, ok := d.seen[key]
+	if ok {
+		entry := elem.Value.(*dedupEntry)
+		if atError.When.Sub(entry.reportedAt) < window {
+			entry.suppressed++
+			return false
+		}
+		if entry.suppressed > 0 {
+			atError.Occurrences = entry.suppressed + 1
+		}
+		entry.reportedAt = atError.When
+		entry.suppressed = 0
+		d.order.MoveToBack(elem)
+		return true
+	}
+	d.evict()
+	d.seen[key] = d.order.PushBack(&dedupEntry{key: key, reportedAt: atError.When})
+	return true
+}
+
+// evict drops the least recently reported fingerprints while the table is
+// full. Expired fingerprints are kept until then, so an error recurring
+// after its window still reports the occurrences suppressed in it.
+func (d *errorDeduper) evict() {
+	for len(d.seen) >= maxDedupEntries {
+		entry := d.order.Remove(d.order.Front()).(*dedupEntry)
+		delete(d.seen, entry.key)
+	}
+}
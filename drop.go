@@ -0,0 +1,171 @@
+package monoscope
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DroppedAttribute marks the request spans of payloads ShouldExport
+// dropped, see DropSpan.
+const DroppedAttribute = "apitoolkit.dropped"
+
+// maxPendingSpans caps the spans NewDropFilter holds back per trace; the
+// spans of a trace that ends more are passed on as they end, and the first
+// one is reported to the OpenTelemetry error handler, since they are exported
+// even if the span they descend from is dropped later.
+const maxPendingSpans = 1000
+
+// DropSpan marks span, the request span of a payload ShouldExport dropped.
+// The span must still be ended, so its children aren't left with an open
+// parent; the span processor returned by NewDropFilter then drops it and
+// its descendants instead of exporting them.
+func DropSpan(span trace.Span) {
+	span.SetAttributes(attribute.Bool(DroppedAttribute, true))
+}
+
+// NewDropFilter returns a span processor passing the spans that end to next,
+// except for the spans DropSpan marked and their descendants. The spans of
+// a trace are held back until the span it started with in this process,
+// such as a request span, ends, and are then passed on, in the order they
+// ended, or dropped together. The providers of Config.APIKey, InitOTel and
+// ConfigureOpenTelemetry use it; wrap the exporting processor of a Config.TracerProvider with it
+// for payload hooks, batch mode, DropPreflight and tenant budgets to drop
+// spans, which are otherwise exported with DroppedAttribute set.
+//
+//	tp := sdktrace.NewTracerProvider(
+//		sdktrace.WithSpanProcessor(monoscope.NewDropFilter(sdktrace.NewBatchSpanProcessor(exporter))),
+//	)
+func NewDropFilter(next sdktrace.SpanProcessor) sdktrace.SpanProcessor {
+	return &dropFilter{next: next, traces: map[trace.TraceID]*pendingTrace{}}
+}
+
+type dropFilter struct {
+	next sdktrace.SpanProcessor
+
+	mu     sync.Mutex
+	traces map[trace.TraceID]*pendingTrace
+}
+
+// pendingTrace holds the spans of a trace that ended while spans it started
+// with in this process were still running.
+type pendingTrace struct {
+	roots    int
+	spans    []sdktrace.ReadOnlySpan
+	overflow bool
+}
+
+// isLocalRoot reports whether s is the first span of its trace in this
+// process.
+func isLocalRoot(s sdktrace.ReadOnlySpan) bool {
+	return !s.Parent().IsValid() || s.Parent().IsRemote()
+}
+
+func isDropped(s sdktrace.ReadOnlySpan) bool {
+	for _, attr := range s.Attributes() {
+		if attr.Key == DroppedAttribute {
+			return attr.Value.AsBool()
+		}
+	}
+	return false
+}
+
+func (f *dropFilter) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if isLocalRoot(s) {
+		f.mu.Lock()
+		p := f.traces[s.SpanContext().TraceID()]
+		if p == nil {
+			p = &pendingTrace{}
+			f.traces[s.SpanContext().TraceID()] = p
+		}
+		p.roots++
+		f.mu.Unlock()
+	}
+	f.next.OnStart(parent, s)
+}
+
+func (f *dropFilter) OnEnd(s sdktrace.ReadOnlySpan) {
+	traceID := s.SpanContext().TraceID()
+	f.mu.Lock()
+	p := f.traces[traceID]
+	if p == nil || (len(p.spans) >= maxPendingSpans && !isLocalRoot(s)) {
+		report := p != nil && !p.overflow
+		if report {
+			p.overflow = true
+		}
+		f.mu.Unlock()
+		if report {
+			otel.Handle(fmt.Errorf("monoscope: trace %s ended more than %d spans before its root span, passing them on unfiltered", traceID, maxPendingSpans))
+		}
+		if !isDropped(s) {
+			f.next.OnEnd(s)
+		}
+		return
+	}
+	p.spans = append(p.spans, s)
+	if isLocalRoot(s) {
+		p.roots--
+	}
+	if p.roots > 0 {
+		f.mu.Unlock()
+		return
+	}
+	delete(f.traces, traceID)
+	f.mu.Unlock()
+	f.forward(p.spans)
+}
+
+// forward passes spans on to f.next, leaving out the dropped ones and their
+// descendants.
+func (f *dropFilter) forward(spans []sdktrace.ReadOnlySpan) {
+	parents := make(map[trace.SpanID]trace.SpanID, len(spans))
+	dropped := map[trace.SpanID]bool{}
+	for _, s := range spans {
+		parents[s.SpanContext().SpanID()] = s.Parent().SpanID()
+		if isDropped(s) {
+			dropped[s.SpanContext().SpanID()] = true
+		}
+	}
+	for _, s := range spans {
+		if !droppedAncestor(s.SpanContext().SpanID(), parents, dropped) {
+			f.next.OnEnd(s)
+		}
+	}
+}
+
+// droppedAncestor reports whether id, or one of its ancestors in parents, is
+// dropped.
+func droppedAncestor(id trace.SpanID, parents map[trace.SpanID]trace.SpanID, dropped map[trace.SpanID]bool) bool {
+	for id.IsValid() {
+		if dropped[id] {
+			return true
+		}
+		parent, ok := parents[id]
+		if !ok {
+			return false
+		}
+		id = parent
+	}
+	return false
+}
+
+// Shutdown passes on the spans still held back, then shuts next down.
+func (f *dropFilter) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	traces := f.traces
+	f.traces = map[trace.TraceID]*pendingTrace{}
+	f.mu.Unlock()
+	for _, p := range traces {
+		f.forward(p.spans)
+	}
+	return f.next.Shutdown(ctx)
+}
+
+func (f *dropFilter) ForceFlush(ctx context.Context) error {
+	return f.next.ForceFlush(ctx)
+}
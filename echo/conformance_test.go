@@ -0,0 +1,31 @@
+package monoscopeecho
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/monoscope-tech/monoscope-go/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, conformance.Adapter{
+		Serve: func(config apt.Config, routes []conformance.Route) http.Handler {
+			e := echo.New()
+			e.Use(Middleware(Config{
+				TracerProvider:      config.TracerProvider,
+				CaptureRequestBody:  config.CaptureRequestBody,
+				CaptureResponseBody: config.CaptureResponseBody,
+				RedactHeaders:       config.RedactHeaders,
+				RedactRequestBody:   config.RedactRequestBody,
+				RedactResponseBody:  config.RedactResponseBody,
+			}))
+			for _, route := range routes {
+				e.Add(route.Method, conformance.ColonParams(route.Pattern), echo.WrapHandler(route.Handler))
+			}
+			return e
+		},
+		Template: conformance.ColonParams,
+	})
+}
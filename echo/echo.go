@@ -4,39 +4,79 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"errors"
-	"io"
+	"crypto/rsa"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/honeycombio/otel-config-go/otelconfig"
 	"github.com/labstack/echo/v4"
 	apt "github.com/monoscope-tech/monoscope-go"
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
-// bodyDumpResponseWriter use to preserve the http response body during request processing
+// echoBodyLogWriter preserves the http response body during request processing
 type echoBodyLogWriter struct {
-	io.Writer
 	http.ResponseWriter
+	body     *bytes.Buffer
+	span     trace.Span
+	checked  bool
+	skipBody bool
+	written  apt.ByteCounter
+	header   http.Header
 }
 
 func (w *echoBodyLogWriter) WriteHeader(code int) {
+	w.span.AddEvent(apt.EventResponseFirstByte)
+	if w.header == nil {
+		w.header = w.Header().Clone()
+	}
 	w.ResponseWriter.WriteHeader(code)
 }
 
+// sentHeader returns the response headers as they were when the status was
+// written, which is what the client received, or a copy of the current
+// headers if it hasn't been.
+func (w *echoBodyLogWriter) sentHeader() http.Header {
+	if w.header != nil {
+		return w.header
+	}
+	return w.Header().Clone()
+}
+
+// Write streams b to the client, buffering it for capture unless the response
+// headers describe a file download. The decision is made on the first write.
 func (w *echoBodyLogWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+	if !w.checked {
+		w.checked = true
+		w.skipBody = apt.IsFileResponse(w.Header())
+	}
+	w.written.Begin()
+	n, err := w.ResponseWriter.Write(b)
+	w.written.Record(n, err)
+	if !w.skipBody {
+		w.body.Write(b[:n])
+	}
+	return n, err
 }
 
+// Flush and Hijack go through http.ResponseController, so writers lacking
+// them, such as HTTP/3's, which can't be hijacked, fail with
+// http.ErrNotSupported instead of panicking.
 func (w *echoBodyLogWriter) Flush() {
-	w.ResponseWriter.(http.Flusher).Flush()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
 }
 
 func (w *echoBodyLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return w.ResponseWriter.(http.Hijacker).Hijack()
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can set read
+// and write deadlines.
+func (w *echoBodyLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 type Config struct {
@@ -49,116 +89,453 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CaptureRequestHeaders and CaptureResponseHeaders, when set, limit the
+	// captured headers to the named ones (case-insensitive). Redaction still
+	// applies to the headers that are kept.
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+	// RouteTags adds tags to the requests of groups of endpoints, keyed by
+	// route template glob, e.g. {"/admin/**": {"admin"}}.
+	RouteTags map[string][]string
+	// SPAFallbackRoutes lists the route globs of catch-all routes serving a
+	// single-page app, reported as the single route "/*spa" without HTML,
+	// JavaScript and CSS bodies. SPAFallbackExclude exempts routes from it.
+	SPAFallbackRoutes  []string
+	SPAFallbackExclude []string
+	// RedactQueryParams lists query parameters whose values are redacted in
+	// the captured query parameters and raw URL.
+	RedactQueryParams []string
+	// TracerProvider is used to create Monoscope spans. When nil, the global
+	// provider from otel.GetTracerProvider is used.
+	TracerProvider trace.TracerProvider
+	// Propagators extracts the incoming trace context. When nil, the global
+	// propagator from otel.GetTextMapPropagator is used.
+	Propagators propagation.TextMapPropagator
+	// Now and NewMessageID replace time.Now and uuid.New, so tests and replay
+	// tooling can produce reproducible payloads.
+	Now          func() time.Time
+	NewMessageID func() uuid.UUID
+	// UseUUIDv7 generates time-ordered UUIDv7 message IDs instead of UUIDv4.
+	UseUUIDv7 bool
+	// ErrorDedupWindow collapses repeated reports of the same error within the
+	// window into the first one; the next report after it carries the count of
+	// suppressed occurrences. Zero reports every error.
+	ErrorDedupWindow time.Duration
+	// SemanticConventions additionally emits the standard OpenTelemetry HTTP
+	// attributes, so generic backends such as Tempo or Jaeger render the spans.
+	SemanticConventions bool
+	// MeterProvider, when set, receives request latencies in the
+	// http.server.request.duration histogram, with trace exemplars linking
+	// slow requests to their Monoscope traces.
+	MeterProvider metric.MeterProvider
+	// APIKey and Endpoint, when APIKey is set, report this middleware's spans
+	// to its own Monoscope project instead of the one configured globally, so
+	// one binary can host services belonging to different projects. Endpoint
+	// defaults to the Monoscope collector. Call monoscope.Shutdown on exit.
+	APIKey   string
+	Endpoint string
+	// PayloadHook, when set, is called with each payload before it is
+	// recorded, to modify it or, by returning nil, veto its export.
+	PayloadHook func(*apt.Payload) *apt.Payload
+	// StrictRedaction replaces bodies the redaction rules could not be applied
+	// to with apt.RedactionFailedMarker instead of exporting them as they are.
+	StrictRedaction bool
+	// BodyEncryptionKey, when set, encrypts captured bodies for this public
+	// key before they leave the process; see apt.EncryptedBody.
+	BodyEncryptionKey *rsa.PublicKey
+	// GeoResolver, when set, records the country, region and city of each
+	// request's client IP, see apt.GeoResolver.
+	GeoResolver apt.GeoResolver
+	// ProbesMetadataOnly captures requests classified as health probes
+	// without their bodies.
+	ProbesMetadataOnly bool
+	// PreflightMetadataOnly captures CORS preflight requests without their
+	// bodies, and DropPreflight doesn't report them at all.
+	PreflightMetadataOnly bool
+	DropPreflight         bool
+	// MaxAttributeValueLength and MaxAttributes bound the attributes each
+	// span gets, see apt.Config.MaxAttributeValueLength.
+	MaxAttributeValueLength int
+	MaxAttributes           int
+	// IdempotencyKeyHeader, e.g. "Idempotency-Key", links requests reusing
+	// an idempotency key within IdempotencyWindow as retries, see
+	// apt.Config.IdempotencyKeyHeader.
+	IdempotencyKeyHeader string
+	IdempotencyWindow    time.Duration
+	// DebugCaptureSecret lets requests with a signed apt.DebugCaptureHeader
+	// be captured in full, see apt.Config.DebugCaptureSecret.
+	DebugCaptureSecret []byte
+	// ReuseExistingSpan records payloads on the server span of otelhttp,
+	// otelmux or similar instrumentation already in place instead of
+	// nesting a second span, see apt.Config.ReuseExistingSpan.
+	ReuseExistingSpan bool
+	// PayloadSchemaVersion pins the payload schema exported, e.g.
+	// apt.PayloadSchemaV1 for older backends, see
+	// apt.Config.PayloadSchemaVersion.
+	PayloadSchemaVersion int
+	// BatchExport exports successful requests as records batched on one
+	// span per interval, see apt.BatchExport.
+	BatchExport apt.BatchExport
+	// HeartbeatInterval, when set, exports a heartbeat span linked to each
+	// request still running once per interval, see apt.StartHeartbeat.
+	HeartbeatInterval time.Duration
+	// DetectLeaks logs request contexts and capture buffers still referenced
+	// after their request ended, see apt.LeakCheck. Debug turns it on too.
+	DetectLeaks bool
+	// SLOs are the service level objectives each payload is checked against,
+	// see apt.SLO.
+	SLOs []apt.SLO
+	// NDJSONMaxLines is how many lines of newline-delimited JSON bodies are
+	// captured, apt.DefaultNDJSONMaxLines when zero.
+	NDJSONMaxLines int
+	// JWTClaims names the claims of the request's bearer JWT recorded on
+	// payloads, e.g. apt.DefaultJWTClaims. The token itself stays redacted.
+	JWTClaims []string
+	// TraceStateKeys names the members of the incoming tracestate, e.g. a
+	// vendor's sampling hints, recorded as attributes of their own.
+	TraceStateKeys []string
+	// IdentityFunc, when set, resolves the caller of each request, e.g. the
+	// customer and plan of its API key, recorded on its payload.
+	IdentityFunc func(*http.Request) apt.Identity
+	// TenantFunc, when set, returns the tenant of each request of a
+	// multi-tenant API, e.g. the organization of its API key, recorded on
+	// its payload and charged with TenantBudget.
+	TenantFunc func(*http.Request) string
+	// TenantBudget limits what each tenant's requests export per minute, so
+	// one noisy tenant can't use up the telemetry budget of the others.
+	TenantBudget apt.TenantBudget
+	// GRPCSystemCalls is how gRPC health checks and reflection calls to a
+	// gRPC server mounted behind the middleware are reported. By default
+	// they are skipped.
+	GRPCSystemCalls apt.GRPCSystemCalls
+	// ConsentFunc, when set, decides per request how much of it is captured,
+	// e.g. metadata only for EU traffic or users who haven't consented.
+	ConsentFunc func(*http.Request) apt.CaptureLevel
+	// HandlerTimeout, when set, gives handlers at most this long to respond.
+	// On expiry the client receives a 503 and the timeout is reported with the
+	// route and elapsed time. Responses are buffered until the handler returns,
+	// as with http.TimeoutHandler, so errors the handler returns are rendered
+	// with the echo error handler before it does. As with echo's Timeout
+	// middleware, a handler that timed out keeps running and must not use its
+	// echo.Context once its request's context is done.
+	HandlerTimeout time.Duration
+	// ConcurrencyLimit, when MaxConcurrent is set, caps the handlers running
+	// at once. Requests queue for a slot for up to MaxQueueWait and are shed
+	// with a 503 after that; queue wait and shedding are recorded in the payload.
+	ConcurrencyLimit apt.ConcurrencyLimit
 }
 
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
+// TagDataSubject records that the request being handled in ctx concerns the
+// given data subject, see apt.TagDataSubject.
+func TagDataSubject(ctx context.Context, subjectID string) {
+	apt.TagDataSubject(ctx, subjectID)
+}
+
+// ForceSample marks the request being handled in ctx for full capture, see
+// apt.ForceSample.
+func ForceSample(ctx context.Context) {
+	apt.ForceSample(ctx)
+}
+
+// SetFeatureFlags records the flag variations active for the request being
+// handled in ctx, see apt.SetFeatureFlags.
+func SetFeatureFlags(ctx context.Context, flags map[string]string) {
+	apt.SetFeatureFlags(ctx, flags)
+}
+
+// SetExperiment records that the request being handled in ctx was assigned
+// variant of the A/B experiment name, see apt.SetExperiment.
+func SetExperiment(ctx context.Context, name, variant string) {
+	apt.SetExperiment(ctx, name, variant)
+}
+
+// Experiments returns a middleware recording the experiment assignments each
+// request carries in source's header or cookie, see apt.ExperimentSource.
+// Register it after Middleware.
+func Experiments(source apt.ExperimentSource) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			apt.SetExperiments(ctx.Request().Context(), apt.ExperimentAssignments(ctx.Request(), source))
+			return next(ctx)
+		}
+	}
+}
+
+// Stage returns a middleware timing the layers registered after it as the
+// stage called name, e.g. "auth" or "rate_limit", so the payload shows which
+// layer of a deep chain is slow, see apt.EnterStage. Register stages after
+// Middleware.
+func Stage(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			exit := apt.EnterStage(ctx.Request().Context(), name)
+			defer exit()
+			return next(ctx)
+		}
+	}
+}
+
 // EchoMiddleware middleware for echo framework, collects requests, response and publishes the payload
 func Middleware(config Config) echo.MiddlewareFunc {
+	limiter := apt.NewConcurrencyLimiter(config.ConcurrencyLimit)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) (err error) {
-			tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-			newCtx, span := tracer.Start(ctx.Request().Context(), "monoscope.http", trace.WithSpanKind(trace.SpanKindServer))
-			defer span.End()
+			level := apt.CaptureFull
+			if config.ConsentFunc != nil {
+				level = config.ConsentFunc(ctx.Request())
+			}
+			level = config.GRPCSystemCalls.Restrict(level, ctx.Request().URL.Path)
+			if level == apt.CaptureNone {
+				return next(ctx)
+			}
+			aptConfig := getAptConfig(config)
+			apt.ApplyCaptureLevel(&aptConfig, level)
+			start := apt.Now(aptConfig)
+			parentCtx := apt.ApplyDebugCapture(ctx.Request().Context(), &aptConfig, ctx.Request().Header.Get(apt.DebugCaptureHeader))
+			newCtx, span, ownSpan := apt.StartServerSpan(parentCtx, aptConfig, propagation.HeaderCarrier(ctx.Request().Header))
+			// A reused span belongs to the instrumentation that started it. The span
+			// of a payload ShouldExport drops is marked with DropSpan before it ends.
+			defer func() {
+				if ownSpan {
+					span.End()
+				}
+			}()
 
-			msgID := uuid.New()
+			// A message ID already in the context means another Monoscope layer
+			// (e.g. an HTTP middleware in front of an in-process gRPC-Gateway) is
+			// reporting this request, so this payload is recorded as its child.
+			parentID := apt.ParentMessageID(newCtx)
+			msgID := apt.NewMessageID(aptConfig)
+			// These framework-local copies under the legacy string keys are
+			// deprecated: handlers should read apt.MessageIDFrom and
+			// apt.ErrorListFrom on the request context. They are kept until the
+			// next major release.
 			ctx.Set(string(apt.CurrentRequestMessageID), msgID)
 
 			errorList := []apt.ATError{}
 			ctx.Set(string(apt.ErrorListCtxKey), &errorList)
-			newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
-			newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
+			newCtx = apt.WithErrorList(newCtx, &errorList)
+			newCtx = apt.WithMessageID(newCtx, msgID)
+			newCtx = apt.ContextWithConfig(newCtx, aptConfig)
+			newCtx = apt.ContextWithAnnotations(newCtx)
+			newCtx, leaks := apt.StartLeakCheck(newCtx, aptConfig, ctx.Request().Method+" "+ctx.Request().URL.Path)
+			defer leaks.End()
 
 			// add span context to the request context
 			ctx.SetRequest(ctx.Request().WithContext(newCtx))
 
-			var reqBuf []byte
-			// safely read request body
-			if ctx.Request().Body != nil {
-				reqBuf, _ = io.ReadAll(ctx.Request().Body)
-			}
-			ctx.Request().Body = io.NopCloser(bytes.NewBuffer(reqBuf))
-			// create a MultiWriter that streams the response body into resBody
+			reqBuf, body, reqIncomplete, _ := apt.ReadRequestBody(ctx.Request().Body)
+			ctx.Request().Body = body
+			span.AddEvent(apt.EventRequestBodyRead)
+			// wrap the writer so the response body streams into resBody as well,
+			// unless response bodies aren't captured
 			resBody := new(bytes.Buffer)
-			mw := io.MultiWriter(ctx.Response().Writer, resBody)
-			writer := &echoBodyLogWriter{Writer: mw, ResponseWriter: ctx.Response().Writer}
+			apt.TrackLeak(leaks, "response body buffer", resBody)
+			writer := &echoBodyLogWriter{body: resBody, ResponseWriter: ctx.Response().Writer, span: span,
+				checked: !aptConfig.CaptureResponseBody, skipBody: !aptConfig.CaptureResponseBody}
 			ctx.Response().Writer = writer
+			stopHeartbeat := apt.StartHeartbeat(aptConfig, span, &writer.written)
+			defer stopHeartbeat()
 			pathParams := map[string]string{}
 			for _, paramName := range ctx.ParamNames() {
 				pathParams[paramName] = ctx.Param(paramName)
 			}
-			aptConfig := apt.Config{
-				ServiceName:         config.ServiceName,
-				ServiceVersion:      config.ServiceVersion,
-				Tags:                config.Tags,
-				CaptureRequestBody:  config.CaptureRequestBody,
-				CaptureResponseBody: config.CaptureResponseBody,
-				RedactHeaders:       config.RedactHeaders,
-				RedactRequestBody:   config.RedactRequestBody,
-				RedactResponseBody:  config.RedactResponseBody,
+
+			// A handler that timed out may still be running, so its request
+			// isn't read back from ctx.
+			timedOut, shed := false, false
+			var queueWait time.Duration
+			req := ctx.Request()
+			report := func(statusCode int, panicInfo *apt.PanicInfo) {
+				if !timedOut {
+					req = ctx.Request()
+				}
+				payload := apt.BuildPayload(apt.GoDefaultSDKType,
+					req, statusCode,
+					reqBuf, resBody.Bytes(), writer.sentHeader(),
+					pathParams, ctx.Path(),
+					config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
+					errorList,
+					msgID,
+					parentID,
+					aptConfig,
+				)
+				payload.Panic = panicInfo
+				payload.HandlerTimedOut = timedOut
+				payload.QueueWait = queueWait
+				payload.Shed = shed
+				payload.RequestBodyIncomplete = reqIncomplete
+				apt.ApplyWriteStatus(&payload, &writer.written)
+				apt.ApplyResponseCompression(&payload, writer.sentHeader(), writer.Header())
+				payload.MetadataOnly = level == apt.CaptureMetadataOnly
+				if apt.ShouldExport(aptConfig, &payload, apt.Now(aptConfig).Sub(start)) {
+					apt.CreateSpan(payload, aptConfig, span)
+					apt.RecordRequestDuration(newCtx, aptConfig, payload, apt.Now(aptConfig).Sub(start))
+				} else if ownSpan {
+					apt.DropSpan(span)
+				}
 			}
 
 			defer func() {
-				if err := recover(); err != nil {
-					if _, ok := err.(error); !ok {
-						err = errors.New(err.(string))
-					}
-					apt.ReportError(ctx.Request().Context(), err.(error))
-					payload := apt.BuildPayload(apt.GoDefaultSDKType,
-						ctx.Request(), 500,
-						reqBuf, resBody.Bytes(), ctx.Response().Header().Clone(),
-						pathParams, ctx.Path(),
-						config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-						errorList,
-						msgID,
-						nil,
-						aptConfig,
-					)
-					apt.CreateSpan(payload, aptConfig, span)
-					panic(err)
+				if recovered := recover(); recovered != nil {
+					report(500, apt.NewPanicInfo(recovered, aptConfig))
+					panic(recovered)
 				}
 			}()
 
+			var route string
+			if config.ConcurrencyLimit.PerRoute {
+				route = ctx.Path()
+			}
+			release, wait, admitted := limiter.Acquire(newCtx, route)
+			queueWait = wait
+			if !admitted {
+				shed = true
+				err = ctx.NoContent(http.StatusServiceUnavailable)
+				report(http.StatusServiceUnavailable, nil)
+				return err
+			}
+			defer release()
+
 			// pass on request handling
-			err = next(ctx)
+			span.AddEvent(apt.EventHandlerStart)
+			if config.HandlerTimeout > 0 {
+				handlerStart := apt.Now(aptConfig)
+				timedOut = serveWithTimeout(ctx, next, config.HandlerTimeout)
+				if timedOut {
+					apt.ReportError(newCtx, &apt.HandlerTimeoutError{
+						Method:  req.Method,
+						Route:   ctx.Path(),
+						Timeout: config.HandlerTimeout,
+						Elapsed: apt.Now(aptConfig).Sub(handlerStart),
+					})
+				}
+			} else {
+				err = next(ctx)
+			}
+			span.AddEvent(apt.EventHandlerComplete)
 
 			// proceed post-response processing
-			payload := apt.BuildPayload(apt.GoDefaultSDKType,
-				ctx.Request(), ctx.Response().Status,
-				reqBuf, resBody.Bytes(), ctx.Response().Header().Clone(),
-				pathParams, ctx.Path(),
-				config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-				errorList,
-				msgID,
-				nil,
-				aptConfig,
-			)
-			apt.CreateSpan(payload, aptConfig, span)
+			statusCode := ctx.Response().Status
+			if timedOut {
+				statusCode = http.StatusServiceUnavailable
+			}
+			report(statusCode, nil)
 			return err
 		}
 	}
 }
 
-func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+// serveWithTimeout serves ctx with next through apt.ServeWithTimeout,
+// rendering the error next returns with the echo error handler while the
+// response is still buffered. It reports whether the timeout expired; ctx's
+// writer, and the context of its request, are only restored when it didn't,
+// as the handler may still be using them otherwise.
+func serveWithTimeout(ctx echo.Context, next echo.HandlerFunc, timeout time.Duration) bool {
+	req, res := ctx.Request(), ctx.Response().Writer
+	// started orders the handler's setup of ctx before this returns, even
+	// when it times out.
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx.SetRequest(r)
+		ctx.Response().Writer = w
+		close(started)
+		if err := next(ctx); err != nil && r.Context().Err() == nil {
+			ctx.Error(err)
+		}
+	})
+	timedOut := apt.ServeWithTimeout(res, req, h, timeout)
+	<-started
+	if !timedOut {
+		ctx.SetRequest(ctx.Request().WithContext(req.Context()))
+		ctx.Response().Writer = res
+	}
+	return timedOut
+}
+
+func getAptConfig(config Config) apt.Config {
+	return apt.Config{
+		ServiceName:             config.ServiceName,
+		ServiceVersion:          config.ServiceVersion,
+		Tags:                    config.Tags,
+		Debug:                   config.Debug,
+		CaptureRequestBody:      config.CaptureRequestBody,
+		CaptureResponseBody:     config.CaptureResponseBody,
+		RedactHeaders:           config.RedactHeaders,
+		RedactRequestBody:       config.RedactRequestBody,
+		RedactResponseBody:      config.RedactResponseBody,
+		CaptureRequestHeaders:   config.CaptureRequestHeaders,
+		CaptureResponseHeaders:  config.CaptureResponseHeaders,
+		RouteTags:               config.RouteTags,
+		SPAFallbackRoutes:       config.SPAFallbackRoutes,
+		SPAFallbackExclude:      config.SPAFallbackExclude,
+		RedactQueryParams:       config.RedactQueryParams,
+		TracerProvider:          config.TracerProvider,
+		Propagators:             config.Propagators,
+		Now:                     config.Now,
+		NewMessageID:            config.NewMessageID,
+		UseUUIDv7:               config.UseUUIDv7,
+		ErrorDedupWindow:        config.ErrorDedupWindow,
+		SemanticConventions:     config.SemanticConventions,
+		MeterProvider:           config.MeterProvider,
+		APIKey:                  config.APIKey,
+		Endpoint:                config.Endpoint,
+		PayloadHook:             config.PayloadHook,
+		StrictRedaction:         config.StrictRedaction,
+		BodyEncryptionKey:       config.BodyEncryptionKey,
+		GeoResolver:             config.GeoResolver,
+		ProbesMetadataOnly:      config.ProbesMetadataOnly,
+		PreflightMetadataOnly:   config.PreflightMetadataOnly,
+		DropPreflight:           config.DropPreflight,
+		MaxAttributeValueLength: config.MaxAttributeValueLength,
+		MaxAttributes:           config.MaxAttributes,
+		IdempotencyKeyHeader:    config.IdempotencyKeyHeader,
+		IdempotencyWindow:       config.IdempotencyWindow,
+		DebugCaptureSecret:      config.DebugCaptureSecret,
+		ReuseExistingSpan:       config.ReuseExistingSpan,
+		PayloadSchemaVersion:    config.PayloadSchemaVersion,
+		BatchExport:             config.BatchExport,
+		HeartbeatInterval:       config.HeartbeatInterval,
+		DetectLeaks:             config.DetectLeaks,
+		SLOs:                    config.SLOs,
+		NDJSONMaxLines:          config.NDJSONMaxLines,
+		JWTClaims:               config.JWTClaims,
+		TraceStateKeys:          config.TraceStateKeys,
+		IdentityFunc:            config.IdentityFunc,
+		TenantFunc:              config.TenantFunc,
+		TenantBudget:            config.TenantBudget,
+	}
+}
+
+// InitOTel sets up OpenTelemetry directly on its SDK, see apt.InitOTel.
+func InitOTel(ctx context.Context, opts apt.OTelOptions) (shutdown func(context.Context) error, err error) {
+	return apt.InitOTel(ctx, opts)
+}
+
+// ConfigureOpenTelemetry initializes OpenTelemetry with default options and any additional options.
+// Returns a shutdown function to flush telemetry and an error if initialization fails.
+// It is kept for compatibility, see apt.ConfigureOpenTelemetry; InitOTel offers the same options natively.
+func ConfigureOpenTelemetry(opts ...apt.OTelOption) (func(), error) {
+	return apt.ConfigureOpenTelemetry(opts...)
 }
 
 var (
-	WithServiceName            = otelconfig.WithServiceName
-	WithServiceVersion         = otelconfig.WithServiceVersion
-	WithLogLevel               = otelconfig.WithLogLevel
-	WithResourceAttributes     = otelconfig.WithResourceAttributes
-	WithResourceOption         = otelconfig.WithResourceOption
-	WithPropagators            = otelconfig.WithPropagators
-	WithErrorHandler           = otelconfig.WithErrorHandler
-	WithMetricsReportingPeriod = otelconfig.WithMetricsReportingPeriod
-	WithMetricsEnabled         = otelconfig.WithMetricsEnabled
-	WithTracesEnabled          = otelconfig.WithTracesEnabled
-	WithSpanProcessor          = otelconfig.WithSpanProcessor
-	WithSampler                = otelconfig.WithSampler
+	WithServiceName            = apt.WithServiceName
+	WithServiceVersion         = apt.WithServiceVersion
+	WithLogLevel               = apt.WithLogLevel
+	WithResourceAttributes     = apt.WithResourceAttributes
+	WithResourceOption         = apt.WithResourceOption
+	WithPropagators            = apt.WithPropagators
+	WithErrorHandler           = apt.WithErrorHandler
+	WithMetricsReportingPeriod = apt.WithMetricsReportingPeriod
+	WithMetricsEnabled         = apt.WithMetricsEnabled
+	WithTracesEnabled          = apt.WithTracesEnabled
+	WithSpanProcessor          = apt.WithSpanProcessor
+	WithSampler                = apt.WithSampler
 )
 
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
@@ -169,4 +546,6 @@ var (
 	WithRedactHeaders      = apt.WithRedactHeaders
 	WithRedactRequestBody  = apt.WithRedactRequestBody
 	WithRedactResponseBody = apt.WithRedactResponseBody
+	WithRedactQueryParams  = apt.WithRedactQueryParams
+	WithStrictRedaction    = apt.WithStrictRedaction
 )
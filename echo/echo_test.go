@@ -0,0 +1,155 @@
+package monoscopeecho
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	release := make(chan struct{})
+	defer close(release)
+	e := echo.New()
+	e.Use(Middleware(Config{TracerProvider: tp, HandlerTimeout: 20 * time.Millisecond}))
+	e.GET("/slow/:id", func(c echo.Context) error {
+		<-release
+		return nil
+	})
+	e.GET("/fast", func(c echo.Context) error {
+		ReportError(c.Request().Context(), errors.New("handled"))
+		return c.NoContent(http.StatusAccepted)
+	})
+	e.GET("/teapot", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot, "short and stout")
+	})
+
+	tests := []struct {
+		path       string
+		status     int
+		timedOut   bool
+		errMessage string
+	}{
+		{path: "/slow/1", status: http.StatusServiceUnavailable, timedOut: true, errMessage: "GET /slow/:id timed out"},
+		{path: "/fast", status: http.StatusAccepted, errMessage: "handled"},
+		{path: "/teapot", status: http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			exporter.Reset()
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[string]string{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+			if got := attrs["apitoolkit.handler_timed_out"] == "true"; got != tt.timedOut {
+				t.Errorf("Expected handler_timed_out %v, got %v", tt.timedOut, got)
+			}
+			if got := attrs["http.response.status_code"]; got != strconv.Itoa(tt.status) {
+				t.Errorf("Expected status %d recorded, got %s", tt.status, got)
+			}
+			if !strings.Contains(attrs["apitoolkit.errors"], tt.errMessage) {
+				t.Errorf("Expected an error containing %q, got %s", tt.errMessage, attrs["apitoolkit.errors"])
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	for _, tt := range []struct {
+		name     string
+		maxWait  time.Duration
+		status   int
+		shed     bool
+		queueing bool
+	}{
+		{name: "shed when full", status: http.StatusServiceUnavailable, shed: true},
+		{name: "queue for a slot", maxWait: 5 * time.Second, status: http.StatusOK, queueing: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			entered, release := make(chan struct{}), make(chan struct{})
+			router := echo.New()
+			router.Use(Middleware(Config{
+				TracerProvider:   tp,
+				ConcurrencyLimit: apt.ConcurrencyLimit{MaxConcurrent: 1, MaxQueueWait: tt.maxWait},
+			}))
+			router.GET("/busy", func(c echo.Context) error {
+				entered <- struct{}{}
+				<-release
+				return nil
+			})
+			router.GET("/other", func(c echo.Context) error { return nil })
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/busy", nil))
+			}()
+			<-entered
+			if tt.queueing {
+				time.AfterFunc(20*time.Millisecond, func() { close(release) })
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+			if !tt.queueing {
+				close(release)
+			}
+			<-done
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+
+			var attrs map[string]string
+			for _, span := range exporter.GetSpans() {
+				spanAttrs := map[string]string{}
+				for _, attr := range span.Attributes {
+					spanAttrs[string(attr.Key)] = attr.Value.Emit()
+				}
+				if spanAttrs["http.route"] == "/other" {
+					attrs = spanAttrs
+				}
+			}
+			if attrs == nil {
+				t.Fatal("Expected a span for the limited request")
+			}
+			if got := attrs["apitoolkit.shed"] == "true"; got != tt.shed {
+				t.Errorf("Expected shed %v, got %v", tt.shed, got)
+			}
+			if _, got := attrs["apitoolkit.queue_wait_ms"]; got != tt.queueing {
+				t.Errorf("Expected queue wait recorded %v, got %v", tt.queueing, got)
+			}
+		})
+	}
+}
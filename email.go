@@ -0,0 +1,178 @@
+package monoscope
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EmailSender sends an email message. gomail's Sender and SendCloser
+// implement it, as do other mailers with the same Send method.
+type EmailSender interface {
+	Send(from string, to []string, msg io.WriterTo) error
+}
+
+// Email describes an outgoing email recorded by SendEmail or SendMail. The
+// recipients' addresses are never recorded, only their domains.
+type Email struct {
+	// RecipientDomains are the distinct domains of the recipients, sorted.
+	RecipientDomains []string `json:"recipient_domains"`
+	Recipients       int      `json:"recipients"`
+	// TemplateID identifies the template the message was rendered from, see
+	// WithEmailTemplate.
+	TemplateID string `json:"template_id,omitempty"`
+	// Size is the size of the message, headers included.
+	Size int64 `json:"size"`
+}
+
+// EmailOption configures how SendEmail and SendMail record an email.
+type EmailOption func(*emailConfig)
+
+type emailConfig struct {
+	templateID string
+	server     string
+}
+
+// WithEmailTemplate records the ID of the template the message was rendered
+// from, so failures can be grouped by notification type.
+func WithEmailTemplate(id string) EmailOption {
+	return func(c *emailConfig) {
+		c.templateID = id
+	}
+}
+
+// WithEmailServer records the address of the SMTP server the sender
+// delivers to. SendMail records its addr.
+func WithEmailServer(addr string) EmailOption {
+	return func(c *emailConfig) {
+		c.server = addr
+	}
+}
+
+// SendEmail sends msg with s and records the delivery as a PRODUCER span
+// under the span in ctx, usually that of the request that triggered the
+// email, with its recipient domains, template and size. The status recorded
+// is 250 when the message was accepted, the server's reply code when it
+// was rejected, and 554 for other delivery errors, which are also reported
+// on ctx with ReportError.
+func SendEmail(ctx context.Context, s EmailSender, from string, to []string, msg io.WriterTo, opts ...EmailOption) error {
+	var size int64
+	return sendEmail(ctx, to, &size, func() error {
+		return s.Send(from, to, &countingWriterTo{msg: msg, n: &size})
+	}, opts)
+}
+
+// SendMail sends msg through the SMTP server at addr as smtp.SendMail does,
+// recording the delivery like SendEmail.
+func SendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte, opts ...EmailOption) error {
+	size := int64(len(msg))
+	opts = append([]EmailOption{WithEmailServer(addr)}, opts...)
+	return sendEmail(ctx, to, &size, func() error {
+		return smtp.SendMail(addr, a, from, to, msg)
+	}, opts)
+}
+
+// sendEmail records the delivery made by send to the recipients to. size is
+// read once send returns.
+func sendEmail(ctx context.Context, to []string, size *int64, send func() error, opts []EmailOption) error {
+	var ec emailConfig
+	for _, opt := range opts {
+		opt(&ec)
+	}
+	config := configFromContext(ctx)
+	spanCtx, span := StartSpan(ctx, config, trace.SpanKindProducer)
+	defer span.End()
+
+	err := send()
+	status := 250
+	var errorList []ATError
+	if err != nil {
+		ReportError(ctx, err)
+		errorList = append(errorList, BuildError(err))
+		status = 554
+		var reply *textproto.Error
+		if errors.As(err, &reply) {
+			status = reply.Code
+		}
+	}
+
+	req, reqErr := http.NewRequestWithContext(spanCtx, "SEND", "smtp://"+ec.server, nil)
+	if reqErr != nil {
+		return err
+	}
+	email := &Email{
+		RecipientDomains: recipientDomains(to),
+		Recipients:       len(to),
+		TemplateID:       ec.templateID,
+		Size:             *size,
+	}
+	payload := BuildPayloadFromInput(PayloadInput{
+		SDKType:    GoOutgoing,
+		Request:    req,
+		StatusCode: status,
+		Route:      ec.templateID,
+		Errors:     errorList,
+		ParentID:   ParentMessageID(ctx),
+		Config:     config,
+	})
+	payload.SpanKind = trace.SpanKindProducer
+	payload.Messaging = &Messaging{System: "smtp", Destination: strings.Join(email.RecipientDomains, ",")}
+	payload.Email = email
+	CreateSpan(payload, config, span)
+	return err
+}
+
+// recipientDomains returns the distinct domains of the addresses in to,
+// lowercased and sorted. Addresses may carry a display name.
+func recipientDomains(to []string) []string {
+	domains := make([]string, 0, len(to))
+	for _, addr := range to {
+		if parsed, err := mail.ParseAddress(addr); err == nil {
+			addr = parsed.Address
+		}
+		at := strings.LastIndexByte(addr, '@')
+		if at < 0 {
+			continue
+		}
+		domain := strings.ToLower(strings.TrimRight(strings.TrimSpace(addr[at+1:]), ">"))
+		if domain != "" && !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	return domains
+}
+
+// countingWriterTo counts the bytes msg writes.
+type countingWriterTo struct {
+	msg io.WriterTo
+	n   *int64
+}
+
+func (c *countingWriterTo) WriteTo(w io.Writer) (int64, error) {
+	n, err := c.msg.WriteTo(w)
+	*c.n += n
+	return n, err
+}
+
+// emailAttributes returns the span attributes recording email.
+func emailAttributes(email *Email) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.StringSlice("apitoolkit.email.recipient_domains", email.RecipientDomains),
+		attribute.Int("apitoolkit.email.recipients", email.Recipients),
+		attribute.Int64("apitoolkit.email.size", email.Size),
+	}
+	if email.TemplateID != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.email.template_id", email.TemplateID))
+	}
+	return attrs
+}
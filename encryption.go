@@ -0,0 +1,111 @@
+package monoscope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// BodyEncryptionAlgorithm identifies the envelope scheme of encrypted bodies:
+// each body is sealed with a fresh AES-256-GCM key, which is itself encrypted
+// with RSA-OAEP (SHA-256) under Config.BodyEncryptionKey.
+const BodyEncryptionAlgorithm = "RSA-OAEP-256+A256GCM"
+
+// EncryptedBody is the JSON envelope captured in place of a request or
+// response body when Config.BodyEncryptionKey is set. KeyID is the hex
+// SHA-256 fingerprint of the PKIX-encoded public key, so tooling holding
+// several private keys can pick the right one.
+type EncryptedBody struct {
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"kid"`
+	Key        []byte `json:"key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptBody seals body in an EncryptedBody envelope for key.
+func EncryptBody(body []byte, key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(der)
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(EncryptedBody{
+		Algorithm:  BodyEncryptionAlgorithm,
+		KeyID:      hex.EncodeToString(fingerprint[:]),
+		Key:        wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, body, nil),
+	})
+}
+
+// DecryptBody opens an envelope produced by EncryptBody with the private half
+// of the key it was encrypted for.
+func DecryptBody(envelope []byte, key *rsa.PrivateKey) ([]byte, error) {
+	var sealed EncryptedBody
+	if err := json.Unmarshal(envelope, &sealed); err != nil {
+		return nil, err
+	}
+	if sealed.Algorithm != BodyEncryptionAlgorithm {
+		return nil, errors.New("monoscope: unsupported body encryption algorithm " + sealed.Algorithm)
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, sealed.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCapturedBody encrypts a captured body for config.BodyEncryptionKey.
+// Empty bodies are left as they are. A body that can't be encrypted is
+// dropped rather than exported in the clear.
+func encryptCapturedBody(body []byte, config Config) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	sealed, err := EncryptBody(body, config.BodyEncryptionKey)
+	if err != nil {
+		selfMetrics.bodiesDropped.Add(1)
+		if config.Debug {
+			log.Printf("monoscope: dropping body that failed to encrypt: %v", err)
+		}
+		return []byte{}
+	}
+	return sealed
+}
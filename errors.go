@@ -2,12 +2,17 @@ package monoscope
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	gerrors "github.com/go-errors/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ATError is the Apitoolkit error type/object
@@ -18,38 +23,115 @@ type ATError struct {
 	Message          string    `json:"message,omitempty"`
 	RootErrorMessage string    `json:"root_error_message,omitempty"`
 	StackTrace       string    `json:"stack_trace,omitempty"`
+	// Occurrences is the number of times the error was reported since it was
+	// last exported, when Config.ErrorDedupWindow suppressed some of them.
+	Occurrences int `json:"occurrences,omitempty"`
 }
 
 // ReportError Allows you to report an error from your server to APIToolkit.
 // This error would be associated with a given request,
-// and helps give a request more context especially when investigating incidents
+// and helps give a request more context especially when investigating incidents.
+// Errors reported outside a request, either through a DetachContext context or
+// a context the middleware never saw, are exported on their own span.
 func ReportError(ctx context.Context, err error) {
 	if err == nil {
 		return
 	}
+	selfMetrics.errorsReported.Add(1)
 
-	errorList, ok := ctx.Value(ErrorListCtxKey).(*[]ATError)
-	if !ok {
-		log.Printf("APIToolkit: ErrorList context key was not found in the context. Is the middleware configured correctly? Error will not be notified. Error: %v \n", err)
+	_, detached := ctx.Value(detachedSpanCtxKey).(trace.Span)
+	errorList := ErrorListFrom(ctx)
+	wired := errorList != nil
+	config := configFromContext(ctx)
+	if !detached && !wired {
+		config = fallbackConfig()
+		if config.Debug {
+			log.Printf("APIToolkit: ErrorList context key was not found in the context. Is the middleware configured correctly? Reporting the error on a standalone span. Error: %v \n", err)
+		}
+	}
+
+	atError := buildError(err, Now(config), 2)
+	if !errorDedup.admit(&atError, config.ErrorDedupWindow) {
+		return
+	}
+	if detached || !wired {
+		reportStandaloneError(ctx, config, err, atError)
 		return
 	}
+	errorListMu.Lock()
+	*errorList = append(*errorList, atError)
+	errorListMu.Unlock()
+}
+
+// errorListMu guards appends to request error lists, as handlers may report
+// errors from several goroutines at once, e.g. through monoscopex.Group.
+var errorListMu sync.Mutex
+
+var fallback atomic.Pointer[Config]
+
+// SetFallbackConfig sets the Config used by ReportError for contexts that
+// didn't pass through a Monoscope middleware, such as init code and
+// background workers. Its service metadata and tracer provider are applied
+// to the standalone error spans; with Debug set, each such report also logs a
+// warning that the context wasn't wired.
+func SetFallbackConfig(config Config) {
+	fallback.Store(&config)
+}
+
+// fallbackConfig returns the Config set with SetFallbackConfig, or the zero
+// Config when none was set.
+func fallbackConfig() Config {
+	if config := fallback.Load(); config != nil {
+		return *config
+	}
+	return Config{}
+}
 
-	*errorList = append(*errorList, BuildError(err))
+// reportStandaloneError exports err on its own "monoscope.error" span carrying
+// the service metadata from config. Errors from detached contexts are linked
+// to the request span they came from; others become children of whatever
+// span is active in ctx.
+func reportStandaloneError(ctx context.Context, config Config, err error, atError ATError) {
+	var span trace.Span
+	if _, detached := ctx.Value(detachedSpanCtxKey).(trace.Span); detached {
+		_, span = StartLinkedSpan(ctx, "monoscope.error", trace.WithSpanKind(trace.SpanKindInternal))
+	} else {
+		_, span = Tracer(config).Start(ctx, "monoscope.error", trace.WithSpanKind(trace.SpanKindInternal))
+	}
+	defer span.End()
+
+	atErrors, _ := json.Marshal([]ATError{atError})
+	span.SetAttributes(
+		attribute.String("apitoolkit.errors", string(atErrors)),
+		attribute.String("apitoolkit.service_version", config.ServiceVersion),
+		attribute.StringSlice("apitoolkit.tags", config.Tags),
+	)
+	if config.ServiceName != "" {
+		span.SetAttributes(attribute.String("service.name", config.ServiceName))
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 }
 
 func BuildError(err error) ATError {
+	return buildError(err, time.Now(), 3)
+}
+
+// buildError builds an ATError stamped with when. skip is passed to
+// errorStack: 0 starts the stack trace at buildError itself, 1 at its caller.
+func buildError(err error, when time.Time, skip int) ATError {
 	errType := reflect.TypeOf(err).String()
 
 	rootError := rootCause(err)
 	rootErrorType := reflect.TypeOf(rootError).String()
-	errW := gerrors.Wrap(err, 2)
+	message, stack := errorStack(err, skip)
 	return ATError{
-		When:             time.Now(),
+		When:             when,
 		ErrorType:        errType,
 		RootErrorType:    rootErrorType,
 		RootErrorMessage: rootError.Error(),
-		Message:          errW.Error(),
-		StackTrace:       errW.ErrorStack(),
+		Message:          message,
+		StackTrace:       stack,
 	}
 }
 
@@ -0,0 +1,12 @@
+//go:build !monoscope_core_only
+
+package monoscope
+
+import gerrors "github.com/go-errors/errors"
+
+// errorStack returns the message of err and its stack trace, starting skip
+// frames above errorStack's caller, or where err was wrapped by go-errors.
+func errorStack(err error, skip int) (message, stack string) {
+	errW := gerrors.Wrap(err, skip+1)
+	return errW.Error(), errW.ErrorStack()
+}
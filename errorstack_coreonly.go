@@ -0,0 +1,33 @@
+//go:build monoscope_core_only
+
+package monoscope
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// maxStackDepth bounds the frames recorded in a stack trace, as go-errors
+// does.
+const maxStackDepth = 50
+
+// errorStack returns the message of err and its stack trace, starting skip
+// frames above errorStack's caller, in go-errors' ErrorStack format without
+// the source lines.
+func errorStack(err error, skip int) (message, stack string) {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(2+skip, pcs)
+	var b strings.Builder
+	b.WriteString(reflect.TypeOf(err).String() + " " + err.Error() + "\n")
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s:%d (0x%x)\n\t%s\n", frame.File, frame.Line, frame.PC, frame.Function)
+		if !more {
+			break
+		}
+	}
+	return err.Error(), b.String()
+}
@@ -0,0 +1,71 @@
+package monoscope
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// SetExperiment records that the request being handled in ctx was assigned
+// variant of the A/B experiment name, on its payload, so results can be
+// broken down per variant. It does nothing for contexts that didn't pass
+// through a Monoscope middleware.
+func SetExperiment(ctx context.Context, name, variant string) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil || name == "" {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if annotations.experiments == nil {
+		annotations.experiments = map[string]string{}
+	}
+	annotations.experiments[name] = variant
+}
+
+// ExperimentSource is where the Experiments middlewares read a request's
+// experiment assignments from: a header, or else a cookie, holding them as a
+// URL-encoded query string such as "checkout=b&pricing=control".
+type ExperimentSource struct {
+	Header string
+	Cookie string
+}
+
+// ParseExperimentAssignments parses experiment assignments in the
+// "name=variant&name2=variant2" form, returning nil when there are none.
+func ParseExperimentAssignments(value string) map[string]string {
+	values, _ := url.ParseQuery(value)
+	assignments := map[string]string{}
+	for name, variants := range values {
+		if name != "" && len(variants) > 0 {
+			assignments[name] = variants[len(variants)-1]
+		}
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+	return assignments
+}
+
+// ExperimentAssignments returns the experiment assignments req carries in
+// source's header or, when that is empty, its cookie.
+func ExperimentAssignments(req *http.Request, source ExperimentSource) map[string]string {
+	value := ""
+	if source.Header != "" {
+		value = req.Header.Get(source.Header)
+	}
+	if value == "" && source.Cookie != "" {
+		if cookie, err := req.Cookie(source.Cookie); err == nil {
+			value = cookie.Value
+		}
+	}
+	return ParseExperimentAssignments(value)
+}
+
+// SetExperiments records several experiment assignments at once, see
+// SetExperiment.
+func SetExperiments(ctx context.Context, assignments map[string]string) {
+	for name, variant := range assignments {
+		SetExperiment(ctx, name, variant)
+	}
+}
@@ -0,0 +1,38 @@
+package monoscope
+
+import (
+	"context"
+	"maps"
+)
+
+// SetFeatureFlags records the flag variations active for the request being
+// handled in ctx, keyed by flag, on its payload, so regressions can be
+// correlated with flag rollouts. Later calls add to, and override, earlier
+// ones. It does nothing for contexts that didn't pass through a Monoscope
+// middleware.
+func SetFeatureFlags(ctx context.Context, flags map[string]string) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil || len(flags) == 0 {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if annotations.featureFlags == nil {
+		annotations.featureFlags = map[string]string{}
+	}
+	maps.Copy(annotations.featureFlags, flags)
+}
+
+// RecordFlagEvaluation records the variation a single flag evaluated to for
+// the request being handled in ctx. It is meant for the evaluation hooks of
+// flag SDKs, e.g. an OpenFeature hook's After stage or a LaunchDarkly
+// evaluation hook, which see each flag as it is evaluated:
+//
+//	func (h monoscopeHook) After(ctx context.Context, hc openfeature.HookContext,
+//		details openfeature.InterfaceEvaluationDetails, _ openfeature.HookHints) error {
+//		monoscope.RecordFlagEvaluation(ctx, hc.FlagKey(), details.Variant)
+//		return nil
+//	}
+func RecordFlagEvaluation(ctx context.Context, flag, variation string) {
+	SetFeatureFlags(ctx, map[string]string{flag: variation})
+}
@@ -0,0 +1,73 @@
+package monoscopefiber
+
+import (
+	"net/http"
+	"testing"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/monoscope-tech/monoscope-go/conformance"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, conformance.Adapter{
+		Serve: func(config apt.Config, routes []conformance.Route) http.Handler {
+			app := fiber.New(fiber.Config{DisableStartupMessage: true})
+			app.Use(Middleware(Config{
+				TracerProvider:      config.TracerProvider,
+				CaptureRequestBody:  config.CaptureRequestBody,
+				CaptureResponseBody: config.CaptureResponseBody,
+				RedactHeaders:       config.RedactHeaders,
+				RedactRequestBody:   config.RedactRequestBody,
+				RedactResponseBody:  config.RedactResponseBody,
+			}))
+			for _, route := range routes {
+				app.Add(route.Method, conformance.ColonParams(route.Pattern), fiberHandler(route.Handler))
+			}
+			return adaptor.FiberApp(app)
+		},
+		Template: conformance.ColonParams,
+	})
+}
+
+// fiberHandler runs h on the handler's goroutine, unlike
+// adaptor.HTTPHandlerFunc, so its panics reach the middleware unchanged.
+func fiberHandler(h http.HandlerFunc) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req http.Request
+		if err := fasthttpadaptor.ConvertRequest(c.Context(), &req, true); err != nil {
+			return err
+		}
+		h(&fiberResponseWriter{ctx: c, header: http.Header{}}, req.WithContext(c.UserContext()))
+		return nil
+	}
+}
+
+type fiberResponseWriter struct {
+	ctx     *fiber.Ctx
+	header  http.Header
+	written bool
+}
+
+func (w *fiberResponseWriter) Header() http.Header { return w.header }
+
+func (w *fiberResponseWriter) WriteHeader(code int) {
+	if w.written {
+		return
+	}
+	w.written = true
+	for key, values := range w.header {
+		for _, value := range values {
+			w.ctx.Response().Header.Add(key, value)
+		}
+	}
+	w.ctx.Status(code)
+}
+
+func (w *fiberResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.ctx.Response().AppendBody(b)
+	return len(b), nil
+}
@@ -2,14 +2,18 @@ package monoscopefiber
 
 import (
 	"context"
+	"crypto/rsa"
 	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	fiber "github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/honeycombio/otel-config-go/otelconfig"
 	apt "github.com/monoscope-tech/monoscope-go"
-	"go.opentelemetry.io/otel"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -23,104 +27,519 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CaptureRequestHeaders and CaptureResponseHeaders, when set, limit the
+	// captured headers to the named ones (case-insensitive). Redaction still
+	// applies to the headers that are kept.
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+	// RouteTags adds tags to the requests of groups of endpoints, keyed by
+	// route template glob, e.g. {"/admin/**": {"admin"}}.
+	RouteTags map[string][]string
+	// SPAFallbackRoutes lists the route globs of catch-all routes serving a
+	// single-page app, reported as the single route "/*spa" without HTML,
+	// JavaScript and CSS bodies. SPAFallbackExclude exempts routes from it.
+	SPAFallbackRoutes  []string
+	SPAFallbackExclude []string
+	// RedactQueryParams lists query parameters whose values are redacted in
+	// the captured query parameters and raw URL.
+	RedactQueryParams []string
+	// TracerProvider is used to create Monoscope spans. When nil, the global
+	// provider from otel.GetTracerProvider is used.
+	TracerProvider trace.TracerProvider
+	// Propagators extracts the incoming trace context. When nil, the global
+	// propagator from otel.GetTextMapPropagator is used.
+	Propagators propagation.TextMapPropagator
+	// Now and NewMessageID replace time.Now and uuid.New, so tests and replay
+	// tooling can produce reproducible payloads.
+	Now          func() time.Time
+	NewMessageID func() uuid.UUID
+	// UseUUIDv7 generates time-ordered UUIDv7 message IDs instead of UUIDv4.
+	UseUUIDv7 bool
+	// ErrorDedupWindow collapses repeated reports of the same error within the
+	// window into the first one; the next report after it carries the count of
+	// suppressed occurrences. Zero reports every error.
+	ErrorDedupWindow time.Duration
+	// SemanticConventions additionally emits the standard OpenTelemetry HTTP
+	// attributes, so generic backends such as Tempo or Jaeger render the spans.
+	SemanticConventions bool
+	// MeterProvider, when set, receives request latencies in the
+	// http.server.request.duration histogram, with trace exemplars linking
+	// slow requests to their Monoscope traces.
+	MeterProvider metric.MeterProvider
+	// APIKey and Endpoint, when APIKey is set, report this middleware's spans
+	// to its own Monoscope project instead of the one configured globally, so
+	// one binary can host services belonging to different projects. Endpoint
+	// defaults to the Monoscope collector. Call monoscope.Shutdown on exit.
+	APIKey   string
+	Endpoint string
+	// PayloadHook, when set, is called with each payload before it is
+	// recorded, to modify it or, by returning nil, veto its export.
+	PayloadHook func(*apt.Payload) *apt.Payload
+	// StrictRedaction replaces bodies the redaction rules could not be applied
+	// to with apt.RedactionFailedMarker instead of exporting them as they are.
+	StrictRedaction bool
+	// BodyEncryptionKey, when set, encrypts captured bodies for this public
+	// key before they leave the process; see apt.EncryptedBody.
+	BodyEncryptionKey *rsa.PublicKey
+	// GeoResolver, when set, records the country, region and city of each
+	// request's client IP, see apt.GeoResolver.
+	GeoResolver apt.GeoResolver
+	// ProbesMetadataOnly captures requests classified as health probes
+	// without their bodies.
+	ProbesMetadataOnly bool
+	// PreflightMetadataOnly captures CORS preflight requests without their
+	// bodies, and DropPreflight doesn't report them at all.
+	PreflightMetadataOnly bool
+	DropPreflight         bool
+	// MaxAttributeValueLength and MaxAttributes bound the attributes each
+	// span gets, see apt.Config.MaxAttributeValueLength.
+	MaxAttributeValueLength int
+	MaxAttributes           int
+	// IdempotencyKeyHeader, e.g. "Idempotency-Key", links requests reusing
+	// an idempotency key within IdempotencyWindow as retries, see
+	// apt.Config.IdempotencyKeyHeader.
+	IdempotencyKeyHeader string
+	IdempotencyWindow    time.Duration
+	// DebugCaptureSecret lets requests with a signed apt.DebugCaptureHeader
+	// be captured in full, see apt.Config.DebugCaptureSecret.
+	DebugCaptureSecret []byte
+	// ReuseExistingSpan records payloads on the server span of otelhttp,
+	// otelmux or similar instrumentation already in place instead of
+	// nesting a second span, see apt.Config.ReuseExistingSpan.
+	ReuseExistingSpan bool
+	// PayloadSchemaVersion pins the payload schema exported, e.g.
+	// apt.PayloadSchemaV1 for older backends, see
+	// apt.Config.PayloadSchemaVersion.
+	PayloadSchemaVersion int
+	// BatchExport exports successful requests as records batched on one
+	// span per interval, see apt.BatchExport.
+	BatchExport apt.BatchExport
+	// HeartbeatInterval, when set, exports a heartbeat span linked to each
+	// request still running once per interval, see apt.StartHeartbeat.
+	HeartbeatInterval time.Duration
+	// SLOs are the service level objectives each payload is checked against,
+	// see apt.SLO.
+	SLOs []apt.SLO
+	// NDJSONMaxLines is how many lines of newline-delimited JSON bodies are
+	// captured, apt.DefaultNDJSONMaxLines when zero.
+	NDJSONMaxLines int
+	// JWTClaims names the claims of the request's bearer JWT recorded on
+	// payloads, e.g. apt.DefaultJWTClaims. The token itself stays redacted.
+	JWTClaims []string
+	// TraceStateKeys names the members of the incoming tracestate, e.g. a
+	// vendor's sampling hints, recorded as attributes of their own.
+	TraceStateKeys []string
+	// IdentityFunc, when set, resolves the caller of each request, e.g. the
+	// customer and plan of its API key, recorded on its payload.
+	IdentityFunc func(*fiber.Ctx) apt.Identity
+	// TenantFunc, when set, returns the tenant of each request of a
+	// multi-tenant API, e.g. the organization of its API key, recorded on
+	// its payload and charged with TenantBudget.
+	TenantFunc func(*fiber.Ctx) string
+	// TenantBudget limits what each tenant's requests export per minute, so
+	// one noisy tenant can't use up the telemetry budget of the others.
+	TenantBudget apt.TenantBudget
+	// ConsentFunc, when set, decides per request how much of it is captured,
+	// e.g. metadata only for EU traffic or users who haven't consented.
+	ConsentFunc func(*fiber.Ctx) apt.CaptureLevel
 }
 
 func getAptConfig(config Config) apt.Config {
 	return apt.Config{
-		ServiceName:         config.ServiceName,
-		ServiceVersion:      config.ServiceVersion,
-		Tags:                config.Tags,
-		Debug:               config.Debug,
-		CaptureRequestBody:  config.CaptureRequestBody,
-		CaptureResponseBody: config.CaptureResponseBody,
-		RedactHeaders:       config.RedactHeaders,
-		RedactRequestBody:   config.RedactRequestBody,
-		RedactResponseBody:  config.RedactResponseBody,
+		ServiceName:             config.ServiceName,
+		ServiceVersion:          config.ServiceVersion,
+		Tags:                    config.Tags,
+		Debug:                   config.Debug,
+		CaptureRequestBody:      config.CaptureRequestBody,
+		CaptureResponseBody:     config.CaptureResponseBody,
+		RedactHeaders:           config.RedactHeaders,
+		RedactRequestBody:       config.RedactRequestBody,
+		RedactResponseBody:      config.RedactResponseBody,
+		CaptureRequestHeaders:   config.CaptureRequestHeaders,
+		CaptureResponseHeaders:  config.CaptureResponseHeaders,
+		RouteTags:               config.RouteTags,
+		SPAFallbackRoutes:       config.SPAFallbackRoutes,
+		SPAFallbackExclude:      config.SPAFallbackExclude,
+		RedactQueryParams:       config.RedactQueryParams,
+		TracerProvider:          config.TracerProvider,
+		Propagators:             config.Propagators,
+		Now:                     config.Now,
+		NewMessageID:            config.NewMessageID,
+		UseUUIDv7:               config.UseUUIDv7,
+		ErrorDedupWindow:        config.ErrorDedupWindow,
+		SemanticConventions:     config.SemanticConventions,
+		MeterProvider:           config.MeterProvider,
+		APIKey:                  config.APIKey,
+		Endpoint:                config.Endpoint,
+		PayloadHook:             config.PayloadHook,
+		StrictRedaction:         config.StrictRedaction,
+		BodyEncryptionKey:       config.BodyEncryptionKey,
+		GeoResolver:             config.GeoResolver,
+		ProbesMetadataOnly:      config.ProbesMetadataOnly,
+		PreflightMetadataOnly:   config.PreflightMetadataOnly,
+		DropPreflight:           config.DropPreflight,
+		MaxAttributeValueLength: config.MaxAttributeValueLength,
+		MaxAttributes:           config.MaxAttributes,
+		IdempotencyKeyHeader:    config.IdempotencyKeyHeader,
+		IdempotencyWindow:       config.IdempotencyWindow,
+		DebugCaptureSecret:      config.DebugCaptureSecret,
+		ReuseExistingSpan:       config.ReuseExistingSpan,
+		PayloadSchemaVersion:    config.PayloadSchemaVersion,
+		BatchExport:             config.BatchExport,
+		HeartbeatInterval:       config.HeartbeatInterval,
+		SLOs:                    config.SLOs,
+		NDJSONMaxLines:          config.NDJSONMaxLines,
+		JWTClaims:               config.JWTClaims,
+		TenantBudget:            config.TenantBudget,
+		TraceStateKeys:          config.TraceStateKeys,
 	}
 }
 
 func Middleware(config Config) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		baseCtx := ctx.UserContext()
-		tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-		newCtx, span := tracer.Start(baseCtx, "monoscope.http", trace.WithSpanKind(trace.SpanKindServer))
-		defer span.End()
-		msgID := uuid.New()
-		ctx.Locals(string(apt.CurrentRequestMessageID), msgID)
-		errorList := []apt.ATError{}
-		ctx.Locals(string(apt.ErrorListCtxKey), &errorList)
-
-		newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
-		newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
-		ctx.SetUserContext(newCtx)
-
-		respHeaders := map[string][]string{}
-		for k, v := range ctx.GetRespHeaders() {
-			respHeaders[k] = v
+		return handle(config, ctx, ctx.Next, true)
+	}
+}
+
+// NotFoundHandler returns a catch-all handler that answers with 404 and still
+// reports the request. Register it last with app.Use when Middleware is only
+// mounted on groups or individual routes, so unmatched requests aren't lost.
+// It is not needed when Middleware is already registered app-wide.
+func NotFoundHandler(config Config) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return handle(config, ctx, func() error { return fiber.ErrNotFound }, false)
+	}
+}
+
+// handle instruments a single request around next. matched reports whether the
+// request reached a registered route, in which case its template is recorded.
+func handle(config Config, ctx *fiber.Ctx, next func() error, matched bool) error {
+	baseCtx := ctx.UserContext()
+	level := apt.CaptureFull
+	if config.ConsentFunc != nil {
+		level = config.ConsentFunc(ctx)
+	}
+	if level == apt.CaptureNone {
+		return next()
+	}
+	aptConfig := getAptConfig(config)
+	apt.ApplyCaptureLevel(&aptConfig, level)
+	start := apt.Now(aptConfig)
+	baseCtx = apt.ApplyDebugCapture(baseCtx, &aptConfig, ctx.Get(apt.DebugCaptureHeader))
+	newCtx, span, ownSpan := apt.StartServerSpan(baseCtx, aptConfig, headerCarrier{&ctx.Request().Header})
+	// A reused span belongs to the instrumentation that started it. The span
+	// of a payload ShouldExport drops is marked with DropSpan before it ends.
+	defer func() {
+		if ownSpan {
+			span.End()
 		}
-		aptConfig := getAptConfig(config)
-		defer func() {
-			if err := recover(); err != nil {
-				if _, ok := err.(error); !ok {
-					err = errors.New(err.(string))
-				}
-				apt.ReportError(ctx.UserContext(), err.(error))
-				payload := apt.BuildFastHTTPPayload(apt.GoFiberSDKType,
-					ctx.Context(), 500,
-					ctx.Request().Body(), ctx.Response().Body(), respHeaders,
-					ctx.AllParams(), ctx.Route().Path,
-					config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-					errorList,
-					msgID,
-					nil,
-					string(ctx.Context().Referer()),
-					aptConfig,
-				)
+	}()
+	// fasthttp sends the response once the handler returns, so there are no
+	// written bytes to report while it runs.
+	stopHeartbeat := apt.StartHeartbeat(aptConfig, span, nil)
+	defer stopHeartbeat()
+
+	// A message ID already in the context means another Monoscope layer
+	// (e.g. an HTTP middleware in front of an in-process gRPC-Gateway) is
+	// reporting this request, so this payload is recorded as its child.
+	parentID := apt.ParentMessageID(newCtx)
+	msgID := apt.NewMessageID(aptConfig)
+	// These framework-local copies under the legacy string keys are
+	// deprecated: handlers should read apt.MessageIDFrom and
+	// apt.ErrorListFrom on the request context. They are kept until the
+	// next major release.
+	ctx.Locals(string(apt.CurrentRequestMessageID), msgID)
+	errorList := []apt.ATError{}
+	ctx.Locals(string(apt.ErrorListCtxKey), &errorList)
+
+	newCtx = apt.WithErrorList(newCtx, &errorList)
+	newCtx = apt.WithMessageID(newCtx, msgID)
+	newCtx = apt.ContextWithConfig(newCtx, aptConfig)
+	newCtx = apt.ContextWithAnnotations(newCtx)
+	ctx.SetUserContext(newCtx)
+
+	// fasthttp has already read the whole body, chunked or not; only the
+	// captured copy is bounded.
+	reqBody, reqIncomplete := apt.TruncateBody(ctx.Request().Body())
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			payload := apt.BuildRawPayload(apt.GoFiberSDKType,
+				rawRequest(config, ctx), 500,
+				reqBody, responseBody(ctx), responseHeaders(ctx),
+				ctx.AllParams(), routePath(ctx, matched),
+				config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
+				errorList,
+				msgID,
+				parentID,
+				aptConfig,
+			)
+			payload.Panic = apt.NewPanicInfo(recovered, aptConfig)
+			payload.RequestBodyIncomplete = reqIncomplete
+			payload.MetadataOnly = level == apt.CaptureMetadataOnly
+			if apt.ShouldExport(aptConfig, &payload, apt.Now(aptConfig).Sub(start)) {
 				apt.CreateSpan(payload, aptConfig, span)
-				panic(err)
+				apt.RecordRequestDuration(newCtx, aptConfig, payload, apt.Now(aptConfig).Sub(start))
+			} else if ownSpan {
+				apt.DropSpan(span)
 			}
-		}()
-
-		err := ctx.Next()
-		payload := apt.BuildFastHTTPPayload(apt.GoFiberSDKType,
-			ctx.Context(), ctx.Response().StatusCode(),
-			ctx.Request().Body(), ctx.Response().Body(), respHeaders,
-			ctx.AllParams(), ctx.Route().Path,
-			config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-			errorList,
-			msgID,
-			nil,
-			string(ctx.Context().Referer()),
-			aptConfig,
-		)
+			panic(recovered)
+		}
+	}()
+
+	span.AddEvent(apt.EventHandlerStart)
+	err := next()
+	span.AddEvent(apt.EventHandlerComplete)
+
+	// Run the app's ErrorHandler ourselves so the payload reflects the
+	// status, body and headers the client actually receives, rather than the
+	// response as it stood before the error was rendered. The error is
+	// handled, so it isn't returned for fiber to render a second time.
+	if err != nil {
+		var fiberErr *fiber.Error
+		if !errors.As(err, &fiberErr) {
+			apt.ReportError(ctx.UserContext(), err)
+		}
+		if handlerErr := ctx.App().ErrorHandler(ctx, err); handlerErr != nil {
+			_ = ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
 
+	payload := apt.BuildRawPayload(apt.GoFiberSDKType,
+		rawRequest(config, ctx), ctx.Response().StatusCode(),
+		reqBody, responseBody(ctx), responseHeaders(ctx),
+		ctx.AllParams(), routePath(ctx, matched),
+		config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
+		errorList,
+		msgID,
+		parentID,
+		aptConfig,
+	)
+	payload.RequestBodyIncomplete = reqIncomplete
+	payload.MetadataOnly = level == apt.CaptureMetadataOnly
+	if apt.ShouldExport(aptConfig, &payload, apt.Now(aptConfig).Sub(start)) {
 		apt.CreateSpan(payload, aptConfig, span)
-		return err
+		apt.RecordRequestDuration(newCtx, aptConfig, payload, apt.Now(aptConfig).Sub(start))
+	} else if ownSpan {
+		apt.DropSpan(span)
+	}
+	return nil
+}
+
+// headerCarrier adapts fasthttp request headers to propagation.TextMapCarrier.
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (c headerCarrier) Get(key string) string {
+	return string(c.header.Peek(key))
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.header.Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := []string{}
+	c.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// routePath returns the template of the matched route, or an empty string
+// for requests that fell through to a catch-all handler.
+func routePath(ctx *fiber.Ctx, matched bool) string {
+	if !matched {
+		return ""
+	}
+	return ctx.Route().Path
+}
+
+// responseBody returns the response body for capture. Streamed bodies (e.g.
+// SendFile) and file downloads are skipped, since reading them would buffer
+// the whole file in memory.
+func responseBody(ctx *fiber.Ctx) []byte {
+	if ctx.Response().IsBodyStream() || apt.IsFileResponse(ctx.GetRespHeaders()) {
+		return nil
+	}
+	return ctx.Response().Body()
+}
+
+// responseHeaders copies the response headers as they currently stand, so it
+// must be called once the handler chain (and any error handling) has run.
+func responseHeaders(ctx *fiber.Ctx) map[string][]string {
+	respHeaders := map[string][]string{}
+	for k, v := range ctx.GetRespHeaders() {
+		respHeaders[k] = v
 	}
+	return respHeaders
+}
+
+// BuildFastHTTPPayload builds the payload of a fasthttp request. It moved
+// here from the core package, which no longer depends on fasthttp.
+func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
+	statusCode int, reqBody []byte, respBody []byte, respHeader map[string][]string,
+	pathParams map[string]string, urlPath string,
+	redactHeadersList,
+	redactRequestBodyList, redactResponseBodyList []string,
+	errorList []apt.ATError,
+	msgID uuid.UUID,
+	parentID *uuid.UUID,
+	referer string,
+	config apt.Config,
+) apt.Payload {
+	if req == nil || req.URI() == nil {
+		// Early return with empty payload to prevent any nil pointer panics
+		if config.Debug {
+			log.Println("APIToolkit: nil request or client or url while building payload.")
+		}
+		return apt.Payload{}
+	}
+	return apt.BuildRawPayload(SDKType, fastHTTPRequest(req, referer),
+		statusCode, reqBody, respBody, respHeader,
+		pathParams, urlPath,
+		redactHeadersList, redactRequestBodyList, redactResponseBodyList,
+		errorList, msgID, parentID, config)
+}
+
+// rawRequest describes the request of ctx for apt.BuildRawPayload, with the
+// context it was handled with and the identity and tenant config resolves
+// for it.
+func rawRequest(config Config, ctx *fiber.Ctx) apt.RawRequest {
+	req := fastHTTPRequest(ctx.Context(), string(ctx.Context().Referer()))
+	req.Context = ctx.UserContext()
+	req.Identity = resolveIdentity(config, ctx)
+	req.Tenant = resolveTenant(config, ctx)
+	return req
+}
+
+// fastHTTPRequest describes req for apt.BuildRawPayload.
+func fastHTTPRequest(req *fasthttp.RequestCtx, referer string) apt.RawRequest {
+	reqHeaders := map[string][]string{}
+	req.Request.Header.VisitAll(func(key, value []byte) {
+		reqHeaders[string(key)] = []string{string(value)}
+	})
+	rawReq := apt.RawRequest{
+		Host:        string(req.Host()),
+		Method:      string(req.Method()),
+		RequestURI:  string(req.RequestURI()),
+		Path:        string(req.Path()),
+		QueryString: string(req.URI().QueryString()),
+		UserAgent:   string(req.UserAgent()),
+		Referer:     referer,
+		RemoteAddr:  req.RemoteAddr().String(),
+		Header:      reqHeaders,
+		ProtoMajor:  1,
+		ProtoMinor:  1,
+		LocalAddr:   req.LocalAddr(),
+		TLS:         req.IsTLS(),
+	}
+	if !req.Request.Header.IsHTTP11() {
+		rawReq.ProtoMinor = 0
+	}
+	return rawReq
 }
 
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
-func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+// TagDataSubject records that the request being handled in ctx concerns the
+// given data subject, see apt.TagDataSubject.
+func TagDataSubject(ctx context.Context, subjectID string) {
+	apt.TagDataSubject(ctx, subjectID)
+}
+
+// ForceSample marks the request being handled in ctx for full capture, see
+// apt.ForceSample.
+func ForceSample(ctx context.Context) {
+	apt.ForceSample(ctx)
+}
+
+// resolveIdentity returns the caller config.IdentityFunc resolves for ctx, or
+// nil when there is none.
+func resolveIdentity(config Config, ctx *fiber.Ctx) *apt.Identity {
+	if config.IdentityFunc == nil {
+		return nil
+	}
+	return apt.IdentityOrNil(config.IdentityFunc(ctx))
+}
+
+// resolveTenant returns the tenant config.TenantFunc resolves for ctx.
+func resolveTenant(config Config, ctx *fiber.Ctx) string {
+	if config.TenantFunc == nil {
+		return ""
+	}
+	return config.TenantFunc(ctx)
+}
+
+// SetFeatureFlags records the flag variations active for the request being
+// handled in ctx, see apt.SetFeatureFlags.
+func SetFeatureFlags(ctx context.Context, flags map[string]string) {
+	apt.SetFeatureFlags(ctx, flags)
+}
+
+// SetExperiment records that the request being handled in ctx was assigned
+// variant of the A/B experiment name, see apt.SetExperiment.
+func SetExperiment(ctx context.Context, name, variant string) {
+	apt.SetExperiment(ctx, name, variant)
+}
+
+// Experiments returns a middleware recording the experiment assignments each
+// request carries in source's header or cookie, see apt.ExperimentSource.
+// Register it after Middleware.
+func Experiments(source apt.ExperimentSource) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		value := ""
+		if source.Header != "" {
+			value = ctx.Get(source.Header)
+		}
+		if value == "" && source.Cookie != "" {
+			value = ctx.Cookies(source.Cookie)
+		}
+		apt.SetExperiments(ctx.UserContext(), apt.ParseExperimentAssignments(value))
+		return ctx.Next()
+	}
+}
+
+// Stage returns a middleware timing the handlers registered after it as the
+// stage called name, e.g. "auth" or "rate_limit", so the payload shows which
+// layer of a deep chain is slow, see apt.EnterStage. Register stages after
+// Middleware.
+func Stage(name string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		exit := apt.EnterStage(ctx.UserContext(), name)
+		defer exit()
+		return ctx.Next()
+	}
+}
+
+// InitOTel sets up OpenTelemetry directly on its SDK, see apt.InitOTel.
+func InitOTel(ctx context.Context, opts apt.OTelOptions) (shutdown func(context.Context) error, err error) {
+	return apt.InitOTel(ctx, opts)
+}
+
+// ConfigureOpenTelemetry initializes OpenTelemetry with default options and any additional options.
+// Returns a shutdown function to flush telemetry and an error if initialization fails.
+// It is kept for compatibility, see apt.ConfigureOpenTelemetry; InitOTel offers the same options natively.
+func ConfigureOpenTelemetry(opts ...apt.OTelOption) (func(), error) {
+	return apt.ConfigureOpenTelemetry(opts...)
 }
 
 var (
-	WithServiceName            = otelconfig.WithServiceName
-	WithServiceVersion         = otelconfig.WithServiceVersion
-	WithLogLevel               = otelconfig.WithLogLevel
-	WithResourceAttributes     = otelconfig.WithResourceAttributes
-	WithResourceOption         = otelconfig.WithResourceOption
-	WithPropagators            = otelconfig.WithPropagators
-	WithErrorHandler           = otelconfig.WithErrorHandler
-	WithMetricsReportingPeriod = otelconfig.WithMetricsReportingPeriod
-	WithMetricsEnabled         = otelconfig.WithMetricsEnabled
-	WithTracesEnabled          = otelconfig.WithTracesEnabled
-	WithSpanProcessor          = otelconfig.WithSpanProcessor
-	WithSampler                = otelconfig.WithSampler
+	WithServiceName            = apt.WithServiceName
+	WithServiceVersion         = apt.WithServiceVersion
+	WithLogLevel               = apt.WithLogLevel
+	WithResourceAttributes     = apt.WithResourceAttributes
+	WithResourceOption         = apt.WithResourceOption
+	WithPropagators            = apt.WithPropagators
+	WithErrorHandler           = apt.WithErrorHandler
+	WithMetricsReportingPeriod = apt.WithMetricsReportingPeriod
+	WithMetricsEnabled         = apt.WithMetricsEnabled
+	WithTracesEnabled          = apt.WithTracesEnabled
+	WithSpanProcessor          = apt.WithSpanProcessor
+	WithSampler                = apt.WithSampler
 )
 
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
@@ -131,4 +550,6 @@ var (
 	WithRedactHeaders      = apt.WithRedactHeaders
 	WithRedactRequestBody  = apt.WithRedactRequestBody
 	WithRedactResponseBody = apt.WithRedactResponseBody
+	WithRedactQueryParams  = apt.WithRedactQueryParams
+	WithStrictRedaction    = apt.WithStrictRedaction
 )
@@ -0,0 +1,262 @@
+package monoscopefiber
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setupTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func spanAttr(span tracetest.SpanStub, key string) (attribute.Value, bool) {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// TestMiddlewareErrorHandler ensures the payload reflects the response
+// written by the app's ErrorHandler rather than the pre-error response, and
+// that the ErrorHandler runs once.
+func TestMiddlewareErrorHandler(t *testing.T) {
+	exporter := setupTracer(t)
+
+	handled := 0
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			handled++
+			return c.Status(fiber.StatusTeapot).JSON(fiber.Map{"handled": err.Error()})
+		},
+	})
+	app.Use(Middleware(Config{ServiceName: "test-service", CaptureResponseBody: true}))
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		c.Set("X-Handler", "yes")
+		return errors.New("boom")
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/fail", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusTeapot || string(body) != `{"handled":"boom"}` {
+		t.Errorf("Expected the error handler's response, got %d %q", resp.StatusCode, string(body))
+	}
+	if handled != 1 {
+		t.Errorf("Expected the error handler to run once, ran %d times", handled)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	status, _ := spanAttr(spans[0], "http.response.status_code")
+	if status.AsInt64() != fiber.StatusTeapot {
+		t.Errorf("Expected captured status %d, got %d", fiber.StatusTeapot, status.AsInt64())
+	}
+	respBody, _ := spanAttr(spans[0], "http.response.body")
+	if decoded, _ := base64.StdEncoding.DecodeString(respBody.AsString()); string(decoded) != `{"handled":"boom"}` {
+		t.Errorf("Expected the error handler's body to be captured, got %q", decoded)
+	}
+	if _, ok := spanAttr(spans[0], "http.response.header.X-Handler"); !ok {
+		t.Error("Expected headers set by the handler to be captured")
+	}
+	errs, _ := spanAttr(spans[0], "apitoolkit.errors")
+	if errs.AsString() == "null" || errs.AsString() == "[]" {
+		t.Errorf("Expected handler error to be reported, got %s", errs.AsString())
+	}
+
+	exporter.Reset()
+	if _, err := app.Test(httptest.NewRequest("GET", "/missing", nil)); err != nil {
+		t.Fatal(err)
+	}
+	spans = exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if status, _ := spanAttr(spans[0], "http.response.status_code"); status.AsInt64() != fiber.StatusTeapot {
+		t.Errorf("Expected the status the error handler sent, got %d", status.AsInt64())
+	}
+	if handled != 2 {
+		t.Errorf("Expected the error handler to run once per error, ran %d times", handled)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	exporter := setupTracer(t)
+
+	config := Config{ServiceName: "test-service"}
+	app := fiber.New()
+	api := app.Group("/api", Middleware(config))
+	api.Get("/users", func(c *fiber.Ctx) error { return c.SendString("users") })
+	app.Use(NotFoundHandler(config))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/missing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span for unmatched request, got %d", len(spans))
+	}
+	route, _ := spanAttr(spans[0], "http.route")
+	if route.AsString() != "" {
+		t.Errorf("Expected empty route for unmatched request, got %q", route.AsString())
+	}
+}
+
+func TestRepeatedQueryParams(t *testing.T) {
+	exporter := setupTracer(t)
+
+	app := fiber.New()
+	app.Use(Middleware(Config{RedactQueryParams: []string{"api_key"}}))
+	app.Get("/search", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/search?tag=a&tag=b&api_key=s3cr3t", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	query, _ := spanAttr(spans[0], "http.request.query_params")
+	if query.AsString() != `{"api_key":["[CLIENT_REDACTED]"],"tag":["a","b"]}` {
+		t.Errorf("Expected repeated keys kept and api_key redacted, got %s", query.AsString())
+	}
+	target, _ := spanAttr(spans[0], "http.target")
+	if strings.Contains(target.AsString(), "s3cr3t") {
+		t.Errorf("Expected api_key to be redacted from the target, got %s", target.AsString())
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	exporter := setupTracer(t)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(Middleware(Config{ServiceName: "test-service"}))
+	app.Get("/ping", func(c *fiber.Ctx) error {
+		return c.SendString("pong")
+	})
+	// Unix socket paths are limited to about 100 bytes, which t.TempDir can
+	// exceed.
+	dir, err := os.MkdirTemp("", "monoscope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "fiber.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(listener) }()
+	defer func() { _ = app.Shutdown() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://unix/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	for key, want := range map[string]string{"network.transport": "unix", "url.scheme": "http"} {
+		if got, _ := spanAttr(spans[0], key); got.AsString() != want {
+			t.Errorf("Expected %s=%s, got %q", key, want, got.AsString())
+		}
+	}
+}
+
+func TestResponseCompression(t *testing.T) {
+	body := `{"items":[` + strings.Repeat(`"item",`, 100) + `"end"]}`
+	for _, tc := range []struct {
+		name  string
+		outer bool
+		layer string
+	}{
+		{"compress outside", true, ""},
+		{"compress inside", false, apt.ResponseCompressionInner},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := setupTracer(t)
+			monoscope := Middleware(Config{CaptureResponseBody: true})
+			app := fiber.New(fiber.Config{DisableStartupMessage: true})
+			if tc.outer {
+				app.Use(compress.New(), monoscope)
+			} else {
+				app.Use(monoscope, compress.New())
+			}
+			app.Get("/items", func(c *fiber.Ctx) error {
+				c.Set("Content-Type", "application/json")
+				return c.SendString(body)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/items", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.Header.Get("Content-Encoding") != "gzip" {
+				t.Fatalf("Expected a gzipped response, got Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			got, _ := spanAttr(spans[0], "http.response.body")
+			if captured, _ := base64.StdEncoding.DecodeString(got.AsString()); string(captured) != body {
+				t.Errorf("Expected the uncompressed body to be captured, got %q", captured)
+			}
+			// Fiber compresses after the handler chain returns, so an outer
+			// compress middleware runs after the body was captured.
+			if layer, _ := spanAttr(spans[0], "apitoolkit.response_compression"); layer.AsString() != tc.layer {
+				t.Errorf("Expected compression layer %q, got %q", tc.layer, layer.AsString())
+			}
+		})
+	}
+}
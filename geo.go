@@ -0,0 +1,85 @@
+package monoscope
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// GeoLocation is where a client IP address is located. Empty fields are
+// unknown.
+type GeoLocation struct {
+	// Country is the ISO 3166-1 alpha-2 country code, e.g. "DE".
+	Country string `json:"country,omitempty"`
+	// Region is the ISO 3166-2 subdivision code, e.g. "DE-BY".
+	Region string `json:"region,omitempty"`
+	City   string `json:"city,omitempty"`
+}
+
+// GeoResolver maps client IP addresses to locations, see Config.GeoResolver.
+// The maxmind package provides one backed by a MaxMind GeoIP2 or GeoLite2
+// database. Resolve is called once per request and must be safe for
+// concurrent use.
+type GeoResolver interface {
+	Resolve(ip netip.Addr) (GeoLocation, error)
+}
+
+// resolveGeo locates the client of a request with config.GeoResolver. The
+// client IP is the first X-Forwarded-For entry or X-Real-IP when present,
+// as set by proxies in front of the service, and remoteAddr otherwise.
+func resolveGeo(config Config, remoteAddr string, header http.Header) *GeoLocation {
+	if config.GeoResolver == nil {
+		return nil
+	}
+	ip, ok := clientIP(remoteAddr, header)
+	if !ok {
+		return nil
+	}
+	location, err := config.GeoResolver.Resolve(ip)
+	if err != nil {
+		if config.Debug {
+			log.Printf("monoscope: unable to resolve location of %s: %v", ip, err)
+		}
+		return nil
+	}
+	if location == (GeoLocation{}) {
+		return nil
+	}
+	return &location
+}
+
+func clientIP(remoteAddr string, header http.Header) (netip.Addr, bool) {
+	candidate := remoteAddr
+	if forwarded := header.Get("X-Forwarded-For"); forwarded != "" {
+		candidate, _, _ = strings.Cut(forwarded, ",")
+	} else if realIP := header.Get("X-Real-IP"); realIP != "" {
+		candidate = realIP
+	} else if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		candidate = host
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(candidate))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// geoAttributes returns the OpenTelemetry geo attributes for location.
+func geoAttributes(location *GeoLocation) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if location.Country != "" {
+		attrs = append(attrs, semconv.GeoCountryISOCode(location.Country))
+	}
+	if location.Region != "" {
+		attrs = append(attrs, semconv.GeoRegionISOCode(location.Region))
+	}
+	if location.City != "" {
+		attrs = append(attrs, semconv.GeoLocalityName(location.City))
+	}
+	return attrs
+}
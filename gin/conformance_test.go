@@ -0,0 +1,32 @@
+package monoscopegin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/monoscope-tech/monoscope-go/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	conformance.Run(t, conformance.Adapter{
+		Serve: func(config apt.Config, routes []conformance.Route) http.Handler {
+			router := gin.New()
+			router.Use(Middleware(Config{
+				TracerProvider:      config.TracerProvider,
+				CaptureRequestBody:  config.CaptureRequestBody,
+				CaptureResponseBody: config.CaptureResponseBody,
+				RedactHeaders:       config.RedactHeaders,
+				RedactRequestBody:   config.RedactRequestBody,
+				RedactResponseBody:  config.RedactResponseBody,
+			}))
+			for _, route := range routes {
+				router.Handle(route.Method, conformance.ColonParams(route.Pattern), gin.WrapF(route.Handler))
+			}
+			return router
+		},
+		Template: conformance.ColonParams,
+	})
+}
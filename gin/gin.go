@@ -1,18 +1,20 @@
 package monoscopegin
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/rsa"
 	"errors"
-	"io"
-	"log"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/honeycombio/otel-config-go/otelconfig"
 	apt "github.com/monoscope-tech/monoscope-go"
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -26,125 +28,569 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CaptureRequestHeaders and CaptureResponseHeaders, when set, limit the
+	// captured headers to the named ones (case-insensitive). Redaction still
+	// applies to the headers that are kept.
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+	// RouteTags adds tags to the requests of groups of endpoints, keyed by
+	// route template glob, e.g. {"/admin/**": {"admin"}}.
+	RouteTags map[string][]string
+	// SPAFallbackRoutes lists the route globs of catch-all routes serving a
+	// single-page app, reported as the single route "/*spa" without HTML,
+	// JavaScript and CSS bodies. SPAFallbackExclude exempts routes from it.
+	SPAFallbackRoutes  []string
+	SPAFallbackExclude []string
+	// RedactQueryParams lists query parameters whose values are redacted in
+	// the captured query parameters and raw URL.
+	RedactQueryParams []string
+	// TracerProvider is used to create Monoscope spans. When nil, the global
+	// provider from otel.GetTracerProvider is used.
+	TracerProvider trace.TracerProvider
+	// Propagators extracts the incoming trace context. When nil, the global
+	// propagator from otel.GetTextMapPropagator is used.
+	Propagators propagation.TextMapPropagator
+	// Now and NewMessageID replace time.Now and uuid.New, so tests and replay
+	// tooling can produce reproducible payloads.
+	Now          func() time.Time
+	NewMessageID func() uuid.UUID
+	// UseUUIDv7 generates time-ordered UUIDv7 message IDs instead of UUIDv4.
+	UseUUIDv7 bool
+	// ErrorDedupWindow collapses repeated reports of the same error within the
+	// window into the first one; the next report after it carries the count of
+	// suppressed occurrences. Zero reports every error.
+	ErrorDedupWindow time.Duration
+	// SemanticConventions additionally emits the standard OpenTelemetry HTTP
+	// attributes, so generic backends such as Tempo or Jaeger render the spans.
+	SemanticConventions bool
+	// MeterProvider, when set, receives request latencies in the
+	// http.server.request.duration histogram, with trace exemplars linking
+	// slow requests to their Monoscope traces.
+	MeterProvider metric.MeterProvider
+	// APIKey and Endpoint, when APIKey is set, report this middleware's spans
+	// to its own Monoscope project instead of the one configured globally, so
+	// one binary can host services belonging to different projects. Endpoint
+	// defaults to the Monoscope collector. Call monoscope.Shutdown on exit.
+	APIKey   string
+	Endpoint string
+	// PayloadHook, when set, is called with each payload before it is
+	// recorded, to modify it or, by returning nil, veto its export.
+	PayloadHook func(*apt.Payload) *apt.Payload
+	// StrictRedaction replaces bodies the redaction rules could not be applied
+	// to with apt.RedactionFailedMarker instead of exporting them as they are.
+	StrictRedaction bool
+	// BodyEncryptionKey, when set, encrypts captured bodies for this public
+	// key before they leave the process; see apt.EncryptedBody.
+	BodyEncryptionKey *rsa.PublicKey
+	// GeoResolver, when set, records the country, region and city of each
+	// request's client IP, see apt.GeoResolver.
+	GeoResolver apt.GeoResolver
+	// ProbesMetadataOnly captures requests classified as health probes
+	// without their bodies.
+	ProbesMetadataOnly bool
+	// PreflightMetadataOnly captures CORS preflight requests without their
+	// bodies, and DropPreflight doesn't report them at all.
+	PreflightMetadataOnly bool
+	DropPreflight         bool
+	// MaxAttributeValueLength and MaxAttributes bound the attributes each
+	// span gets, see apt.Config.MaxAttributeValueLength.
+	MaxAttributeValueLength int
+	MaxAttributes           int
+	// IdempotencyKeyHeader, e.g. "Idempotency-Key", links requests reusing
+	// an idempotency key within IdempotencyWindow as retries, see
+	// apt.Config.IdempotencyKeyHeader.
+	IdempotencyKeyHeader string
+	IdempotencyWindow    time.Duration
+	// DebugCaptureSecret lets requests with a signed apt.DebugCaptureHeader
+	// be captured in full, see apt.Config.DebugCaptureSecret.
+	DebugCaptureSecret []byte
+	// ReuseExistingSpan records payloads on the server span of otelhttp,
+	// otelmux or similar instrumentation already in place instead of
+	// nesting a second span, see apt.Config.ReuseExistingSpan.
+	ReuseExistingSpan bool
+	// PayloadSchemaVersion pins the payload schema exported, e.g.
+	// apt.PayloadSchemaV1 for older backends, see
+	// apt.Config.PayloadSchemaVersion.
+	PayloadSchemaVersion int
+	// BatchExport exports successful requests as records batched on one
+	// span per interval, see apt.BatchExport.
+	BatchExport apt.BatchExport
+	// HeartbeatInterval, when set, exports a heartbeat span linked to each
+	// request still running once per interval, see apt.StartHeartbeat.
+	HeartbeatInterval time.Duration
+	// DetectLeaks logs request contexts and capture buffers still referenced
+	// after their request ended, see apt.LeakCheck. Debug turns it on too.
+	DetectLeaks bool
+	// SLOs are the service level objectives each payload is checked against,
+	// see apt.SLO.
+	SLOs []apt.SLO
+	// NDJSONMaxLines is how many lines of newline-delimited JSON bodies are
+	// captured, apt.DefaultNDJSONMaxLines when zero.
+	NDJSONMaxLines int
+	// JWTClaims names the claims of the request's bearer JWT recorded on
+	// payloads, e.g. apt.DefaultJWTClaims. The token itself stays redacted.
+	JWTClaims []string
+	// TraceStateKeys names the members of the incoming tracestate, e.g. a
+	// vendor's sampling hints, recorded as attributes of their own.
+	TraceStateKeys []string
+	// IdentityFunc, when set, resolves the caller of each request, e.g. the
+	// customer and plan of its API key, recorded on its payload.
+	IdentityFunc func(*http.Request) apt.Identity
+	// TenantFunc, when set, returns the tenant of each request of a
+	// multi-tenant API, e.g. the organization of its API key, recorded on
+	// its payload and charged with TenantBudget.
+	TenantFunc func(*http.Request) string
+	// TenantBudget limits what each tenant's requests export per minute, so
+	// one noisy tenant can't use up the telemetry budget of the others.
+	TenantBudget apt.TenantBudget
+	// GRPCSystemCalls is how gRPC health checks and reflection calls to a
+	// gRPC server mounted behind the middleware are reported. By default
+	// they are skipped.
+	GRPCSystemCalls apt.GRPCSystemCalls
+	// ConsentFunc, when set, decides per request how much of it is captured,
+	// e.g. metadata only for EU traffic or users who haven't consented.
+	ConsentFunc func(*http.Request) apt.CaptureLevel
+	// HandlerTimeout, when set, gives handlers at most this long to respond:
+	// their request context is cancelled after it and the timeout is reported
+	// with the route and elapsed time. gin's context can't be handed to
+	// another goroutine, so handlers keep running in the request's; their
+	// response is buffered, and replaced with a 503 if they return after the
+	// deadline.
+	HandlerTimeout time.Duration
+	// ConcurrencyLimit, when MaxConcurrent is set, caps the handlers running
+	// at once. Requests queue for a slot for up to MaxQueueWait and are shed
+	// with a 503 after that; queue wait and shedding are recorded in the payload.
+	ConcurrencyLimit apt.ConcurrencyLimit
 }
 
 type ginBodyLogWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	body     *bytes.Buffer
+	span     trace.Span
+	written  bool
+	checked  bool
+	skipBody bool
+	count    apt.ByteCounter
+	header   http.Header
+}
+
+// markWritten records the first-byte span event and snapshots the headers
+// sent the first time the response is committed.
+func (w *ginBodyLogWriter) markWritten() {
+	if !w.written {
+		w.written = true
+		w.header = w.Header().Clone()
+		w.span.AddEvent(apt.EventResponseFirstByte)
+	}
+}
+
+// sentHeader returns the response headers as they were when the response
+// was committed, which is what the client received, or a copy of the
+// current headers if it hasn't been.
+func (w *ginBodyLogWriter) sentHeader() http.Header {
+	if w.header != nil {
+		return w.header
+	}
+	return w.Header().Clone()
+}
+
+// captureBody reports whether written bytes should be buffered. The decision is
+// made once, on the first write, when the response headers are final.
+func (w *ginBodyLogWriter) captureBody() bool {
+	if !w.checked {
+		w.checked = true
+		w.skipBody = apt.IsFileResponse(w.Header())
+	}
+	return !w.skipBody
+}
+
+func (w *ginBodyLogWriter) WriteHeaderNow() {
+	w.markWritten()
+	w.ResponseWriter.WriteHeaderNow()
 }
 
 func (w *ginBodyLogWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
-	return w.ResponseWriter.Write(b)
+	w.markWritten()
+	w.count.Begin()
+	n, err := w.ResponseWriter.Write(b)
+	w.count.Record(n, err)
+	if w.captureBody() {
+		w.body.Write(b[:n])
+	}
+	return n, err
 }
 
 func (w *ginBodyLogWriter) WriteString(s string) (int, error) {
-	w.body.WriteString(s)
-	return w.ResponseWriter.WriteString(s)
+	w.markWritten()
+	w.count.Begin()
+	n, err := w.ResponseWriter.WriteString(s)
+	w.count.Record(n, err)
+	if w.captureBody() {
+		w.body.WriteString(s[:n])
+	}
+	return n, err
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can set read
+// and write deadlines.
+func (w *ginBodyLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
+// TagDataSubject records that the request being handled in ctx concerns the
+// given data subject, see apt.TagDataSubject.
+func TagDataSubject(ctx context.Context, subjectID string) {
+	apt.TagDataSubject(ctx, subjectID)
+}
+
+// ForceSample marks the request being handled in ctx for full capture, see
+// apt.ForceSample.
+func ForceSample(ctx context.Context) {
+	apt.ForceSample(ctx)
+}
+
+// SetFeatureFlags records the flag variations active for the request being
+// handled in ctx, see apt.SetFeatureFlags.
+func SetFeatureFlags(ctx context.Context, flags map[string]string) {
+	apt.SetFeatureFlags(ctx, flags)
+}
+
+// SetExperiment records that the request being handled in ctx was assigned
+// variant of the A/B experiment name, see apt.SetExperiment.
+func SetExperiment(ctx context.Context, name, variant string) {
+	apt.SetExperiment(ctx, name, variant)
+}
+
+// Experiments returns a middleware recording the experiment assignments each
+// request carries in source's header or cookie, see apt.ExperimentSource.
+// Register it after Middleware.
+func Experiments(source apt.ExperimentSource) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		apt.SetExperiments(ctx.Request.Context(), apt.ExperimentAssignments(ctx.Request, source))
+		ctx.Next()
+	}
+}
+
+// Stage returns a middleware timing the handlers registered after it as the
+// stage called name, e.g. "auth" or "rate_limit", so the payload shows which
+// layer of a deep chain is slow, see apt.EnterStage. Register stages after
+// Middleware.
+func Stage(name string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		exit := apt.EnterStage(ctx.Request.Context(), name)
+		defer exit()
+		ctx.Next()
+	}
+}
+
 func Middleware(config Config) gin.HandlerFunc {
+	limiter := apt.NewConcurrencyLimiter(config.ConcurrencyLimit)
 	return func(ctx *gin.Context) {
+		level := apt.CaptureFull
+		if config.ConsentFunc != nil {
+			level = config.ConsentFunc(ctx.Request)
+		}
+		level = config.GRPCSystemCalls.Restrict(level, ctx.Request.URL.Path)
+		if level == apt.CaptureNone {
+			ctx.Next()
+			return
+		}
+		aptConfig := getAptConfig(config)
+		apt.ApplyCaptureLevel(&aptConfig, level)
 		newCtx := ctx.Request.Context()
-		tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-		newCtx, span := tracer.Start(newCtx, "monoscope.http", trace.WithSpanKind(trace.SpanKindServer))
-		defer span.End()
+		start := apt.Now(aptConfig)
+		newCtx = apt.ApplyDebugCapture(newCtx, &aptConfig, ctx.Request.Header.Get(apt.DebugCaptureHeader))
+		newCtx, span, ownSpan := apt.StartServerSpan(newCtx, aptConfig, propagation.HeaderCarrier(ctx.Request.Header))
+		// A reused span belongs to the instrumentation that started it. The span
+		// of a payload ShouldExport drops is marked with DropSpan before it ends.
+		defer func() {
+			if ownSpan {
+				span.End()
+			}
+		}()
 
-		msgID := uuid.New()
+		// A message ID already in the context means another Monoscope layer
+		// (e.g. an HTTP middleware in front of an in-process gRPC-Gateway) is
+		// reporting this request, so this payload is recorded as its child.
+		parentID := apt.ParentMessageID(newCtx)
+		msgID := apt.NewMessageID(aptConfig)
+		// These framework-local copies under the legacy string keys are
+		// deprecated: handlers should read apt.MessageIDFrom and
+		// apt.ErrorListFrom on the request context. They are kept until the
+		// next major release.
 		ctx.Set(string(apt.CurrentRequestMessageID), msgID)
 		errorList := []apt.ATError{}
 		ctx.Set(string(apt.ErrorListCtxKey), &errorList)
-		newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
-		newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
+		newCtx = apt.WithErrorList(newCtx, &errorList)
+		newCtx = apt.WithMessageID(newCtx, msgID)
+		newCtx = apt.ContextWithConfig(newCtx, aptConfig)
+		newCtx = apt.ContextWithAnnotations(newCtx)
+		newCtx, leaks := apt.StartLeakCheck(newCtx, aptConfig, ctx.Request.Method+" "+ctx.Request.URL.Path)
+		defer leaks.End()
 		ctx.Request = ctx.Request.WithContext(newCtx)
 
-		reqByteBody, _ := io.ReadAll(ctx.Request.Body)
-		ctx.Request.Body = io.NopCloser(bytes.NewBuffer(reqByteBody))
+		reqByteBody, body, reqIncomplete, _ := apt.ReadRequestBody(ctx.Request.Body)
+		ctx.Request.Body = body
+		span.AddEvent(apt.EventRequestBodyRead)
 
-		blw := &ginBodyLogWriter{body: bytes.NewBuffer([]byte{}), ResponseWriter: ctx.Writer}
+		// Response bodies that aren't captured aren't buffered either.
+		blw := &ginBodyLogWriter{body: bytes.NewBuffer([]byte{}), ResponseWriter: ctx.Writer, span: span,
+			checked: !aptConfig.CaptureResponseBody, skipBody: !aptConfig.CaptureResponseBody}
+		apt.TrackLeak(leaks, "response body buffer", blw.body)
+		stopHeartbeat := apt.StartHeartbeat(aptConfig, span, &blw.count)
+		defer stopHeartbeat()
 		ctx.Writer = blw
 
 		pathParams := map[string]string{}
 		for _, param := range ctx.Params {
 			pathParams[param.Key] = param.Value
 		}
-		aptConfig := getAptConfig(config)
 
-		defer func() {
-			if err := recover(); err != nil {
-				if _, ok := err.(error); !ok {
-					err = errors.New(err.(string))
-				}
-				apt.ReportError(ctx.Request.Context(), err.(error))
-				payload := apt.BuildPayload(apt.GoGinSDKType,
-					ctx.Request, 500,
-					reqByteBody, blw.body.Bytes(), ctx.Writer.Header().Clone(),
-					pathParams, ctx.FullPath(),
-					config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-					errorList,
-					msgID,
-					nil,
-					aptConfig,
-				)
+		timedOut, shed := false, false
+		var queueWait time.Duration
+		report := func(statusCode int, panicInfo *apt.PanicInfo) {
+			payload := apt.BuildPayload(apt.GoGinSDKType,
+				ctx.Request, statusCode,
+				reqByteBody, blw.body.Bytes(), blw.sentHeader(),
+				pathParams, ctx.FullPath(),
+				config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
+				errorList,
+				msgID,
+				parentID,
+				aptConfig,
+			)
+			payload.Panic = panicInfo
+			payload.HandlerTimedOut = timedOut
+			payload.QueueWait = queueWait
+			payload.Shed = shed
+			payload.RequestBodyIncomplete = reqIncomplete
+			apt.ApplyWriteStatus(&payload, &blw.count)
+			apt.ApplyResponseCompression(&payload, blw.sentHeader(), blw.Header())
+			payload.MetadataOnly = level == apt.CaptureMetadataOnly
+			if apt.ShouldExport(aptConfig, &payload, apt.Now(aptConfig).Sub(start)) {
 				apt.CreateSpan(payload, aptConfig, span)
-				panic(err)
+				apt.RecordRequestDuration(newCtx, aptConfig, payload, apt.Now(aptConfig).Sub(start))
+			} else if ownSpan {
+				apt.DropSpan(span)
+			}
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				report(500, apt.NewPanicInfo(recovered, aptConfig))
+				panic(recovered)
 			}
 		}()
-		ctx.Next()
-		payload := apt.BuildPayload(apt.GoGinSDKType,
-			ctx.Request, ctx.Writer.Status(),
-			reqByteBody, blw.body.Bytes(), ctx.Writer.Header().Clone(),
-			pathParams, ctx.FullPath(),
-			config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
-			errorList,
-			msgID,
-			nil,
-			aptConfig,
-		)
-		if config.Debug {
-			log.Println(payload)
+		var route string
+		if config.ConcurrencyLimit.PerRoute {
+			route = ctx.FullPath()
+		}
+		release, wait, admitted := limiter.Acquire(newCtx, route)
+		queueWait = wait
+		if !admitted {
+			shed = true
+			ctx.AbortWithStatus(http.StatusServiceUnavailable)
+			report(http.StatusServiceUnavailable, nil)
+			return
 		}
-		apt.CreateSpan(payload, aptConfig, span)
+		defer release()
+
+		span.AddEvent(apt.EventHandlerStart)
+		if config.HandlerTimeout > 0 {
+			handlerStart := apt.Now(aptConfig)
+			timedOut = serveWithTimeout(ctx, config.HandlerTimeout)
+			if timedOut {
+				apt.ReportError(newCtx, &apt.HandlerTimeoutError{
+					Method:  ctx.Request.Method,
+					Route:   ctx.FullPath(),
+					Timeout: config.HandlerTimeout,
+					Elapsed: apt.Now(aptConfig).Sub(handlerStart),
+				})
+			}
+		} else {
+			ctx.Next()
+		}
+		span.AddEvent(apt.EventHandlerComplete)
+		report(ctx.Writer.Status(), nil)
+	}
+}
+
+// serveWithTimeout runs the handlers after the middleware with a request
+// context cancelled after timeout, buffering their response, which is sent
+// once they return, or replaced with a 503 if they returned after the
+// deadline. It reports whether they did.
+func serveWithTimeout(ctx *gin.Context, timeout time.Duration) bool {
+	req, w := ctx.Request, ctx.Writer
+	timeoutCtx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	buf := &timeoutWriter{ResponseWriter: w, header: w.Header().Clone(), status: http.StatusOK, size: -1}
+	ctx.Request, ctx.Writer = req.WithContext(timeoutCtx), buf
+	defer func() {
+		ctx.Request, ctx.Writer = ctx.Request.WithContext(req.Context()), w
+	}()
+	ctx.Next()
+
+	if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.WriteHeaderNow()
+		return true
+	}
+	dst := w.Header()
+	clear(dst)
+	for k, v := range buf.header {
+		dst[k] = v
+	}
+	w.WriteHeader(buf.status)
+	if buf.Written() {
+		w.WriteHeaderNow()
+		_, _ = w.Write(buf.body.Bytes())
+	}
+	return false
+}
+
+// timeoutWriter buffers the response of handlers running under
+// HandlerTimeout. As with http.TimeoutHandler, it supports neither flushing
+// nor hijacking.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	body   bytes.Buffer
+	status int
+	size   int
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
 
+func (w *timeoutWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
 	}
 }
 
+func (w *timeoutWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.body.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	return w.size
+}
+
+func (w *timeoutWriter) Written() bool {
+	return w.size >= 0
+}
+
+func (w *timeoutWriter) Flush() {}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *timeoutWriter) Pusher() http.Pusher {
+	return nil
+}
+
 func getAptConfig(config Config) apt.Config {
 	return apt.Config{
-		ServiceName:         config.ServiceName,
-		ServiceVersion:      config.ServiceVersion,
-		Tags:                config.Tags,
-		Debug:               config.Debug,
-		CaptureRequestBody:  config.CaptureRequestBody,
-		CaptureResponseBody: config.CaptureResponseBody,
-		RedactHeaders:       config.RedactHeaders,
-		RedactRequestBody:   config.RedactRequestBody,
-		RedactResponseBody:  config.RedactResponseBody,
+		ServiceName:             config.ServiceName,
+		ServiceVersion:          config.ServiceVersion,
+		Tags:                    config.Tags,
+		Debug:                   config.Debug,
+		CaptureRequestBody:      config.CaptureRequestBody,
+		CaptureResponseBody:     config.CaptureResponseBody,
+		RedactHeaders:           config.RedactHeaders,
+		RedactRequestBody:       config.RedactRequestBody,
+		RedactResponseBody:      config.RedactResponseBody,
+		CaptureRequestHeaders:   config.CaptureRequestHeaders,
+		CaptureResponseHeaders:  config.CaptureResponseHeaders,
+		RouteTags:               config.RouteTags,
+		SPAFallbackRoutes:       config.SPAFallbackRoutes,
+		SPAFallbackExclude:      config.SPAFallbackExclude,
+		RedactQueryParams:       config.RedactQueryParams,
+		TracerProvider:          config.TracerProvider,
+		Propagators:             config.Propagators,
+		Now:                     config.Now,
+		NewMessageID:            config.NewMessageID,
+		UseUUIDv7:               config.UseUUIDv7,
+		ErrorDedupWindow:        config.ErrorDedupWindow,
+		SemanticConventions:     config.SemanticConventions,
+		MeterProvider:           config.MeterProvider,
+		APIKey:                  config.APIKey,
+		Endpoint:                config.Endpoint,
+		PayloadHook:             config.PayloadHook,
+		StrictRedaction:         config.StrictRedaction,
+		BodyEncryptionKey:       config.BodyEncryptionKey,
+		GeoResolver:             config.GeoResolver,
+		ProbesMetadataOnly:      config.ProbesMetadataOnly,
+		PreflightMetadataOnly:   config.PreflightMetadataOnly,
+		DropPreflight:           config.DropPreflight,
+		MaxAttributeValueLength: config.MaxAttributeValueLength,
+		MaxAttributes:           config.MaxAttributes,
+		IdempotencyKeyHeader:    config.IdempotencyKeyHeader,
+		IdempotencyWindow:       config.IdempotencyWindow,
+		DebugCaptureSecret:      config.DebugCaptureSecret,
+		ReuseExistingSpan:       config.ReuseExistingSpan,
+		PayloadSchemaVersion:    config.PayloadSchemaVersion,
+		BatchExport:             config.BatchExport,
+		HeartbeatInterval:       config.HeartbeatInterval,
+		DetectLeaks:             config.DetectLeaks,
+		SLOs:                    config.SLOs,
+		NDJSONMaxLines:          config.NDJSONMaxLines,
+		JWTClaims:               config.JWTClaims,
+		TraceStateKeys:          config.TraceStateKeys,
+		IdentityFunc:            config.IdentityFunc,
+		TenantFunc:              config.TenantFunc,
+		TenantBudget:            config.TenantBudget,
 	}
 }
 
-func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+// InitOTel sets up OpenTelemetry directly on its SDK, see apt.InitOTel.
+func InitOTel(ctx context.Context, opts apt.OTelOptions) (shutdown func(context.Context) error, err error) {
+	return apt.InitOTel(ctx, opts)
+}
+
+// ConfigureOpenTelemetry initializes OpenTelemetry with default options and any additional options.
+// Returns a shutdown function to flush telemetry and an error if initialization fails.
+// It is kept for compatibility, see apt.ConfigureOpenTelemetry; InitOTel offers the same options natively.
+func ConfigureOpenTelemetry(opts ...apt.OTelOption) (func(), error) {
+	return apt.ConfigureOpenTelemetry(opts...)
 }
 
 var (
-	WithServiceName            = otelconfig.WithServiceName
-	WithServiceVersion         = otelconfig.WithServiceVersion
-	WithLogLevel               = otelconfig.WithLogLevel
-	WithResourceAttributes     = otelconfig.WithResourceAttributes
-	WithResourceOption         = otelconfig.WithResourceOption
-	WithPropagators            = otelconfig.WithPropagators
-	WithErrorHandler           = otelconfig.WithErrorHandler
-	WithMetricsReportingPeriod = otelconfig.WithMetricsReportingPeriod
-	WithMetricsEnabled         = otelconfig.WithMetricsEnabled
-	WithTracesEnabled          = otelconfig.WithTracesEnabled
-	WithSpanProcessor          = otelconfig.WithSpanProcessor
-	WithSampler                = otelconfig.WithSampler
+	WithServiceName            = apt.WithServiceName
+	WithServiceVersion         = apt.WithServiceVersion
+	WithLogLevel               = apt.WithLogLevel
+	WithResourceAttributes     = apt.WithResourceAttributes
+	WithResourceOption         = apt.WithResourceOption
+	WithPropagators            = apt.WithPropagators
+	WithErrorHandler           = apt.WithErrorHandler
+	WithMetricsReportingPeriod = apt.WithMetricsReportingPeriod
+	WithMetricsEnabled         = apt.WithMetricsEnabled
+	WithTracesEnabled          = apt.WithTracesEnabled
+	WithSpanProcessor          = apt.WithSpanProcessor
+	WithSampler                = apt.WithSampler
 )
 
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
@@ -155,4 +601,6 @@ var (
 	WithRedactHeaders      = apt.WithRedactHeaders
 	WithRedactRequestBody  = apt.WithRedactRequestBody
 	WithRedactResponseBody = apt.WithRedactResponseBody
+	WithRedactQueryParams  = apt.WithRedactQueryParams
+	WithStrictRedaction    = apt.WithStrictRedaction
 )
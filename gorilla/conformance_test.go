@@ -0,0 +1,30 @@
+package monoscopegorilla
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/monoscope-tech/monoscope-go/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, conformance.Adapter{
+		Serve: func(config apt.Config, routes []conformance.Route) http.Handler {
+			router := mux.NewRouter()
+			router.Use(Middleware(Config{
+				TracerProvider:      config.TracerProvider,
+				CaptureRequestBody:  config.CaptureRequestBody,
+				CaptureResponseBody: config.CaptureResponseBody,
+				RedactHeaders:       config.RedactHeaders,
+				RedactRequestBody:   config.RedactRequestBody,
+				RedactResponseBody:  config.RedactResponseBody,
+			}))
+			for _, route := range routes {
+				router.HandleFunc(route.Pattern, route.Handler).Methods(route.Method)
+			}
+			return router
+		},
+	})
+}
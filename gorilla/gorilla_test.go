@@ -6,17 +6,35 @@ package monoscopegorilla
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	apt "github.com/monoscope-tech/monoscope-go"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 func TestMiddleware(t *testing.T) {
@@ -374,3 +392,2025 @@ func TestMultipleWriteHeaderCalls(t *testing.T) {
 		t.Errorf("Multiple WriteHeader calls not handled correctly: got %d, want 201", rec.Code)
 	}
 }
+
+func TestUnmatchedRouteHandlers(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	config := Config{ServiceName: "test-service"}
+	router := mux.NewRouter()
+	router.Use(Middleware(config))
+	router.NotFoundHandler = NotFoundHandler(config, nil)
+	router.MethodNotAllowedHandler = MethodNotAllowedHandler(config, nil)
+	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+
+	tests := []struct {
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound},
+		{http.MethodPost, "/users", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		exporter.Reset()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.expectedStatus, rec.Code)
+		}
+		if spans := exporter.GetSpans(); len(spans) != 1 {
+			t.Errorf("%s %s: expected 1 span, got %d", tt.method, tt.path, len(spans))
+		}
+	}
+}
+
+func TestFileResponseBodySkipped(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{ServiceName: "test-service", CaptureResponseBody: true}))
+	router.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+		w.Write([]byte("a,b,c"))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download", nil))
+
+	if rec.Body.String() != "a,b,c" {
+		t.Errorf("Expected download body to pass through, got %q", rec.Body.String())
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["http.response.body_skipped"] != "true" {
+		t.Error("Expected http.response.body_skipped to be set for attachments")
+	}
+	if attrs["http.response.body"] != "" {
+		t.Errorf("Expected no captured response body, got %q", attrs["http.response.body"])
+	}
+}
+
+func TestLifecycleSpanEvents(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{ServiceName: "test-service", CaptureRequestBody: true}))
+	router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString("{}")))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	expected := []string{
+		"monoscope.request.body_read",
+		"monoscope.handler.start",
+		"monoscope.response.first_byte",
+		"monoscope.handler.complete",
+	}
+	events := spans[0].Events
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d span events, got %d", len(expected), len(events))
+	}
+	for i, name := range expected {
+		if events[i].Name != name {
+			t.Errorf("Expected event %d to be %s, got %s", i, name, events[i].Name)
+		}
+	}
+}
+
+func TestMiddlewareWithTracerProvider(t *testing.T) {
+	globalExporter := tracetest.NewInMemoryExporter()
+	globalTP := trace.NewTracerProvider(trace.WithSyncer(globalExporter))
+	otel.SetTracerProvider(globalTP)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = globalTP.Shutdown(context.Background())
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{ServiceName: "test-service", TracerProvider: tp}))
+	router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if got := len(exporter.GetSpans()); got != 1 {
+		t.Errorf("Expected 1 span on the configured provider, got %d", got)
+	}
+	if got := len(globalExporter.GetSpans()); got != 0 {
+		t.Errorf("Expected no spans on the global provider, got %d", got)
+	}
+}
+
+func TestMiddlewareWithPropagators(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{
+		ServiceName:    "test-service",
+		TracerProvider: tp,
+		Propagators:    propagation.TraceContext{},
+	}))
+	router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected span to continue the incoming trace, got trace ID %s", got)
+	}
+	if got := spans[0].Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected incoming span as parent, got %s", got)
+	}
+}
+
+func TestMiddlewareClockAndMessageID(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	fixedID := uuid.MustParse("00000000-0000-0000-0000-000000000042")
+	fixedTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{
+		TracerProvider: tp,
+		Now:            func() time.Time { return fixedTime },
+		NewMessageID:   func() uuid.UUID { return fixedID },
+	}))
+	router.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+		ReportError(r.Context(), errors.New("failed"))
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["apitoolkit.msg_id"] != fixedID.String() {
+		t.Errorf("Expected msg_id %s, got %s", fixedID, attrs["apitoolkit.msg_id"])
+	}
+	var reported []struct {
+		When time.Time `json:"when"`
+	}
+	if err := json.Unmarshal([]byte(attrs["apitoolkit.errors"]), &reported); err != nil || len(reported) != 1 {
+		t.Fatalf("Expected one reported error, got %s", attrs["apitoolkit.errors"])
+	}
+	if !reported[0].When.Equal(fixedTime) {
+		t.Errorf("Expected error time %s, got %s", fixedTime, reported[0].When)
+	}
+}
+
+func TestMiddlewareCapturesPanic(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/crash", func(w http.ResponseWriter, r *http.Request) {
+		panic(42)
+	})
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != 42 {
+				t.Errorf("Expected the original panic value to be re-raised, got %v", recovered)
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/crash", nil))
+	}()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["http.response.status_code"] != "500" {
+		t.Errorf("Expected status 500, got %s", attrs["http.response.status_code"])
+	}
+	var panicInfo apt.PanicInfo
+	if err := json.Unmarshal([]byte(attrs["apitoolkit.panic"]), &panicInfo); err != nil {
+		t.Fatalf("Expected a panic section, got %q", attrs["apitoolkit.panic"])
+	}
+	if panicInfo.Value != "42" || panicInfo.ValueType != "int" {
+		t.Errorf("Expected panic value 42 of type int, got %q of type %q", panicInfo.Value, panicInfo.ValueType)
+	}
+	if panicInfo.GoroutineID == 0 || !strings.Contains(panicInfo.Stack, "TestMiddlewareCapturesPanic") {
+		t.Errorf("Expected goroutine ID and the panicking frame in the stack, got %d and %q", panicInfo.GoroutineID, panicInfo.Stack)
+	}
+	if attrs["apitoolkit.errors"] != "[]" {
+		t.Errorf("Expected the panic to stay out of the error list, got %s", attrs["apitoolkit.errors"])
+	}
+}
+
+func TestBodylessResponses(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureResponseBody: true}))
+	router.HandleFunc("/doc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "5000000")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+		}
+		_, _ = w.Write([]byte(`{"doc":true}`))
+	})
+
+	tests := []struct {
+		name            string
+		method          string
+		ifNoneMatch     string
+		cacheValidation string
+	}{
+		{name: "not modified", method: http.MethodGet, ifNoneMatch: `"v1"`, cacheValidation: "hit"},
+		{name: "stale validator", method: http.MethodGet, ifNoneMatch: `"v0"`, cacheValidation: "miss"},
+		{name: "head", method: http.MethodHead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			req := httptest.NewRequest(tt.method, "/doc", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[string]string{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+			if attrs["http.cache_validation"] != tt.cacheValidation {
+				t.Errorf("Expected cache validation %q, got %q", tt.cacheValidation, attrs["http.cache_validation"])
+			}
+			if tt.cacheValidation == "miss" {
+				return
+			}
+			if attrs["http.response.body"] != "" {
+				t.Errorf("Expected no response body, got %q", attrs["http.response.body"])
+			}
+			if _, ok := attrs["http.response.body_skipped"]; ok {
+				t.Error("Expected a bodyless response not to be reported as a skipped download")
+			}
+		})
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	release := make(chan struct{})
+	defer close(release)
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, HandlerTimeout: 20 * time.Millisecond}))
+	router.HandleFunc("/slow/{id}", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		ReportError(r.Context(), errors.New("too late"))
+	})
+	router.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		ReportError(r.Context(), errors.New("handled"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	tests := []struct {
+		path       string
+		status     int
+		timedOut   bool
+		errMessage string
+	}{
+		{path: "/slow/1", status: http.StatusServiceUnavailable, timedOut: true, errMessage: "GET /slow/{id} timed out"},
+		{path: "/fast", status: http.StatusAccepted, errMessage: "handled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			exporter.Reset()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[string]string{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+			if got := attrs["apitoolkit.handler_timed_out"] == "true"; got != tt.timedOut {
+				t.Errorf("Expected handler_timed_out %v, got %v", tt.timedOut, got)
+			}
+			if !strings.Contains(attrs["apitoolkit.errors"], tt.errMessage) {
+				t.Errorf("Expected an error containing %q, got %s", tt.errMessage, attrs["apitoolkit.errors"])
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	for _, tt := range []struct {
+		name     string
+		maxWait  time.Duration
+		status   int
+		shed     bool
+		queueing bool
+	}{
+		{name: "shed when full", status: http.StatusServiceUnavailable, shed: true},
+		{name: "queue for a slot", maxWait: 5 * time.Second, status: http.StatusOK, queueing: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			entered, release := make(chan struct{}), make(chan struct{})
+			router := mux.NewRouter()
+			router.Use(Middleware(Config{
+				TracerProvider:   tp,
+				ConcurrencyLimit: apt.ConcurrencyLimit{MaxConcurrent: 1, MaxQueueWait: tt.maxWait},
+			}))
+			router.HandleFunc("/busy", func(w http.ResponseWriter, r *http.Request) {
+				entered <- struct{}{}
+				<-release
+			})
+			router.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/busy", nil))
+			}()
+			<-entered
+			if tt.queueing {
+				time.AfterFunc(20*time.Millisecond, func() { close(release) })
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+			if !tt.queueing {
+				close(release)
+			}
+			<-done
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+
+			var attrs map[string]string
+			for _, span := range exporter.GetSpans() {
+				spanAttrs := map[string]string{}
+				for _, attr := range span.Attributes {
+					spanAttrs[string(attr.Key)] = attr.Value.Emit()
+				}
+				if spanAttrs["http.route"] == "/other" {
+					attrs = spanAttrs
+				}
+			}
+			if attrs == nil {
+				t.Fatal("Expected a span for the limited request")
+			}
+			if got := attrs["apitoolkit.shed"] == "true"; got != tt.shed {
+				t.Errorf("Expected shed %v, got %v", tt.shed, got)
+			}
+			if _, got := attrs["apitoolkit.queue_wait_ms"]; got != tt.queueing {
+				t.Errorf("Expected queue wait recorded %v, got %v", tt.queueing, got)
+			}
+		})
+	}
+}
+
+func TestNestedMiddlewareReportsChildPayload(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	// The inner middleware stands in for instrumentation of an in-process
+	// gRPC-Gateway mounted behind the router.
+	config := Config{TracerProvider: tp}
+	router := mux.NewRouter()
+	router.Use(Middleware(config))
+	router.Handle("/v1/items", Middleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/items", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	inner, outer := map[string]string{}, map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		inner[string(attr.Key)] = attr.Value.Emit()
+	}
+	for _, attr := range spans[1].Attributes {
+		outer[string(attr.Key)] = attr.Value.Emit()
+	}
+	if _, ok := outer["apitoolkit.parent_msg_id"]; ok {
+		t.Errorf("Expected the outer payload to have no parent, got %s", outer["apitoolkit.parent_msg_id"])
+	}
+	if inner["apitoolkit.parent_msg_id"] != outer["apitoolkit.msg_id"] {
+		t.Errorf("Expected the inner payload to reference %s as its parent, got %q", outer["apitoolkit.msg_id"], inner["apitoolkit.parent_msg_id"])
+	}
+}
+
+func TestSemanticConventions(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, SemanticConventions: true}))
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com:8443/items/7?full=1", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	expected := map[string]string{
+		"http.route":               "/items/{id}",
+		"url.path":                 "/items/7",
+		"url.query":                "full=1",
+		"server.address":           "api.example.com",
+		"server.port":              "8443",
+		"network.protocol.name":    "http",
+		"network.protocol.version": "1.1",
+		"user_agent.original":      "curl/8.0",
+		"error.type":               "502",
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, attrs[key])
+		}
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("Expected error span status for a 502, got %v", spans[0].Status.Code)
+	}
+}
+
+func TestRequestDurationExemplars(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, MeterProvider: mp}))
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/7", nil))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 1 || len(rm.ScopeMetrics[0].Metrics) != 1 {
+		t.Fatalf("Expected one metric, got %+v", rm.ScopeMetrics)
+	}
+	m := rm.ScopeMetrics[0].Metrics[0]
+	if m.Name != apt.RequestDurationMetric {
+		t.Errorf("Expected %s, got %s", apt.RequestDurationMetric, m.Name)
+	}
+	histogram, ok := m.Data.(metricdata.Histogram[float64])
+	if !ok || len(histogram.DataPoints) != 1 {
+		t.Fatalf("Expected one histogram data point, got %+v", m.Data)
+	}
+	point := histogram.DataPoints[0]
+	if route, _ := point.Attributes.Value("http.route"); route.AsString() != "/items/{id}" {
+		t.Errorf("Expected route attribute, got %q", route.AsString())
+	}
+	traceID := exporter.GetSpans()[0].SpanContext.TraceID()
+	if len(point.Exemplars) != 1 || string(point.Exemplars[0].TraceID) != string(traceID[:]) {
+		t.Errorf("Expected an exemplar for trace %s, got %+v", traceID, point.Exemplars)
+	}
+}
+
+func TestRequestBodyCaptureIsBounded(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	var received int
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureRequestBody: true}))
+	router.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = len(body)
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		size       int
+		incomplete bool
+	}{
+		{name: "GET with body", method: http.MethodGet, size: 16},
+		{name: "chunked over the limit", method: http.MethodPost, size: apt.MaxCaptureContentLength + 10, incomplete: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			// io.MultiReader hides the length, so the request is sent chunked.
+			body := io.MultiReader(strings.NewReader(strings.Repeat("a", tt.size)))
+			req := httptest.NewRequest(tt.method, "/upload", body)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if received != tt.size {
+				t.Errorf("Expected the handler to read %d bytes, got %d", tt.size, received)
+			}
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[string]attribute.Value{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value
+			}
+			if got := attrs["http.request.body_incomplete"].AsBool(); got != tt.incomplete {
+				t.Errorf("Expected body_incomplete %v, got %v", tt.incomplete, got)
+			}
+		})
+	}
+}
+
+func TestPayloadHook(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSpanProcessor(apt.NewDropFilter(trace.NewSimpleSpanProcessor(exporter))))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	hook := func(payload *apt.Payload) *apt.Payload {
+		if payload.URLPath == "/health" {
+			return nil
+		}
+		payload.ResponseBody = nil
+		payload.Tags = append(payload.Tags, "hooked")
+		return payload
+	}
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureResponseBody: true, PayloadHook: hook}))
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, child := tp.Tracer("test").Start(r.Context(), "db.ping")
+		child.End()
+	})
+	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"secret":"value"}`))
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("Expected the vetoed payload and its children not to be exported, got %d spans", len(spans))
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if body := attrs["http.response.body"]; body != "" {
+		t.Errorf("Expected the hook to drop the response body, got %q", body)
+	}
+	if tags := attrs["apitoolkit.tags"]; !strings.Contains(tags, "hooked") {
+		t.Errorf("Expected the hook's tag to be recorded, got %q", tags)
+	}
+}
+
+func TestConfigureOpenTelemetryDropsVetoedSpans(t *testing.T) {
+	prevTP, prevProp, prevHandler := otel.GetTracerProvider(), otel.GetTextMapPropagator(), otel.GetErrorHandler()
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+		otel.SetErrorHandler(prevHandler)
+	}()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:1")
+	t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "100")
+
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := ConfigureOpenTelemetry(WithSpanProcessor(trace.NewSimpleSpanProcessor(exporter)), WithMetricsEnabled(false),
+		WithErrorHandler(otel.ErrorHandlerFunc(func(error) {})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{PayloadHook: func(payload *apt.Payload) *apt.Payload {
+		if payload.URLPath == "/health" {
+			return nil
+		}
+		return payload
+	}}))
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected only the kept request's span to be exported, got %d", len(spans))
+	}
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "http.route" && attr.Value.AsString() != "/users" {
+			t.Errorf("Expected the /users span, got route %q", attr.Value.AsString())
+		}
+	}
+}
+
+func TestTagDataSubject(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/transfers", func(w http.ResponseWriter, r *http.Request) {
+		TagDataSubject(r.Context(), "user-1")
+		TagDataSubject(r.Context(), "user-2")
+		TagDataSubject(r.Context(), "user-1")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/transfers", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	var subjects []string
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "apitoolkit.data_subjects" {
+			subjects = attr.Value.AsStringSlice()
+		}
+	}
+	if want := []string{"user-1", "user-2"}; !slices.Equal(subjects, want) {
+		t.Errorf("Expected data subjects %v, got %v", want, subjects)
+	}
+}
+
+func TestConsentFunc(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	consent := func(r *http.Request) apt.CaptureLevel {
+		switch r.Header.Get("X-Consent") {
+		case "metadata":
+			return apt.CaptureMetadataOnly
+		case "none":
+			return apt.CaptureNone
+		}
+		return apt.CaptureFull
+	}
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true, ConsentFunc: consent}))
+	router.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	})
+
+	tests := []struct {
+		consent      string
+		spans        int
+		metadataOnly bool
+	}{
+		{consent: "full", spans: 1},
+		{consent: "metadata", spans: 1, metadataOnly: true},
+		{consent: "none", spans: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.consent, func(t *testing.T) {
+			exporter.Reset()
+			req := httptest.NewRequest(http.MethodPost, "/profile", strings.NewReader(`{"name":"jane"}`))
+			req.Header.Set("X-Consent", tt.consent)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Body.String() != `{"name":"jane"}` {
+				t.Errorf("Expected the handler to echo the body, got %q", rec.Body.String())
+			}
+			spans := exporter.GetSpans()
+			if len(spans) != tt.spans {
+				t.Fatalf("Expected %d spans, got %d", tt.spans, len(spans))
+			}
+			if tt.spans == 0 {
+				return
+			}
+			attrs := map[string]attribute.Value{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value
+			}
+			if got := attrs["apitoolkit.metadata_only"].AsBool(); got != tt.metadataOnly {
+				t.Errorf("Expected metadata_only %v, got %v", tt.metadataOnly, got)
+			}
+			if captured := attrs["http.request.body"].AsString() != ""; captured == tt.metadataOnly {
+				t.Errorf("Expected request body captured %v, got %v", !tt.metadataOnly, captured)
+			}
+		})
+	}
+}
+
+func TestProbesMetadataOnly(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureResponseBody: true, ProbesMetadataOnly: true}))
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("User-Agent", "kube-probe/1.29")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]attribute.Value{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value
+	}
+	if got := attrs["apitoolkit.traffic_class"].AsString(); got != string(apt.TrafficProbe) {
+		t.Errorf("Expected traffic class probe, got %q", got)
+	}
+	if !attrs["apitoolkit.metadata_only"].AsBool() {
+		t.Error("Expected the probe to be captured metadata-only")
+	}
+	if body := attrs["http.response.body"].AsString(); body != "" {
+		t.Errorf("Expected no response body for the probe, got %q", body)
+	}
+}
+
+func TestWrapCache(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	prices := map[string]int{"apple": 3}
+	cache := apt.WrapCache("prices", apt.CacheFunc[string, int](func(key string) (int, bool) {
+		price, ok := prices[key]
+		return price, ok
+	}))
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/prices", func(w http.ResponseWriter, r *http.Request) {
+		for _, fruit := range []string{"apple", "apple", "pear", "apple"} {
+			cache.Get(r.Context(), fruit)
+		}
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/prices", nil))
+
+	spans := exporter.GetSpans()
+	var lookups, hits int
+	attrs := map[string]attribute.Value{}
+	for _, span := range spans {
+		switch span.Name {
+		case "monoscope.cache":
+			lookups++
+			for _, attr := range span.Attributes {
+				if attr.Key == "cache.hit" && attr.Value.AsBool() {
+					hits++
+				}
+			}
+		case "monoscope.http":
+			for _, attr := range span.Attributes {
+				attrs[string(attr.Key)] = attr.Value
+			}
+		}
+	}
+	if lookups != 4 || hits != 3 {
+		t.Errorf("Expected 4 cache spans with 3 hits, got %d with %d hits", lookups, hits)
+	}
+	if got := attrs["apitoolkit.cache_stats"].AsString(); got != `{"prices":{"hits":3,"misses":1}}` {
+		t.Errorf("Unexpected cache stats %s", got)
+	}
+	if got := attrs["apitoolkit.cache_hit_rate"].AsFloat64(); got != 0.75 {
+		t.Errorf("Expected a hit rate of 0.75, got %v", got)
+	}
+}
+
+func TestStartSegment(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx, render := apt.StartSegment(r.Context(), "render.user_profile")
+		_, serialize := apt.StartSegment(ctx, "serialize")
+		serialize.End()
+		render.End()
+		render.End()
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	serialize, render, request := spans[0], spans[1], spans[2]
+	if serialize.Parent.SpanID() != render.SpanContext.SpanID() || render.Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Error("Expected segments to nest under the request span")
+	}
+	var segments []apt.SegmentTiming
+	for _, attr := range request.Attributes {
+		if attr.Key == "apitoolkit.segments" {
+			_ = json.Unmarshal([]byte(attr.Value.AsString()), &segments)
+		}
+	}
+	if len(segments) != 2 || segments[0].Name != "serialize" || segments[1].Name != "render.user_profile" {
+		t.Errorf("Expected the serialize and render.user_profile segments, got %+v", segments)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, IdempotencyKeyHeader: "Idempotency-Key"}))
+	router.HandleFunc("/charges", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}).Methods(http.MethodPost)
+	for _, key := range []string{"key-1", "key-1", "key-2"} {
+		req := httptest.NewRequest(http.MethodPost, "/charges", nil)
+		req.Header.Set("Idempotency-Key", key)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	attrs := make([]map[string]attribute.Value, len(spans))
+	for i, span := range spans {
+		attrs[i] = map[string]attribute.Value{}
+		for _, attr := range span.Attributes {
+			attrs[i][string(attr.Key)] = attr.Value
+		}
+	}
+	first, retry, other := attrs[0], attrs[1], attrs[2]
+	if first["apitoolkit.idempotency_key"] != retry["apitoolkit.idempotency_key"] || first["apitoolkit.idempotency_key"] == other["apitoolkit.idempotency_key"] {
+		t.Error("Expected attempts to share a key hash distinct from other keys")
+	}
+	if strings.Contains(first["apitoolkit.idempotency_key"].AsString(), "key-1") {
+		t.Error("Expected the idempotency key to be hashed")
+	}
+	if got := retry["apitoolkit.idempotency_attempt"].AsInt64(); got != 2 {
+		t.Errorf("Expected the retry to be attempt 2, got %d", got)
+	}
+	if got, want := retry["apitoolkit.idempotency_first_msg_id"].AsString(), first["apitoolkit.msg_id"].AsString(); got != want {
+		t.Errorf("Expected the retry to link to %s, got %s", want, got)
+	}
+	if _, ok := first["apitoolkit.idempotency_first_msg_id"]; ok {
+		t.Error("Expected no first message ID on the first attempt")
+	}
+	if got := other["apitoolkit.idempotency_attempt"].AsInt64(); got != 1 {
+		t.Errorf("Expected a new key to be attempt 1, got %d", got)
+	}
+}
+
+func TestDebugCaptureHeader(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter), trace.WithSampler(apt.ForcedSampler(trace.NeverSample())))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	secret := []byte("support-secret")
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, DebugCaptureSecret: secret}))
+	router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":1}`))
+	})
+	send := func(value string) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if value != "" {
+			req.Header.Set(apt.DebugCaptureHeader, value)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("")
+	send(apt.SignDebugCapture([]byte("wrong-secret"), time.Now().Add(time.Hour)))
+	send(apt.SignDebugCapture(secret, time.Now().Add(-time.Minute)))
+	if got := len(exporter.GetSpans()); got != 0 {
+		t.Fatalf("Expected unsigned, forged and expired requests to stay unsampled, got %d spans", got)
+	}
+
+	send(apt.SignDebugCapture(secret, time.Now().Add(time.Hour)))
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected the signed request to be sampled, got %d spans", len(spans))
+	}
+	attrs := map[string]attribute.Value{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value
+	}
+	if !attrs["apitoolkit.force_sampled"].AsBool() {
+		t.Error("Expected the payload to be marked force-sampled")
+	}
+	body, _ := base64.StdEncoding.DecodeString(attrs["http.response.body"].AsString())
+	if string(body) != `{"id":1}` {
+		t.Errorf("Expected the response body to be captured, got %q", body)
+	}
+}
+
+// serverSpansOnly samples request spans and nothing else.
+type serverSpansOnly struct{}
+
+func (serverSpansOnly) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	if p.Kind == oteltrace.SpanKindServer {
+		return trace.SamplingResult{Decision: trace.RecordAndSample}
+	}
+	return trace.SamplingResult{Decision: trace.Drop}
+}
+
+func (serverSpansOnly) Description() string { return "serverSpansOnly" }
+
+func TestForceSample(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter), trace.WithSampler(apt.ForcedSampler(serverSpansOnly{})))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		_, before := apt.StartSegment(r.Context(), "before")
+		before.End()
+		ForceSample(r.Context())
+		_, after := apt.StartSegment(r.Context(), "after")
+		after.End()
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	names := map[string]bool{}
+	var forced bool
+	for _, span := range exporter.GetSpans() {
+		names[span.Name] = true
+		for _, attr := range span.Attributes {
+			if attr.Key == "apitoolkit.force_sampled" {
+				forced = attr.Value.AsBool()
+			}
+		}
+	}
+	if names["before"] || !names["after"] {
+		t.Errorf("Expected only spans started after ForceSample to be sampled, got %v", names)
+	}
+	if !forced {
+		t.Error("Expected the request payload to be marked force-sampled")
+	}
+}
+
+func TestStage(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	router := mux.NewRouter()
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now = now.Add(10 * time.Millisecond)
+			next.ServeHTTP(w, r)
+		})
+	}
+	router.Use(Middleware(Config{TracerProvider: tp, Now: func() time.Time { return now }}), Stage("auth"), auth, Stage("handler"))
+	router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		now = now.Add(30 * time.Millisecond)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	var stages []apt.StageTiming
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "apitoolkit.stages" {
+			_ = json.Unmarshal([]byte(attr.Value.AsString()), &stages)
+		}
+	}
+	want := []apt.StageTiming{
+		{Name: "auth", Duration: 40 * time.Millisecond, Self: 10 * time.Millisecond},
+		{Name: "handler", Duration: 30 * time.Millisecond, Self: 30 * time.Millisecond},
+	}
+	if !slices.Equal(stages, want) {
+		t.Errorf("Expected stages %+v, got %+v", want, stages)
+	}
+	entered := 0
+	for _, event := range spans[0].Events {
+		if event.Name == apt.EventStageEnter {
+			entered++
+		}
+	}
+	if entered != 2 {
+		t.Errorf("Expected 2 stage events, got %d", entered)
+	}
+}
+
+func TestReuseExistingSpan(t *testing.T) {
+	for _, reuse := range []bool{true, false} {
+		exporter := tracetest.NewInMemoryExporter()
+		tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+
+		router := mux.NewRouter()
+		router.Use(Middleware(Config{TracerProvider: tp, ReuseExistingSpan: reuse}))
+		router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		})
+		// Stands in for otelhttp or otelmux wrapping the router.
+		instrumented := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tp.Tracer("otelhttp").Start(r.Context(), "GET /orders", oteltrace.WithSpanKind(oteltrace.SpanKindServer))
+			defer span.End()
+			router.ServeHTTP(w, r.WithContext(ctx))
+		})
+		instrumented.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+		spans := exporter.GetSpans()
+		if reuse {
+			if len(spans) != 1 || spans[0].Name != "GET /orders" {
+				t.Fatalf("Expected only the existing span, got %d spans", len(spans))
+			}
+			attrs := map[string]attribute.Value{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value
+			}
+			if got := attrs["http.response.status_code"].AsInt64(); got != http.StatusAccepted {
+				t.Errorf("Expected the payload on the existing span, got status %d", got)
+			}
+		} else if len(spans) != 2 {
+			t.Errorf("Expected a nested monoscope.http span without ReuseExistingSpan, got %d spans", len(spans))
+		}
+		_ = tp.Shutdown(context.Background())
+	}
+}
+
+func TestSubrouterTemplates(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/users/{id}", ok)
+	api.PathPrefix("/v2").Subrouter().HandleFunc("/items/{id}", ok)
+	router.PathPrefix("/static/").HandlerFunc(ok)
+	router.Host("{tenant}.example.com").Subrouter().HandleFunc("/dashboard", ok)
+
+	tests := []struct {
+		url, route, host string
+	}{
+		{url: "/api/users/1", route: "/api/users/{id}"},
+		{url: "/api/v2/items/3", route: "/api/v2/items/{id}"},
+		{url: "/static/app.css", route: "/static/*"},
+		{url: "http://acme.example.com/dashboard", route: "/dashboard", host: "{tenant}.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			exporter.Reset()
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.url, nil))
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[string]string{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+			if attrs["http.route"] != tt.route {
+				t.Errorf("Expected route %q, got %q", tt.route, attrs["http.route"])
+			}
+			if attrs["apitoolkit.host_template"] != tt.host {
+				t.Errorf("Expected host template %q, got %q", tt.host, attrs["apitoolkit.host_template"])
+			}
+		})
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureResponseBody: true, SPAFallbackRoutes: []string{"/*"}}))
+	router.HandleFunc("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":1}`))
+	})
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	})
+
+	for _, tt := range []struct{ url, route, body string }{
+		{"/", "/*spa", ""},
+		{"/settings/profile", "/*spa", ""},
+		{"/api/users/1", "/api/users/{id}", base64.StdEncoding.EncodeToString([]byte(`{"id":1}`))},
+	} {
+		exporter.Reset()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("%s: expected 1 span, got %d", tt.url, len(spans))
+		}
+		attrs := map[string]string{}
+		for _, attr := range spans[0].Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		if attrs["http.route"] != tt.route || attrs["http.response.body"] != tt.body {
+			t.Errorf("%s: expected route %q and body %q, got %q and %q", tt.url, tt.route, tt.body, attrs["http.route"], attrs["http.response.body"])
+		}
+		if rec.Body.Len() == 0 {
+			t.Errorf("%s: expected the client to receive the body", tt.url)
+		}
+	}
+}
+
+func TestNilRoute(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	config := Config{TracerProvider: tp}
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handlers := map[string]http.Handler{
+		"wrapping the router": Middleware(config)(router),
+		"without a router":    Middleware(config)(http.NotFoundHandler()),
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			exporter.Reset()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			for _, attr := range spans[0].Attributes {
+				if attr.Key == "http.route" && attr.Value.AsString() != "" {
+					t.Errorf("Expected an empty route, got %q", attr.Value.AsString())
+				}
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, HeartbeatInterval: 5 * time.Millisecond}))
+	router.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("a,b,c"))
+		time.Sleep(40 * time.Millisecond)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/export", nil))
+
+	spans := exporter.GetSpans()
+	request := spans[len(spans)-1]
+	heartbeats := 0
+	for _, span := range spans[:len(spans)-1] {
+		if span.Name != apt.EventHeartbeat {
+			t.Fatalf("Expected the request span last, after its heartbeats, got %s", span.Name)
+		}
+		heartbeats++
+		if len(span.Links) != 1 || span.Links[0].SpanContext.SpanID() != request.SpanContext.SpanID() {
+			t.Errorf("Expected the heartbeat to link to the request span, got %v", span.Links)
+		}
+		for _, attr := range span.Attributes {
+			if attr.Key == "apitoolkit.bytes_written" && attr.Value.AsInt64() != 5 {
+				t.Errorf("Expected 5 bytes written so far, got %d", attr.Value.AsInt64())
+			}
+		}
+	}
+	if heartbeats == 0 {
+		t.Error("Expected heartbeats exported while the request was running")
+	}
+}
+
+// failingWriter is a ResponseWriter whose client went away after limit bytes.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if len(b) <= w.limit {
+		w.limit -= len(b)
+		return w.ResponseRecorder.Write(b)
+	}
+	n, _ := w.ResponseRecorder.Write(b[:w.limit])
+	w.limit = 0
+	return n, errors.New("write: broken pipe")
+}
+
+func TestResponseWriteError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, CaptureResponseBody: true}))
+	router.HandleFunc("/report", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":12345}`))
+		_, _ = w.Write([]byte(`{"id":67890}`))
+	})
+	router.ServeHTTP(&failingWriter{httptest.NewRecorder(), 12}, httptest.NewRequest(http.MethodGet, "/report", nil))
+
+	attrs := map[string]string{}
+	for _, attr := range exporter.GetSpans()[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["apitoolkit.response_aborted"] != "true" || attrs["apitoolkit.response_bytes_written"] != "12" {
+		t.Errorf("Expected an aborted response with 12 bytes written, got %v", attrs)
+	}
+	if !strings.Contains(attrs["apitoolkit.errors"], "write: broken pipe") {
+		t.Errorf("Expected the write error to be recorded, got %s", attrs["apitoolkit.errors"])
+	}
+	if body, _ := base64.StdEncoding.DecodeString(attrs["http.response.body"]); string(body) != `{"id":12345}` {
+		t.Errorf("Expected only the delivered bytes to be captured, got %q", body)
+	}
+}
+
+// slowReader yields chunks of data with a delay before each.
+type slowReader struct {
+	chunks int
+	delay  time.Duration
+}
+
+func (r *slowReader) Read(b []byte) (int, error) {
+	if r.chunks == 0 {
+		return 0, io.EOF
+	}
+	r.chunks--
+	time.Sleep(r.delay)
+	return copy(b, "0123456789"), nil
+}
+
+func TestStreamingByteCount(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		for range 4 {
+			_, _ = w.Write([]byte("data: tick\n\n"))
+			http.NewResponseController(w).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+	// io.Copy goes through the server's ReadFrom in a single call.
+	router.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, &slowReader{chunks: 4, delay: 5 * time.Millisecond})
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for path, size := range map[string]int64{"/events": 48, "/download": 40} {
+		exporter.Reset()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("%s: expected 1 span, got %d", path, len(spans))
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range spans[0].Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		if got := attrs["apitoolkit.response_bytes_written"].AsInt64(); got != size {
+			t.Errorf("%s: expected %d bytes written without body capture, got %d", path, size, got)
+		}
+		if got := attrs["apitoolkit.response_write_ms"].AsFloat64(); got < 15 {
+			t.Errorf("%s: expected the write duration to span the stream, got %.2fms", path, got)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	serve := func(config Config, method string) []tracetest.SpanStub {
+		exporter := tracetest.NewInMemoryExporter()
+		config.TracerProvider = trace.NewTracerProvider(trace.WithSpanProcessor(apt.NewDropFilter(trace.NewSimpleSpanProcessor(exporter))))
+		router := mux.NewRouter()
+		router.Use(Middleware(config))
+		router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodOptions, http.MethodPost)
+		req := httptest.NewRequest(method, "/orders", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return exporter.GetSpans()
+	}
+	requestType := func(span tracetest.SpanStub) string {
+		for _, attr := range span.Attributes {
+			if attr.Key == "apitoolkit.request_type" {
+				return attr.Value.AsString()
+			}
+		}
+		return ""
+	}
+
+	spans := serve(Config{}, http.MethodOptions)
+	if len(spans) != 1 || requestType(spans[0]) != apt.RequestTypePreflight {
+		t.Errorf("Expected the preflight to be tagged, got %v", spans)
+	}
+	if spans := serve(Config{}, http.MethodPost); len(spans) != 1 || requestType(spans[0]) != "" {
+		t.Errorf("Expected a POST not to be tagged as a preflight")
+	}
+	if spans := serve(Config{DropPreflight: true}, http.MethodOptions); len(spans) != 0 {
+		t.Errorf("Expected DropPreflight to leave preflights unreported, got %d spans", len(spans))
+	}
+	if spans := serve(Config{DropPreflight: true}, http.MethodPost); len(spans) != 1 {
+		t.Errorf("Expected DropPreflight to keep other requests, got %d spans", len(spans))
+	}
+}
+
+func TestGRPCSystemCalls(t *testing.T) {
+	serve := func(config Config, path string) []tracetest.SpanStub {
+		exporter := tracetest.NewInMemoryExporter()
+		config.TracerProvider = trace.NewTracerProvider(trace.WithSyncer(exporter))
+		config.CaptureRequestBody = true
+		handler := Middleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"service":""}`))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return exporter.GetSpans()
+	}
+
+	if spans := serve(Config{}, "/grpc.health.v1.Health/Check"); len(spans) != 0 {
+		t.Errorf("Expected health checks to be skipped by default, got %d spans", len(spans))
+	}
+	if spans := serve(Config{}, "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"); len(spans) != 0 {
+		t.Errorf("Expected reflection calls to be skipped by default, got %d spans", len(spans))
+	}
+	if spans := serve(Config{}, "/orders.v1.Orders/Get"); len(spans) != 1 {
+		t.Errorf("Expected other calls to be reported, got %d spans", len(spans))
+	}
+	spans := serve(Config{GRPCSystemCalls: apt.GRPCSystemCallsMetadataOnly}, "/grpc.health.v1.Health/Check")
+	if len(spans) != 1 {
+		t.Fatalf("Expected the health check to be reported, got %d spans", len(spans))
+	}
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "http.request.body" && attr.Value.AsString() != "" {
+			t.Errorf("Expected no request body in metadata-only mode, got %q", attr.Value.AsString())
+		}
+	}
+}
+
+func TestIdentityFunc(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	customers := map[string]apt.Identity{"key-1": {CustomerID: "cus_1", Plan: "enterprise"}}
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{
+		TracerProvider: tp,
+		IdentityFunc: func(r *http.Request) apt.Identity {
+			return customers[r.Header.Get("X-Api-Key")]
+		},
+	}))
+	router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {})
+	for _, key := range []string{"key-1", "unknown"} {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-Api-Key", key)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["apitoolkit.customer_id"] != "cus_1" || attrs["apitoolkit.customer_plan"] != "enterprise" {
+		t.Errorf("Expected the caller's identity, got %v", attrs)
+	}
+	for _, attr := range spans[1].Attributes {
+		if attr.Key == "apitoolkit.customer_id" {
+			t.Errorf("Expected no identity for an unknown key, got %s", attr.Value.Emit())
+		}
+	}
+}
+
+func TestSetFeatureFlags(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/checkout", func(w http.ResponseWriter, r *http.Request) {
+		SetFeatureFlags(r.Context(), map[string]string{"new-checkout": "on", "pricing": "control"})
+		apt.RecordFlagEvaluation(r.Context(), "pricing", "variant-b")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/checkout", nil))
+
+	var flags map[string]string
+	for _, attr := range exporter.GetSpans()[0].Attributes {
+		if attr.Key == "apitoolkit.feature_flags" {
+			_ = json.Unmarshal([]byte(attr.Value.AsString()), &flags)
+		}
+	}
+	if flags["new-checkout"] != "on" || flags["pricing"] != "variant-b" || len(flags) != 2 {
+		t.Errorf("Expected the request's flag variations, got %v", flags)
+	}
+}
+
+func TestExperiments(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}), Experiments(apt.ExperimentSource{Header: "X-Experiments", Cookie: "ab"}))
+	router.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		SetExperiment(r.Context(), "hero", "video")
+	})
+	withHeader := httptest.NewRequest(http.MethodGet, "/home", nil)
+	withHeader.Header.Set("X-Experiments", "checkout=b&pricing=control")
+	withCookie := httptest.NewRequest(http.MethodGet, "/home", nil)
+	withCookie.AddCookie(&http.Cookie{Name: "ab", Value: "checkout=a"})
+	router.ServeHTTP(httptest.NewRecorder(), withHeader)
+	router.ServeHTTP(httptest.NewRecorder(), withCookie)
+
+	experiments := func(span tracetest.SpanStub) map[string]string {
+		var assigned map[string]string
+		for _, attr := range span.Attributes {
+			if attr.Key == "apitoolkit.experiments" {
+				_ = json.Unmarshal([]byte(attr.Value.AsString()), &assigned)
+			}
+		}
+		return assigned
+	}
+	spans := exporter.GetSpans()
+	if got := experiments(spans[0]); len(got) != 3 || got["checkout"] != "b" || got["pricing"] != "control" || got["hero"] != "video" {
+		t.Errorf("Expected the header's and handler's assignments, got %v", got)
+	}
+	if got := experiments(spans[1]); len(got) != 2 || got["checkout"] != "a" {
+		t.Errorf("Expected the cookie's assignments, got %v", got)
+	}
+}
+
+func TestResponseWriterWithoutBodyCapture(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, flusher := w.(http.Flusher)
+		_, hijacker := w.(http.Hijacker)
+		if !flusher || !hijacker {
+			t.Errorf("Expected the server's optional interfaces, got flusher=%v hijacker=%v", flusher, hijacker)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["http.response.status_code"] != "202" {
+		t.Errorf("Expected the 202 status to be recorded, got %v", attrs)
+	}
+	if body := attrs["http.response.body"]; body != "" {
+		t.Errorf("Expected no response body, got %q", body)
+	}
+}
+
+func TestResponseController(t *testing.T) {
+	for _, capture := range []bool{false, true} {
+		exporter := tracetest.NewInMemoryExporter()
+		tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+
+		router := mux.NewRouter()
+		router.Use(Middleware(Config{TracerProvider: tp, CaptureResponseBody: capture}))
+		router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			deadline := time.Now().Add(time.Minute)
+			if err := rc.SetReadDeadline(deadline); err != nil {
+				t.Errorf("capture=%v: SetReadDeadline: %v", capture, err)
+			}
+			if err := rc.SetWriteDeadline(deadline); err != nil {
+				t.Errorf("capture=%v: SetWriteDeadline: %v", capture, err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := rc.Flush(); err != nil {
+				t.Errorf("capture=%v: Flush: %v", capture, err)
+			}
+			_, _ = w.Write([]byte(`{"event":1}`))
+		})
+		srv := httptest.NewServer(router)
+		resp, err := http.Get(srv.URL + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		srv.Close()
+		if string(body) != `{"event":1}` {
+			t.Errorf("capture=%v: unexpected body %q", capture, body)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("capture=%v: expected 1 span, got %d", capture, len(spans))
+		}
+		attrs := map[string]string{}
+		for _, attr := range spans[0].Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		if attrs["http.response.status_code"] != "200" {
+			t.Errorf("capture=%v: expected the flushed 200 status, got %v", capture, attrs["http.response.status_code"])
+		}
+		wantBody := ""
+		if capture {
+			wantBody = base64.StdEncoding.EncodeToString(body)
+		}
+		if attrs["http.response.body"] != wantBody {
+			t.Errorf("capture=%v: expected response body %q, got %q", capture, wantBody, attrs["http.response.body"])
+		}
+		_ = tp.Shutdown(context.Background())
+	}
+}
+
+func TestInformationalResponses(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, Debug: true}))
+	router.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected the client to get 201, got %d", resp.StatusCode)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "http.response.status_code" && attr.Value.AsInt64() != http.StatusCreated {
+			t.Errorf("Expected the final 201 status, got %d", attr.Value.AsInt64())
+		}
+	}
+	var informational []int64
+	for _, event := range spans[0].Events {
+		if event.Name != apt.EventInformationalResponse {
+			continue
+		}
+		for _, attr := range event.Attributes {
+			if attr.Key == "http.response.status_code" {
+				informational = append(informational, attr.Value.AsInt64())
+			}
+		}
+	}
+	if !slices.Equal(informational, []int64{http.StatusEarlyHints}) {
+		t.Errorf("Expected a 103 informational event, got %v", informational)
+	}
+	if !strings.Contains(logs.String(), "superfluous WriteHeader(500)") {
+		t.Errorf("Expected a superfluous WriteHeader warning, got %q", logs.String())
+	}
+}
+
+func TestResponseHeadersAfterWriteHeader(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp}))
+	router.HandleFunc("/late", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Before", "sent")
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("X-After", "never sent")
+		_, _ = w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.Header.Get("X-After") != "" {
+		t.Fatal("Expected the client not to receive X-After")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["http.response.header.X-Before"] == "" {
+		t.Errorf("Expected X-Before to be captured, got %v", attrs)
+	}
+	if _, ok := attrs["http.response.header.X-After"]; ok {
+		t.Error("Expected X-After, set after WriteHeader, not to be captured")
+	}
+}
+
+func TestTransports(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{TracerProvider: tp, SemanticConventions: true}))
+	router.HandleFunc("/proto", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	})
+
+	// Unix socket paths are limited to about 100 bytes, which t.TempDir can
+	// exceed.
+	dir, err := os.MkdirTemp("", "monoscope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "http.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixServer := &http.Server{Handler: router}
+	go func() { _ = unixServer.Serve(listener) }()
+	defer unixServer.Close()
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	h2cServer := httptest.NewUnstartedServer(router)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+	h2cProtocols := new(http.Protocols)
+	h2cProtocols.SetUnencryptedHTTP2(true)
+	h2cClient := &http.Client{Transport: &http.Transport{Protocols: h2cProtocols}}
+
+	tlsServer := httptest.NewUnstartedServer(router)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	tests := []struct {
+		name, url string
+		client    *http.Client
+		proto     string
+		want      map[string]string
+	}{
+		{"unix", "http://unix/proto", unixClient, "HTTP/1.1",
+			map[string]string{"network.transport": "unix", "network.protocol.version": "1.1", "url.scheme": "http"}},
+		{"h2c", h2cServer.URL + "/proto", h2cClient, "HTTP/2.0",
+			map[string]string{"network.transport": "tcp", "network.protocol.version": "2", "url.scheme": "http"}},
+		{"h2", tlsServer.URL + "/proto", tlsServer.Client(), "HTTP/2.0",
+			map[string]string{"network.transport": "tcp", "network.protocol.version": "2", "url.scheme": "https"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			resp, err := tt.client.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if string(body) != tt.proto {
+				t.Fatalf("Expected the request to be served over %s, got %q", tt.proto, body)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[string]string{}
+			for _, attr := range spans[0].Attributes {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+			for key, value := range tt.want {
+				if attrs[key] != value {
+					t.Errorf("Expected %s=%s, got %q", key, value, attrs[key])
+				}
+			}
+		})
+	}
+}
+
+// gzipMiddleware compresses responses as common gzip middlewares do: setting
+// Content-Encoding and dropping Content-Length when the status is written,
+// or with eager, setting Content-Encoding before calling the handler.
+func gzipMiddleware(eager bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if eager {
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+			gw := &gzipWriter{ResponseWriter: w, gz: gzip.NewWriter(w)}
+			defer func() { _ = gw.gz.Close() }()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+type gzipWriter struct {
+	http.ResponseWriter
+	gz    *gzip.Writer
+	wrote bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.gz.Write(b)
+}
+
+func TestResponseCompression(t *testing.T) {
+	const body = `{"compressed":false}`
+	for _, tc := range []struct {
+		name  string
+		outer bool
+		eager bool
+		layer string
+	}{
+		{"gzip outside", true, false, apt.ResponseCompressionOuter},
+		{"eager gzip outside", true, true, apt.ResponseCompressionOuter},
+		{"gzip inside", false, false, apt.ResponseCompressionInner},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			tp := trace.NewTracerProvider(trace.WithSyncer(exporter))
+			defer func() {
+				_ = tp.Shutdown(context.Background())
+			}()
+
+			monoscope := Middleware(Config{TracerProvider: tp, CaptureResponseBody: true})
+			router := mux.NewRouter()
+			if tc.outer {
+				router.Use(gzipMiddleware(tc.eager), monoscope)
+			} else {
+				router.Use(monoscope, gzipMiddleware(tc.eager))
+			}
+			router.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", "20")
+				_, _ = w.Write([]byte(body))
+			})
+			srv := httptest.NewServer(router)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if !resp.Uncompressed || string(got) != body {
+				t.Fatalf("Expected the client to receive %q gzipped, got %q (gzipped %v)", body, got, resp.Uncompressed)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, kv := range spans[0].Attributes {
+				attrs[kv.Key] = kv.Value
+			}
+			if captured, _ := base64.StdEncoding.DecodeString(attrs["http.response.body"].AsString()); string(captured) != body {
+				t.Errorf("Expected the uncompressed body to be captured, got %q", captured)
+			}
+			if got := attrs["apitoolkit.response_compression"].AsString(); got != tc.layer {
+				t.Errorf("Expected compression layer %q, got %q", tc.layer, got)
+			}
+			if got := attrs["apitoolkit.response_content_encoding"].AsString(); got != "gzip" {
+				t.Errorf("Expected content encoding gzip, got %q", got)
+			}
+			if got, ok := attrs["http.response.header.Content-Encoding"]; ok {
+				t.Errorf("Expected the captured headers to describe the uncompressed body, got Content-Encoding %v", got.AsStringSlice())
+			}
+			if got, ok := attrs["http.response.header.Content-Length"]; ok && !slices.Equal(got.AsStringSlice(), []string{"20"}) {
+				t.Errorf("Expected no Content-Length or the uncompressed one, got %v", got.AsStringSlice())
+			}
+		})
+	}
+}
+
+func TestTenantBudget(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := trace.NewTracerProvider(trace.WithSpanProcessor(apt.NewDropFilter(trace.NewSimpleSpanProcessor(exporter))))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware(Config{
+		TracerProvider: tp,
+		TenantFunc: func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		},
+		TenantBudget: apt.TenantBudget{MaxPayloadsPerMinute: 2},
+	}))
+	router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {})
+	for _, tenant := range []string{"noisy", "noisy", "noisy", "quiet"} {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-Tenant", tenant)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var tenants []string
+	for _, span := range exporter.GetSpans() {
+		for _, attr := range span.Attributes {
+			if attr.Key == "apitoolkit.tenant" {
+				tenants = append(tenants, attr.Value.AsString())
+			}
+		}
+	}
+	if !slices.Equal(tenants, []string{"noisy", "noisy", "quiet"}) {
+		t.Errorf("Expected the noisy tenant's third request to be dropped, got %v", tenants)
+	}
+}
@@ -0,0 +1,51 @@
+package monoscope
+
+import "strings"
+
+// GRPCSystemCalls is how middlewares report gRPC health checks and server
+// reflection calls, for gRPC servers mounted as an http.Handler. Kubernetes
+// gRPC probes and tooling would otherwise dominate span volume.
+type GRPCSystemCalls int
+
+const (
+	// GRPCSystemCallsSkip passes the calls through without reporting them.
+	GRPCSystemCallsSkip GRPCSystemCalls = iota
+	// GRPCSystemCallsMetadataOnly reports them without their bodies.
+	GRPCSystemCallsMetadataOnly
+	// GRPCSystemCallsCapture reports them like any other request.
+	GRPCSystemCallsCapture
+)
+
+// grpcSystemServices are the path prefixes of the gRPC health and reflection
+// services' methods.
+var grpcSystemServices = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1.ServerReflection/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+// IsGRPCSystemCall reports whether path is a call to the gRPC health or
+// server reflection service, such as /grpc.health.v1.Health/Check.
+func IsGRPCSystemCall(path string) bool {
+	for _, prefix := range grpcSystemServices {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Restrict returns the capture level of a request to path already captured
+// at level, lowered as m requires when path is a gRPC system call.
+func (m GRPCSystemCalls) Restrict(level CaptureLevel, path string) CaptureLevel {
+	if !IsGRPCSystemCall(path) {
+		return level
+	}
+	switch m {
+	case GRPCSystemCallsSkip:
+		return CaptureNone
+	case GRPCSystemCallsMetadataOnly:
+		return max(level, CaptureMetadataOnly)
+	}
+	return level
+}
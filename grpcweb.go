@@ -0,0 +1,94 @@
+package monoscope
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// envelopeFlagData masks the envelope flags marking gRPC-Web trailers and
+// Connect end-of-stream messages, which carry no message data.
+const envelopeFlagData = 0x80 | 0x02
+
+// envelopeFlagCompressed marks compressed messages, which are captured by
+// size only.
+const envelopeFlagCompressed = 0x01
+
+// framedBodyFormat describes a length-prefixed gRPC, gRPC-Web or Connect
+// streaming body, going by its Content-Type header: whether it is framed at
+// all, whether it is base64 encoded as with grpc-web-text, and whether its
+// messages are JSON rather than protobuf.
+func framedBodyFormat(header map[string][]string) (framed, text, jsonMessages bool) {
+	mediaType, _, err := mime.ParseMediaType(http.Header(header).Get("Content-Type"))
+	if err != nil {
+		return false, false, false
+	}
+	base, codec, _ := strings.Cut(mediaType, "+")
+	switch base {
+	case "application/grpc", "application/grpc-web", "application/connect":
+	case "application/grpc-web-text":
+		text = true
+	default:
+		return false, false, false
+	}
+	if base == "application/connect" && codec == "" {
+		return false, false, false
+	}
+	return true, text, codec == "json"
+}
+
+// IsFramedBodyContent reports whether the Content-Type header describes a
+// length-prefixed gRPC, gRPC-Web or Connect streaming body, such as
+// application/grpc-web+proto or application/connect+json.
+func IsFramedBodyContent(header map[string][]string) bool {
+	framed, _, _ := framedBodyFormat(header)
+	return framed
+}
+
+// decodeFramedBody strips the 5-byte envelopes of a gRPC, gRPC-Web or
+// Connect body, returning its messages as JSON: JSON messages as they are and
+// protobuf messages, which can't be decoded without their schema, as base64
+// strings. A body with one message is returned as that message, one with
+// several as an array. gRPC-Web trailers and Connect end-of-stream messages
+// are left out.
+func decodeFramedBody(body []byte, text, jsonMessages bool) ([]byte, error) {
+	if text {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	var messages []json.RawMessage
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, errors.New("truncated envelope")
+		}
+		flags, size := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(size) {
+			return nil, errors.New("truncated message")
+		}
+		message := body[5 : 5+size]
+		body = body[5+size:]
+		if flags&envelopeFlagData != 0 {
+			continue
+		}
+		if flags&envelopeFlagCompressed != 0 {
+			return nil, errors.New("compressed message")
+		}
+		if !jsonMessages {
+			message, _ = json.Marshal(message)
+		} else if !json.Valid(message) {
+			return nil, errors.New("invalid JSON message")
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) == 1 {
+		return messages[0], nil
+	}
+	return json.Marshal(messages)
+}
@@ -0,0 +1,50 @@
+package monoscope
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartHeartbeat exports a short span named EventHeartbeat, linked to span,
+// every config.HeartbeatInterval until stop is called, so a long request,
+// e.g. a streaming export, is visible with its elapsed time and written
+// bytes before it completes: events added to span would only be exported
+// with it, once it ends. written may be nil when the bytes can't be
+// counted. Without a HeartbeatInterval it does nothing.
+func StartHeartbeat(config Config, span trace.Span, written *ByteCounter) (stop func()) {
+	if config.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+	start := Now(config)
+	ticker := time.NewTicker(config.HeartbeatInterval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				attrs := []attribute.KeyValue{attribute.Int64("apitoolkit.elapsed_ns", int64(Now(config).Sub(start)))}
+				if written != nil {
+					attrs = append(attrs, attribute.Int64("apitoolkit.bytes_written", written.Load()))
+				}
+				_, heartbeat := Tracer(config).Start(context.Background(), EventHeartbeat,
+					trace.WithNewRoot(),
+					trace.WithSpanKind(trace.SpanKindInternal),
+					trace.WithLinks(trace.Link{SpanContext: span.SpanContext()}),
+					trace.WithAttributes(attrs...))
+				heartbeat.End()
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,41 @@
+// Package monoscopehttp3 serves HTTP/3 with quic-go's http3.Server behind the
+// native Monoscope middleware. The middleware needs nothing HTTP/3 specific:
+// requests arrive as HTTP/3.0 and are recorded with network.transport quic,
+// and network.protocol.version 3 with Config.SemanticConventions. The
+// response writer, which isn't an http.Hijacker, keeps its Flusher and
+// deadline support through http.ResponseController. Handlers relying on
+// http3.HTTPStreamer, such as WebTransport sessions, must be served outside
+// the middleware, since it wraps the response writer.
+package monoscopehttp3
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	monoscopenative "github.com/monoscope-tech/monoscope-go/native"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// NewServer returns an http3.Server for the UDP address addr serving handler
+// behind monoscopenative.Middleware(config). tlsConfig holds the server's
+// certificates; it is set up for HTTP/3 with http3.ConfigureTLSConfig.
+func NewServer(addr string, tlsConfig *tls.Config, handler http.Handler, config monoscopenative.Config) *http3.Server {
+	return &http3.Server{
+		Addr:      addr,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+		Handler:   monoscopenative.Middleware(config)(handler),
+	}
+}
+
+// AltSvc returns middleware advertising server to clients reaching handler
+// over HTTP/1.1 or HTTP/2 with an Alt-Svc header, so they move to HTTP/3,
+// see http3.Server.SetQUICHeaders. Put it in front of the Monoscope
+// middleware on the TCP server so the header is captured too.
+func AltSvc(server *http3.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = server.SetQUICHeaders(w.Header())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
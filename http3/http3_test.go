@@ -0,0 +1,173 @@
+package monoscopehttp3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	monoscopenative "github.com/monoscope-tech/monoscope-go/native"
+	"github.com/quic-go/quic-go/http3"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// selfSignedTLS returns a server TLS config with a certificate for
+// 127.0.0.1, and a client config trusting it.
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: roots}
+}
+
+// testServer is an HTTP/3 server on a local UDP port exporting its spans to
+// exporter.
+type testServer struct {
+	server   *http3.Server
+	exporter *tracetest.InMemoryExporter
+	client   *http.Client
+	url      string
+}
+
+func serveHTTP3(t *testing.T, handler http.Handler) testServer {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	serverTLS, clientTLS := selfSignedTLS(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(conn.LocalAddr().String(), serverTLS, handler, monoscopenative.Config{
+		TracerProvider:      tp,
+		CaptureRequestBody:  true,
+		CaptureResponseBody: true,
+		SemanticConventions: true,
+	})
+	go func() { _ = server.Serve(conn) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	transport := &http3.Transport{TLSClientConfig: clientTLS}
+	t.Cleanup(func() { _ = transport.Close() })
+	return testServer{
+		server:   server,
+		exporter: exporter,
+		client:   &http.Client{Transport: transport, Timeout: 5 * time.Second},
+		url:      "https://" + conn.LocalAddr().String(),
+	}
+}
+
+func spanAttributes(t *testing.T, exporter *tracetest.InMemoryExporter) map[attribute.Key]attribute.Value {
+	t.Helper()
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestHTTP3(t *testing.T) {
+	srv := serveHTTP3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); ok {
+			t.Error("Expected the writer not to claim http.Hijacker over HTTP/3")
+		}
+		if _, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			t.Error("Expected hijacking to fail over HTTP/3")
+		}
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Errorf("Expected write deadlines to reach the HTTP/3 writer: %v", err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"echo":`))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Expected flushing to work over HTTP/3: %v", err)
+		}
+		_, _ = w.Write(append(body, '}'))
+	}))
+
+	resp, err := srv.client.Post(srv.url+"/upload?part=1", "application/json", strings.NewReader(`{"over":"quic"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.ProtoMajor != 3 || resp.StatusCode != http.StatusAccepted || string(body) != `{"echo":{"over":"quic"}}` {
+		t.Fatalf("Unexpected response %s %d %q", resp.Proto, resp.StatusCode, body)
+	}
+
+	attrs := spanAttributes(t, srv.exporter)
+	for key, want := range map[attribute.Key]string{
+		"network.protocol.name":    "http",
+		"network.protocol.version": "3",
+		"network.transport":        "quic",
+		"http.route":               "/upload",
+	} {
+		if got := attrs[key].AsString(); got != want {
+			t.Errorf("Expected %s %q, got %q", key, want, got)
+		}
+	}
+	if got := attrs["http.response.status_code"].AsInt64(); got != http.StatusAccepted {
+		t.Errorf("Expected status 202, got %d", got)
+	}
+	for key, want := range map[attribute.Key]string{"http.request.body": `{"over":"quic"}`, "http.response.body": `{"echo":{"over":"quic"}}`} {
+		if got, _ := base64.StdEncoding.DecodeString(attrs[key].AsString()); string(got) != want {
+			t.Errorf("Expected %s %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestAltSvc(t *testing.T) {
+	srv := serveHTTP3(t, http.NotFoundHandler())
+	_, port, _ := net.SplitHostPort(srv.server.Addr)
+	// The header is set up once the server is serving.
+	for deadline := time.Now().Add(time.Second); srv.server.SetQUICHeaders(http.Header{}) != nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	AltSvc(srv.server)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Header().Get("Alt-Svc"), `h3=":`+port+`"; ma=2592000`; got != want {
+		t.Errorf("Expected Alt-Svc %q, got %q", want, got)
+	}
+}
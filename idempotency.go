@@ -0,0 +1,115 @@
+package monoscope
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long an idempotency key is remembered when
+// Config.IdempotencyWindow is zero.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// maxIdempotencyEntries bounds the keys kept by idempotencyKeys. Once it is
+// reached the oldest keys are forgotten, even within their window, so a
+// retry of one of them counts as a first attempt.
+const maxIdempotencyEntries = 4096
+
+// Idempotency links requests that reused an idempotency key, see
+// Config.IdempotencyKeyHeader.
+type Idempotency struct {
+	// Key is a hash of the idempotency key, so attempts can be grouped
+	// without exporting the key itself.
+	Key string `json:"key"`
+	// Attempt counts the requests seen with the key within the window, 1
+	// for the first. Later attempts are client retries or duplicates.
+	Attempt int `json:"attempt"`
+	// FirstMsgID is the message ID of the first attempt, set on retries.
+	FirstMsgID string `json:"first_msg_id,omitempty"`
+}
+
+var idempotencyKeys = newIdempotencyTracker()
+
+// idempotencyTracker remembers the idempotency keys seen by this process.
+// Retries that land on another instance are not linked.
+type idempotencyTracker struct {
+	mu   sync.Mutex
+	seen map[string]*list.Element
+	// order holds the *idempotencyEntry values, oldest first.
+	order *list.List
+}
+
+type idempotencyEntry struct {
+	key        string
+	firstSeen  time.Time
+	firstMsgID string
+	attempts   int
+}
+
+func newIdempotencyTracker() *idempotencyTracker {
+	return &idempotencyTracker{seen: map[string]*list.Element{}, order: list.New()}
+}
+
+// track records a request with key to method and route, returning its
+// attempt number and the first attempt's message ID.
+func (t *idempotencyTracker) track(key, method, route, msgID string, now time.Time, window time.Duration) (int, string) {
+	scoped := method + "\x00" + route + "\x00" + key
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.seen[scoped]; ok {
+		entry := elem.Value.(*idempotencyEntry)
+		if now.Sub(entry.firstSeen) < window {
+			entry.attempts++
+			return entry.attempts, entry.firstMsgID
+		}
+		t.order.Remove(elem)
+		delete(t.seen, scoped)
+	}
+	t.evict(now, window)
+	entry := &idempotencyEntry{key: scoped, firstSeen: now, firstMsgID: msgID, attempts: 1}
+	t.seen[scoped] = t.order.PushBack(entry)
+	return entry.attempts, entry.firstMsgID
+}
+
+// evict drops the oldest keys while their window has ended or the table is
+// full.
+func (t *idempotencyTracker) evict(now time.Time, window time.Duration) {
+	for elem := t.order.Front(); elem != nil; elem = t.order.Front() {
+		entry := elem.Value.(*idempotencyEntry)
+		if len(t.seen) < maxIdempotencyEntries && now.Sub(entry.firstSeen) < window {
+			return
+		}
+		t.order.Remove(elem)
+		delete(t.seen, entry.key)
+	}
+}
+
+// trackIdempotency returns the Idempotency of a request to method and route
+// with header, or nil when Config.IdempotencyKeyHeader is unset or absent
+// from the request.
+func trackIdempotency(config Config, header http.Header, method, route, msgID string) *Idempotency {
+	if config.IdempotencyKeyHeader == "" {
+		return nil
+	}
+	key := header.Get(config.IdempotencyKeyHeader)
+	if key == "" {
+		return nil
+	}
+	window := config.IdempotencyWindow
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	sum := sha256.Sum256([]byte(key))
+	idem := &Idempotency{Key: hex.EncodeToString(sum[:8])}
+	var firstMsgID string
+	idem.Attempt, firstMsgID = idempotencyKeys.track(idem.Key, method, route, msgID, Now(config), window)
+	if idem.Attempt > 1 {
+		idem.FirstMsgID = firstMsgID
+	}
+	return idem
+}
@@ -0,0 +1,45 @@
+package monoscope
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Identity is the caller of a request as resolved by Config.IdentityFunc,
+// e.g. from its API key, so errors and latency can be broken down per
+// customer.
+type Identity struct {
+	CustomerID string `json:"customer_id,omitempty"`
+	Plan       string `json:"plan,omitempty"`
+}
+
+// resolveIdentity returns the identity config.IdentityFunc resolves for req,
+// or nil when there is none.
+func resolveIdentity(config Config, req *http.Request) *Identity {
+	if config.IdentityFunc == nil {
+		return nil
+	}
+	return IdentityOrNil(config.IdentityFunc(req))
+}
+
+// IdentityOrNil returns a pointer to identity, or nil when it is the zero
+// Identity, for middlewares resolving identities themselves.
+func IdentityOrNil(identity Identity) *Identity {
+	if identity == (Identity{}) {
+		return nil
+	}
+	return &identity
+}
+
+// identityAttributes returns the attributes recording identity.
+func identityAttributes(identity *Identity) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if identity.CustomerID != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.customer_id", identity.CustomerID))
+	}
+	if identity.Plan != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.customer_plan", identity.Plan))
+	}
+	return attrs
+}
@@ -0,0 +1,7 @@
+// Package integration runs every framework integration end to end: a request
+// is served through the middleware, exported over OTLP/gRPC and OTLP/HTTP to
+// an in-process collector, and the payload rebuilt from the received span is
+// checked, both against what the request and response were and against the
+// payloads of the other frameworks, so behavior drifting between
+// integrations fails the build. It holds tests only.
+package integration
@@ -0,0 +1,243 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gorilla/mux"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	monoscopechi "github.com/monoscope-tech/monoscope-go/chi"
+	monoscopeecho "github.com/monoscope-tech/monoscope-go/echo"
+	monoscopefiber "github.com/monoscope-tech/monoscope-go/fiber"
+	monoscopegin "github.com/monoscope-tech/monoscope-go/gin"
+	monoscopegorilla "github.com/monoscope-tech/monoscope-go/gorilla"
+	"github.com/monoscope-tech/monoscope-go/monoscopetest"
+	monoscopenative "github.com/monoscope-tech/monoscope-go/native"
+)
+
+const (
+	requestBody  = `{"name":"ada","password":"hunter2"}`
+	responseBody = `{"id":42,"token":"eyJhbGciOiJIUzI1NiJ9"}`
+)
+
+// Settings every target's middleware is configured with.
+var (
+	redactHeaders      = []string{"X-Api-Key"}
+	redactRequestBody  = []string{"$.password"}
+	redactResponseBody = []string{"$.token"}
+)
+
+// target serves the scenario through one framework integration.
+type target struct {
+	name string
+	// route and pathParams are what the framework reports for the request.
+	route      string
+	pathParams map[string]string
+	// serve starts a server with the middleware exporting through tp and
+	// returns its base URL.
+	serve func(t *testing.T, tp trace.TracerProvider) string
+}
+
+// writeResponse is the handler every net/http style target routes to.
+func writeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Resp-Id", "r1")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(responseBody))
+}
+
+func listen(t *testing.T, h http.Handler) string {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+var targets = []target{
+	{"native", "/users/42", nil, func(t *testing.T, tp trace.TracerProvider) string {
+		return listen(t, monoscopenative.Middleware(monoscopenative.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true,
+			RedactHeaders: redactHeaders, RedactRequestBody: redactRequestBody, RedactResponseBody: redactResponseBody,
+		})(http.HandlerFunc(writeResponse)))
+	}},
+	{"gorilla", "/users/{id}", map[string]string{"id": "42"}, func(t *testing.T, tp trace.TracerProvider) string {
+		router := mux.NewRouter()
+		router.Use(monoscopegorilla.Middleware(monoscopegorilla.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true,
+			RedactHeaders: redactHeaders, RedactRequestBody: redactRequestBody, RedactResponseBody: redactResponseBody,
+		}))
+		router.HandleFunc("/users/{id}", writeResponse)
+		return listen(t, router)
+	}},
+	{"chi", "/users/{id}", map[string]string{"id": "42"}, func(t *testing.T, tp trace.TracerProvider) string {
+		router := chi.NewRouter()
+		router.Use(monoscopechi.Middleware(monoscopechi.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true,
+			RedactHeaders: redactHeaders, RedactRequestBody: redactRequestBody, RedactResponseBody: redactResponseBody,
+		}))
+		router.Post("/users/{id}", writeResponse)
+		return listen(t, router)
+	}},
+	{"gin", "/users/:id", map[string]string{"id": "42"}, func(t *testing.T, tp trace.TracerProvider) string {
+		router := gin.New()
+		router.Use(monoscopegin.Middleware(monoscopegin.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true,
+			RedactHeaders: redactHeaders, RedactRequestBody: redactRequestBody, RedactResponseBody: redactResponseBody,
+		}))
+		router.POST("/users/:id", func(c *gin.Context) {
+			writeResponse(c.Writer, c.Request)
+		})
+		return listen(t, router)
+	}},
+	{"echo", "/users/:id", map[string]string{"id": "42"}, func(t *testing.T, tp trace.TracerProvider) string {
+		e := echo.New()
+		e.Use(monoscopeecho.Middleware(monoscopeecho.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true,
+			RedactHeaders: redactHeaders, RedactRequestBody: redactRequestBody, RedactResponseBody: redactResponseBody,
+		}))
+		e.POST("/users/:id", func(c echo.Context) error {
+			writeResponse(c.Response(), c.Request())
+			return nil
+		})
+		return listen(t, e)
+	}},
+	{"fiber", "/users/:id", map[string]string{"id": "42"}, func(t *testing.T, tp trace.TracerProvider) string {
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		app.Use(monoscopefiber.Middleware(monoscopefiber.Config{
+			TracerProvider: tp, CaptureRequestBody: true, CaptureResponseBody: true,
+			RedactHeaders: redactHeaders, RedactRequestBody: redactRequestBody, RedactResponseBody: redactResponseBody,
+		}))
+		app.Post("/users/:id", func(c *fiber.Ctx) error {
+			c.Set("Content-Type", "application/json")
+			c.Set("X-Resp-Id", "r1")
+			return c.Status(http.StatusCreated).SendString(responseBody)
+		})
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { _ = app.Listener(lis) }()
+		t.Cleanup(func() { _ = app.Shutdown() })
+		return "http://" + lis.Addr().String()
+	}},
+}
+
+// exporters builds the OTLP exporters the scenario is run over, pointed at
+// collector.
+var exporters = []struct {
+	name string
+	new  func(collector *monoscopetest.Collector) (sdktrace.SpanExporter, error)
+}{
+	{"grpc", func(collector *monoscopetest.Collector) (sdktrace.SpanExporter, error) {
+		return otlptracegrpc.New(context.Background(),
+			otlptracegrpc.WithEndpoint(collector.GRPCEndpoint()), otlptracegrpc.WithInsecure())
+	}},
+	{"http", func(collector *monoscopetest.Collector) (sdktrace.SpanExporter, error) {
+		return otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(collector.Endpoint()), otlptracehttp.WithInsecure())
+	}},
+}
+
+// sendRequest sends the scenario's request to baseURL.
+func sendRequest(t *testing.T, baseURL string) {
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/users/42?debug=1", bytes.NewBufferString(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Resp-Id") != "r1" {
+		t.Fatalf("Unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+// observed is the part of a payload every framework must report the same
+// way for the scenario.
+type observed struct {
+	Method         string
+	StatusCode     int
+	QueryParams    map[string][]string
+	RequestBody    map[string]any
+	ResponseBody   map[string]any
+	RequestAPIKey  []string
+	ResponseID     []string
+	ResponseCTypes []string
+}
+
+func observe(t *testing.T, payload apt.Payload) observed {
+	obs := observed{
+		Method:         payload.Method,
+		StatusCode:     payload.StatusCode,
+		QueryParams:    payload.QueryParams,
+		RequestAPIKey:  http.Header(payload.RequestHeaders).Values("X-Api-Key"),
+		ResponseID:     http.Header(payload.ResponseHeaders).Values("X-Resp-Id"),
+		ResponseCTypes: http.Header(payload.ResponseHeaders).Values("Content-Type"),
+	}
+	if err := json.Unmarshal(payload.RequestBody, &obs.RequestBody); err != nil {
+		t.Errorf("Request body %q isn't JSON: %v", payload.RequestBody, err)
+	}
+	if err := json.Unmarshal(payload.ResponseBody, &obs.ResponseBody); err != nil {
+		t.Errorf("Response body %q isn't JSON: %v", payload.ResponseBody, err)
+	}
+	return obs
+}
+
+func TestFrameworksEndToEnd(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	want := observed{
+		Method:         http.MethodPost,
+		StatusCode:     http.StatusCreated,
+		QueryParams:    map[string][]string{"debug": {"1"}},
+		RequestBody:    map[string]any{"name": "ada", "password": monoscopetest.RedactedValue},
+		ResponseBody:   map[string]any{"id": float64(42), "token": monoscopetest.RedactedValue},
+		RequestAPIKey:  []string{monoscopetest.RedactedValue},
+		ResponseID:     []string{"r1"},
+		ResponseCTypes: []string{"application/json"},
+	}
+
+	for _, exp := range exporters {
+		for _, tgt := range targets {
+			t.Run(exp.name+"/"+tgt.name, func(t *testing.T) {
+				collector := monoscopetest.NewCollector(t)
+				exporter, err := exp.new(collector)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+				defer func() { _ = tp.Shutdown(context.Background()) }()
+
+				sendRequest(t, tgt.serve(t, tp))
+				if err := tp.ForceFlush(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+
+				payload := collector.AssertCaptured(t, http.MethodPost, tgt.route)
+				if len(tgt.pathParams) > 0 && !reflect.DeepEqual(payload.PathParams, tgt.pathParams) {
+					t.Errorf("Expected path params %v, got %v", tgt.pathParams, payload.PathParams)
+				}
+				if got := observe(t, payload); !reflect.DeepEqual(got, want) {
+					t.Errorf("Payload drifted from the other integrations:\n got: %+v\nwant: %+v", got, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package monoscope
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one step of a JSONPath expression: a member name, an array
+// index, or a wildcard matching every member or element.
+type jsonPathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseSimpleJSONPath parses the subset of JSONPath redaction rules are
+// usually written in: "$" followed by ".name", ".*", "[n]", "[*]" and
+// "['name']" steps. Recursive descent, filters, slices and unions are
+// reported as errors.
+func parseSimpleJSONPath(path string) ([]jsonPathStep, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, errors.New("monoscope: JSONPath must start with $: " + path)
+	}
+	var steps []jsonPathStep
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			return nil, errors.New("monoscope: unsupported JSONPath recursive descent: " + path)
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			name := rest[1:end]
+			if name == "" {
+				return nil, errors.New("monoscope: empty JSONPath member: " + path)
+			}
+			steps = append(steps, jsonPathStep{name: name, wildcard: name == "*"})
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.New("monoscope: unclosed JSONPath bracket: " + path)
+			}
+			step, err := parseJSONPathBracket(rest[1:end])
+			if err != nil {
+				return nil, errors.New("monoscope: " + err.Error() + ": " + path)
+			}
+			steps = append(steps, step)
+			rest = rest[end+1:]
+		default:
+			return nil, errors.New("monoscope: invalid JSONPath: " + path)
+		}
+	}
+	return steps, nil
+}
+
+// parseJSONPathBracket parses the inside of a bracketed JSONPath step.
+func parseJSONPathBracket(s string) (jsonPathStep, error) {
+	if s == "*" {
+		return jsonPathStep{wildcard: true}, nil
+	}
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return jsonPathStep{name: s[1 : len(s)-1]}, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return jsonPathStep{}, errors.New("unsupported JSONPath subscript [" + s + "]")
+	}
+	return jsonPathStep{index: index, isIndex: true}, nil
+}
+
+// applySimpleJSONPath replaces the values path matches in the decoded JSON
+// src with value, returning how many were replaced. path is parsed with
+// parseSimpleJSONPath. Steps that don't match src match nothing.
+func applySimpleJSONPath(src any, path string, value any) (int, error) {
+	steps, err := parseSimpleJSONPath(path)
+	if err != nil || len(steps) == 0 {
+		return 0, err
+	}
+	return replaceJSONPath(src, steps, value), nil
+}
+
+func replaceJSONPath(node any, steps []jsonPathStep, value any) int {
+	step, last := steps[0], len(steps) == 1
+	replaced := 0
+	visit := func(child any, set func()) {
+		if last {
+			set()
+			replaced++
+		} else {
+			replaced += replaceJSONPath(child, steps[1:], value)
+		}
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		if step.isIndex {
+			return 0
+		}
+		for key, child := range n {
+			if step.wildcard || key == step.name {
+				visit(child, func() { n[key] = value })
+			}
+		}
+	case []any:
+		switch {
+		case step.wildcard:
+			for i, child := range n {
+				visit(child, func() { n[i] = value })
+			}
+		case step.isIndex:
+			i := step.index
+			if i < 0 {
+				i += len(n)
+			}
+			if i >= 0 && i < len(n) {
+				visit(n[i], func() { n[i] = value })
+			}
+		}
+	}
+	return replaced
+}
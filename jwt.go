@@ -0,0 +1,49 @@
+package monoscope
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// DefaultJWTClaims are the claims commonly safe to record: the principal, the
+// audience, the granted scope and the expiry.
+var DefaultJWTClaims = []string{"sub", "aud", "scope", "exp"}
+
+// jwtClaims returns the claims named in names of the bearer JWT in the
+// Authorization header, or nil when there is none. The token is decoded
+// locally without verifying its signature, so claims are only fit for
+// attributing requests, not for trusting them; the token itself stays
+// redacted with the Authorization header.
+func jwtClaims(header http.Header, names []string) map[string]any {
+	if len(names) == 0 {
+		return nil
+	}
+	scheme, token, ok := strings.Cut(header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var all map[string]any
+	if json.Unmarshal(decoded, &all) != nil {
+		return nil
+	}
+	claims := map[string]any{}
+	for _, name := range names {
+		if value, ok := all[name]; ok {
+			claims[name] = value
+		}
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+	return claims
+}
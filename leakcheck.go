@@ -0,0 +1,172 @@
+package monoscope
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/metrics"
+	"sync"
+	"time"
+	"weak"
+)
+
+// LeakCheck watches, with Config.DetectLeaks or Debug, what a request
+// handed its handler: the
+// request context and the buffers capturing its bodies. A handler retaining
+// them after the request ends, e.g. in a goroutine or cache outliving it,
+// keeps the whole request alive; LeakCheck logs what is still referenced
+// once a few GC cycles have passed since then. A nil *LeakCheck is inert.
+type LeakCheck struct {
+	name   string
+	probes []leakProbe
+	// endedAt is the GC cycle count when End was called.
+	endedAt uint64
+}
+
+type leakProbe struct {
+	what  string
+	alive func() bool
+}
+
+type leakMarkerCtxKey struct{}
+
+// leakMarker is stored in the request context, so it stays reachable for as
+// long as anything references that context or one derived from it. It holds
+// a pointer so it isn't batched with other tiny allocations, which would
+// keep it reachable regardless.
+type leakMarker struct {
+	name string
+}
+
+const (
+	// leakCheckGCCycles is how many GC cycles must complete after a request
+	// ends before what it tracked is expected to be collected. sync.Pool,
+	// which gin and echo keep their request contexts in, drops idle items
+	// within two.
+	leakCheckGCCycles = 3
+	// leakCheckInterval is how often ended requests are checked.
+	leakCheckInterval = 10 * time.Second
+	// maxPendingLeakChecks bounds the ended requests waiting to be checked;
+	// the oldest are dropped unchecked past it.
+	maxPendingLeakChecks = 4096
+)
+
+// StartLeakCheck returns ctx with a marker tracked by the returned
+// LeakCheck, named for logs by name, e.g. "GET /users/42". Without
+// config.DetectLeaks or config.Debug it returns ctx and a nil LeakCheck.
+func StartLeakCheck(ctx context.Context, config Config, name string) (context.Context, *LeakCheck) {
+	if !config.DetectLeaks && !config.Debug {
+		return ctx, nil
+	}
+	check := &LeakCheck{name: name}
+	marker := &leakMarker{name: name}
+	TrackLeak(check, "request context", marker)
+	return context.WithValue(ctx, leakMarkerCtxKey{}, marker), check
+}
+
+// TrackLeak adds p to check, reported as what if it is still referenced
+// after the request ends. p should be at least 16 bytes or hold pointers;
+// smaller pointer-free values may share an allocation that stays alive.
+func TrackLeak[T any](check *LeakCheck, what string, p *T) {
+	if check == nil || p == nil {
+		return
+	}
+	ptr := weak.Make(p)
+	check.probes = append(check.probes, leakProbe{what: what, alive: func() bool { return ptr.Value() != nil }})
+}
+
+// End marks the request as ended; call it once the handler has returned.
+func (c *LeakCheck) End() {
+	if c == nil {
+		return
+	}
+	c.endedAt = gcCycles()
+	leakChecks.add(c)
+}
+
+var leakChecks leakSweeper
+
+// leakSweeper checks ended requests in a goroutine that runs while any are
+// pending.
+type leakSweeper struct {
+	mu      sync.Mutex
+	pending []*LeakCheck
+	running bool
+}
+
+func (s *leakSweeper) add(c *LeakCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= maxPendingLeakChecks {
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, c)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+}
+
+func (s *leakSweeper) run() {
+	for {
+		time.Sleep(leakCheckInterval)
+		for _, leak := range s.sweep() {
+			log.Printf("APIToolkit: possible leak: %s", leak)
+		}
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sweep checks the requests that ended leakCheckGCCycles or more GC cycles
+// ago, returning a description of each tracked value still referenced.
+func (s *leakSweeper) sweep() []string {
+	cycles := gcCycles()
+	s.mu.Lock()
+	var due []*LeakCheck
+	kept := s.pending[:0]
+	for _, c := range s.pending {
+		if cycles-c.endedAt >= leakCheckGCCycles {
+			due = append(due, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	clear(s.pending[len(kept):])
+	s.pending = kept
+	s.mu.Unlock()
+
+	var leaks []string
+	for _, c := range due {
+		for _, probe := range c.probes {
+			if probe.alive() {
+				leaks = append(leaks, fmt.Sprintf("%s of %s is still referenced %d GC cycles after the request ended; a goroutine or cache started by the handler may be retaining it",
+					probe.what, c.name, cycles-c.endedAt))
+			}
+		}
+	}
+	return leaks
+}
+
+// CheckLeaks checks the requests that ended leakCheckGCCycles or more GC
+// cycles ago now, rather than at the next periodic check, and returns what
+// they left referenced instead of logging it. Tests can call it after a few
+// runtime.GC calls to assert handlers retain nothing.
+func CheckLeaks() []string {
+	return leakChecks.sweep()
+}
+
+// gcCycles returns the number of completed GC cycles.
+func gcCycles() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
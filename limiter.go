@@ -0,0 +1,83 @@
+package monoscope
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimit configures the concurrency limiter middlewares can place in
+// front of handlers. The zero value disables it.
+type ConcurrencyLimit struct {
+	// MaxConcurrent is the number of handlers allowed to run at once.
+	MaxConcurrent int
+	// MaxQueueWait is how long a request waits for a free slot before it is
+	// shed with a 503. Zero sheds requests as soon as all slots are taken.
+	MaxQueueWait time.Duration
+	// PerRoute applies MaxConcurrent to each route template separately
+	// instead of to all requests together.
+	PerRoute bool
+}
+
+// ConcurrencyLimiter bounds the number of requests handled concurrently,
+// globally or per route. A nil *ConcurrencyLimiter admits every request.
+type ConcurrencyLimiter struct {
+	limit ConcurrencyLimit
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewConcurrencyLimiter returns a limiter enforcing limit, or nil when
+// limit.MaxConcurrent isn't positive.
+func NewConcurrencyLimiter(limit ConcurrencyLimit) *ConcurrencyLimiter {
+	if limit.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &ConcurrencyLimiter{limit: limit, slots: map[string]chan struct{}{}}
+}
+
+// Acquire waits for a slot for a request to route, for at most MaxQueueWait
+// or until ctx is done. It returns how long the request queued and whether it
+// was admitted; admitted requests must call release once handled.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, route string) (release func(), wait time.Duration, ok bool) {
+	if l == nil {
+		return func() {}, 0, true
+	}
+	slots := l.slotsFor(route)
+	release = func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, 0, true
+	default:
+	}
+	if l.limit.MaxQueueWait <= 0 {
+		return nil, 0, false
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(l.limit.MaxQueueWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, time.Since(start), true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil, time.Since(start), false
+}
+
+// slotsFor returns the semaphore for route, creating it on first use.
+func (l *ConcurrencyLimiter) slotsFor(route string) chan struct{} {
+	if !l.limit.PerRoute {
+		route = ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[route]
+	if !ok {
+		slots = make(chan struct{}, l.limit.MaxConcurrent)
+		l.slots[route] = slots
+	}
+	return slots
+}
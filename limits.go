@@ -0,0 +1,109 @@
+package monoscope
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AttributeTruncatedMarker ends attribute values cut to
+// Config.MaxAttributeValueLength. For bodies it ends the decoded body.
+const AttributeTruncatedMarker = "...[TRUNCATED]"
+
+// bodyAttributes hold base64 bodies, which are cut before encoding.
+var bodyAttributes = map[attribute.Key]bool{
+	"http.request.body":  true,
+	"http.response.body": true,
+}
+
+// limitAttributes applies config.MaxAttributes and
+// config.MaxAttributeValueLength to attrs, appending the attributes that
+// record what was cut, along with the keys in truncated, cut beforehand.
+// attrs is in priority order: the last ones are dropped first. Encrypted
+// bodies are left whole, as a cut envelope can't be opened; they are cut
+// before encryption with truncateEncodedBody.
+func limitAttributes(attrs []attribute.KeyValue, config Config, truncated []string) []attribute.KeyValue {
+	dropped := 0
+	if config.MaxAttributes > 0 && len(attrs) > config.MaxAttributes {
+		dropped = len(attrs) - config.MaxAttributes
+		attrs = attrs[:config.MaxAttributes]
+	}
+
+	if limit := config.MaxAttributeValueLength; limit > 0 {
+		for i, kv := range attrs {
+			var cut bool
+			switch {
+			case bodyAttributes[kv.Key] && config.BodyEncryptionKey != nil:
+			case bodyAttributes[kv.Key]:
+				kv.Value, cut = truncateBodyValue(kv.Value.AsString(), limit)
+			case kv.Value.Type() == attribute.STRING:
+				var s string
+				s, cut = truncateValue(kv.Value.AsString(), limit)
+				kv.Value = attribute.StringValue(s)
+			case kv.Value.Type() == attribute.STRINGSLICE:
+				values := kv.Value.AsStringSlice()
+				for j, v := range values {
+					var c bool
+					values[j], c = truncateValue(v, limit)
+					cut = cut || c
+				}
+				kv.Value = attribute.StringSliceValue(values)
+			}
+			if cut {
+				attrs[i] = kv
+				truncated = append(truncated, string(kv.Key))
+			}
+		}
+	}
+
+	if len(truncated) > 0 {
+		attrs = append(attrs, attribute.StringSlice("apitoolkit.truncated_attributes", truncated))
+	}
+	if dropped > 0 {
+		attrs = append(attrs, attribute.Int("apitoolkit.dropped_attributes", dropped))
+	}
+	return attrs
+}
+
+// truncateValue cuts s so that, with AttributeTruncatedMarker appended, it
+// is at most limit bytes, without splitting a UTF-8 sequence.
+func truncateValue(s string, limit int) (string, bool) {
+	if len(s) <= limit {
+		return s, false
+	}
+	if limit <= len(AttributeTruncatedMarker) {
+		return AttributeTruncatedMarker[:limit], true
+	}
+	n := limit - len(AttributeTruncatedMarker)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + AttributeTruncatedMarker, true
+}
+
+// truncateBodyValue cuts the body encoded in the base64 value so that its
+// encoding, ending in AttributeTruncatedMarker, is at most limit bytes.
+func truncateBodyValue(encoded string, limit int) (attribute.Value, bool) {
+	if len(encoded) <= limit {
+		return attribute.StringValue(encoded), false
+	}
+	body, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		s, _ := truncateValue(encoded, limit)
+		return attribute.StringValue(s), true
+	}
+	cut, _ := truncateEncodedBody(body, limit)
+	return attribute.StringValue(base64.StdEncoding.EncodeToString(cut)), true
+}
+
+// truncateEncodedBody cuts body so that its base64 encoding, ending in
+// AttributeTruncatedMarker, is at most limit bytes. A limit of 0 or less
+// leaves it whole.
+func truncateEncodedBody(body []byte, limit int) ([]byte, bool) {
+	if limit <= 0 || base64.StdEncoding.EncodedLen(len(body)) <= limit {
+		return body, false
+	}
+	n := min(max(base64.StdEncoding.DecodedLen(limit)-len(AttributeTruncatedMarker), 0), len(body))
+	return append(body[:n:n], AttributeTruncatedMarker...), true
+}
@@ -0,0 +1,62 @@
+// Package maxmind provides a monoscope.GeoResolver backed by a MaxMind GeoIP2
+// or GeoLite2 City or Country database.
+package maxmind
+
+import (
+	"net/netip"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Resolver looks client IPs up in a MaxMind database. It is safe for
+// concurrent use.
+type Resolver struct {
+	reader *maxminddb.Reader
+}
+
+// Open opens the MaxMind database file at path, e.g. GeoLite2-City.mmdb.
+// Close the Resolver once it is no longer used.
+func Open(path string) (*Resolver, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{reader: reader}, nil
+}
+
+// record holds the fields of a City or Country database record that are
+// recorded; Country databases simply have no subdivisions or city.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// Resolve returns the location of ip. Addresses missing from the database,
+// such as private ones, resolve to an empty location.
+func (r *Resolver) Resolve(ip netip.Addr) (apt.GeoLocation, error) {
+	var rec record
+	if err := r.reader.Lookup(ip).Decode(&rec); err != nil {
+		return apt.GeoLocation{}, err
+	}
+	location := apt.GeoLocation{
+		Country: rec.Country.ISOCode,
+		City:    rec.City.Names["en"],
+	}
+	if len(rec.Subdivisions) > 0 && rec.Country.ISOCode != "" && rec.Subdivisions[0].ISOCode != "" {
+		location.Region = rec.Country.ISOCode + "-" + rec.Subdivisions[0].ISOCode
+	}
+	return location, nil
+}
+
+// Close releases the database.
+func (r *Resolver) Close() error {
+	return r.reader.Close()
+}
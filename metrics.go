@@ -0,0 +1,50 @@
+package monoscope
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// RequestDurationMetric is the histogram request latencies are recorded in,
+// named after the OpenTelemetry HTTP semantic conventions.
+const RequestDurationMetric = "http.server.request.duration"
+
+// requestDurationBuckets are the bucket boundaries, in seconds, recommended
+// by the semantic conventions for RequestDurationMetric.
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// durationHistograms caches the RequestDurationMetric instrument per
+// metric.MeterProvider.
+var durationHistograms sync.Map
+
+// RecordRequestDuration records duration for payload in the
+// RequestDurationMetric histogram of config.MeterProvider, doing nothing when
+// it is nil. ctx must carry the request span: SDKs with exemplars enabled
+// (the default for sampled spans) attach its trace and span IDs to the
+// measurement, linking latency outliers to an example Monoscope trace.
+func RecordRequestDuration(ctx context.Context, config Config, payload Payload, duration time.Duration) {
+	if config.MeterProvider == nil {
+		return
+	}
+	histogram, ok := durationHistograms.Load(config.MeterProvider)
+	if !ok {
+		h, err := config.MeterProvider.Meter("monoscope").Float64Histogram(RequestDurationMetric,
+			metric.WithUnit("s"),
+			metric.WithDescription("Duration of HTTP server requests."),
+			metric.WithExplicitBucketBoundaries(requestDurationBuckets...),
+		)
+		if err != nil {
+			return
+		}
+		histogram, _ = durationHistograms.LoadOrStore(config.MeterProvider, h)
+	}
+	histogram.(metric.Float64Histogram).Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("http.request.method", payload.Method),
+		attribute.String("http.route", payload.URLPath),
+		attribute.Int("http.response.status_code", payload.StatusCode),
+	))
+}
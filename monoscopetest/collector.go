@@ -0,0 +1,190 @@
+package monoscopetest
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	"go.opentelemetry.io/otel/attribute"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Collector is a fake OTLP trace receiver. Point an OTLP HTTP exporter at
+// Endpoint, or an OTLP gRPC exporter at GRPCEndpoint (both insecure), to
+// assert on what actually leaves the process, after batching and
+// serialization.
+type Collector struct {
+	asserter
+	server     *httptest.Server
+	grpcServer *grpc.Server
+	grpcAddr   string
+
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+// NewCollector starts a Collector that is closed when the test ends.
+func NewCollector(t testing.TB) *Collector {
+	c := &Collector{}
+	c.asserter = asserter{payloads: c.Payloads}
+	c.server = httptest.NewServer(http.HandlerFunc(c.handleTraces))
+	t.Cleanup(c.server.Close)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("monoscopetest: listening for OTLP gRPC: %v", err)
+	}
+	c.grpcAddr = lis.Addr().String()
+	c.grpcServer = grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(c.grpcServer, grpcTraceService{c: c})
+	go func() { _ = c.grpcServer.Serve(lis) }()
+	t.Cleanup(c.grpcServer.Stop)
+	return c
+}
+
+// GRPCEndpoint returns the host:port the collector receives OTLP/gRPC on, as
+// expected by otlptracegrpc.WithEndpoint.
+func (c *Collector) GRPCEndpoint() string {
+	return c.grpcAddr
+}
+
+// Endpoint returns the host:port the collector listens on, as expected by
+// otlptracehttp.WithEndpoint.
+func (c *Collector) Endpoint() string {
+	return strings.TrimPrefix(c.server.URL, "http://")
+}
+
+// URL returns the full OTLP/HTTP traces URL.
+func (c *Collector) URL() string {
+	return c.server.URL + "/v1/traces"
+}
+
+// Spans returns every span received so far.
+func (c *Collector) Spans() []*tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*tracepb.Span(nil), c.spans...)
+}
+
+// Payloads returns the Monoscope payloads received so far.
+func (c *Collector) Payloads() []apt.Payload {
+	payloads := []apt.Payload{}
+	for _, span := range c.Spans() {
+		if span.GetName() == "monoscope.http" {
+			payloads = append(payloads, PayloadFromAttributes(attributesFromOTLP(span.GetAttributes())))
+		}
+	}
+	return payloads
+}
+
+// Reset discards all received spans.
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = nil
+}
+
+func (c *Collector) handleTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &collectortrace.ExportTraceServiceRequest{}
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	if isJSON {
+		err = protojson.Unmarshal(data, req)
+	} else {
+		err = proto.Unmarshal(data, req)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.record(req)
+
+	var resp []byte
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		resp, _ = protojson.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		resp, _ = proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	}
+	w.Write(resp)
+}
+
+// record stores the spans of an export request.
+func (c *Collector) record(req *collectortrace.ExportTraceServiceRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			c.spans = append(c.spans, ss.GetSpans()...)
+		}
+	}
+}
+
+// grpcTraceService receives OTLP/gRPC exports for a Collector.
+type grpcTraceService struct {
+	collectortrace.UnimplementedTraceServiceServer
+	c *Collector
+}
+
+func (s grpcTraceService) Export(_ context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	s.c.record(req)
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+// attributesFromOTLP converts OTLP attributes back into OpenTelemetry ones.
+func attributesFromOTLP(kvs []*commonpb.KeyValue) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		key := attribute.Key(kv.GetKey())
+		switch v := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			attrs = append(attrs, key.String(v.StringValue))
+		case *commonpb.AnyValue_BoolValue:
+			attrs = append(attrs, key.Bool(v.BoolValue))
+		case *commonpb.AnyValue_IntValue:
+			attrs = append(attrs, key.Int64(v.IntValue))
+		case *commonpb.AnyValue_DoubleValue:
+			attrs = append(attrs, key.Float64(v.DoubleValue))
+		case *commonpb.AnyValue_ArrayValue:
+			values := make([]string, 0, len(v.ArrayValue.GetValues()))
+			for _, item := range v.ArrayValue.GetValues() {
+				values = append(values, item.GetStringValue())
+			}
+			attrs = append(attrs, key.StringSlice(values))
+		}
+	}
+	return attrs
+}
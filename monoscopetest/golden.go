@@ -0,0 +1,45 @@
+package monoscopetest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+)
+
+// UpdateGoldenEnv is the environment variable that makes AssertGolden rewrite
+// golden files instead of comparing against them, e.g.
+//
+//	MONOSCOPE_UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "MONOSCOPE_UPDATE_GOLDEN"
+
+// AssertGolden compares the snapshot of payload (see apt.MarshalSnapshot)
+// with testdata/<name>.golden, failing the test on any difference. Review
+// golden diffs like code: a new header or body field in the snapshot means the
+// SDK started capturing it.
+func AssertGolden(t testing.TB, name string, payload apt.Payload) {
+	t.Helper()
+	got, err := apt.MarshalSnapshot(payload)
+	if err != nil {
+		t.Fatalf("monoscopetest: marshalling snapshot: %v", err)
+	}
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("monoscopetest: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("monoscopetest: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("monoscopetest: reading golden file (set %s=1 to create it): %v", UpdateGoldenEnv, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("monoscopetest: payload snapshot differs from %s (set %s=1 to update)\n--- got\n%s\n--- want\n%s", path, UpdateGoldenEnv, got, want)
+	}
+}
@@ -0,0 +1,452 @@
+// Package monoscopetest provides helpers for testing applications that use the
+// Monoscope middlewares: an in-memory Recorder that captures the payloads the
+// SDK exports, assertions on those payloads (such as checking that redaction
+// rules actually fire), and a fake OTLP/HTTP Collector for end-to-end tests.
+package monoscopetest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AsaiYusuke/jsonpath"
+	apt "github.com/monoscope-tech/monoscope-go"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedactedValue is the placeholder the SDK substitutes for redacted values.
+const RedactedValue = "[CLIENT_REDACTED]"
+
+// Recorder captures Monoscope payloads in memory. Pass its TracerProvider in
+// the middleware Config (or with apt.WithTracerProvider for HTTP clients).
+type Recorder struct {
+	asserter
+	exporter *tracetest.InMemoryExporter
+	provider *sdktrace.TracerProvider
+}
+
+// NewRecorder returns a Recorder with its own tracer provider, leaving the
+// global provider untouched. It is shut down when the test ends.
+func NewRecorder(t testing.TB) *Recorder {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+	r := &Recorder{exporter: exporter, provider: provider}
+	r.asserter = asserter{payloads: r.Payloads}
+	return r
+}
+
+// TracerProvider returns the provider payloads must be exported through to be
+// recorded.
+func (r *Recorder) TracerProvider() trace.TracerProvider {
+	return r.provider
+}
+
+// Payloads returns the payloads recorded so far, in export order.
+func (r *Recorder) Payloads() []apt.Payload {
+	payloads := []apt.Payload{}
+	for _, span := range r.exporter.GetSpans() {
+		if span.Name == apt.SpanNameHTTP || span.Name == apt.SpanNameMessaging {
+			payloads = append(payloads, PayloadFromAttributes(span.Attributes))
+		}
+	}
+	return payloads
+}
+
+// Reset discards all recorded payloads.
+func (r *Recorder) Reset() {
+	r.exporter.Reset()
+}
+
+// asserter implements the payload assertions shared by Recorder and Collector.
+type asserter struct {
+	payloads func() []apt.Payload
+}
+
+// AssertCaptured fails the test unless a payload was recorded for the given
+// method and route template, and returns the first one found.
+func (a asserter) AssertCaptured(t testing.TB, method, route string) apt.Payload {
+	t.Helper()
+	payloads := a.payloads()
+	for _, p := range payloads {
+		if strings.EqualFold(p.Method, method) && p.URLPath == route {
+			return p
+		}
+	}
+	seen := make([]string, 0, len(payloads))
+	for _, p := range payloads {
+		seen = append(seen, p.Method+" "+p.URLPath)
+	}
+	t.Errorf("monoscopetest: no payload captured for %s %s (captured: %v)", method, route, seen)
+	return apt.Payload{}
+}
+
+// AssertRedacted fails the test if any recorded request or response body has
+// a value at the JSONPath expression path that wasn't redacted, or if no
+// recorded body contains path at all (which usually means the rule never ran).
+func (a asserter) AssertRedacted(t testing.TB, path string) {
+	t.Helper()
+	found := false
+	for _, p := range a.payloads() {
+		for _, body := range [][]byte{p.RequestBody, p.ResponseBody} {
+			values, ok := lookup(body, path)
+			if !ok {
+				continue
+			}
+			found = true
+			for _, v := range values {
+				if v != RedactedValue {
+					t.Errorf("monoscopetest: %s %s exported unredacted value %v at %s", p.Method, p.URLPath, v, path)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("monoscopetest: no captured body contains %s", path)
+	}
+}
+
+// AssertHeaderRedacted fails the test if any recorded payload exported the
+// named request or response header with a value other than RedactedValue.
+func (a asserter) AssertHeaderRedacted(t testing.TB, name string) {
+	t.Helper()
+	for _, p := range a.payloads() {
+		for _, headers := range []map[string][]string{p.RequestHeaders, p.ResponseHeaders} {
+			for k, values := range headers {
+				if !strings.EqualFold(k, name) {
+					continue
+				}
+				for _, v := range values {
+					if v != RedactedValue {
+						t.Errorf("monoscopetest: %s %s exported unredacted header %s", p.Method, p.URLPath, k)
+					}
+				}
+			}
+		}
+	}
+}
+
+// lookup returns the values at path in the JSON document body.
+func lookup(body []byte, path string) ([]interface{}, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	var src interface{}
+	if err := json.Unmarshal(body, &src); err != nil {
+		return nil, false
+	}
+	values, err := jsonpath.Retrieve(path, src)
+	if err != nil || len(values) == 0 {
+		return nil, false
+	}
+	return values, true
+}
+
+// geo returns p.Geo, allocating it on first use.
+func geo(p *apt.Payload) *apt.GeoLocation {
+	if p.Geo == nil {
+		p.Geo = &apt.GeoLocation{}
+	}
+	return p.Geo
+}
+
+// messaging returns p.Messaging, allocating it on first use.
+func messaging(p *apt.Payload) *apt.Messaging {
+	if p.Messaging == nil {
+		p.Messaging = &apt.Messaging{}
+	}
+	return p.Messaging
+}
+
+// deadlineBudget returns p.DeadlineBudget, allocating it on first use.
+func deadlineBudget(p *apt.Payload) *apt.DeadlineBudget {
+	if p.DeadlineBudget == nil {
+		p.DeadlineBudget = &apt.DeadlineBudget{}
+	}
+	return p.DeadlineBudget
+}
+
+// email returns p.Email, allocating it on first use.
+func email(p *apt.Payload) *apt.Email {
+	if p.Email == nil {
+		p.Email = &apt.Email{}
+	}
+	return p.Email
+}
+
+// signature returns p.Signature, allocating it on first use.
+func signature(p *apt.Payload) *apt.SignatureVerification {
+	if p.Signature == nil {
+		p.Signature = &apt.SignatureVerification{}
+	}
+	return p.Signature
+}
+
+// idempotency returns p.Idempotency, allocating it on first use.
+func idempotency(p *apt.Payload) *apt.Idempotency {
+	if p.Idempotency == nil {
+		p.Idempotency = &apt.Idempotency{}
+	}
+	return p.Idempotency
+}
+
+// spanKinds maps the apitoolkit.span_kind values back to span kinds.
+var spanKinds = map[string]trace.SpanKind{
+	trace.SpanKindInternal.String(): trace.SpanKindInternal,
+	trace.SpanKindServer.String():   trace.SpanKindServer,
+	trace.SpanKindClient.String():   trace.SpanKindClient,
+	trace.SpanKindProducer.String(): trace.SpanKindProducer,
+	trace.SpanKindConsumer.String(): trace.SpanKindConsumer,
+}
+
+// PayloadFromAttributes rebuilds a payload from the span attributes written
+// by apt.CreateSpan. Bodies are only present when capture was enabled.
+func PayloadFromAttributes(attrs []attribute.KeyValue) apt.Payload {
+	p := apt.Payload{
+		RequestHeaders:  map[string][]string{},
+		ResponseHeaders: map[string][]string{},
+	}
+	for _, kv := range attrs {
+		key := string(kv.Key)
+		switch key {
+		case "net.host.name":
+			p.Host = kv.Value.AsString()
+		case "http.route":
+			p.URLPath = kv.Value.AsString()
+		case "network.transport":
+			p.Transport = kv.Value.AsString()
+		case "url.scheme":
+			p.Scheme = kv.Value.AsString()
+		case "http.request.method":
+			p.Method = kv.Value.AsString()
+		case "http.response.status_code":
+			p.StatusCode = int(kv.Value.AsInt64())
+		case "http.request.query_params":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.QueryParams)
+		case "http.target":
+			p.RawURL = kv.Value.AsString()
+		case "http.request.path_params":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.PathParams)
+		case "apitoolkit.sdk_type":
+			p.SdkType = kv.Value.AsString()
+		case "apitoolkit.service_version":
+			if v := kv.Value.AsString(); v != "" {
+				p.ServiceVersion = &v
+			}
+		case "http.request.body":
+			p.RequestBody, _ = base64.StdEncoding.DecodeString(kv.Value.AsString())
+		case "http.response.body":
+			p.ResponseBody, _ = base64.StdEncoding.DecodeString(kv.Value.AsString())
+		case "apitoolkit.errors":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Errors)
+		case "apitoolkit.tags":
+			p.Tags = kv.Value.AsStringSlice()
+		case "apitoolkit.msg_id":
+			p.MsgID = kv.Value.AsString()
+		case "apitoolkit.parent_msg_id":
+			if v := kv.Value.AsString(); v != "" {
+				p.ParentID = &v
+			}
+		case "http.request.body_incomplete":
+			p.RequestBodyIncomplete = kv.Value.AsBool()
+		case "apitoolkit.request_body_lines":
+			p.RequestBodyLines = int(kv.Value.AsInt64())
+		case "apitoolkit.response_body_lines":
+			p.ResponseBodyLines = int(kv.Value.AsInt64())
+		case "apitoolkit.request_body_undecoded_size":
+			p.RequestBodyUndecodedSize = int(kv.Value.AsInt64())
+		case "apitoolkit.response_body_undecoded_size":
+			p.ResponseBodyUndecodedSize = int(kv.Value.AsInt64())
+		case "apitoolkit.segments":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Segments)
+		case "apitoolkit.stages":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Stages)
+		case "apitoolkit.cache_stats":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.CacheStats)
+		case "apitoolkit.host_template":
+			p.HostTemplate = kv.Value.AsString()
+		case "apitoolkit.traffic_class":
+			p.TrafficClass = apt.TrafficClass(kv.Value.AsString())
+		case "geo.country.iso_code":
+			geo(&p).Country = kv.Value.AsString()
+		case "geo.region.iso_code":
+			geo(&p).Region = kv.Value.AsString()
+		case "geo.locality.name":
+			geo(&p).City = kv.Value.AsString()
+		case "apitoolkit.metadata_only":
+			p.MetadataOnly = kv.Value.AsBool()
+		case "apitoolkit.force_sampled":
+			p.ForceSampled = kv.Value.AsBool()
+		case "apitoolkit.data_subjects":
+			p.DataSubjects = kv.Value.AsStringSlice()
+		case "apitoolkit.redactions":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Redactions)
+		case "http.response.body_skipped":
+			p.ResponseBodySkipped = kv.Value.AsBool()
+		case "apitoolkit.response_content_encoding":
+			p.ResponseContentEncoding = kv.Value.AsString()
+		case "apitoolkit.response_compression":
+			p.ResponseCompression = kv.Value.AsString()
+		case "apitoolkit.client_disconnected":
+			p.ClientDisconnected = kv.Value.AsBool()
+		case "apitoolkit.deadline_exceeded":
+			p.DeadlineExceeded = kv.Value.AsBool()
+		case "apitoolkit.queue_wait_ms":
+			p.QueueWait = time.Duration(kv.Value.AsFloat64() * float64(time.Millisecond))
+		case "apitoolkit.shed":
+			p.Shed = kv.Value.AsBool()
+		case "apitoolkit.rate_limit.limit", "apitoolkit.rate_limit.remaining",
+			"apitoolkit.rate_limit.reset_seconds", "apitoolkit.rate_limit.retry_after_seconds":
+			if p.RateLimit == nil {
+				p.RateLimit = &apt.RateLimit{}
+			}
+			v := kv.Value.AsInt64()
+			switch kv.Key {
+			case "apitoolkit.rate_limit.limit":
+				p.RateLimit.Limit = &v
+			case "apitoolkit.rate_limit.remaining":
+				p.RateLimit.Remaining = &v
+			case "apitoolkit.rate_limit.reset_seconds":
+				p.RateLimit.ResetSeconds = &v
+			default:
+				p.RateLimit.RetryAfterSeconds = &v
+			}
+		case "apitoolkit.cache.control", "apitoolkit.cache.max_age_seconds", "apitoolkit.cache.age_seconds",
+			"apitoolkit.cache.status", "apitoolkit.cache.stale":
+			if p.CacheInfo == nil {
+				p.CacheInfo = &apt.CacheInfo{}
+			}
+			v := kv.Value.AsInt64()
+			switch kv.Key {
+			case "apitoolkit.cache.control":
+				p.CacheInfo.CacheControl = kv.Value.AsString()
+			case "apitoolkit.cache.max_age_seconds":
+				p.CacheInfo.MaxAgeSeconds = &v
+			case "apitoolkit.cache.age_seconds":
+				p.CacheInfo.AgeSeconds = &v
+			case "apitoolkit.cache.status":
+				p.CacheInfo.Status = kv.Value.AsString()
+			default:
+				p.CacheInfo.Stale = kv.Value.AsBool()
+			}
+		case "apitoolkit.shadow.compared", "apitoolkit.shadow.mismatches", "apitoolkit.shadow.diffs":
+			if p.Shadow == nil {
+				p.Shadow = &apt.ShadowComparison{}
+			}
+			switch kv.Key {
+			case "apitoolkit.shadow.compared":
+				p.Shadow.Compared = int(kv.Value.AsInt64())
+			case "apitoolkit.shadow.mismatches":
+				p.Shadow.Mismatches = int(kv.Value.AsInt64())
+			default:
+				_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Shadow.Diffs)
+			}
+		case "apitoolkit.rate_limit.policy":
+			if p.RateLimit == nil {
+				p.RateLimit = &apt.RateLimit{}
+			}
+			p.RateLimit.Policy = kv.Value.AsString()
+		case "apitoolkit.customer_id":
+			if p.Identity == nil {
+				p.Identity = &apt.Identity{}
+			}
+			p.Identity.CustomerID = kv.Value.AsString()
+		case "apitoolkit.customer_plan":
+			if p.Identity == nil {
+				p.Identity = &apt.Identity{}
+			}
+			p.Identity.Plan = kv.Value.AsString()
+		case "apitoolkit.tracestate":
+			p.TraceState = kv.Value.AsString()
+		case "apitoolkit.experiments":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Experiments)
+		case "apitoolkit.feature_flags":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.FeatureFlags)
+		case "apitoolkit.jwt_claims":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.JWTClaims)
+		case "apitoolkit.slo_violated":
+			v := kv.Value.AsBool()
+			p.SLOViolated = &v
+		case "apitoolkit.request_type":
+			p.RequestType = kv.Value.AsString()
+		case "apitoolkit.handler_timed_out":
+			p.HandlerTimedOut = kv.Value.AsBool()
+		case "apitoolkit.response_aborted":
+			p.ResponseAborted = kv.Value.AsBool()
+		case "apitoolkit.response_bytes_written":
+			p.ResponseBytesWritten = kv.Value.AsInt64()
+		case "apitoolkit.response_write_ms":
+			p.ResponseWriteDuration = time.Duration(kv.Value.AsFloat64() * float64(time.Millisecond))
+		case "apitoolkit.deadline_remaining_ms":
+			deadlineBudget(&p).Remaining = time.Duration(kv.Value.AsFloat64() * float64(time.Millisecond))
+		case "apitoolkit.deadline_budget_used":
+			deadlineBudget(&p).Used = kv.Value.AsFloat64()
+		case "apitoolkit.deadline_budget_exceeded":
+			deadlineBudget(&p).Exceeded = kv.Value.AsBool()
+		case "apitoolkit.email.recipient_domains":
+			email(&p).RecipientDomains = kv.Value.AsStringSlice()
+		case "apitoolkit.email.recipients":
+			email(&p).Recipients = int(kv.Value.AsInt64())
+		case "apitoolkit.email.template_id":
+			email(&p).TemplateID = kv.Value.AsString()
+		case "apitoolkit.email.size":
+			email(&p).Size = kv.Value.AsInt64()
+		case "apitoolkit.tenant":
+			p.Tenant = kv.Value.AsString()
+		case "apitoolkit.tenant_budget_exceeded":
+			p.TenantBudgetExceeded = kv.Value.AsBool()
+		case "apitoolkit.signature.scheme":
+			signature(&p).Scheme = kv.Value.AsString()
+		case "apitoolkit.signature.valid":
+			signature(&p).Valid = kv.Value.AsBool()
+		case "apitoolkit.signature.attempts":
+			signature(&p).Attempts = int(kv.Value.AsInt64())
+		case "apitoolkit.signature.duration_ms":
+			signature(&p).Duration = time.Duration(kv.Value.AsFloat64() * float64(time.Millisecond))
+		case "apitoolkit.signature.error":
+			signature(&p).Error = kv.Value.AsString()
+		case "http.cache_validation":
+			p.CacheValidation = kv.Value.AsString()
+		case "apitoolkit.panic":
+			_ = json.Unmarshal([]byte(kv.Value.AsString()), &p.Panic)
+		case "apitoolkit.idempotency_key":
+			idempotency(&p).Key = kv.Value.AsString()
+		case "apitoolkit.idempotency_attempt":
+			idempotency(&p).Attempt = int(kv.Value.AsInt64())
+		case "apitoolkit.idempotency_first_msg_id":
+			idempotency(&p).FirstMsgID = kv.Value.AsString()
+		case "apitoolkit.schema_version":
+			p.SchemaVersion = int(kv.Value.AsInt64())
+		case "apitoolkit.span_kind":
+			p.SpanKind = spanKinds[kv.Value.AsString()]
+		case "messaging.system":
+			messaging(&p).System = kv.Value.AsString()
+		case "messaging.destination.name":
+			messaging(&p).Destination = kv.Value.AsString()
+		case "messaging.operation.name":
+			messaging(&p).Operation = kv.Value.AsString()
+		case "messaging.message.id":
+			messaging(&p).MessageID = kv.Value.AsString()
+		default:
+			if name, ok := strings.CutPrefix(key, "http.request.header."); ok {
+				p.RequestHeaders[name] = kv.Value.AsStringSlice()
+			} else if name, ok := strings.CutPrefix(key, "http.response.header."); ok {
+				p.ResponseHeaders[name] = kv.Value.AsStringSlice()
+			} else if name, ok := strings.CutPrefix(key, "apitoolkit.tracestate."); ok {
+				if p.TraceStateValues == nil {
+					p.TraceStateValues = map[string]string{}
+				}
+				p.TraceStateValues[name] = kv.Value.AsString()
+			}
+		}
+	}
+	return p
+}
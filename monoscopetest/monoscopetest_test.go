@@ -0,0 +1,149 @@
+package monoscopetest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	apt "github.com/monoscope-tech/monoscope-go"
+	monoscopegorilla "github.com/monoscope-tech/monoscope-go/gorilla"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newRouter(tp trace.TracerProvider) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(monoscopegorilla.Middleware(monoscopegorilla.Config{
+		TracerProvider:      tp,
+		CaptureRequestBody:  true,
+		CaptureResponseBody: true,
+		RedactHeaders:       []string{"X-Api-Key"},
+		RedactRequestBody:   []string{"$.password"},
+	}))
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1}`))
+	}).Methods(http.MethodPost)
+	return router
+}
+
+func sendLogin(router http.Handler) {
+	req := httptest.NewRequest(http.MethodPost, "/users/1", bytes.NewBufferString(`{"user":"jane","password":"hunter2"}`))
+	req.Header.Set("X-Api-Key", "secret")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder(t)
+	sendLogin(newRouter(rec.TracerProvider()))
+
+	payload := rec.AssertCaptured(t, http.MethodPost, "/users/{id}")
+	if payload.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", payload.StatusCode)
+	}
+	if payload.PathParams["id"] != "1" {
+		t.Errorf("Expected path param id=1, got %v", payload.PathParams)
+	}
+	rec.AssertRedacted(t, "$.password")
+	rec.AssertHeaderRedacted(t, "X-Api-Key")
+
+	rec.Reset()
+	if got := len(rec.Payloads()); got != 0 {
+		t.Errorf("Expected no payloads after Reset, got %d", got)
+	}
+}
+
+func TestAssertRedactedDetectsLeaks(t *testing.T) {
+	rec := NewRecorder(t)
+	sendLogin(newRouter(rec.TracerProvider()))
+
+	fake := &testing.T{}
+	rec.AssertRedacted(fake, "$.user")
+	if !fake.Failed() {
+		t.Error("Expected AssertRedacted to fail for an unredacted field")
+	}
+}
+
+func TestMockTransport(t *testing.T) {
+	rec := NewRecorder(t)
+	mock := NewMockTransport().
+		Stub(http.MethodPost, "/v1/login", MockResponse{Status: http.StatusCreated, Body: `{"token":"abc"}`})
+	client := &http.Client{Transport: apt.WrapRoundTripper(context.Background(), mock,
+		apt.WithTracerProvider(rec.TracerProvider()),
+		apt.WithRedactRequestBody("$.password"),
+		apt.WithRedactResponseBody("$.token"),
+	)}
+
+	res, err := client.Post("http://upstream.test/v1/login", "application/json", bytes.NewBufferString(`{"user":"jane","password":"hunter2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", res.StatusCode)
+	}
+
+	payload := rec.AssertCaptured(t, http.MethodPost, "/v1/login")
+	if payload.SdkType != apt.GoOutgoing {
+		t.Errorf("Expected SdkType %s, got %s", apt.GoOutgoing, payload.SdkType)
+	}
+	rec.AssertRedacted(t, "$.password")
+	rec.AssertRedacted(t, "$.token")
+	if got := len(mock.Requests()); got != 1 {
+		t.Errorf("Expected 1 request, got %d", got)
+	}
+
+	if _, err := client.Get("http://upstream.test/unknown"); err == nil {
+		t.Error("Expected an error for an unstubbed request")
+	}
+}
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector(t)
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(collector.Endpoint()),
+		otlptracehttp.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	sendLogin(newRouter(tp))
+
+	collector.AssertCaptured(t, http.MethodPost, "/users/{id}")
+	collector.AssertRedacted(t, "$.password")
+	collector.AssertHeaderRedacted(t, "X-Api-Key")
+}
+
+func TestCollectorGRPC(t *testing.T) {
+	collector := NewCollector(t)
+	exporter, err := otlptracegrpc.New(context.Background(),
+		otlptracegrpc.WithEndpoint(collector.GRPCEndpoint()),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	sendLogin(newRouter(tp))
+
+	collector.AssertCaptured(t, http.MethodPost, "/users/{id}")
+	collector.AssertRedacted(t, "$.password")
+	collector.AssertHeaderRedacted(t, "X-Api-Key")
+}
+
+func TestAssertGolden(t *testing.T) {
+	rec := NewRecorder(t)
+	sendLogin(newRouter(rec.TracerProvider()))
+
+	AssertGolden(t, "login", rec.AssertCaptured(t, http.MethodPost, "/users/{id}"))
+}
@@ -0,0 +1,110 @@
+package monoscopetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MockResponse is the response a MockTransport stub answers with. Err, when
+// set, fails the round trip instead, as a network error would.
+type MockResponse struct {
+	Status int
+	Header http.Header
+	Body   string
+	Err    error
+}
+
+// MockTransport is an http.RoundTripper that answers requests from stubs
+// rather than calling upstreams. Wrap it with apt.WrapRoundTripper and a
+// Recorder's TracerProvider to exercise the capture and redaction of outgoing
+// requests and assert on the payloads they produce:
+//
+//	mock := monoscopetest.NewMockTransport().
+//		Stub(http.MethodPost, "/v1/charges", monoscopetest.MockResponse{Status: 201, Body: `{"id":"ch_1"}`})
+//	client := &http.Client{Transport: apt.WrapRoundTripper(ctx, mock,
+//		apt.WithTracerProvider(rec.TracerProvider()))}
+type MockTransport struct {
+	mu       sync.Mutex
+	stubs    []mockStub
+	requests []*http.Request
+}
+
+type mockStub struct {
+	method, path string
+	response     MockResponse
+}
+
+// NewMockTransport returns a MockTransport without stubs.
+func NewMockTransport() *MockTransport {
+	return &MockTransport{}
+}
+
+// Stub answers requests with the given method and URL path with response. An
+// empty method or path matches any. Stubs are tried in the order they were
+// added. It returns m for chaining.
+func (m *MockTransport) Stub(method, path string, response MockResponse) *MockTransport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stubs = append(m.stubs, mockStub{method: method, path: path, response: response})
+	return m
+}
+
+// RoundTrip answers req from the first matching stub. Requests no stub
+// matches fail with an error naming them.
+func (m *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+	}
+	recorded := req.Clone(req.Context())
+	recorded.Body = io.NopCloser(bytes.NewReader(body))
+
+	m.mu.Lock()
+	m.requests = append(m.requests, recorded)
+	stubs := m.stubs
+	m.mu.Unlock()
+
+	for _, stub := range stubs {
+		if (stub.method != "" && !strings.EqualFold(stub.method, req.Method)) || (stub.path != "" && stub.path != req.URL.Path) {
+			continue
+		}
+		if stub.response.Err != nil {
+			return nil, stub.response.Err
+		}
+		status := stub.response.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		header := stub.response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(stub.response.Body)),
+			ContentLength: int64(len(stub.response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("monoscopetest: no stub for %s %s", req.Method, req.URL.Path)
+}
+
+// Requests returns the requests received so far, with their bodies intact.
+func (m *MockTransport) Requests() []*http.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*http.Request(nil), m.requests...)
+}
@@ -0,0 +1,81 @@
+// Package monoscopex provides concurrency helpers that keep the work a
+// handler fans out attached to the Monoscope request that started it.
+package monoscopex
+
+import (
+	"context"
+	"fmt"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrGroup is an errgroup.Group whose goroutines are traced and report their
+// failures to the request they were started from, see Group.
+type ErrGroup struct {
+	group *errgroup.Group
+	ctx   context.Context
+}
+
+// Group returns an ErrGroup for the request in ctx, along with the derived
+// context that is canceled when a goroutine fails or Wait returns, as with
+// errgroup.WithContext.
+func Group(ctx context.Context) (*ErrGroup, context.Context) {
+	group, ctx := errgroup.WithContext(ctx)
+	return &ErrGroup{group: group, ctx: ctx}, ctx
+}
+
+// Go runs f in a new goroutine as a segment called name, see
+// monoscope.StartSegment. An error returned by f, or a panic in it, is
+// reported on the request's payload and returned by Wait; panics are
+// recovered as a *PanicError rather than crashing the process.
+func (g *ErrGroup) Go(name string, f func(ctx context.Context) error) {
+	g.group.Go(g.wrap(name, f))
+}
+
+// TryGo is Go, except that it only starts f if the number of active
+// goroutines is below the limit set with SetLimit, and reports whether it did.
+func (g *ErrGroup) TryGo(name string, f func(ctx context.Context) error) bool {
+	return g.group.TryGo(g.wrap(name, f))
+}
+
+// SetLimit limits the number of active goroutines in the group to n; a
+// negative n removes the limit.
+func (g *ErrGroup) SetLimit(n int) {
+	g.group.SetLimit(n)
+}
+
+// Wait blocks until every goroutine started with Go has returned, then
+// returns the first error, if any.
+func (g *ErrGroup) Wait() error {
+	return g.group.Wait()
+}
+
+func (g *ErrGroup) wrap(name string, f func(ctx context.Context) error) func() error {
+	return func() (err error) {
+		ctx, segment := apt.StartSegment(g.ctx, name)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = &PanicError{Name: name, Info: apt.NewPanicInfo(recovered, apt.Config{})}
+			}
+			if err != nil {
+				segment.RecordError(err)
+				apt.ReportError(ctx, err)
+			}
+			segment.End()
+		}()
+		return f(ctx)
+	}
+}
+
+// PanicError is the error a goroutine started by ErrGroup fails with when it
+// panics.
+type PanicError struct {
+	// Name is the name the goroutine was started with.
+	Name string
+	Info *apt.PanicInfo
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("monoscopex: %s panicked: %s", e.Name, e.Info.Value)
+}
@@ -0,0 +1,56 @@
+package monoscopex
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestGroup(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	// Wire the context the way the middlewares do.
+	errorList := []apt.ATError{}
+	ctx, reqSpan := tp.Tracer("test").Start(context.Background(), "monoscope.http")
+	ctx = apt.WithErrorList(ctx, &errorList)
+	ctx = apt.ContextWithConfig(ctx, apt.Config{TracerProvider: tp})
+	ctx = apt.ContextWithAnnotations(ctx)
+
+	errFetch := errors.New("inventory unavailable")
+	group, groupCtx := Group(ctx)
+	group.Go("fetch.prices", func(ctx context.Context) error { return nil })
+	group.Go("fetch.inventory", func(ctx context.Context) error { return errFetch })
+	group.Go("fetch.reviews", func(ctx context.Context) error { panic("nil map") })
+	err := group.Wait()
+	reqSpan.End()
+
+	if !errors.Is(err, errFetch) && !errors.As(err, new(*PanicError)) {
+		t.Errorf("Expected Wait to return a goroutine's error, got %v", err)
+	}
+	if groupCtx.Err() == nil {
+		t.Error("Expected the group context to be canceled after Wait")
+	}
+	if len(errorList) != 2 {
+		t.Fatalf("Expected the error and the panic on the request, got %+v", errorList)
+	}
+
+	children := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		if span.Parent.SpanID() == reqSpan.SpanContext().SpanID() {
+			children[span.Name] = true
+		}
+	}
+	for _, name := range []string{"fetch.prices", "fetch.inventory", "fetch.reviews"} {
+		if !children[name] {
+			t.Errorf("Expected a %s child span of the request", name)
+		}
+	}
+}
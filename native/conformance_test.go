@@ -0,0 +1,29 @@
+package apitoolkitnative
+
+import (
+	"net/http"
+	"testing"
+
+	apt "github.com/monoscope-tech/monoscope-go"
+	"github.com/monoscope-tech/monoscope-go/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, conformance.Adapter{
+		Serve: func(config apt.Config, routes []conformance.Route) http.Handler {
+			mux := http.NewServeMux()
+			for _, route := range routes {
+				mux.Handle(route.Method+" "+route.Pattern, route.Handler)
+			}
+			return Middleware(Config{
+				TracerProvider:      config.TracerProvider,
+				CaptureRequestBody:  config.CaptureRequestBody,
+				CaptureResponseBody: config.CaptureResponseBody,
+				RedactHeaders:       config.RedactHeaders,
+				RedactRequestBody:   config.RedactRequestBody,
+				RedactResponseBody:  config.RedactResponseBody,
+			})(mux)
+		},
+		RawPaths: true,
+	})
+}
@@ -0,0 +1,75 @@
+package monoscope
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+)
+
+// DefaultNDJSONMaxLines is how many lines of an NDJSON body are captured when
+// Config.NDJSONMaxLines is zero.
+const DefaultNDJSONMaxLines = 100
+
+// ndjsonMediaTypes are the media types of newline-delimited JSON bodies.
+var ndjsonMediaTypes = map[string]bool{
+	"application/x-ndjson":      true,
+	"application/ndjson":        true,
+	"application/jsonl":         true,
+	"application/x-jsonlines":   true,
+	"application/stream+json":   true,
+	"application/x-json-stream": true,
+}
+
+// IsNDJSONContent reports whether the Content-Type header describes a
+// newline-delimited JSON body, such as application/x-ndjson.
+func IsNDJSONContent(header map[string][]string) bool {
+	mediaType, _, err := mime.ParseMediaType(http.Header(header).Get("Content-Type"))
+	return err == nil && ndjsonMediaTypes[mediaType]
+}
+
+// limitNDJSON keeps the first maxLines lines of an NDJSON body, or
+// DefaultNDJSONMaxLines when maxLines is zero, so bulk-ingest streams are
+// captured without massive payloads. It returns the kept lines and the
+// body's line count, which is zero for other bodies. Blank lines aren't
+// counted.
+func limitNDJSON(body []byte, header map[string][]string, maxLines int) ([]byte, int) {
+	if !IsNDJSONContent(header) {
+		return body, 0
+	}
+	if maxLines <= 0 {
+		maxLines = DefaultNDJSONMaxLines
+	}
+	var kept [][]byte
+	count := 0
+	for line := range bytes.Lines(body) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		count++
+		if count <= maxLines {
+			kept = append(kept, line)
+		}
+	}
+	return bytes.Join(kept, []byte("\n")), count
+}
+
+// redactNDJSON redacts each line of an NDJSON body as a JSON document,
+// returning the first error. As with other JSON bodies, a line that fails to
+// parse is captured as null.
+func redactNDJSON(data []byte, redactList []string, audit redactionAudit, target string) ([]byte, error) {
+	var lines [][]byte
+	var redactErr error
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		redacted, err := redactJSON(line, redactList, audit, target)
+		if err != nil && redactErr == nil {
+			redactErr = err
+		}
+		lines = append(lines, redacted)
+	}
+	return bytes.Join(lines, []byte("\n")), redactErr
+}
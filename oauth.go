@@ -0,0 +1,66 @@
+package monoscope
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// OAuth token request parameters and response members that carry
+// credentials. They are redacted from the payloads of token endpoint calls
+// whatever the configured redaction rules, see isOAuthTokenRequest.
+var (
+	oauthTokenRequestSecrets = []string{
+		"client_secret", "client_assertion", "assertion", "code", "code_verifier",
+		"refresh_token", "access_token", "subject_token", "actor_token", "device_code", "password",
+	}
+	oauthTokenResponseSecrets = []string{"access_token", "refresh_token", "id_token"}
+)
+
+// isOAuthTokenRequest reports whether a request is a call to an OAuth 2.0
+// token endpoint: its path ends in a token endpoint such as /oauth/token,
+// /oauth2/v1/token or /protocol/openid-connect/token, or its body carries a
+// grant_type parameter, form-encoded or as a JSON member.
+func isOAuthTokenRequest(path string, body []byte) bool {
+	path = strings.ToLower(strings.TrimSuffix(path, "/"))
+	if strings.HasSuffix(path, "/token") &&
+		(strings.Contains(path, "oauth") || strings.Contains(path, "openid-connect") || strings.HasSuffix(path, "/connect/token")) {
+		return true
+	}
+	if !bytes.Contains(body, []byte("grant_type")) {
+		return false
+	}
+	if values, err := url.ParseQuery(string(body)); err == nil && values.Has("grant_type") {
+		return true
+	}
+	var members map[string]json.RawMessage
+	if json.Unmarshal(body, &members) == nil {
+		_, ok := members["grant_type"]
+		return ok
+	}
+	return false
+}
+
+// withOAuthTokenRedaction returns config and the body redaction lists of an
+// OAuth token endpoint call, extended to redact its credentials from the
+// query string and both bodies. Redaction is made strict, so a body the
+// rules can't be applied to, such as a form-encoded one, is replaced with
+// RedactionFailedMarker rather than captured.
+func withOAuthTokenRedaction(config Config, redactRequestBodyList, redactResponseBodyList []string) (Config, []string, []string) {
+	config.RedactQueryParams = slices.Concat(config.RedactQueryParams, oauthTokenRequestSecrets)
+	config.StrictRedaction = true
+	return config,
+		slices.Concat(redactRequestBodyList, jsonMemberPaths(oauthTokenRequestSecrets)),
+		slices.Concat(redactResponseBodyList, jsonMemberPaths(oauthTokenResponseSecrets))
+}
+
+// jsonMemberPaths returns the JSONPaths of the top-level members names.
+func jsonMemberPaths(names []string) []string {
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = "$." + name
+	}
+	return paths
+}
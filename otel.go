@@ -0,0 +1,174 @@
+package monoscope
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTelOptions configures InitOTel. The zero value exports traces and metrics
+// to DefaultEndpoint, or the OTEL_EXPORTER_OTLP_ENDPOINT environment
+// variable, over OTLP/gRPC.
+type OTelOptions struct {
+	ServiceName    string
+	ServiceVersion string
+	// Endpoint is the OTLP/gRPC collector address, e.g.
+	// "http://localhost:4317". An http:// scheme disables TLS.
+	Endpoint string
+	// Headers are sent with every export, e.g. for collector auth.
+	Headers map[string]string
+	// ResourceAttributes are added to the resource describing the service,
+	// along with those of OTEL_RESOURCE_ATTRIBUTES.
+	ResourceAttributes map[string]string
+	// ResourceOptions add detectors and attributes to that resource, such
+	// as resource.WithHost().
+	ResourceOptions []resource.Option
+	// Propagators are installed as the global propagator; W3C trace context
+	// and baggage when nil.
+	Propagators propagation.TextMapPropagator
+	// Sampler samples traces; the SDK's parent-based always-on sampler when
+	// nil.
+	Sampler sdktrace.Sampler
+	// SpanProcessors are registered in addition to the batching exporter.
+	// Like it, they are wrapped with NewDropFilter, so the spans marked with
+	// DropSpan and their descendants don't reach them.
+	SpanProcessors []sdktrace.SpanProcessor
+	// ErrorHandler, when set, is installed as the global OpenTelemetry
+	// error handler.
+	ErrorHandler otel.ErrorHandler
+	// DisableTraces and DisableMetrics turn off either signal.
+	DisableTraces  bool
+	DisableMetrics bool
+	// MetricsReportingPeriod is how often metrics are exported; the SDK's
+	// default of one minute when zero.
+	MetricsReportingPeriod time.Duration
+}
+
+// InitOTel sets up the global OpenTelemetry tracer and meter providers and
+// propagator directly on the OpenTelemetry SDK, exporting over OTLP/gRPC as
+// opts describes. It returns a function flushing and stopping the providers,
+// to call before the service exits. ConfigureOpenTelemetry is built on it.
+func InitOTel(ctx context.Context, opts OTelOptions) (shutdown func(context.Context) error, err error) {
+	if opts.ErrorHandler != nil {
+		otel.SetErrorHandler(opts.ErrorHandler)
+	}
+	propagator := opts.Propagators
+	if propagator == nil {
+		propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+	otel.SetTextMapPropagator(propagator)
+
+	res, err := otelResource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := opts.Endpoint
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		endpoint = DefaultEndpoint
+	}
+
+	var shutdowns []func(context.Context) error
+	shutdown = func(ctx context.Context) error {
+		var errs []error
+		for _, fn := range shutdowns {
+			errs = append(errs, fn(ctx))
+		}
+		return errors.Join(errs...)
+	}
+
+	if !opts.DisableTraces {
+		var exporterOpts []otlptracegrpc.Option
+		if endpoint != "" {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpointURL(withScheme(endpoint)))
+		}
+		if len(opts.Headers) > 0 {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithHeaders(opts.Headers))
+		}
+		exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, errors.Join(err, shutdown(ctx))
+		}
+		tpOpts := []sdktrace.TracerProviderOption{
+			sdktrace.WithSpanProcessor(NewDropFilter(sdktrace.NewBatchSpanProcessor(exporter))),
+			sdktrace.WithResource(res),
+		}
+		if opts.Sampler != nil {
+			tpOpts = append(tpOpts, sdktrace.WithSampler(opts.Sampler))
+		}
+		for _, sp := range opts.SpanProcessors {
+			tpOpts = append(tpOpts, sdktrace.WithSpanProcessor(NewDropFilter(sp)))
+		}
+		tp := sdktrace.NewTracerProvider(tpOpts...)
+		otel.SetTracerProvider(tp)
+		shutdowns = append(shutdowns, tp.Shutdown)
+	}
+
+	if !opts.DisableMetrics {
+		var exporterOpts []otlpmetricgrpc.Option
+		if endpoint != "" {
+			exporterOpts = append(exporterOpts, otlpmetricgrpc.WithEndpointURL(withScheme(endpoint)))
+		}
+		if len(opts.Headers) > 0 {
+			exporterOpts = append(exporterOpts, otlpmetricgrpc.WithHeaders(opts.Headers))
+		}
+		exporter, err := otlpmetricgrpc.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, errors.Join(err, shutdown(ctx))
+		}
+		var readerOpts []sdkmetric.PeriodicReaderOption
+		if opts.MetricsReportingPeriod > 0 {
+			readerOpts = append(readerOpts, sdkmetric.WithInterval(opts.MetricsReportingPeriod))
+		}
+		mp := sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, readerOpts...)),
+			sdkmetric.WithResource(res),
+		)
+		otel.SetMeterProvider(mp)
+		shutdowns = append(shutdowns, mp.Shutdown)
+	}
+	return shutdown, nil
+}
+
+// otelResource returns the resource describing the service opts configures,
+// merged over the SDK's default resource, which reads OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES.
+func otelResource(ctx context.Context, opts OTelOptions) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	if opts.ServiceName != "" {
+		attrs = append(attrs, attribute.String("service.name", opts.ServiceName))
+	}
+	if opts.ServiceVersion != "" {
+		attrs = append(attrs, attribute.String("service.version", opts.ServiceVersion))
+	}
+	for k, v := range opts.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	if len(opts.ResourceOptions) == 0 {
+		return resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	}
+	detected, err := resource.New(ctx, append(opts.ResourceOptions, resource.WithAttributes(attrs...))...)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Merge(resource.Default(), detected)
+}
+
+// withScheme returns endpoint as a URL, treating bare host:port addresses as
+// plaintext, as the framework packages' ConfigureOpenTelemetry defaults do.
+func withScheme(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	return "http://" + endpoint
+}
@@ -0,0 +1,127 @@
+package monoscope
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTelOption configures ConfigureOpenTelemetry. The options are those of
+// otel-config-go, which the framework packages' ConfigureOpenTelemetry was
+// built on, so existing setups keep compiling.
+type OTelOption func(*otelCompatConfig)
+
+type otelCompatConfig struct {
+	opts        OTelOptions
+	propagators []string
+}
+
+// ConfigureOpenTelemetry sets up OpenTelemetry with InitOTel from options in
+// the style of otel-config-go, exporting to DefaultEndpoint unless the
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variable is set. It returns a
+// function flushing and stopping the providers. New code should call
+// InitOTel.
+func ConfigureOpenTelemetry(opts ...OTelOption) (shutdown func(), err error) {
+	var c otelCompatConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.propagators != nil {
+		var propagators []propagation.TextMapPropagator
+		for _, name := range c.propagators {
+			switch name {
+			case "tracecontext":
+				propagators = append(propagators, propagation.TraceContext{})
+			case "baggage":
+				propagators = append(propagators, propagation.Baggage{})
+			default:
+				return func() {}, fmt.Errorf("monoscope: unsupported propagator %q", name)
+			}
+		}
+		c.opts.Propagators = propagation.NewCompositeTextMapPropagator(propagators...)
+	}
+	ctx := context.Background()
+	stop, err := InitOTel(ctx, c.opts)
+	if err != nil {
+		return func() {}, err
+	}
+	return func() { _ = stop(ctx) }, nil
+}
+
+// WithServiceName sets the service.name resource attribute.
+func WithServiceName(name string) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.ServiceName = name }
+}
+
+// WithServiceVersion sets the service.version resource attribute.
+func WithServiceVersion(version string) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.ServiceVersion = version }
+}
+
+// WithLogLevel is accepted for compatibility with otel-config-go, whose
+// setup logging it configured. It has no effect.
+func WithLogLevel(string) OTelOption {
+	return func(*otelCompatConfig) {}
+}
+
+// WithResourceAttributes adds attributes to the resource describing the
+// service.
+func WithResourceAttributes(attributes map[string]string) OTelOption {
+	return func(c *otelCompatConfig) {
+		if c.opts.ResourceAttributes == nil {
+			c.opts.ResourceAttributes = map[string]string{}
+		}
+		for k, v := range attributes {
+			c.opts.ResourceAttributes[k] = v
+		}
+	}
+}
+
+// WithResourceOption adds a resource option, such as a detector, to the
+// resource describing the service.
+func WithResourceOption(option resource.Option) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.ResourceOptions = append(c.opts.ResourceOptions, option) }
+}
+
+// WithPropagators sets the global propagators by name: "tracecontext" and
+// "baggage" are supported.
+func WithPropagators(propagators []string) OTelOption {
+	return func(c *otelCompatConfig) { c.propagators = propagators }
+}
+
+// WithErrorHandler installs handler as the global OpenTelemetry error
+// handler.
+func WithErrorHandler(handler otel.ErrorHandler) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.ErrorHandler = handler }
+}
+
+// WithMetricsReportingPeriod sets how often metrics are exported.
+func WithMetricsReportingPeriod(p time.Duration) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.MetricsReportingPeriod = p }
+}
+
+// WithMetricsEnabled turns metrics on or off; they are on by default.
+func WithMetricsEnabled(enabled bool) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.DisableMetrics = !enabled }
+}
+
+// WithTracesEnabled turns traces on or off; they are on by default.
+func WithTracesEnabled(enabled bool) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.DisableTraces = !enabled }
+}
+
+// WithSpanProcessor registers span processors in addition to the exporter,
+// see OTelOptions.SpanProcessors.
+func WithSpanProcessor(sp ...sdktrace.SpanProcessor) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.SpanProcessors = append(c.opts.SpanProcessors, sp...) }
+}
+
+// WithSampler sets the sampler of the tracer provider.
+func WithSampler(sampler sdktrace.Sampler) OTelOption {
+	return func(c *otelCompatConfig) { c.opts.Sampler = sampler }
+}
@@ -3,11 +3,17 @@ package monoscope
 import (
 	"bytes"
 	"context"
+	"crypto/rsa"
+	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"sync"
 
 	"github.com/google/uuid"
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -15,6 +21,7 @@ type roundTripper struct {
 	base http.RoundTripper
 	ctx  context.Context
 	cfg  *roundTripperConfig
+	pool *poolMetrics
 }
 
 func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err error) {
@@ -24,74 +31,165 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 		}
 	}()
 
-	tracer := otel.GetTracerProvider().Tracer("")
-	_, span := tracer.Start(rt.ctx, "monoscope.http", trace.WithSpanKind(trace.SpanKindClient))
+	conf := roundTripperConfigToConfig(rt.cfg, middlewareConfig(req.Context(), rt.ctx))
+	spanCtx, span := Tracer(conf).Start(rt.ctx, "monoscope.http", trace.WithSpanKind(trace.SpanKindClient))
 	defer span.End()
 
+	// Propagate the trace context on a copy, as RoundTrip must not modify req.
+	reqCtx := req.Context()
+	if rt.pool != nil {
+		reqCtx = rt.pool.withClientTrace(reqCtx, req.URL.Host)
+	}
+	req = req.Clone(reqCtx)
+	Propagator(conf).Inject(spanCtx, propagation.HeaderCarrier(req.Header))
+
 	// Capture the request body
 	reqBodyBytes := []byte{}
-	if req.Body != nil {
+	var reqBody func() []byte
+	if rt.cfg.CaptureOnError {
+		reqBody = requestBodyOnDemand(req)
+	} else if req.Body != nil {
 		reqBodyBytes, _ = io.ReadAll(req.Body)
 		req.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes))
 	}
 
+	deadline, hasDeadline := callDeadline(req.Context(), rt.ctx)
+	start := Now(conf)
 	// Add a header to all outgoing requests "X-APITOOLKIT-TRACE-PARENT-ID"
 	res, err = rt.base.RoundTrip(req)
+	var budget *DeadlineBudget
+	if hasDeadline {
+		budget = newDeadlineBudget(deadline, start, Now(conf), rt.cfg.DeadlineBudget)
+	}
 	var errorList []ATError
 	if err != nil {
 		// Add the error for the given request payload
 		errorList = append(errorList, BuildError(err))
 	}
+	if reqBody != nil {
+		// Force-sampled calls capture their bodies in full, see ForceSample.
+		if err != nil || res.StatusCode >= 400 || forceSampled(req.Context()) || forceSampled(rt.ctx) {
+			reqBodyBytes = reqBody()
+		} else {
+			conf.CaptureRequestBody, conf.CaptureResponseBody = false, false
+		}
+	}
+
+	urlPath := req.URL.Path
+	var pathParams map[string]string
+	if profile, ok := providerProfiles[rt.cfg.ProviderProfile]; ok {
+		if template, params, ok := profile.route(urlPath); ok {
+			urlPath, pathParams = template, params
+		}
+	}
 
 	var payload Payload
 	var parentMsgIDPtr *uuid.UUID
-	parentMsgID, ok := rt.ctx.Value(CurrentRequestMessageID).(uuid.UUID)
+	parentMsgID, ok := MessageIDFrom(rt.ctx)
 	if ok {
 		parentMsgIDPtr = &parentMsgID
 	}
 
 	// Capture the response body
-	conf := roundTripperConfigToConfig(rt.cfg)
 	if res != nil {
-		respBodyBytes, _ := io.ReadAll(res.Body)
-		res.Body = io.NopCloser(bytes.NewBuffer(respBodyBytes))
+		var respBodyBytes []byte
+		if conf.CaptureResponseBody {
+			respBodyBytes, _ = io.ReadAll(res.Body)
+			res.Body = io.NopCloser(bytes.NewBuffer(respBodyBytes))
+		}
 		payload = BuildPayload(
 			GoOutgoing,
 			req, res.StatusCode, reqBodyBytes,
-			respBodyBytes, res.Header, nil,
-			req.URL.Path,
-			rt.cfg.RedactHeaders, rt.cfg.RedactRequestBody, rt.cfg.RedactResponseBody,
+			respBodyBytes, res.Header, pathParams,
+			urlPath,
+			conf.RedactHeaders, conf.RedactRequestBody, conf.RedactResponseBody,
 			errorList,
 			uuid.Nil,
 			parentMsgIDPtr,
 			conf,
 		)
+		payload.DeadlineBudget = budget
+		var profileAttrs []attribute.KeyValue
+		if rt.cfg.SOAPProfile {
+			profileAttrs = soapProfile(req, res, respBodyBytes, Now(conf), &payload)
+		}
+		if rt.cfg.ProviderProfile != "" {
+			profileAttrs = append(profileAttrs, applyProviderProfile(rt.cfg.ProviderProfile, res, respBodyBytes, Now(conf), &payload)...)
+		}
 		CreateSpan(payload, conf, span)
+		span.SetAttributes(rt.cfg.spanAttributes()...)
+		span.SetAttributes(profileAttrs...)
 
 	} else {
 		payload = BuildPayload(
 			GoOutgoing,
 			req, 503, reqBodyBytes,
-			nil, nil, nil,
-			req.URL.Path,
-			rt.cfg.RedactHeaders, rt.cfg.RedactRequestBody, rt.cfg.RedactResponseBody,
+			nil, nil, pathParams,
+			urlPath,
+			conf.RedactHeaders, conf.RedactRequestBody, conf.RedactResponseBody,
 			errorList,
 			uuid.Nil,
 			parentMsgIDPtr,
 			conf,
 		)
+		payload.DeadlineBudget = budget
 		CreateSpan(payload, conf, span)
+		span.SetAttributes(rt.cfg.spanAttributes()...)
 
 	}
 	return res, err
 }
 
+// requestBodyOnDemand returns a function returning req's body once the
+// round trip is over, for WithCaptureOnError, without reading it up front: a
+// body that can be recreated with GetBody is read again only when asked for,
+// and any other is copied as the transport sends it.
+func requestBodyOnDemand(req *http.Request) func() []byte {
+	if req.Body == nil || req.Body == http.NoBody {
+		return func() []byte { return nil }
+	}
+	if req.GetBody != nil {
+		return func() []byte {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil
+			}
+			defer body.Close()
+			b, _ := io.ReadAll(body)
+			return b
+		}
+	}
+	sent := &lockedBuffer{}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(req.Body, sent), req.Body}
+	return sent.Bytes
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use: the transport may
+// still be writing the request body when the response arrives.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what was written so far.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
 func HTTPClient(ctx context.Context, opts ...RoundTripperOption) *http.Client {
 	// Run the roundTripperConfig to extract out a httpClient Transport
-	cfg := new(roundTripperConfig)
-	for _, opt := range opts {
-		opt(cfg)
-	}
+	cfg := newRoundTripperConfig(ctx, opts)
 
 	httpClientV := *http.DefaultClient
 	httpClient := &httpClientV
@@ -109,14 +207,110 @@ func HTTPClient(ctx context.Context, opts ...RoundTripperOption) *http.Client {
 }
 
 type roundTripperConfig struct {
-	HTTPClient         *http.Client
-	RedactHeaders      []string
-	RedactRequestBody  []string
-	RedactResponseBody []string
+	HTTPClient          *http.Client
+	RedactHeaders       []string
+	RedactRequestBody   []string
+	RedactResponseBody  []string
+	RedactQueryParams   []string
+	TracerProvider      trace.TracerProvider
+	Propagators         propagation.TextMapPropagator
+	Attributes          map[string]any
+	OutgoingTag         string
+	SOAPProfile         bool
+	SemanticConventions bool
+	StrictRedaction     bool
+	BodyEncryptionKey   *rsa.PublicKey
+	CaptureOnError      bool
+	DeadlineBudget      float64
+	MeterProvider       metric.MeterProvider
+	ProviderProfile     string
+}
+
+// spanAttributes returns the caller-supplied attributes for outgoing spans.
+func (cfg *roundTripperConfig) spanAttributes() []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(cfg.Attributes)+2)
+	for key, value := range cfg.Attributes {
+		attrs = append(attrs, attributeFromValue(key, value))
+	}
+	if tag := cfg.outgoingTag(); tag != "" {
+		attrs = append(attrs,
+			attribute.String("apitoolkit.outgoing_tag", tag),
+			attribute.String("peer.service", tag),
+		)
+	}
+	return attrs
+}
+
+// outgoingTag returns the WithOutgoingTag of the client, defaulting to the
+// name of its provider profile.
+func (cfg *roundTripperConfig) outgoingTag() string {
+	if cfg.OutgoingTag != "" {
+		return cfg.OutgoingTag
+	}
+	return cfg.ProviderProfile
+}
+
+// attributeFromValue converts value to the matching attribute type, falling
+// back to its fmt.Sprint form for types OpenTelemetry has no attribute for.
+func attributeFromValue(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	case []bool:
+		return attribute.BoolSlice(key, v)
+	case []int:
+		return attribute.IntSlice(key, v)
+	case []int64:
+		return attribute.Int64Slice(key, v)
+	case []float64:
+		return attribute.Float64Slice(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
 }
 
 type RoundTripperOption func(*roundTripperConfig)
 
+// clientDefaultsCtxKey holds the options set with WithClientDefaults.
+var clientDefaultsCtxKey = ctxKey("client-defaults")
+
+// WithClientDefaults returns a copy of ctx carrying opts as defaults for every
+// HTTPClient and WrapRoundTripper created with it, so options such as
+// redaction can be set once per request scope rather than at each call site.
+// Options passed at the call site are applied after the defaults and take
+// precedence. Calling WithClientDefaults again on the returned context adds to
+// the defaults already set.
+func WithClientDefaults(ctx context.Context, opts ...RoundTripperOption) context.Context {
+	defaults, _ := ctx.Value(clientDefaultsCtxKey).([]RoundTripperOption)
+	merged := make([]RoundTripperOption, 0, len(defaults)+len(opts))
+	merged = append(merged, defaults...)
+	merged = append(merged, opts...)
+	return context.WithValue(ctx, clientDefaultsCtxKey, merged)
+}
+
+// newRoundTripperConfig applies the defaults stored in ctx, then opts.
+func newRoundTripperConfig(ctx context.Context, opts []RoundTripperOption) *roundTripperConfig {
+	cfg := new(roundTripperConfig)
+	defaults, _ := ctx.Value(clientDefaultsCtxKey).([]RoundTripperOption)
+	for _, opt := range defaults {
+		opt(cfg)
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
 // WithHTTPClient allows you supply your own custom http client
 func WithHTTPClient(httpClient *http.Client) RoundTripperOption {
 	return func(rc *roundTripperConfig) {
@@ -124,6 +318,54 @@ func WithHTTPClient(httpClient *http.Client) RoundTripperOption {
 	}
 }
 
+// WithTracerProvider creates outgoing request spans with tp instead of the
+// global tracer provider.
+func WithTracerProvider(tp trace.TracerProvider) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.TracerProvider = tp
+	}
+}
+
+// WithPropagator injects the trace context into outgoing requests with p
+// instead of the global propagator, e.g. for upstreams that expect B3 headers.
+func WithPropagator(p propagation.TextMapPropagator) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.Propagators = p
+	}
+}
+
+// WithAttributes adds attrs to the spans of outgoing requests. Values of types
+// OpenTelemetry has no attribute for are recorded as strings. Attributes from
+// repeated WithAttributes options, including WithClientDefaults ones, are
+// merged, with later values winning.
+func WithAttributes(attrs map[string]any) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		if rc.Attributes == nil {
+			rc.Attributes = make(map[string]any, len(attrs))
+		}
+		for key, value := range attrs {
+			rc.Attributes[key] = value
+		}
+	}
+}
+
+// WithOutgoingTag labels outgoing requests with the name of the dependency
+// they call, e.g. "payments-api" or "stripe", recorded as
+// apitoolkit.outgoing_tag and peer.service on the span.
+func WithOutgoingTag(tag string) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.OutgoingTag = tag
+	}
+}
+
+// WithSemanticConventions additionally emits the standard OpenTelemetry HTTP
+// client attributes on outgoing spans, see Config.SemanticConventions.
+func WithSemanticConventions() RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.SemanticConventions = true
+	}
+}
+
 func WithRedactHeaders(headers ...string) RoundTripperOption {
 	return func(rc *roundTripperConfig) {
 		rc.RedactHeaders = headers
@@ -142,13 +384,70 @@ func WithRedactResponseBody(fields ...string) RoundTripperOption {
 	}
 }
 
+// WithRedactQueryParams redacts the values of the named query parameters of
+// outgoing requests, see Config.RedactQueryParams.
+func WithRedactQueryParams(params ...string) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.RedactQueryParams = params
+	}
+}
+
+// WithStrictRedaction replaces outgoing request and response bodies that the
+// redaction rules could not be applied to with RedactionFailedMarker, see
+// Config.StrictRedaction.
+func WithStrictRedaction() RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.StrictRedaction = true
+	}
+}
+
+// WithCaptureOnError captures the bodies of outgoing requests only when they
+// fail, with a transport error or a status of 400 or above. Successful
+// requests are recorded without bodies, and their response bodies are
+// passed through unbuffered, keeping the overhead of chatty internal calls
+// near zero. Request bodies are still available when a request fails: bodies
+// the request can recreate through GetBody, as those of http.NewRequest with
+// a bytes or strings reader can, are read again only then, and others are
+// copied as they are sent. Requests made for a request marked with
+// ForceSample are captured in full whatever their outcome.
+func WithCaptureOnError() RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.CaptureOnError = true
+	}
+}
+
+// WithMeterProvider records the connection pool use of the client in mp:
+// the connections its requests were sent over, how long they waited for one
+// and the share that reused one, see ClientConnectionsMetric. Clients are
+// told apart by their WithOutgoingTag, recorded as peer.service.
+func WithMeterProvider(mp metric.MeterProvider) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.MeterProvider = mp
+	}
+}
+
+// WithDeadlineBudget sets the share of the time left before their context
+// deadline, e.g. 0.5 for half, that outgoing calls may take before they are
+// flagged as exceeding their budget, see Payload.DeadlineBudget. It defaults
+// to DefaultDeadlineBudget.
+func WithDeadlineBudget(share float64) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.DeadlineBudget = share
+	}
+}
+
+// WithBodyEncryptionKey encrypts the captured bodies of outgoing requests for
+// key, see Config.BodyEncryptionKey.
+func WithBodyEncryptionKey(key *rsa.PublicKey) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.BodyEncryptionKey = key
+	}
+}
+
 // WrapRoundTripper returns a new RoundTripper which traces all requests sent
 // over the transport.
 func WrapRoundTripper(ctx context.Context, rt http.RoundTripper, opts ...RoundTripperOption) http.RoundTripper {
-	cfg := new(roundTripperConfig)
-	for _, opt := range opts {
-		opt(cfg)
-	}
+	cfg := newRoundTripperConfig(ctx, opts)
 
 	// If no rt is passed in, then use the default standard library transport
 	if rt == nil {
@@ -158,14 +457,39 @@ func WrapRoundTripper(ctx context.Context, rt http.RoundTripper, opts ...RoundTr
 		base: rt,
 		ctx:  ctx,
 		cfg:  cfg,
+		pool: poolMetricsFor(cfg.MeterProvider, cfg.outgoingTag()),
+	}
+}
+
+// middlewareConfig returns the Config of the middleware handling the request
+// an outgoing call is made for, found in the call's context or else in the
+// client's.
+func middlewareConfig(reqCtx, clientCtx context.Context) Config {
+	if config, ok := reqCtx.Value(configCtxKey).(*Config); ok {
+		return *config
 	}
+	return configFromContext(clientCtx)
 }
 
-func roundTripperConfigToConfig(cfg *roundTripperConfig) Config {
+// roundTripperConfigToConfig returns the Config outgoing spans are created
+// with. They are reported to the project of parent, the Config of the
+// middleware the call is made under, see Config.APIKey.
+func roundTripperConfigToConfig(cfg *roundTripperConfig, parent Config) Config {
+	profile := providerProfiles[cfg.ProviderProfile]
 	return Config{
-		RedactHeaders:       cfg.RedactHeaders,
-		RedactRequestBody:   cfg.RedactRequestBody,
-		RedactResponseBody:  cfg.RedactResponseBody,
+		APIKey:              parent.APIKey,
+		Endpoint:            parent.Endpoint,
+		ServiceName:         parent.ServiceName,
+		ServiceVersion:      parent.ServiceVersion,
+		RedactHeaders:       slices.Concat(cfg.RedactHeaders, profile.redactHeaders),
+		RedactRequestBody:   slices.Concat(cfg.RedactRequestBody, profile.redactRequestBody),
+		RedactResponseBody:  slices.Concat(cfg.RedactResponseBody, profile.redactResponseBody),
+		RedactQueryParams:   slices.Concat(cfg.RedactQueryParams, profile.redactQueryParams),
+		TracerProvider:      cfg.TracerProvider,
+		Propagators:         cfg.Propagators,
+		SemanticConventions: cfg.SemanticConventions,
+		StrictRedaction:     cfg.StrictRedaction,
+		BodyEncryptionKey:   cfg.BodyEncryptionKey,
 		CaptureRequestBody:  true,
 		CaptureResponseBody: true,
 	}
@@ -0,0 +1,49 @@
+package monoscope
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// PanicInfo describes a panic recovered while a request was being handled.
+// It is reported in its own section of the payload, apart from the errors
+// handlers report, so crashes can be triaged separately.
+type PanicInfo struct {
+	When        time.Time `json:"when"`
+	Value       string    `json:"value"`
+	ValueType   string    `json:"value_type"`
+	Stack       string    `json:"stack"`
+	GoroutineID int64     `json:"goroutine_id"`
+}
+
+// NewPanicInfo builds a PanicInfo for a value returned by recover. It must be
+// called from the deferred function that recovered, so the captured stack
+// still includes the frames that panicked.
+func NewPanicInfo(recovered interface{}, config Config) *PanicInfo {
+	selfMetrics.panicsRecovered.Add(1)
+	stack := debug.Stack()
+	return &PanicInfo{
+		When:        Now(config),
+		Value:       fmt.Sprint(recovered),
+		ValueType:   fmt.Sprintf("%T", recovered),
+		Stack:       string(stack),
+		GoroutineID: goroutineID(stack),
+	}
+}
+
+// goroutineID parses the ID from the "goroutine N [running]:" header of a
+// stack trace, returning 0 if it can't be found.
+func goroutineID(stack []byte) int64 {
+	stack, ok := bytes.CutPrefix(stack, []byte("goroutine "))
+	if !ok {
+		return 0
+	}
+	if i := bytes.IndexByte(stack, ' '); i >= 0 {
+		stack = stack[:i]
+	}
+	id, _ := strconv.ParseInt(string(stack), 10, 64)
+	return id
+}
@@ -0,0 +1,88 @@
+package monoscope
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// PayloadInput is what BuildPayloadFromInput builds a payload from: the
+// request, the response written to it, and how the integration reporting it
+// is configured. It is the stable way to report requests from frameworks the
+// SDK has no middleware for. A minimal net/http style integration:
+//
+//	ctx, span, owned := monoscope.StartServerSpan(r.Context(), config, propagation.HeaderCarrier(r.Header))
+//	if owned {
+//		defer span.End()
+//	}
+//	ctx = monoscope.ContextWithConfig(ctx, config)
+//	ctx = monoscope.ContextWithAnnotations(ctx)
+//	// ...serve the request with ctx, recording the status and body...
+//	payload := monoscope.BuildPayloadFromInput(monoscope.PayloadInput{
+//		Request:        r.WithContext(ctx),
+//		StatusCode:     status,
+//		ResponseBody:   body,
+//		ResponseHeader: w.Header(),
+//		Route:          "/users/{id}",
+//		PathParams:     map[string]string{"id": id},
+//		Config:         config,
+//	})
+//	if monoscope.ShouldExport(config, &payload, time.Since(start)) {
+//		monoscope.CreateSpan(payload, config, span)
+//	} else if owned {
+//		monoscope.DropSpan(span)
+//	}
+type PayloadInput struct {
+	// SDKType identifies the integration, GoDefaultSDKType when empty.
+	SDKType string
+	// Request is the request being reported. Its context supplies the
+	// annotations, cancellation status and parent message ID.
+	Request *http.Request
+	// RequestBody and ResponseBody are the captured bodies, before
+	// redaction. Leave them nil when body capture is disabled.
+	RequestBody  []byte
+	ResponseBody []byte
+	// StatusCode and ResponseHeader are what was sent to the client.
+	StatusCode     int
+	ResponseHeader http.Header
+	// Route is the route template the request matched, such as
+	// "/users/{id}", and PathParams the values of its parameters. Leave
+	// Route empty for unmatched requests rather than using the raw path.
+	Route      string
+	PathParams map[string]string
+	// Errors are the errors reported while handling the request, usually
+	// collected with WithErrorList.
+	Errors []ATError
+	// MsgID identifies the payload, a NewMessageID when zero. ParentID is
+	// the message ID of an enclosing reported request, if any, see
+	// ParentMessageID.
+	MsgID    uuid.UUID
+	ParentID *uuid.UUID
+	// Config configures capture and redaction. Its RedactHeaders,
+	// RedactRequestBody and RedactResponseBody rules are applied.
+	Config Config
+}
+
+// BuildPayloadFromInput builds the payload of in.Request, redacted and
+// annotated as the framework middlewares do, ready for CreateSpan. Unlike
+// BuildPayload it fills in defaults for the fields left zero, and new
+// options are added as fields rather than parameters.
+func BuildPayloadFromInput(in PayloadInput) Payload {
+	sdkType := in.SDKType
+	if sdkType == "" {
+		sdkType = GoDefaultSDKType
+	}
+	msgID := in.MsgID
+	if msgID == uuid.Nil {
+		msgID = NewMessageID(in.Config)
+	}
+	return BuildPayload(sdkType, in.Request,
+		in.StatusCode, in.RequestBody, in.ResponseBody, in.ResponseHeader,
+		in.PathParams, in.Route,
+		in.Config.RedactHeaders, in.Config.RedactRequestBody, in.Config.RedactResponseBody,
+		in.Errors,
+		msgID,
+		in.ParentID,
+		in.Config,
+	)
+}
@@ -0,0 +1,148 @@
+package monoscope
+
+import (
+	"context"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Connection pool metrics recorded for clients instrumented with
+// WithMeterProvider. ClientTimeInQueueMetric is named after the OpenTelemetry
+// HTTP semantic conventions.
+const (
+	// ClientConnectionsMetric counts the connections outgoing requests were
+	// sent over, by monoscope.connection.state: "new" for a freshly dialed
+	// connection, "idle" for one reused from the idle pool and "shared" for
+	// one in use by other requests, such as an HTTP/2 connection.
+	ClientConnectionsMetric = "monoscope.http.client.connections"
+	// ClientTimeInQueueMetric records how long outgoing requests waited for
+	// a connection, including dialing a new one.
+	ClientTimeInQueueMetric = "http.client.request.time_in_queue"
+	// ClientConnectionReuseMetric is the share of the requests since the
+	// previous collection that reused a connection. A falling ratio with a
+	// rising time in queue points at an exhausted pool.
+	ClientConnectionReuseMetric = "monoscope.http.client.connection.reuse_ratio"
+)
+
+// poolMetricsByClient caches the poolMetrics per metric.MeterProvider and
+// client, so clients created per request don't each register a callback.
+var poolMetricsByClient sync.Map
+
+type poolMetricsKey struct {
+	mp     metric.MeterProvider
+	client string
+}
+
+// poolMetrics records the connection pool use of the clients named client.
+type poolMetrics struct {
+	client      string
+	connections metric.Int64Counter
+	timeInQueue metric.Float64Histogram
+
+	mu       sync.Mutex
+	acquired int64
+	reused   int64
+}
+
+// poolMetricsFor returns the poolMetrics of client in mp, or nil when mp is
+// nil or its instruments can't be created. client is the client's outgoing
+// tag, recorded as peer.service when set.
+func poolMetricsFor(mp metric.MeterProvider, client string) *poolMetrics {
+	if mp == nil {
+		return nil
+	}
+	key := poolMetricsKey{mp, client}
+	if m, ok := poolMetricsByClient.Load(key); ok {
+		return m.(*poolMetrics)
+	}
+	meter := mp.Meter("monoscope")
+	m := &poolMetrics{client: client}
+	var err error
+	if m.connections, err = meter.Int64Counter(ClientConnectionsMetric,
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Connections outgoing HTTP requests were sent over, by whether they were new, idle or shared."),
+	); err != nil {
+		return nil
+	}
+	if m.timeInQueue, err = meter.Float64Histogram(ClientTimeInQueueMetric,
+		metric.WithUnit("s"),
+		metric.WithDescription("Time outgoing HTTP requests waited for a connection."),
+		metric.WithExplicitBucketBoundaries(requestDurationBuckets...),
+	); err != nil {
+		return nil
+	}
+	reuse, err := meter.Float64ObservableGauge(ClientConnectionReuseMetric,
+		metric.WithUnit("1"),
+		metric.WithDescription("Share of the outgoing HTTP requests since the previous collection that reused a connection."),
+	)
+	if err != nil {
+		return nil
+	}
+	actual, loaded := poolMetricsByClient.LoadOrStore(key, m)
+	if loaded {
+		return actual.(*poolMetrics)
+	}
+	_, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		if ratio, ok := m.reuseRatio(); ok {
+			o.ObserveFloat64(reuse, ratio, metric.WithAttributes(m.attributes()...))
+		}
+		return nil
+	}, reuse)
+	return m
+}
+
+// attributes returns the attributes identifying the client, followed by
+// extra.
+func (m *poolMetrics) attributes(extra ...attribute.KeyValue) []attribute.KeyValue {
+	if m.client == "" {
+		return extra
+	}
+	return append([]attribute.KeyValue{attribute.String("peer.service", m.client)}, extra...)
+}
+
+// withClientTrace returns ctx with an httptrace.ClientTrace recording how a
+// request to host got its connection.
+func (m *poolMetrics) withClientTrace(ctx context.Context, host string) context.Context {
+	var waitStart time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { waitStart = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			state := "new"
+			switch {
+			case info.WasIdle:
+				state = "idle"
+			case info.Reused:
+				state = "shared"
+			}
+			server := attribute.String("server.address", host)
+			m.connections.Add(ctx, 1, metric.WithAttributes(m.attributes(server, attribute.String("monoscope.connection.state", state))...))
+			if !waitStart.IsZero() {
+				m.timeInQueue.Record(ctx, time.Since(waitStart).Seconds(), metric.WithAttributes(m.attributes(server)...))
+			}
+			m.mu.Lock()
+			m.acquired++
+			if info.Reused {
+				m.reused++
+			}
+			m.mu.Unlock()
+		},
+	})
+}
+
+// reuseRatio returns the share of the connections acquired since the
+// previous call that were reused, and resets the counts. It reports false
+// when none were acquired.
+func (m *poolMetrics) reuseRatio() (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.acquired == 0 {
+		return 0, false
+	}
+	ratio := float64(m.reused) / float64(m.acquired)
+	m.acquired, m.reused = 0, 0
+	return ratio, true
+}
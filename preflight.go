@@ -0,0 +1,21 @@
+package monoscope
+
+import "net/http"
+
+// RequestTypePreflight is the request type of CORS preflight requests.
+const RequestTypePreflight = "preflight"
+
+// IsPreflight reports whether a request with the given method and headers is
+// a CORS preflight: an OPTIONS request carrying Origin and
+// Access-Control-Request-Method, sent by browsers before the actual request.
+func IsPreflight(method string, header http.Header) bool {
+	return method == http.MethodOptions && header.Get("Origin") != "" && header.Get("Access-Control-Request-Method") != ""
+}
+
+// requestType returns the request type recorded for a request.
+func requestType(method string, header http.Header) string {
+	if IsPreflight(method, header) {
+		return RequestTypePreflight
+	}
+	return ""
+}
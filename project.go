@@ -0,0 +1,97 @@
+package monoscope
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultEndpoint is the Monoscope collector spans are exported to when
+// Config.APIKey is set without an Endpoint.
+const DefaultEndpoint = "http://otelcol.apitoolkit.io:4317"
+
+// projectKeyAttribute is the resource attribute the collector uses to route
+// spans to a project, the same one set via OTEL_RESOURCE_ATTRIBUTES.
+const projectKeyAttribute = "at-project-key"
+
+type projectKey struct {
+	apiKey, endpoint, serviceName, serviceVersion string
+}
+
+var (
+	projectsMu sync.Mutex
+	projects   = map[projectKey]*sdktrace.TracerProvider{}
+)
+
+// projectTracerProvider returns the provider exporting to config.APIKey's
+// project, creating it on first use. Providers are shared by every Config
+// with the same key, endpoint and service, so building a Config per request
+// doesn't create an exporter per request.
+func projectTracerProvider(config Config) trace.TracerProvider {
+	key := projectKey{config.APIKey, config.Endpoint, config.ServiceName, config.ServiceVersion}
+	if key.endpoint == "" {
+		key.endpoint = DefaultEndpoint
+	}
+
+	projectsMu.Lock()
+	defer projectsMu.Unlock()
+	if tp, ok := projects[key]; ok {
+		return tp
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(key.endpoint)}
+	if strings.Contains(key.endpoint, "://") {
+		opts = []otlptracegrpc.Option{otlptracegrpc.WithEndpointURL(key.endpoint)}
+	}
+	// The gRPC connection is established lazily, so this only fails on
+	// invalid options.
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		log.Printf("monoscope: unable to create exporter for %s: %v", key.endpoint, err)
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{attribute.String(projectKeyAttribute, key.apiKey)}
+	if key.serviceName != "" {
+		attrs = append(attrs, attribute.String("service.name", key.serviceName))
+	}
+	if key.serviceVersion != "" {
+		attrs = append(attrs, attribute.String("service.version", key.serviceVersion))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		res = resource.NewSchemaless(attrs...)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(NewDropFilter(sdktrace.NewBatchSpanProcessor(exporter))),
+		sdktrace.WithResource(res),
+	)
+	projects[key] = tp
+	return tp
+}
+
+// Shutdown flushes and stops the exporters created for Config.APIKey; call it
+// once the services using them have stopped serving. It does not affect
+// providers set up with ConfigureOpenTelemetry or passed in
+// Config.TracerProvider.
+func Shutdown(ctx context.Context) error {
+	projectsMu.Lock()
+	tps := projects
+	projects = map[projectKey]*sdktrace.TracerProvider{}
+	projectsMu.Unlock()
+
+	var errs []error
+	for _, tp := range tps {
+		errs = append(errs, tp.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,250 @@
+package monoscope
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// providerProfile is a capture profile for the API of a third-party
+// provider, see WithProviderProfile.
+type providerProfile struct {
+	redactHeaders      []string
+	redactRequestBody  []string
+	redactResponseBody []string
+	redactQueryParams  []string
+	// pathTemplates are the provider's route templates. A "{name}" stands
+	// for a path segment, or for the part of one between a literal prefix
+	// and suffix, as in "{sid}.json".
+	pathTemplates []string
+	// errorCode and errorMessage are where the error code and message are
+	// found in error response bodies, as dotted member paths such as
+	// "error.code" in which numbers index arrays. The first path that
+	// resolves to a value wins.
+	errorCode    []string
+	errorMessage []string
+}
+
+// providerProfiles are the profiles WithProviderProfile selects from.
+// Request bodies sent form-encoded, as Stripe's and Twilio's are, can't be
+// redacted field by field and are never captured.
+var providerProfiles = map[string]providerProfile{
+	"stripe": {
+		redactResponseBody: []string{
+			"$.client_secret", "$.data[*].client_secret",
+			"$.email", "$.data[*].email", "$.phone", "$.data[*].phone",
+			"$.address", "$.shipping", "$.billing_details", "$.data[*].billing_details",
+		},
+		pathTemplates: []string{
+			"/v1/customers/search", "/v1/customers/{customer}", "/v1/customers/{customer}/sources/{source}",
+			"/v1/payment_intents/search", "/v1/payment_intents/{intent}", "/v1/payment_intents/{intent}/confirm",
+			"/v1/payment_intents/{intent}/capture", "/v1/payment_intents/{intent}/cancel",
+			"/v1/setup_intents/{intent}", "/v1/setup_intents/{intent}/confirm",
+			"/v1/payment_methods/{payment_method}", "/v1/payment_methods/{payment_method}/attach",
+			"/v1/payment_methods/{payment_method}/detach",
+			"/v1/charges/search", "/v1/charges/{charge}", "/v1/charges/{charge}/capture", "/v1/refunds/{refund}",
+			"/v1/invoices/search", "/v1/invoices/upcoming", "/v1/invoices/{invoice}", "/v1/invoices/{invoice}/pay",
+			"/v1/invoices/{invoice}/finalize", "/v1/invoices/{invoice}/void",
+			"/v1/subscriptions/search", "/v1/subscriptions/{subscription}",
+			"/v1/checkout/sessions/{session}", "/v1/checkout/sessions/{session}/expire",
+			"/v1/prices/{price}", "/v1/products/{product}", "/v1/events/{event}",
+		},
+		errorCode:    []string{"error.code", "error.type"},
+		errorMessage: []string{"error.message"},
+	},
+	"twilio": {
+		redactResponseBody: []string{
+			"$.body", "$.to", "$.from",
+			"$.messages[*].body", "$.messages[*].to", "$.messages[*].from",
+			"$.calls[*].to", "$.calls[*].from",
+		},
+		pathTemplates: []string{
+			"/2010-04-01/Accounts/{account}.json",
+			"/2010-04-01/Accounts/{account}/Messages.json",
+			"/2010-04-01/Accounts/{account}/Messages/{message}.json",
+			"/2010-04-01/Accounts/{account}/Messages/{message}/Media.json",
+			"/2010-04-01/Accounts/{account}/Calls.json",
+			"/2010-04-01/Accounts/{account}/Calls/{call}.json",
+			"/2010-04-01/Accounts/{account}/Recordings/{recording}.json",
+			"/v2/Services/{service}/Verifications",
+			"/v2/Services/{service}/Verifications/{verification}",
+			"/v2/Services/{service}/VerificationCheck",
+		},
+		errorCode:    []string{"code"},
+		errorMessage: []string{"message"},
+	},
+	"sendgrid": {
+		redactRequestBody: []string{
+			"$.personalizations[*].to[*].email", "$.personalizations[*].cc[*].email",
+			"$.personalizations[*].bcc[*].email", "$.personalizations[*].dynamic_template_data",
+			"$.personalizations[*].substitutions", "$.from.email", "$.reply_to.email",
+			"$.content[*].value", "$.attachments[*].content",
+			"$.contacts[*].email", "$.contacts[*].phone_number",
+		},
+		pathTemplates: []string{
+			"/v3/mail/send", "/v3/marketing/contacts",
+			"/v3/templates/{template}", "/v3/templates/{template}/versions/{version}",
+			"/v3/messages/{message}",
+		},
+		errorCode:    []string{"errors.0.field"},
+		errorMessage: []string{"errors.0.message"},
+	},
+	"paystack": {
+		redactRequestBody: []string{
+			"$.email", "$.authorization_code", "$.pin", "$.otp", "$.phone",
+			"$.card.number", "$.card.cvv", "$.card.expiry_month", "$.card.expiry_year",
+			"$.bank.account_number", "$.account_number",
+		},
+		redactResponseBody: []string{
+			"$.data.access_code", "$.data.authorization.authorization_code",
+			"$.data.customer.email", "$.data.customer.phone",
+			"$.data[*].authorization.authorization_code", "$.data[*].customer.email",
+			"$.data.details.account_number",
+		},
+		pathTemplates: []string{
+			"/transaction/initialize", "/transaction/verify/{reference}", "/transaction/charge_authorization",
+			"/transaction/totals", "/transaction/export", "/transaction/{transaction}",
+			"/customer/{customer}", "/charge/submit_pin", "/charge/submit_otp", "/charge/{reference}",
+			"/transfer/finalize_transfer", "/transfer/verify/{reference}", "/transfer/{transfer}",
+			"/transferrecipient/{recipient}", "/refund/{refund}",
+			"/subscription/{subscription}", "/plan/{plan}",
+		},
+		errorCode:    []string{"code"},
+		errorMessage: []string{"message"},
+	},
+}
+
+// WithProviderProfile applies the capture profile of a third-party provider
+// the client calls: "stripe", "twilio", "sendgrid" or "paystack". A profile
+// redacts the provider's sensitive body fields on top of the client's own
+// rules, records the provider's route templates instead of raw paths, and
+// reports error responses as errors of type "<provider>.Error" with the
+// provider's error code and message. The provider's name becomes the
+// client's outgoing tag unless WithOutgoingTag sets one. Unknown providers
+// are ignored.
+func WithProviderProfile(provider string) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		if _, ok := providerProfiles[provider]; ok {
+			rc.ProviderProfile = provider
+		}
+	}
+}
+
+// route returns the template of the profile's routes path matches, and the
+// values of its parameters. Templates with more literal segments win, so
+// "/v1/customers/search" isn't taken for a customer ID. ok is false when no
+// template matches.
+func (p providerProfile) route(path string) (template string, params map[string]string, ok bool) {
+	best := -1
+	for _, t := range p.pathTemplates {
+		if values, literals, matched := matchPathTemplate(t, path); matched && literals > best {
+			template, params, best = t, values, literals
+		}
+	}
+	return template, params, best >= 0
+}
+
+// matchPathTemplate matches path against template, returning the values of
+// the template's parameters and how many of its segments are literal.
+func matchPathTemplate(template, path string) (params map[string]string, literals int, ok bool) {
+	tSegs := strings.Split(strings.Trim(template, "/"), "/")
+	pSegs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(tSegs) != len(pSegs) {
+		return nil, 0, false
+	}
+	for i, t := range tSegs {
+		open, end := strings.IndexByte(t, '{'), strings.IndexByte(t, '}')
+		if open < 0 || end < open {
+			if t != pSegs[i] {
+				return nil, 0, false
+			}
+			literals++
+			continue
+		}
+		prefix, suffix := t[:open], t[end+1:]
+		seg := pSegs[i]
+		if len(seg) <= len(prefix)+len(suffix) || !strings.HasPrefix(seg, prefix) || !strings.HasSuffix(seg, suffix) {
+			return nil, 0, false
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[t[open+1:end]] = seg[len(prefix) : len(seg)-len(suffix)]
+	}
+	return params, literals, true
+}
+
+// providerError returns the error the profile finds in the body of an
+// error response, and ok false when the body has none.
+func (p providerProfile) providerError(provider string, respBody []byte, when time.Time) (ATError, bool) {
+	var body any
+	if json.Unmarshal(respBody, &body) != nil {
+		return ATError{}, false
+	}
+	code, message := firstJSONMember(body, p.errorCode), firstJSONMember(body, p.errorMessage)
+	if code == "" && message == "" {
+		return ATError{}, false
+	}
+	return ATError{
+		When:             when,
+		ErrorType:        provider + ".Error",
+		RootErrorType:    code,
+		Message:          message,
+		RootErrorMessage: message,
+	}, true
+}
+
+// firstJSONMember returns the value of the first of paths that resolves to
+// a scalar in the decoded JSON body, formatted as a string.
+func firstJSONMember(body any, paths []string) string {
+	for _, path := range paths {
+		node := body
+		for _, name := range strings.Split(path, ".") {
+			switch n := node.(type) {
+			case map[string]any:
+				node = n[name]
+			case []any:
+				i, err := strconv.Atoi(name)
+				if err != nil || i < 0 || i >= len(n) {
+					node = nil
+				} else {
+					node = n[i]
+				}
+			default:
+				node = nil
+			}
+		}
+		switch v := node.(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64, bool:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// applyProviderProfile applies the provider profile to an outgoing request's
+// payload and returns the span attributes it adds.
+func applyProviderProfile(provider string, res *http.Response, respBody []byte, when time.Time, payload *Payload) []attribute.KeyValue {
+	profile := providerProfiles[provider]
+	if res == nil || res.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	apiErr, ok := profile.providerError(provider, respBody, when)
+	if !ok {
+		return nil
+	}
+	payload.Errors = append(payload.Errors, apiErr)
+	return []attribute.KeyValue{
+		attribute.String("apitoolkit.provider.error_code", apiErr.RootErrorType),
+		attribute.String("apitoolkit.provider.error_message", apiErr.Message),
+	}
+}
@@ -0,0 +1,58 @@
+package monoscope
+
+import (
+	"net/url"
+	"strings"
+)
+
+// parseQueryParams parses rawQuery into a map of parameter values. Repeated
+// keys keep every value in order, and array-style keys such as "ids[]" are
+// merged under their base name, so "ids[]=1&ids[]=2&ids=3" gives
+// {"ids": ["1", "2", "3"]}. Values of parameters named in redactList
+// (case-insensitive) are replaced with "[CLIENT_REDACTED]" and counted in
+// audit.
+func parseQueryParams(rawQuery string, redactList []string, audit redactionAudit) map[string][]string {
+	params := map[string][]string{}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			continue
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			continue
+		}
+		key = strings.TrimSuffix(key, "[]")
+		if find(redactList, key) {
+			value = "[CLIENT_REDACTED]"
+			audit.fired("query", strings.ToLower(key))
+		}
+		params[key] = append(params[key], value)
+	}
+	return params
+}
+
+// redactRawURL replaces the values of query parameters named in redactList
+// in a request URI, so they don't leak through the raw URL either.
+func redactRawURL(rawURL string, redactList []string) string {
+	if len(redactList) == 0 {
+		return rawURL
+	}
+	path, rawQuery, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		rawKey, _, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err == nil && find(redactList, strings.TrimSuffix(key, "[]")) {
+			pairs[i] = rawKey + "=" + url.QueryEscape("[CLIENT_REDACTED]")
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
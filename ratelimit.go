@@ -0,0 +1,110 @@
+package monoscope
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RateLimit is the throttling state a response advertised in its standard
+// rate-limit headers: RateLimit-*, the combined RateLimit header, their
+// X-RateLimit-* predecessors and Retry-After. Fields the response didn't
+// carry are nil.
+type RateLimit struct {
+	Limit     *int64 `json:"limit,omitempty"`
+	Remaining *int64 `json:"remaining,omitempty"`
+	// ResetSeconds is how long until the quota resets. X-RateLimit-Reset
+	// values that are Unix times are converted.
+	ResetSeconds *int64 `json:"reset_seconds,omitempty"`
+	// RetryAfterSeconds is how long the client was asked to wait, with
+	// Retry-After dates converted.
+	RetryAfterSeconds *int64 `json:"retry_after_seconds,omitempty"`
+	Policy            string `json:"policy,omitempty"`
+}
+
+// unixTimeThreshold separates X-RateLimit-Reset values that are Unix times
+// from those counting seconds.
+const unixTimeThreshold = 1_000_000_000
+
+// parseRateLimit reads the rate-limit headers of a response received at now,
+// or returns nil when it has none.
+func parseRateLimit(header http.Header, now time.Time) *RateLimit {
+	var rl RateLimit
+	found := false
+	number := func(names ...string) *int64 {
+		for _, name := range names {
+			if v, err := strconv.ParseInt(strings.TrimSpace(header.Get(name)), 10, 64); err == nil {
+				found = true
+				return &v
+			}
+		}
+		return nil
+	}
+	rl.Limit = number("RateLimit-Limit", "X-RateLimit-Limit")
+	rl.Remaining = number("RateLimit-Remaining", "X-RateLimit-Remaining")
+	rl.ResetSeconds = number("RateLimit-Reset", "X-RateLimit-Reset")
+	if rl.ResetSeconds != nil && *rl.ResetSeconds > unixTimeThreshold {
+		reset := max(*rl.ResetSeconds-now.Unix(), 0)
+		rl.ResetSeconds = &reset
+	}
+	// The combined form: RateLimit: limit=100, remaining=50, reset=30.
+	for _, item := range strings.Split(header.Get("RateLimit"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		found = true
+		switch strings.ToLower(key) {
+		case "limit":
+			rl.Limit = &v
+		case "remaining":
+			rl.Remaining = &v
+		case "reset":
+			rl.ResetSeconds = &v
+		}
+	}
+	if policy := header.Get("RateLimit-Policy"); policy != "" {
+		found = true
+		rl.Policy = policy
+	}
+	if retryAfter := strings.TrimSpace(header.Get("Retry-After")); retryAfter != "" {
+		if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil {
+			rl.RetryAfterSeconds = &seconds
+			found = true
+		} else if at, err := http.ParseTime(retryAfter); err == nil {
+			seconds := max(int64(at.Sub(now)/time.Second), 0)
+			rl.RetryAfterSeconds = &seconds
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &rl
+}
+
+// rateLimitAttributes returns the attributes recording rl.
+func rateLimitAttributes(rl *RateLimit) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, field := range []struct {
+		key   string
+		value *int64
+	}{
+		{"apitoolkit.rate_limit.limit", rl.Limit},
+		{"apitoolkit.rate_limit.remaining", rl.Remaining},
+		{"apitoolkit.rate_limit.reset_seconds", rl.ResetSeconds},
+		{"apitoolkit.rate_limit.retry_after_seconds", rl.RetryAfterSeconds},
+	} {
+		if field.value != nil {
+			attrs = append(attrs, attribute.Int64(field.key, *field.value))
+		}
+	}
+	if rl.Policy != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.rate_limit.policy", rl.Policy))
+	}
+	return attrs
+}
@@ -0,0 +1,34 @@
+//go:build !monoscope_core_only
+
+package monoscope
+
+import "github.com/AsaiYusuke/jsonpath"
+
+// applyJSONPath replaces the values the JSONPath expression path matches in
+// the decoded JSON src with value, returning how many were replaced. Paths
+// that match nothing are not errors.
+func applyJSONPath(src any, path string, value any) (int, error) {
+	config := jsonpath.Config{}
+	config.SetAccessorMode()
+	output, err := jsonpath.Retrieve(path, src, config)
+	if err != nil && !isJSONPathNoMatch(err) {
+		return 0, err
+	}
+	replaced := 0
+	for _, v := range output {
+		if accessor, ok := v.(jsonpath.Accessor); ok {
+			accessor.Set(value)
+			replaced++
+		}
+	}
+	return replaced, nil
+}
+
+// isJSONPathNoMatch reports whether err only means the path matched nothing.
+func isJSONPathNoMatch(err error) bool {
+	switch err.(type) {
+	case jsonpath.ErrorMemberNotExist, jsonpath.ErrorTypeUnmatched:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,11 @@
+//go:build monoscope_core_only
+
+package monoscope
+
+// applyJSONPath replaces the values the JSONPath expression path matches in
+// the decoded JSON src with value, returning how many were replaced.
+// Core-only builds support the subset of JSONPath parseSimpleJSONPath
+// accepts; other expressions fail like invalid ones.
+func applyJSONPath(src any, path string, value any) (int, error) {
+	return applySimpleJSONPath(src, path, value)
+}
@@ -0,0 +1,80 @@
+package monoscope
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MatchRouteGlob reports whether the route template route matches pattern.
+// Patterns follow path.Match, so "*" matches within a single path segment,
+// e.g. "/api/*/orders"; a pattern ending in "/**" also matches its prefix and
+// everything below it, e.g. "/admin/**". Templates keep their placeholders,
+// so "/users/{id}" is matched by "/users/*".
+func MatchRouteGlob(pattern, route string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return route == prefix || strings.HasPrefix(route, prefix+"/")
+	}
+	matched, _ := path.Match(pattern, route)
+	return matched
+}
+
+// routeTags returns config.Tags followed by the config.RouteTags of every
+// pattern matching route, without duplicates. Patterns are applied in sorted
+// order so the tags are stable.
+func routeTags(config Config, route string) []string {
+	if len(config.RouteTags) == 0 {
+		return config.Tags
+	}
+	patterns := make([]string, 0, len(config.RouteTags))
+	for pattern := range config.RouteTags {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	tags := slices.Clone(config.Tags)
+	for _, pattern := range patterns {
+		if !MatchRouteGlob(pattern, route) {
+			continue
+		}
+		for _, tag := range config.RouteTags[pattern] {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// SPAFallbackRoute is the route requests matching Config.SPAFallbackRoutes
+// are reported under.
+const SPAFallbackRoute = "/*spa"
+
+// appShellContentTypes lists the media types a single-page app's shell and
+// assets are served as.
+var appShellContentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"text/javascript",
+	"application/javascript",
+	"application/x-javascript",
+	"text/css",
+}
+
+// spaFallback reports whether route matches one of config.SPAFallbackRoutes
+// and none of config.SPAFallbackExclude.
+func spaFallback(config Config, route string) bool {
+	matches := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool { return MatchRouteGlob(pattern, route) })
+	}
+	return matches(config.SPAFallbackRoutes) && !matches(config.SPAFallbackExclude)
+}
+
+// isAppShellResponse reports whether the response headers describe HTML,
+// JavaScript or CSS.
+func isAppShellResponse(header map[string][]string) bool {
+	mediaType, _, err := mime.ParseMediaType(http.Header(header).Get("Content-Type"))
+	return err == nil && slices.Contains(appShellContentTypes, mediaType)
+}
@@ -0,0 +1,58 @@
+package monoscope
+
+// Payload schema versions, see Config.PayloadSchemaVersion.
+const (
+	// PayloadSchemaV1 is the original payload: request and response
+	// metadata, headers, bodies, errors, tags and message IDs.
+	PayloadSchemaV1 = 1
+	// PayloadSchemaV2 adds the fields introduced since, such as redaction
+	// counts, geo, traffic class, segments and the span kind, and records
+	// the version as apitoolkit.schema_version.
+	PayloadSchemaV2 = 2
+	// CurrentPayloadSchemaVersion is the version payloads are built in.
+	CurrentPayloadSchemaVersion = PayloadSchemaV2
+)
+
+// payloadSchemaVersion returns the version config.PayloadSchemaVersion asks
+// for, CurrentPayloadSchemaVersion when it is unset or unknown.
+func payloadSchemaVersion(config Config) int {
+	if config.PayloadSchemaVersion < PayloadSchemaV1 || config.PayloadSchemaVersion > CurrentPayloadSchemaVersion {
+		return CurrentPayloadSchemaVersion
+	}
+	return config.PayloadSchemaVersion
+}
+
+// ConvertPayload returns payload in the given schema version. Converting to
+// an older version drops the fields it doesn't have; converting to a newer
+// one leaves the added fields zero, as they would be for a request that
+// didn't use them. Unknown versions are treated as
+// CurrentPayloadSchemaVersion.
+func ConvertPayload(payload Payload, version int) Payload {
+	version = payloadSchemaVersion(Config{PayloadSchemaVersion: version})
+	if version == PayloadSchemaV1 {
+		payload = Payload{
+			RequestHeaders:  payload.RequestHeaders,
+			QueryParams:     payload.QueryParams,
+			PathParams:      payload.PathParams,
+			ResponseHeaders: payload.ResponseHeaders,
+			Method:          payload.Method,
+			SdkType:         payload.SdkType,
+			Host:            payload.Host,
+			RawURL:          payload.RawURL,
+			Referer:         payload.Referer,
+			URLPath:         payload.URLPath,
+			ResponseBody:    payload.ResponseBody,
+			RequestBody:     payload.RequestBody,
+			ProtoMinor:      payload.ProtoMinor,
+			StatusCode:      payload.StatusCode,
+			ProtoMajor:      payload.ProtoMajor,
+			Errors:          payload.Errors,
+			ServiceVersion:  payload.ServiceVersion,
+			Tags:            payload.Tags,
+			MsgID:           payload.MsgID,
+			ParentID:        payload.ParentID,
+		}
+	}
+	payload.SchemaVersion = version
+	return payload
+}
@@ -1,16 +1,23 @@
 package monoscope
 
 import (
+	"context"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/AsaiYusuke/jsonpath"
 	"github.com/google/uuid"
-	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -22,15 +29,84 @@ const (
 	GoFiberSDKType   = "GoFiber"
 )
 
+// Span event names marking request lifecycle milestones, so a single span
+// shows time spent in the middleware chain versus the handler itself.
+const (
+	EventRequestBodyRead   = "monoscope.request.body_read"
+	EventHandlerStart      = "monoscope.handler.start"
+	EventResponseFirstByte = "monoscope.response.first_byte"
+	EventHandlerComplete   = "monoscope.handler.complete"
+	EventStageEnter        = "monoscope.stage.enter"
+	EventHeartbeat         = "monoscope.heartbeat"
+	// EventInformationalResponse marks a 1xx response, such as 103 Early
+	// Hints, sent ahead of the final one. Its http.response.status_code
+	// attribute holds the status.
+	EventInformationalResponse = "monoscope.response.informational"
+	// EventUploadPart and EventUploadComplete record the parts of a
+	// multipart upload and its end, see InstrumentMultipartReader.
+	EventUploadPart     = "monoscope.upload.part"
+	EventUploadComplete = "monoscope.upload.complete"
+)
+
 type ctxKey string
 
 var (
-	ErrorListCtxKey         = ctxKey("error-list")
+	// ErrorListCtxKey is the legacy key of the request's error list.
+	//
+	// Deprecated: use WithErrorList and ErrorListFrom. Values stored under
+	// it are still read until the next major release.
+	ErrorListCtxKey = ctxKey("error-list")
+	// CurrentRequestMessageID is the legacy key of the request's message ID.
+	//
+	// Deprecated: use WithMessageID and MessageIDFrom. Values stored under
+	// it are still read until the next major release.
 	CurrentRequestMessageID = ctxKey("current-req-msg-id")
 	CurrentSpan             = ctxKey("current=apitoolkit-client")
 	SpanName                = ctxKey("monoscope.http")
 )
 
+// messageIDCtxKey and errorListCtxKey hold the message ID and error list of
+// the request being reported.
+var (
+	messageIDCtxKey = ctxKey("message-id")
+	errorListCtxKey = ctxKey("errors")
+)
+
+// WithMessageID returns a copy of ctx carrying the message ID of the request
+// being reported. Middlewares call it when setting up the request context.
+func WithMessageID(ctx context.Context, msgID uuid.UUID) context.Context {
+	ctx = context.WithValue(ctx, messageIDCtxKey, msgID)
+	return context.WithValue(ctx, CurrentRequestMessageID, msgID)
+}
+
+// MessageIDFrom returns the message ID stored by WithMessageID, or under the
+// legacy CurrentRequestMessageID key.
+func MessageIDFrom(ctx context.Context) (uuid.UUID, bool) {
+	if msgID, ok := ctx.Value(messageIDCtxKey).(uuid.UUID); ok {
+		return msgID, true
+	}
+	msgID, ok := ctx.Value(CurrentRequestMessageID).(uuid.UUID)
+	return msgID, ok
+}
+
+// WithErrorList returns a copy of ctx collecting the errors ReportError
+// reports into errorList. Middlewares call it when setting up the request
+// context and export the list with the request's payload.
+func WithErrorList(ctx context.Context, errorList *[]ATError) context.Context {
+	ctx = context.WithValue(ctx, errorListCtxKey, errorList)
+	return context.WithValue(ctx, ErrorListCtxKey, errorList)
+}
+
+// ErrorListFrom returns the error list stored by WithErrorList, or under the
+// legacy ErrorListCtxKey key, or nil.
+func ErrorListFrom(ctx context.Context) *[]ATError {
+	if errorList, ok := ctx.Value(errorListCtxKey).(*[]ATError); ok {
+		return errorList
+	}
+	errorList, _ := ctx.Value(ErrorListCtxKey).(*[]ATError)
+	return errorList
+}
+
 // Payload represents request and response details
 // FIXME: How would we handle errors from background processes (Not web requests)
 type Payload struct {
@@ -54,6 +130,164 @@ type Payload struct {
 	Tags            []string            `json:"tags"`
 	MsgID           string              `json:"msg_id"`
 	ParentID        *string             `json:"parent_id"`
+	// SchemaVersion is the payload schema version, see
+	// Config.PayloadSchemaVersion.
+	SchemaVersion int `json:"schema_version,omitempty"`
+	// ResponseBodySkipped is set when the response looked like a file
+	// download or binary asset, so only its metadata was captured.
+	ResponseBodySkipped bool `json:"response_body_skipped,omitempty"`
+	// ClientDisconnected and DeadlineExceeded record that the request context
+	// ended before the handler finished, see ApplyContextStatus.
+	ClientDisconnected bool `json:"client_disconnected,omitempty"`
+	DeadlineExceeded   bool `json:"deadline_exceeded,omitempty"`
+	// CacheValidation is CacheValidationHit or CacheValidationMiss for
+	// conditional requests, and empty otherwise.
+	CacheValidation string `json:"cache_validation,omitempty"`
+	// HandlerTimedOut is set when the handler exceeded the configured
+	// HandlerTimeout and the client was answered with a 503.
+	HandlerTimedOut bool `json:"handler_timed_out,omitempty"`
+	// QueueWait is how long the request waited for a ConcurrencyLimiter
+	// slot, and Shed is set when it was rejected with a 503 instead.
+	QueueWait time.Duration `json:"queue_wait_ns,omitempty"`
+	Shed      bool          `json:"shed,omitempty"`
+	// RequestBodyIncomplete is set when the request body was longer than
+	// MaxCaptureContentLength and only its beginning was captured.
+	RequestBodyIncomplete bool `json:"request_body_incomplete,omitempty"`
+	// RequestBodyLines and ResponseBodyLines count the lines of NDJSON
+	// bodies, of which only the first Config.NDJSONMaxLines are captured.
+	RequestBodyLines  int `json:"request_body_lines,omitempty"`
+	ResponseBodyLines int `json:"response_body_lines,omitempty"`
+	// RequestBodyUndecodedSize and ResponseBodyUndecodedSize are the sizes of
+	// MessagePack, CBOR, gRPC-Web or Connect bodies that failed to decode, or
+	// of compressed response bodies that couldn't be decompressed, and so
+	// were captured by size only.
+	RequestBodyUndecodedSize  int `json:"request_body_undecoded_size,omitempty"`
+	ResponseBodyUndecodedSize int `json:"response_body_undecoded_size,omitempty"`
+	// ResponseBytesWritten is how much of the response body was delivered
+	// and ResponseWriteDuration how long writing it took, recorded whether
+	// or not the body was captured. ResponseAborted is set when writing
+	// failed, e.g. because the client went away. See ApplyWriteStatus.
+	ResponseBytesWritten  int64         `json:"response_bytes_written,omitempty"`
+	ResponseWriteDuration time.Duration `json:"response_write_duration_ns,omitempty"`
+	ResponseAborted       bool          `json:"response_aborted,omitempty"`
+	// Redactions counts how many values each redaction rule replaced, keyed
+	// by where it applied and the rule, e.g. "request_body:$.password" or
+	// "request_header:authorization". Redacted values are never recorded.
+	// Rules that matched nothing are absent.
+	Redactions map[string]int `json:"redactions,omitempty"`
+	// DataSubjects lists the data subjects the request concerns, as tagged
+	// with TagDataSubject.
+	DataSubjects []string `json:"data_subjects,omitempty"`
+	// MetadataOnly is set when the middleware's ConsentFunc returned
+	// CaptureMetadataOnly, so the bodies were deliberately not captured.
+	MetadataOnly bool `json:"metadata_only,omitempty"`
+	// Geo is the client's location as resolved by Config.GeoResolver.
+	Geo *GeoLocation `json:"geo,omitempty"`
+	// TrafficClass is whether the request came from a human, a bot or a
+	// health probe, see ClassifyTraffic.
+	TrafficClass TrafficClass `json:"traffic_class,omitempty"`
+	// RequestType is RequestTypePreflight for CORS preflight requests, and
+	// empty otherwise.
+	RequestType string `json:"request_type,omitempty"`
+	// SLOViolated records whether the request missed the Config.SLOs
+	// covering it, and is nil when none does.
+	SLOViolated *bool `json:"slo_violated,omitempty"`
+	// JWTClaims are the Config.JWTClaims read from the request's bearer
+	// token.
+	JWTClaims map[string]any `json:"jwt_claims,omitempty"`
+	// Identity is the caller as resolved by Config.IdentityFunc.
+	Identity *Identity `json:"identity,omitempty"`
+	// RateLimit is the throttling state advertised by the response's
+	// rate-limit headers, for server responses and, on the instrumented
+	// client, upstream ones.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	// CacheInfo is what the response's Cache-Control, Age and CDN cache
+	// status headers say about its caching.
+	CacheInfo *CacheInfo `json:"cache_info,omitempty"`
+	// Shadow sums up the ShadowCompare calls made while handling the
+	// request.
+	Shadow *ShadowComparison `json:"shadow,omitempty"`
+	// FeatureFlags are the flag variations active for the request, see
+	// SetFeatureFlags.
+	FeatureFlags map[string]string `json:"feature_flags,omitempty"`
+	// Experiments are the A/B experiment variants the request was assigned,
+	// see SetExperiment.
+	Experiments map[string]string `json:"experiments,omitempty"`
+	// TraceState is the request's incoming W3C tracestate, and
+	// TraceStateValues the values of its Config.TraceStateKeys members.
+	TraceState       string            `json:"tracestate,omitempty"`
+	TraceStateValues map[string]string `json:"tracestate_values,omitempty"`
+	// CacheStats counts the lookups made through WrapCache caches while
+	// handling the request, by cache name.
+	CacheStats map[string]CacheStats `json:"cache_stats,omitempty"`
+	// Segments lists the phases timed with StartSegment, in the order they
+	// ended.
+	Segments []SegmentTiming `json:"segments,omitempty"`
+	// Stages lists the middleware stages marked with EnterStage, in the
+	// order the request entered them.
+	Stages []StageTiming `json:"stages,omitempty"`
+	// Panic is set when the handler panicked, see NewPanicInfo.
+	Panic *PanicInfo `json:"panic,omitempty"`
+	// SpanKind is the kind of span the payload describes. When unset it is
+	// CLIENT for GoOutgoing payloads and SERVER otherwise.
+	SpanKind trace.SpanKind `json:"span_kind,omitempty"`
+	// Messaging describes the message of PRODUCER and CONSUMER payloads.
+	Messaging *Messaging `json:"messaging,omitempty"`
+	// Idempotency links requests that reused an idempotency key, see
+	// Config.IdempotencyKeyHeader.
+	Idempotency *Idempotency `json:"idempotency,omitempty"`
+	// HostTemplate is the host pattern of the matched route on routers that
+	// match by host, e.g. "{tenant}.example.com".
+	HostTemplate string `json:"host_template,omitempty"`
+	// ForceSampled is set for requests forced to full capture, see
+	// ApplyDebugCapture and ForceSample.
+	ForceSampled bool `json:"force_sampled,omitempty"`
+	// Transport is the network transport the request arrived over: "tcp",
+	// "unix" or "quic", and empty when it isn't known. Scheme is "https"
+	// for requests served over TLS and "http" otherwise, including h2c.
+	Transport string `json:"transport,omitempty"`
+	Scheme    string `json:"scheme,omitempty"`
+	// ResponseContentEncoding is the Content-Encoding a compression
+	// middleware sent the response with, and ResponseCompression where that
+	// middleware sits, ResponseCompressionOuter or ResponseCompressionInner.
+	// Either way the captured body is uncompressed, and the captured
+	// response headers describe it rather than what was sent.
+	ResponseContentEncoding string `json:"response_content_encoding,omitempty"`
+	ResponseCompression     string `json:"response_compression,omitempty"`
+	// DeadlineBudget records, for outgoing calls made with a context
+	// deadline, the time left when the call started and how much of it the
+	// call took.
+	DeadlineBudget *DeadlineBudget `json:"deadline_budget,omitempty"`
+	// Email describes the message of payloads recorded by SendEmail and
+	// SendMail.
+	Email *Email `json:"email,omitempty"`
+	// Signature records the verification of the request's signature, see
+	// VerifySignature.
+	Signature *SignatureVerification `json:"signature,omitempty"`
+	// Tenant is the tenant of the request as resolved by Config.TenantFunc,
+	// and TenantBudgetExceeded is set when its bodies were dropped because
+	// the tenant used up its Config.TenantBudget.
+	Tenant               string `json:"tenant,omitempty"`
+	TenantBudgetExceeded bool   `json:"tenant_budget_exceeded,omitempty"`
+}
+
+// StatusClientClosedRequest is the non-standard status recorded for requests
+// whose client went away before a response was sent.
+const StatusClientClosedRequest = 499
+
+// ApplyContextStatus marks payload when ctx, the request context, ended before
+// the handler finished: a cancelled context means the client disconnected and
+// is recorded as 499, an expired deadline is recorded as 408. Whatever status
+// the handler managed to set is not what the client received in either case.
+func ApplyContextStatus(ctx context.Context, payload *Payload) {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		payload.ClientDisconnected = true
+		payload.StatusCode = StatusClientClosedRequest
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		payload.DeadlineExceeded = true
+		payload.StatusCode = http.StatusRequestTimeout
+	}
 }
 
 type Config struct {
@@ -66,9 +300,267 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CaptureRequestHeaders and CaptureResponseHeaders, when set, limit the
+	// captured headers to the named ones (case-insensitive). Redaction still
+	// applies to the headers that are kept.
+	CaptureRequestHeaders  []string
+	CaptureResponseHeaders []string
+	// RouteTags adds tags to the requests of groups of endpoints, e.g.
+	// {"/admin/**": {"admin"}}, keyed by route template glob, see
+	// MatchRouteGlob.
+	RouteTags map[string][]string
+	// SPAFallbackRoutes lists, as route globs (see MatchRouteGlob), the
+	// catch-all routes serving a single-page app or static site, e.g. "/*"
+	// with chi, echo, fiber or a gorilla PathPrefix("/"), "/*filepath" with
+	// gin, or "/**" with the native middleware. Their requests are reported
+	// under the single route SPAFallbackRoute without path parameters, and
+	// their HTML, JavaScript and CSS responses are recorded without bodies,
+	// so app-shell traffic stays out of API analytics.
+	SPAFallbackRoutes []string
+	// SPAFallbackExclude exempts routes matching SPAFallbackRoutes, e.g.
+	// "/api/**" when the native middleware serves an API beside the app.
+	SPAFallbackExclude []string
+	// RedactQueryParams lists query parameters (case-insensitive) whose
+	// values are replaced with "[CLIENT_REDACTED]" in both the parsed query
+	// parameters and the raw URL.
+	RedactQueryParams []string
+	// TracerProvider is used to create Monoscope spans. When nil, the global
+	// provider from otel.GetTracerProvider is used.
+	TracerProvider trace.TracerProvider
+	// Propagators extracts incoming and injects outgoing trace context. When
+	// nil, the global propagator from otel.GetTextMapPropagator is used.
+	Propagators propagation.TextMapPropagator
+	// Now and NewMessageID replace time.Now and uuid.New, so tests and replay
+	// tooling can produce reproducible payloads. Both default to the real
+	// implementations when nil.
+	Now          func() time.Time
+	NewMessageID func() uuid.UUID
+	// UseUUIDv7 generates message IDs as time-ordered UUIDv7 instead of
+	// random UUIDv4, so they sort by creation time in storage. Ignored when
+	// NewMessageID is set.
+	UseUUIDv7 bool
+	// ErrorDedupWindow collapses repeated reports of the same error (same type
+	// and message) within the window into the first one, so a tight retry loop
+	// can't flood the exporter. The next report after the window carries the
+	// number of occurrences it stands for. Zero reports every error.
+	ErrorDedupWindow time.Duration
+	// SemanticConventions additionally emits the standard OpenTelemetry HTTP
+	// attributes (url.*, server.*, network.*, error.type) and
+	// error span status, so generic backends such as Tempo or Jaeger render
+	// Monoscope spans correctly.
+	SemanticConventions bool
+	// MeterProvider, when set, receives request latencies in the
+	// http.server.request.duration histogram, with trace exemplars linking
+	// slow requests to their Monoscope traces. See RecordRequestDuration.
+	MeterProvider metric.MeterProvider
+	// APIKey and Endpoint, when APIKey is set, report this Config's spans to
+	// its own Monoscope project rather than through the global provider set
+	// up by ConfigureOpenTelemetry, so one binary can host services that
+	// belong to different projects. Endpoint is an OTLP/gRPC collector address
+	// and defaults to the Monoscope collector; "http://" URLs are sent
+	// insecurely. Ignored when TracerProvider is set. Outgoing calls made
+	// with HTTPClient under the request's context go to the same project.
+	// Call Shutdown before exiting to flush these spans.
+	APIKey   string
+	Endpoint string
+	// PayloadHook, when set, is called with each payload after it is built
+	// and before it is recorded on its span, for last-mile changes such as
+	// dropping fields or adding computed ones. It may modify the payload in
+	// place or return a different one; returning nil vetoes the payload, so
+	// its request duration isn't recorded and its span is marked with
+	// DropSpan, for NewDropFilter to drop it and its children.
+	PayloadHook func(*Payload) *Payload
+	// StrictRedaction fails closed: a request or response body the redaction
+	// rules could not be applied to, because an expression is invalid or the
+	// body can't be parsed, is replaced with RedactionFailedMarker instead of
+	// being exported as it is.
+	StrictRedaction bool
+	// BodyEncryptionKey, when set, encrypts captured request and response
+	// bodies for this public key before they leave the process, so only the
+	// holder of the private key can read them; see EncryptedBody for the
+	// envelope format and DecryptBody to open it. Bodies are encrypted after
+	// redaction and PayloadHook, and dropped if encryption fails.
+	BodyEncryptionKey *rsa.PublicKey
+	// GeoResolver, when set, maps each request's client IP to its country,
+	// region and city, recorded as the geo.* attributes so traffic and errors
+	// can be sliced by geography. The IP itself is not recorded.
+	GeoResolver GeoResolver
+	// ProbesMetadataOnly captures requests classified as health probes
+	// without their bodies, as with CaptureMetadataOnly, so frequent probes
+	// stay cheap while remaining visible.
+	ProbesMetadataOnly bool
+	// PreflightMetadataOnly captures CORS preflight requests without their
+	// bodies, and DropPreflight doesn't export them at all, so they don't
+	// inflate request counts. See IsPreflight.
+	PreflightMetadataOnly bool
+	DropPreflight         bool
+	// MaxAttributeValueLength, when positive, truncates longer attribute
+	// values to that many bytes ending in AttributeTruncatedMarker, rather
+	// than leaving collectors to cut them unpredictably. Bodies are cut
+	// before encoding so they still decode, and before encryption with
+	// BodyEncryptionKey so they still decrypt, to the plaintext the limit
+	// would leave; their envelopes are longer. MaxAttributes, when positive,
+	// caps the number of attributes per span, dropping headers first.
+	// Either records what it cut in apitoolkit.truncated_attributes and
+	// apitoolkit.dropped_attributes.
+	MaxAttributeValueLength int
+	MaxAttributes           int
+	// IdempotencyKeyHeader names the request header carrying idempotency
+	// keys, e.g. "Idempotency-Key". When set, requests reusing a key on the
+	// same route within IdempotencyWindow (DefaultIdempotencyWindow when
+	// zero) are recorded as retries linked to the first attempt, so
+	// duplicate processing is easy to spot. Keys are tracked per process
+	// and exported only as a hash.
+	IdempotencyKeyHeader string
+	IdempotencyWindow    time.Duration
+	// DebugCaptureSecret, when set, lets requests carrying a
+	// DebugCaptureHeader signed with it (see SignDebugCapture) be captured
+	// with their bodies and sampled by ForcedSampler, so a single problem
+	// request can be captured in full in production.
+	DebugCaptureSecret []byte
+	// ReuseExistingSpan makes middlewares record payloads on the server span
+	// of an instrumentation already in place, such as otelhttp, otelmux or
+	// otelfiber, instead of nesting a second monoscope.http span under it,
+	// see StartServerSpan. Payloads vetoed by PayloadHook are then left off
+	// that span, which is still exported by its owner.
+	ReuseExistingSpan bool
+	// PayloadSchemaVersion pins the payload schema exported, e.g.
+	// PayloadSchemaV1 for collectors and backends that predate later
+	// fields, so upgrading the SDK doesn't change what they receive. Zero
+	// means CurrentPayloadSchemaVersion. See ConvertPayload.
+	PayloadSchemaVersion int
+	// BatchExport, when its Interval is set, exports successful requests as
+	// lightweight records batched on one span per interval instead of a span
+	// each, see BatchExport and ShouldExport.
+	BatchExport BatchExport
+	// HeartbeatInterval, when set, exports a heartbeat span with the elapsed
+	// time and bytes written so far, linked to each request still running,
+	// once per interval, see StartHeartbeat.
+	HeartbeatInterval time.Duration
+	// DetectLeaks logs request contexts and capture buffers still referenced
+	// a few GC cycles after their request ended, a sign of handlers retaining
+	// them, see LeakCheck. Debug turns it on too.
+	DetectLeaks bool
+	// SLOs are the service level objectives each payload is checked against,
+	// recorded as apitoolkit.slo_violated, see SLO.
+	SLOs []SLO
+	// NDJSONMaxLines is how many lines of newline-delimited JSON bodies are
+	// captured, DefaultNDJSONMaxLines when zero. Each line is redacted as a
+	// JSON document.
+	NDJSONMaxLines int
+	// JWTClaims names the claims of the request's Authorization bearer JWT
+	// recorded on payloads, e.g. DefaultJWTClaims, so requests can be
+	// attributed to principals. The token itself stays redacted.
+	JWTClaims []string
+	// IdentityFunc, when set, resolves the caller of each request, e.g. the
+	// customer and plan of its API key, recorded on its payload.
+	IdentityFunc func(*http.Request) Identity
+	// TenantFunc, when set, returns the tenant of each request of a
+	// multi-tenant API, e.g. the organization of its API key, recorded on
+	// its payload and charged with TenantBudget. Requests it returns "" for
+	// aren't limited.
+	TenantFunc func(*http.Request) string
+	// TenantBudget limits what each tenant's requests export per minute.
+	TenantBudget TenantBudget
+	// TraceStateKeys names the members of the incoming tracestate, e.g. a
+	// vendor's sampling hints, whose values are recorded as attributes of
+	// their own. The whole tracestate is always recorded.
+	TraceStateKeys []string
+}
+
+// NewMessageID returns a message ID for a new request payload, generated by
+// config.NewMessageID when set, or as a UUIDv7 when config.UseUUIDv7 is set.
+func NewMessageID(config Config) uuid.UUID {
+	if config.NewMessageID != nil {
+		return config.NewMessageID()
+	}
+	if config.UseUUIDv7 {
+		if id, err := uuid.NewV7(); err == nil {
+			return id
+		}
+	}
+	return uuid.New()
+}
+
+// Now returns the current time according to config.Now when set.
+func Now(config Config) time.Time {
+	if config.Now != nil {
+		return config.Now()
+	}
+	return time.Now()
+}
+
+// ParentMessageID returns the message ID of the request an enclosing
+// Monoscope middleware is already reporting for ctx, or nil. Middlewares use
+// it to record nested instrumentation, such as an HTTP middleware in front of
+// an in-process gRPC-Gateway, as a child payload rather than a duplicate.
+func ParentMessageID(ctx context.Context) *uuid.UUID {
+	if msgID, ok := MessageIDFrom(ctx); ok && msgID != uuid.Nil {
+		return &msgID
+	}
+	return nil
+}
+
+// configCtxKey holds the Config of the middleware handling a request.
+var configCtxKey = ctxKey("monoscope-config")
+
+// ContextWithConfig returns a copy of ctx carrying config, so helpers called
+// with the request context, such as ReportError, honour the middleware's
+// settings. Middlewares call it when setting up the request context.
+func ContextWithConfig(ctx context.Context, config Config) context.Context {
+	return context.WithValue(ctx, configCtxKey, &config)
+}
+
+// configFromContext returns the Config stored by ContextWithConfig, or the
+// zero Config when there is none.
+func configFromContext(ctx context.Context) Config {
+	if config, ok := ctx.Value(configCtxKey).(*Config); ok {
+		return *config
+	}
+	return Config{}
+}
+
+// Propagator returns the propagator used for trace context headers, taken from
+// config.Propagators or the global propagator when none is set.
+func Propagator(config Config) propagation.TextMapPropagator {
+	if config.Propagators != nil {
+		return config.Propagators
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// Tracer returns the tracer Monoscope spans are created with, taken from
+// config.TracerProvider, the provider of config.APIKey's project, or the
+// global provider, in that order.
+func Tracer(config Config) trace.Tracer {
+	tp := config.TracerProvider
+	if tp == nil && config.APIKey != "" {
+		tp = projectTracerProvider(config)
+	}
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(config.ServiceName)
+}
+
+// ApplyPayloadHook passes payload through config.PayloadHook, replacing it
+// with the hook's result. It returns false when the hook vetoed the payload;
+// callers must then mark its span with DropSpan before ending it.
+func ApplyPayloadHook(config Config, payload *Payload) bool {
+	if config.PayloadHook == nil {
+		return true
+	}
+	hooked := config.PayloadHook(payload)
+	if hooked == nil {
+		selfMetrics.payloadsVetoed.Add(1)
+		return false
+	}
+	*payload = *hooked
+	return true
 }
 
 func CreateSpan(payload Payload, config Config, span trace.Span) {
+	selfMetrics.payloadsExported.Add(1)
 	atErrors, _ := json.Marshal(payload.Errors)
 	queryParams, _ := json.Marshal(payload.QueryParams)
 	pathParams, _ := json.Marshal(payload.PathParams)
@@ -80,6 +572,42 @@ func CreateSpan(payload Payload, config Config, span trace.Span) {
 	if config.CaptureResponseBody {
 		responseBody = payload.ResponseBody
 	}
+	if payload.TrafficClass == TrafficProbe && config.ProbesMetadataOnly && !payload.ForceSampled {
+		payload.MetadataOnly = true
+	}
+	if payload.RequestType == RequestTypePreflight && config.PreflightMetadataOnly && !payload.ForceSampled {
+		payload.MetadataOnly = true
+	}
+	if payload.MetadataOnly {
+		requestBody, responseBody = []byte{}, []byte{}
+	}
+	var truncated []string
+	if config.BodyEncryptionKey != nil {
+		// Bodies are cut before they are encrypted, see limitAttributes.
+		var cut bool
+		if requestBody, cut = truncateEncodedBody(requestBody, config.MaxAttributeValueLength); cut {
+			truncated = append(truncated, "http.request.body")
+		}
+		if responseBody, cut = truncateEncodedBody(responseBody, config.MaxAttributeValueLength); cut {
+			truncated = append(truncated, "http.response.body")
+		}
+		requestBody = encryptCapturedBody(requestBody, config)
+		responseBody = encryptCapturedBody(responseBody, config)
+	}
+	// Shadow diff values are response body content, exported only when the
+	// response body is, and never alongside encrypted bodies.
+	if payload.Shadow != nil && (!config.CaptureResponseBody || payload.MetadataOnly || config.BodyEncryptionKey != nil) {
+		payload.Shadow = payload.Shadow.withoutValues()
+	}
+	// Converting after the bodies are settled keeps older schemas from
+	// losing the fields that decide what is captured, such as MetadataOnly.
+	payload = ConvertPayload(payload, config.PayloadSchemaVersion)
+	tapped := payload
+	tapped.RequestBody, tapped.ResponseBody = requestBody, responseBody
+	debugTap.publish(tapped)
+	if config.Debug {
+		printDebugSummary(tapped, config, span)
+	}
 	attrs := []attribute.KeyValue{
 		attribute.String("apitoolkit.service_version", config.ServiceVersion),
 		attribute.String("net.host.name", payload.Host),
@@ -95,51 +623,280 @@ func CreateSpan(payload Payload, config Config, span trace.Span) {
 		attribute.String("apitoolkit.errors", string(atErrors)),
 		attribute.StringSlice("apitoolkit.tags", payload.Tags),
 	}
-	span.SetAttributes(attrs...)
-
-	for key, value := range payload.RequestHeaders {
-		span.SetAttributes(attribute.KeyValue{Key: attribute.Key("http.request.header." + key), Value: attribute.StringSliceValue(value)})
+	if payload.SchemaVersion >= PayloadSchemaV2 {
+		attrs = append(attrs,
+			attribute.Int("apitoolkit.schema_version", payload.SchemaVersion),
+			attribute.String("apitoolkit.span_kind", spanKind(payload).String()),
+		)
 	}
-
-	for key, value := range payload.ResponseHeaders {
-		span.SetAttributes(attribute.KeyValue{Key: attribute.Key("http.response.header." + key), Value: attribute.StringSliceValue(value)})
+	if kind := spanKind(payload); payload.Messaging != nil && (kind == trace.SpanKindProducer || kind == trace.SpanKindConsumer) {
+		attrs = append(attrs, messagingAttributes(kind, payload.Messaging)...)
+	}
+	if payload.RequestBodyIncomplete {
+		attrs = append(attrs, attribute.Bool("http.request.body_incomplete", true))
+	}
+	if payload.RequestBodyLines > 0 {
+		attrs = append(attrs, attribute.Int("apitoolkit.request_body_lines", payload.RequestBodyLines))
+	}
+	if payload.ResponseBodyLines > 0 {
+		attrs = append(attrs, attribute.Int("apitoolkit.response_body_lines", payload.ResponseBodyLines))
+	}
+	if payload.RequestBodyUndecodedSize > 0 {
+		attrs = append(attrs, attribute.Int("apitoolkit.request_body_undecoded_size", payload.RequestBodyUndecodedSize))
+	}
+	if payload.ResponseBodyUndecodedSize > 0 {
+		attrs = append(attrs, attribute.Int("apitoolkit.response_body_undecoded_size", payload.ResponseBodyUndecodedSize))
+	}
+	if config.BodyEncryptionKey != nil {
+		attrs = append(attrs, attribute.String("apitoolkit.body_encryption", BodyEncryptionAlgorithm))
+	}
+	if len(payload.Segments) > 0 {
+		segments, _ := json.Marshal(payload.Segments)
+		attrs = append(attrs, attribute.String("apitoolkit.segments", string(segments)))
+	}
+	if len(payload.Stages) > 0 {
+		stages, _ := json.Marshal(payload.Stages)
+		attrs = append(attrs, attribute.String("apitoolkit.stages", string(stages)))
+	}
+	if len(payload.CacheStats) > 0 {
+		cacheStats, _ := json.Marshal(payload.CacheStats)
+		attrs = append(attrs,
+			attribute.String("apitoolkit.cache_stats", string(cacheStats)),
+			attribute.Float64("apitoolkit.cache_hit_rate", cacheHitRate(payload.CacheStats)),
+		)
+	}
+	if payload.HostTemplate != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.host_template", payload.HostTemplate))
+	}
+	if payload.TrafficClass != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.traffic_class", string(payload.TrafficClass)))
+	}
+	if payload.Transport != "" {
+		attrs = append(attrs, attribute.String("network.transport", payload.Transport))
+	}
+	if payload.Scheme != "" {
+		attrs = append(attrs, attribute.String("url.scheme", payload.Scheme))
+	}
+	if payload.RequestType != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.request_type", payload.RequestType))
+	}
+	if len(payload.FeatureFlags) > 0 {
+		flags, _ := json.Marshal(payload.FeatureFlags)
+		attrs = append(attrs, attribute.String("apitoolkit.feature_flags", string(flags)))
+	}
+	if payload.TraceState != "" {
+		attrs = append(attrs, traceStateAttributes(payload)...)
+	}
+	if len(payload.Experiments) > 0 {
+		experiments, _ := json.Marshal(payload.Experiments)
+		attrs = append(attrs, attribute.String("apitoolkit.experiments", string(experiments)))
+	}
+	if payload.Shadow != nil {
+		diffs, _ := json.Marshal(payload.Shadow.Diffs)
+		attrs = append(attrs,
+			attribute.Int("apitoolkit.shadow.compared", payload.Shadow.Compared),
+			attribute.Int("apitoolkit.shadow.mismatches", payload.Shadow.Mismatches),
+			attribute.String("apitoolkit.shadow.diffs", string(diffs)),
+		)
+	}
+	if payload.CacheInfo != nil {
+		attrs = append(attrs, cacheInfoAttributes(payload.CacheInfo)...)
+	}
+	if payload.RateLimit != nil {
+		attrs = append(attrs, rateLimitAttributes(payload.RateLimit)...)
+	}
+	if payload.Identity != nil {
+		attrs = append(attrs, identityAttributes(payload.Identity)...)
+	}
+	if len(payload.JWTClaims) > 0 {
+		claims, _ := json.Marshal(payload.JWTClaims)
+		attrs = append(attrs, attribute.String("apitoolkit.jwt_claims", string(claims)))
+	}
+	if payload.SLOViolated != nil {
+		attrs = append(attrs, attribute.Bool("apitoolkit.slo_violated", *payload.SLOViolated))
+	}
+	if payload.Geo != nil {
+		attrs = append(attrs, geoAttributes(payload.Geo)...)
+	}
+	if payload.Idempotency != nil {
+		attrs = append(attrs,
+			attribute.String("apitoolkit.idempotency_key", payload.Idempotency.Key),
+			attribute.Int("apitoolkit.idempotency_attempt", payload.Idempotency.Attempt),
+		)
+		if payload.Idempotency.FirstMsgID != "" {
+			attrs = append(attrs, attribute.String("apitoolkit.idempotency_first_msg_id", payload.Idempotency.FirstMsgID))
+		}
+	}
+	if payload.MetadataOnly {
+		attrs = append(attrs, attribute.Bool("apitoolkit.metadata_only", true))
+	}
+	if payload.ForceSampled {
+		attrs = append(attrs, attribute.Bool("apitoolkit.force_sampled", true))
+	}
+	if len(payload.DataSubjects) > 0 {
+		attrs = append(attrs, attribute.StringSlice("apitoolkit.data_subjects", payload.DataSubjects))
+	}
+	if len(payload.Redactions) > 0 {
+		redactions, _ := json.Marshal(payload.Redactions)
+		attrs = append(attrs, attribute.String("apitoolkit.redactions", string(redactions)))
+	}
+	if payload.ResponseBodySkipped {
+		attrs = append(attrs, attribute.Bool("http.response.body_skipped", true))
+	}
+	if payload.ResponseCompression != "" {
+		attrs = append(attrs,
+			attribute.String("apitoolkit.response_content_encoding", payload.ResponseContentEncoding),
+			attribute.String("apitoolkit.response_compression", payload.ResponseCompression),
+		)
+	}
+	if payload.ClientDisconnected {
+		attrs = append(attrs, attribute.Bool("apitoolkit.client_disconnected", true))
+	}
+	if payload.DeadlineExceeded {
+		attrs = append(attrs, attribute.Bool("apitoolkit.deadline_exceeded", true))
+	}
+	if payload.QueueWait > 0 {
+		attrs = append(attrs, attribute.Float64("apitoolkit.queue_wait_ms", float64(payload.QueueWait)/float64(time.Millisecond)))
+	}
+	if payload.Shed {
+		attrs = append(attrs, attribute.Bool("apitoolkit.shed", true))
+	}
+	if payload.HandlerTimedOut {
+		attrs = append(attrs, attribute.Bool("apitoolkit.handler_timed_out", true))
+	}
+	if payload.ResponseBytesWritten > 0 {
+		attrs = append(attrs,
+			attribute.Int64("apitoolkit.response_bytes_written", payload.ResponseBytesWritten),
+			attribute.Float64("apitoolkit.response_write_ms", float64(payload.ResponseWriteDuration)/float64(time.Millisecond)),
+		)
+	}
+	if payload.ResponseAborted {
+		attrs = append(attrs, attribute.Bool("apitoolkit.response_aborted", true))
+	}
+	if budget := payload.DeadlineBudget; budget != nil {
+		attrs = append(attrs,
+			attribute.Float64("apitoolkit.deadline_remaining_ms", float64(budget.Remaining)/float64(time.Millisecond)),
+			attribute.Float64("apitoolkit.deadline_budget_used", budget.Used),
+			attribute.Bool("apitoolkit.deadline_budget_exceeded", budget.Exceeded),
+		)
+	}
+	if payload.Email != nil {
+		attrs = append(attrs, emailAttributes(payload.Email)...)
+	}
+	if payload.Tenant != "" {
+		attrs = append(attrs, attribute.String("apitoolkit.tenant", payload.Tenant))
+	}
+	if payload.TenantBudgetExceeded {
+		attrs = append(attrs, attribute.Bool("apitoolkit.tenant_budget_exceeded", true))
+	}
+	if signature := payload.Signature; signature != nil {
+		attrs = append(attrs,
+			attribute.String("apitoolkit.signature.scheme", signature.Scheme),
+			attribute.Bool("apitoolkit.signature.valid", signature.Valid),
+			attribute.Int("apitoolkit.signature.attempts", signature.Attempts),
+			attribute.Float64("apitoolkit.signature.duration_ms", float64(signature.Duration)/float64(time.Millisecond)),
+		)
+		if signature.Error != "" {
+			attrs = append(attrs, attribute.String("apitoolkit.signature.error", signature.Error))
+		}
+	}
+	if payload.CacheValidation != "" {
+		attrs = append(attrs, attribute.String("http.cache_validation", payload.CacheValidation))
+	}
+	if payload.Panic != nil {
+		panicInfo, _ := json.Marshal(payload.Panic)
+		attrs = append(attrs, attribute.String("apitoolkit.panic", string(panicInfo)))
+	}
+	if config.SemanticConventions {
+		attrs = append(attrs, semanticConventionAttributes(payload)...)
+		setSemanticConventionStatus(payload, span)
 	}
 	if payload.MsgID != "" {
-		span.SetAttributes(attribute.String("apitoolkit.msg_id", payload.MsgID))
-
+		attrs = append(attrs, attribute.String("apitoolkit.msg_id", payload.MsgID))
 	}
+	if payload.ParentID != nil {
+		attrs = append(attrs, attribute.String("apitoolkit.parent_msg_id", *payload.ParentID))
+	}
+	attrs = append(attrs, headerAttributes("http.request.header.", payload.RequestHeaders)...)
+	attrs = append(attrs, headerAttributes("http.response.header.", payload.ResponseHeaders)...)
+	attrs = limitAttributes(attrs, config, truncated)
+	span.SetAttributes(append(attrs, costAttributes(attrs)...)...)
+}
 
+// headerAttributes returns one attribute per header, sorted by name so that
+// MaxAttributes drops the same headers every time.
+func headerAttributes(prefix string, headers map[string][]string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(headers))
+	for key, value := range headers {
+		attrs = append(attrs, attribute.StringSlice(prefix+key, value))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
 }
 
 func RedactJSON(data []byte, redactList []string) []byte {
-	config := jsonpath.Config{}
-	config.SetAccessorMode()
+	redacted, _ := redactJSON(data, redactList, nil, "")
+	return redacted
+}
+
+// RedactionFailedMarker replaces bodies that redaction rules could not be
+// applied to when Config.StrictRedaction is set.
+const RedactionFailedMarker = "[CLIENT_REDACTION_FAILED]"
 
+// redactJSON is RedactJSON, additionally returning the first error that kept
+// a redaction rule from being applied: an invalid JSONPath expression, or a
+// body that isn't JSON while there are rules to apply. Paths that match
+// nothing in the body are not errors. Values replaced are counted in audit
+// under target.
+func redactJSON(data []byte, redactList []string, audit redactionAudit, target string) ([]byte, error) {
 	var src interface{}
-	json.Unmarshal(data, &src)
+	var redactErr error
+	if err := json.Unmarshal(data, &src); err != nil && len(data) > 0 && len(redactList) > 0 {
+		redactErr = err
+	}
 
 	for _, key := range redactList {
-		output, _ := jsonpath.Retrieve(key, src, config)
-		for _, v := range output {
-			accessor, ok := v.(jsonpath.Accessor)
-			if ok {
-				accessor.Set("[CLIENT_REDACTED]")
-			}
+		replaced, err := applyJSONPath(src, key, "[CLIENT_REDACTED]")
+		if err != nil && redactErr == nil {
+			redactErr = err
+		}
+		for range replaced {
+			audit.fired(target, key)
 		}
 	}
 	dataJSON, _ := json.Marshal(src)
-	return dataJSON
+	return dataJSON, redactErr
 }
 
 func RedactHeaders(headers map[string][]string, redactList []string) map[string][]string {
+	return redactHeaders(headers, redactList, nil, "")
+}
+
+// redactHeaders is RedactHeaders, counting the headers replaced in audit
+// under target.
+func redactHeaders(headers map[string][]string, redactList []string, audit redactionAudit, target string) map[string][]string {
 	for k := range headers {
 		if find(redactList, k) {
 			headers[k] = []string{"[CLIENT_REDACTED]"}
+			audit.fired(target, strings.ToLower(k))
 		}
 	}
 	return headers
 }
 
+// filterHeaders returns a copy of headers containing only the names in
+// allowlist (case-insensitive), or every header when allowlist is empty.
+// Copying also keeps redaction from mutating the live request/response headers.
+func filterHeaders(headers map[string][]string, allowlist []string) map[string][]string {
+	filtered := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		if len(allowlist) == 0 || find(allowlist, k) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
 func find(haystack []string, needle string) bool {
 	for _, hay := range haystack {
 		if strings.EqualFold(hay, needle) {
@@ -149,6 +906,10 @@ func find(haystack []string, needle string) bool {
 	return false
 }
 
+// BuildPayload builds the payload of req from positional arguments. It is
+// what the framework middlewares call; custom integrations should prefer
+// BuildPayloadFromInput. Calls to OAuth token endpoints always have their
+// credentials redacted, whatever the redaction lists and config say.
 func BuildPayload(SDKType string, req *http.Request,
 	statusCode int, reqBody []byte, respBody []byte, respHeader map[string][]string,
 	pathParams map[string]string, urlPath string,
@@ -166,51 +927,93 @@ func BuildPayload(SDKType string, req *http.Request,
 		}
 		return Payload{}
 	}
-
-	redactedHeaders := []string{"password", "Authorization", "Cookies"}
-	for _, v := range redactHeadersList {
-		redactedHeaders = append(redactedHeaders, strings.ToLower(v))
+	localAddr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	raw := RawRequest{
+		Host:        req.Host,
+		Method:      req.Method,
+		RequestURI:  req.URL.RequestURI(),
+		Path:        req.URL.Path,
+		QueryString: req.URL.RawQuery,
+		UserAgent:   req.UserAgent(),
+		Referer:     req.Referer(),
+		RemoteAddr:  req.RemoteAddr,
+		Header:      req.Header,
+		ProtoMajor:  req.ProtoMajor,
+		ProtoMinor:  req.ProtoMinor,
+		LocalAddr:   localAddr,
+		TLS:         req.TLS != nil,
+		Context:     req.Context(),
 	}
-
-	var parentIDVal *string
-	if parentID != nil {
-		parentIDStr := (*parentID).String()
-		parentIDVal = &parentIDStr
+	if SDKType != GoOutgoing {
+		raw.Identity = resolveIdentity(config, req)
+		raw.Tenant = resolveTenant(config, req)
 	}
+	return buildPayload(SDKType, raw,
+		statusCode, reqBody, respBody, respHeader,
+		pathParams, urlPath,
+		redactHeadersList, redactRequestBodyList, redactResponseBodyList,
+		errorList, msgID, parentID, config)
+}
 
-	var serviceVersion *string
-	if config.ServiceVersion != "" {
-		serviceVersion = &config.ServiceVersion
-	}
-	msgIDStr := ""
-	if msgID != uuid.Nil {
-		msgIDStr = msgID.String()
+// RawRequest describes a request served by a server that does not use
+// net/http, such as fasthttp, in the terms BuildRawPayload needs. Header
+// holds a single value per header name.
+type RawRequest struct {
+	Host        string
+	Method      string
+	RequestURI  string
+	Path        string
+	QueryString string
+	UserAgent   string
+	Referer     string
+	RemoteAddr  string
+	Header      map[string][]string
+	// ProtoMajor and ProtoMinor are the HTTP version, 1.1 when unset.
+	ProtoMajor int
+	ProtoMinor int
+	// LocalAddr is the address of the connection the request arrived on,
+	// and TLS is set when it was served over TLS.
+	LocalAddr net.Addr
+	TLS       bool
+	// Context is the context the request was handled with, which supplies
+	// its annotations and cancellation status, see ApplyAnnotations and
+	// ApplyContextStatus.
+	Context context.Context
+	// Identity and Tenant are who made the request and for which tenant,
+	// for servers resolving them with functions of their own request type
+	// rather than Config.IdentityFunc and Config.TenantFunc.
+	Identity *Identity
+	Tenant   string
+}
+
+// BuildRawPayload is BuildPayload for servers that do not use net/http. The
+// fiber integration builds its payloads with it, so the core module does not
+// depend on fasthttp.
+func BuildRawPayload(SDKType string, req RawRequest,
+	statusCode int, reqBody []byte, respBody []byte, respHeader map[string][]string,
+	pathParams map[string]string, urlPath string,
+	redactHeadersList,
+	redactRequestBodyList, redactResponseBodyList []string,
+	errorList []ATError,
+	msgID uuid.UUID,
+	parentID *uuid.UUID,
+	config Config,
+) Payload {
+	if req.Header == nil {
+		req.Header = map[string][]string{}
 	}
-	return Payload{
-		Host:            req.Host,
-		Method:          req.Method,
-		PathParams:      pathParams,
-		ProtoMajor:      req.ProtoMajor,
-		ProtoMinor:      req.ProtoMinor,
-		QueryParams:     req.URL.Query(),
-		RawURL:          req.URL.RequestURI(),
-		Referer:         req.Referer(),
-		RequestBody:     RedactJSON(reqBody, redactRequestBodyList),
-		RequestHeaders:  RedactHeaders(req.Header, redactedHeaders),
-		ResponseBody:    RedactJSON(respBody, redactResponseBodyList),
-		ResponseHeaders: RedactHeaders(respHeader, redactedHeaders),
-		SdkType:         SDKType,
-		StatusCode:      statusCode,
-		URLPath:         urlPath,
-		Errors:          errorList,
-		ServiceVersion:  serviceVersion,
-		Tags:            config.Tags,
-		MsgID:           msgIDStr,
-		ParentID:        parentIDVal,
+	if req.ProtoMajor == 0 {
+		req.ProtoMajor, req.ProtoMinor = 1, 1
 	}
+	return buildPayload(SDKType, req,
+		statusCode, reqBody, respBody, respHeader,
+		pathParams, urlPath,
+		redactHeadersList, redactRequestBodyList, redactResponseBodyList,
+		errorList, msgID, parentID, config)
 }
 
-func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
+// buildPayload builds the payloads of BuildPayload and BuildRawPayload.
+func buildPayload(SDKType string, req RawRequest,
 	statusCode int, reqBody []byte, respBody []byte, respHeader map[string][]string,
 	pathParams map[string]string, urlPath string,
 	redactHeadersList,
@@ -218,27 +1021,14 @@ func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
 	errorList []ATError,
 	msgID uuid.UUID,
 	parentID *uuid.UUID,
-	referer string,
 	config Config,
 ) Payload {
-	if req == nil || req.URI() == nil {
-		// Early return with empty payload to prevent any nil pointer panics
-		if config.Debug {
-			log.Println("APIToolkit: nil request or client or url while building payload.")
-		}
-		return Payload{}
+	selfMetrics.payloadsBuilt.Add(1)
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	queryParams := map[string][]string{}
-	req.QueryArgs().VisitAll(func(key, value []byte) {
-		queryParams[string(key)] = []string{string(value)}
-	})
-
-	reqHeaders := map[string][]string{}
-	req.Request.Header.VisitAll(func(key, value []byte) {
-		reqHeaders[string(key)] = []string{string(value)}
-	})
-
 	redactedHeaders := []string{"password", "Authorization", "Cookies"}
 	for _, v := range redactHeadersList {
 		redactedHeaders = append(redactedHeaders, strings.ToLower(v))
@@ -254,27 +1044,85 @@ func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
 	if config.ServiceVersion != "" {
 		serviceVersion = &config.ServiceVersion
 	}
-
-	return Payload{
-		Host:            string(req.Host()),
-		Method:          string(req.Method()),
+	msgIDStr := ""
+	if msgID != uuid.Nil {
+		msgIDStr = msgID.String()
+	}
+	if isOAuthTokenRequest(req.Path, reqBody) {
+		config, redactRequestBodyList, redactResponseBodyList = withOAuthTokenRedaction(config, redactRequestBodyList, redactResponseBodyList)
+	}
+	hasBody := ResponseHasBody(req.Method, statusCode)
+	spa := spaFallback(config, urlPath)
+	if spa {
+		urlPath, pathParams = SPAFallbackRoute, nil
+	}
+	responseBodySkipped := hasBody && (IsFileResponse(respHeader) || spa && isAppShellResponse(respHeader))
+	audit := redactionAudit{}
+	reqBody, reqUndecoded := decodeBinaryBody(reqBody, req.Header)
+	reqBody, reqLines := limitNDJSON(reqBody, req.Header, config.NDJSONMaxLines)
+	var responseBody []byte
+	var respLines, respUndecoded int
+	var respEncoding, respCompression string
+	if hasBody && !responseBodySkipped {
+		respBody, respHeader, respEncoding, respCompression, respUndecoded = decodeContentEncoding(respBody, respHeader)
+		if respUndecoded == 0 {
+			respBody, respUndecoded = decodeBinaryBody(respBody, respHeader)
+		}
+		respBody, respLines = limitNDJSON(respBody, respHeader, config.NDJSONMaxLines)
+		responseBody = redactBody(respBody, respHeader, redactResponseBodyList, config.StrictRedaction, audit, "response_body")
+	}
+	payload := Payload{
+		Host:            req.Host,
+		Method:          req.Method,
 		PathParams:      pathParams,
-		ProtoMajor:      1, // req.ProtoMajor,
-		ProtoMinor:      1, // req.ProtoMinor,
-		QueryParams:     queryParams,
-		RawURL:          string(req.RequestURI()),
-		Referer:         referer,
-		RequestBody:     RedactJSON(reqBody, redactRequestBodyList),
-		RequestHeaders:  RedactHeaders(reqHeaders, redactedHeaders),
-		ResponseBody:    RedactJSON(respBody, redactResponseBodyList),
-		ResponseHeaders: RedactHeaders(respHeader, redactedHeaders),
+		ProtoMajor:      req.ProtoMajor,
+		ProtoMinor:      req.ProtoMinor,
+		QueryParams:     parseQueryParams(req.QueryString, config.RedactQueryParams, audit),
+		RawURL:          redactRawURL(req.RequestURI, config.RedactQueryParams),
+		Referer:         req.Referer,
+		RequestBody:     redactBody(reqBody, req.Header, redactRequestBodyList, config.StrictRedaction, audit, "request_body"),
+		RequestHeaders:  redactHeaders(filterHeaders(req.Header, config.CaptureRequestHeaders), redactedHeaders, audit, "request_header"),
+		ResponseBody:    responseBody,
+		ResponseHeaders: redactHeaders(filterHeaders(respHeader, config.CaptureResponseHeaders), redactedHeaders, audit, "response_header"),
 		SdkType:         SDKType,
 		StatusCode:      statusCode,
 		URLPath:         urlPath,
 		Errors:          errorList,
 		ServiceVersion:  serviceVersion,
-		Tags:            config.Tags,
-		MsgID:           msgID.String(),
+		Tags:            routeTags(config, urlPath),
+		MsgID:           msgIDStr,
 		ParentID:        parentIDVal,
+
+		ResponseBodySkipped: responseBodySkipped,
+		RequestBodyLines:    reqLines,
+		ResponseBodyLines:   respLines,
+
+		RequestBodyUndecodedSize:  reqUndecoded,
+		ResponseBodyUndecodedSize: respUndecoded,
+		ResponseContentEncoding:   respEncoding,
+		ResponseCompression:       respCompression,
+		CacheValidation:           cacheValidation(req.Header, statusCode),
+		RateLimit:                 parseRateLimit(respHeader, Now(config)),
+		CacheInfo:                 parseCacheInfo(respHeader),
+	}
+	if len(audit) > 0 {
+		payload.Redactions = audit
+	}
+	// A cancelled context on an outgoing request means the caller gave up,
+	// not that a client disconnected from us.
+	if SDKType != GoOutgoing {
+		ApplyContextStatus(ctx, &payload)
+		payload.Geo = resolveGeo(config, req.RemoteAddr, req.Header)
+		payload.Transport = networkTransport(req.ProtoMajor, req.LocalAddr)
+		payload.Scheme = urlScheme(req.TLS)
+		payload.TrafficClass = ClassifyTraffic(req.Method, req.Path, req.UserAgent)
+		payload.RequestType = requestType(req.Method, req.Header)
+		payload.JWTClaims = jwtClaims(req.Header, config.JWTClaims)
+		payload.Identity = req.Identity
+		payload.Tenant = req.Tenant
+		payload.TraceState, payload.TraceStateValues = parseTraceState(req.Header, config.TraceStateKeys)
+		payload.Idempotency = trackIdempotency(config, req.Header, req.Method, urlPath, msgIDStr)
 	}
+	ApplyAnnotations(ctx, &payload)
+	return payload
 }
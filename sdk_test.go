@@ -0,0 +1,2342 @@
+package monoscope
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/ugorji/go/codec"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestBuildPayloadHeaderAllowlist(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-Internal-Route", "pod-7")
+	respHeader := http.Header{"Content-Type": {"application/json"}, "X-Backend": {"b1"}}
+
+	config := Config{
+		CaptureRequestHeaders:  []string{"x-request-id", "authorization"},
+		CaptureResponseHeaders: []string{"Content-Type"},
+	}
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, respHeader, nil, "/users",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+
+	if _, ok := payload.RequestHeaders["X-Internal-Route"]; ok {
+		t.Error("Expected X-Internal-Route to be excluded by the allowlist")
+	}
+	if got := payload.RequestHeaders["X-Request-Id"]; len(got) != 1 || got[0] != "abc" {
+		t.Errorf("Expected X-Request-Id to be captured, got %v", got)
+	}
+	if got := payload.RequestHeaders["Authorization"]; len(got) != 1 || got[0] != "[CLIENT_REDACTED]" {
+		t.Errorf("Expected allowlisted Authorization to still be redacted, got %v", got)
+	}
+	if _, ok := payload.ResponseHeaders["X-Backend"]; ok {
+		t.Error("Expected X-Backend to be excluded by the allowlist")
+	}
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		t.Error("Expected redaction not to mutate the request headers")
+	}
+}
+
+func TestBuildPayloadQueryParams(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/search?ids[]=1&ids[]=2&ids=3&q=go+lang&filter[status]=open&Token=abc", nil)
+	config := Config{RedactQueryParams: []string{"token"}}
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/search",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+
+	expected := map[string][]string{
+		"ids":            {"1", "2", "3"},
+		"q":              {"go lang"},
+		"filter[status]": {"open"},
+		"Token":          {"[CLIENT_REDACTED]"},
+	}
+	if !reflect.DeepEqual(payload.QueryParams, expected) {
+		t.Errorf("Expected query params %v, got %v", expected, payload.QueryParams)
+	}
+	if strings.Contains(payload.RawURL, "abc") {
+		t.Errorf("Expected the token to be redacted from the raw URL, got %s", payload.RawURL)
+	}
+}
+
+func TestBuildPayloadRedactionAudit(t *testing.T) {
+	body := `{"password":"a","cards":[{"cvv":"1"},{"cvv":"2"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/login?token=abc", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer abc")
+	config := Config{RedactQueryParams: []string{"token"}}
+	payload := BuildPayload(GoDefaultSDKType, req, 200, []byte(body), nil, nil, nil, "/login",
+		nil, []string{"$.password", "$.cards[*].cvv", "$.ssn"}, nil, nil, uuid.New(), nil, config)
+
+	expected := map[string]int{
+		"request_body:$.password":      1,
+		"request_body:$.cards[*].cvv":  2,
+		"request_header:authorization": 1,
+		"query:token":                  1,
+	}
+	if !reflect.DeepEqual(payload.Redactions, expected) {
+		t.Errorf("Expected redactions %v, got %v", expected, payload.Redactions)
+	}
+}
+
+func TestStartLinkedSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	msgID := uuid.New()
+	ctx, reqSpan := tp.Tracer("test").Start(context.Background(), "monoscope.http")
+	ctx = WithMessageID(ctx, msgID)
+
+	_, jobSpan := StartLinkedSpan(ctx, "send-email")
+	jobSpan.End()
+	reqSpan.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	job := spans[0]
+	if job.Parent.IsValid() {
+		t.Error("Expected linked span to be a new root")
+	}
+	if job.SpanContext.TraceID() == reqSpan.SpanContext().TraceID() {
+		t.Error("Expected linked span to start a new trace")
+	}
+	if len(job.Links) != 1 || job.Links[0].SpanContext.SpanID() != reqSpan.SpanContext().SpanID() {
+		t.Errorf("Expected a link to the request span, got %v", job.Links)
+	}
+}
+
+func TestDetachContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	msgID := uuid.New()
+	errorList := []ATError{}
+	reqCtx, cancel := context.WithCancel(context.Background())
+	reqCtx, reqSpan := tp.Tracer("test").Start(reqCtx, "monoscope.http")
+	reqCtx = WithMessageID(reqCtx, msgID)
+	reqCtx = WithErrorList(reqCtx, &errorList)
+
+	detached := DetachContext(reqCtx)
+	cancel()
+	reqSpan.End()
+
+	if detached.Err() != nil {
+		t.Error("Expected detached context not to be cancelled with the request")
+	}
+	if got, _ := MessageIDFrom(detached); got != msgID {
+		t.Errorf("Expected message ID %s to be kept, got %s", msgID, got)
+	}
+
+	ReportError(detached, errors.New("background failure"))
+	if len(errorList) != 0 {
+		t.Errorf("Expected the finished request's error list to be untouched, got %d errors", len(errorList))
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected request span and error span, got %d spans", len(spans))
+	}
+	errSpan := spans[1]
+	if errSpan.Name != "monoscope.error" {
+		t.Errorf("Expected monoscope.error span, got %s", errSpan.Name)
+	}
+	if len(errSpan.Links) != 1 || errSpan.Links[0].SpanContext.SpanID() != reqSpan.SpanContext().SpanID() {
+		t.Errorf("Expected error span to link to the request span, got %v", errSpan.Links)
+	}
+}
+
+func TestReportErrorFallback(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	SetFallbackConfig(Config{ServiceName: "worker", ServiceVersion: "1.2.3", TracerProvider: tp})
+	defer fallback.Store(nil)
+
+	ReportError(context.Background(), errors.New("startup failure"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one error span, got %d spans", len(spans))
+	}
+	errSpan := spans[0]
+	if errSpan.Name != "monoscope.error" {
+		t.Errorf("Expected monoscope.error span, got %s", errSpan.Name)
+	}
+	attrs := map[string]string{}
+	for _, attr := range errSpan.Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["apitoolkit.service_version"] != "1.2.3" || attrs["service.name"] != "worker" {
+		t.Errorf("Expected service metadata on the error span, got %v", attrs)
+	}
+	if !strings.Contains(attrs["apitoolkit.errors"], "startup failure") {
+		t.Errorf("Expected the error in apitoolkit.errors, got %q", attrs["apitoolkit.errors"])
+	}
+}
+
+func TestReportErrorDedupWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := Config{ErrorDedupWindow: time.Second, Now: func() time.Time { return now }}
+	errorList := []ATError{}
+	ctx := WithErrorList(context.Background(), &errorList)
+	ctx = ContextWithConfig(ctx, config)
+
+	err := errors.New("dedup: connection refused")
+	for i := 0; i < 5; i++ {
+		ReportError(ctx, err)
+	}
+	ReportError(ctx, errors.New("dedup: other failure"))
+	if len(errorList) != 2 {
+		t.Fatalf("Expected repeats within the window to be suppressed, got %d errors", len(errorList))
+	}
+	if errorList[0].Occurrences != 0 {
+		t.Errorf("Expected first occurrence without a count, got %d", errorList[0].Occurrences)
+	}
+
+	now = now.Add(time.Second)
+	ReportError(ctx, err)
+	if len(errorList) != 3 {
+		t.Fatalf("Expected the error to be reported again after the window, got %d errors", len(errorList))
+	}
+	if errorList[2].Occurrences != 5 {
+		t.Errorf("Expected the report after the window to stand for 5 occurrences, got %d", errorList[2].Occurrences)
+	}
+}
+
+func TestErrorDedupBound(t *testing.T) {
+	deduper := newErrorDeduper()
+	now := time.Now()
+	for i := range maxDedupEntries + 10 {
+		deduper.admit(&ATError{ErrorType: "*errors.errorString", Message: fmt.Sprint("order ", i, " not found"), When: now}, time.Hour)
+	}
+	if len(deduper.seen) != maxDedupEntries || deduper.order.Len() != maxDedupEntries {
+		t.Errorf("Expected fingerprints to be capped at %d, got %d", maxDedupEntries, len(deduper.seen))
+	}
+	if !deduper.admit(&ATError{ErrorType: "*errors.errorString", Message: "order 0 not found", When: now}, time.Hour) {
+		t.Error("Expected the oldest fingerprint to have been evicted")
+	}
+	last := fmt.Sprint("order ", maxDedupEntries+9, " not found")
+	if deduper.admit(&ATError{ErrorType: "*errors.errorString", Message: last, When: now}, time.Hour) {
+		t.Error("Expected the newest fingerprint to still be suppressed")
+	}
+}
+
+func TestWithClientDefaults(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Session", "s3cr3t")
+	}))
+	defer server.Close()
+
+	ctx := WithClientDefaults(context.Background(), WithTracerProvider(tp), WithRedactHeaders("X-Session", "X-Api-Key"))
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("X-Api-Key", "key")
+	if _, err := HTTPClient(ctx).Do(req); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("X-Api-Key", "key")
+	if _, err := HTTPClient(ctx, WithRedactHeaders()).Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans from the default tracer provider, got %d", len(spans))
+	}
+	spanAttrs := func(span tracetest.SpanStub) map[string]string {
+		attrs := map[string]string{}
+		for _, attr := range span.Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		return attrs
+	}
+	defaulted, overridden := spanAttrs(spans[0]), spanAttrs(spans[1])
+	if defaulted["http.request.header.X-Api-Key"] != `["[CLIENT_REDACTED]"]` || defaulted["http.response.header.X-Session"] != `["[CLIENT_REDACTED]"]` {
+		t.Errorf("Expected default redaction to apply, got %v", defaulted)
+	}
+	if overridden["http.request.header.X-Api-Key"] != `["key"]` {
+		t.Errorf("Expected call-site options to override the defaults, got %v", overridden)
+	}
+}
+
+func TestOutgoingAttributes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ctx := WithClientDefaults(context.Background(), WithAttributes(map[string]any{"team": "billing", "retries": 2}))
+	client := HTTPClient(ctx,
+		WithTracerProvider(tp),
+		WithOutgoingTag("payments-api"),
+		WithAttributes(map[string]any{"retries": 3, "region": []string{"eu"}}),
+	)
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	expected := map[string]string{
+		"team":                    "billing",
+		"retries":                 "3",
+		"region":                  `["eu"]`,
+		"apitoolkit.outgoing_tag": "payments-api",
+		"peer.service":            "payments-api",
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, attrs[key])
+		}
+	}
+}
+
+func TestCaptureOnError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		_, _ = w.Write(append([]byte(`{"echo":`), append(body, '}')...))
+	}))
+	defer server.Close()
+	client := HTTPClient(context.Background(), WithTracerProvider(tp), WithCaptureOnError())
+
+	for _, tc := range []struct {
+		name, path string
+		// body is wrapped so the request can't recreate it with GetBody.
+		wrapped bool
+		forced  bool
+		capture bool
+	}{
+		{"success", "/ok", false, false, false},
+		{"failure", "/fail", false, false, true},
+		{"failure without GetBody", "/fail", true, false, true},
+		{"force-sampled success", "/ok", false, true, true},
+	} {
+		exporter.Reset()
+		var body io.Reader = strings.NewReader(`{"n":1}`)
+		if tc.wrapped {
+			body = struct{ io.Reader }{body}
+		}
+		ctx := ContextWithAnnotations(context.Background())
+		if tc.forced {
+			ForceSample(ctx)
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+tc.path, body)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(got) != `{"echo":{"n":1}}` {
+			t.Errorf("%s: expected the response body to reach the caller, got %q", tc.name, got)
+		}
+
+		attrs := map[attribute.Key]string{}
+		for _, kv := range exporter.GetSpans()[0].Attributes {
+			attrs[kv.Key] = kv.Value.AsString()
+		}
+		reqBody, respBody := attrs["http.request.body"], attrs["http.response.body"]
+		reqCaptured, respCaptured := reqBody != "", respBody != ""
+		if reqCaptured != tc.capture || respCaptured != tc.capture {
+			t.Errorf("%s: expected bodies captured %v, got request %v and response %v", tc.name, tc.capture, reqCaptured, respCaptured)
+		}
+		if !tc.capture {
+			continue
+		}
+		if b, _ := base64.StdEncoding.DecodeString(reqBody); string(b) != `{"n":1}` {
+			t.Errorf("%s: expected the request body to be captured, got %q", tc.name, b)
+		}
+		if b, _ := base64.StdEncoding.DecodeString(respBody); string(b) != `{"echo":{"n":1}}` {
+			t.Errorf("%s: expected the response body to be captured, got %q", tc.name, b)
+		}
+	}
+}
+
+func TestDeadlineBudget(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name         string
+		timeout      time.Duration
+		opts         []RoundTripperOption
+		wantExceeded bool
+	}{
+		{"no deadline", 0, nil, false},
+		{"within the default budget", time.Second, nil, false},
+		{"over a tight budget", time.Second, []RoundTripperOption{WithDeadlineBudget(0.01)}, true},
+	} {
+		exporter.Reset()
+		ctx := context.Background()
+		if tc.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+			defer cancel()
+		}
+		client := HTTPClient(ctx, append(tc.opts, WithTracerProvider(tp))...)
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range exporter.GetSpans()[0].Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		remaining, ok := attrs["apitoolkit.deadline_remaining_ms"]
+		if ok != (tc.timeout > 0) {
+			t.Errorf("%s: expected the remaining deadline recorded %v, got %v", tc.name, tc.timeout > 0, ok)
+		}
+		if !ok {
+			continue
+		}
+		if ms := remaining.AsFloat64(); ms <= 900 || ms > 1000 {
+			t.Errorf("%s: expected about 1s remaining at the start of the call, got %.1fms", tc.name, ms)
+		}
+		if used := attrs["apitoolkit.deadline_budget_used"].AsFloat64(); used < 0.03 || used >= 0.5 {
+			t.Errorf("%s: expected the 30ms call to use about 3%% of the budget, got %.3f", tc.name, used)
+		}
+		if got := attrs["apitoolkit.deadline_budget_exceeded"].AsBool(); got != tc.wantExceeded {
+			t.Errorf("%s: expected exceeded %v, got %v", tc.name, tc.wantExceeded, got)
+		}
+	}
+
+	start := time.Now()
+	if budget := newDeadlineBudget(start.Add(-time.Second), start, start, 0); budget.Remaining != 0 || budget.Used != 1 || !budget.Exceeded {
+		t.Errorf("Expected a call started past its deadline to have used all of it, got %+v", budget)
+	}
+}
+
+func TestConnectionPoolMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	for range 3 {
+		// Clients built per call share their metrics and, here, a transport.
+		client := HTTPClient(context.Background(), WithHTTPClient(server.Client()), WithMeterProvider(mp), WithOutgoingTag("inventory"))
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	collect := func() map[string]metricdata.Aggregation {
+		var rm metricdata.ResourceMetrics
+		if err := reader.Collect(context.Background(), &rm); err != nil {
+			t.Fatal(err)
+		}
+		metrics := map[string]metricdata.Aggregation{}
+		for _, scope := range rm.ScopeMetrics {
+			for _, m := range scope.Metrics {
+				metrics[m.Name] = m.Data
+			}
+		}
+		return metrics
+	}
+	metrics := collect()
+
+	connections := map[string]int64{}
+	for _, point := range metrics[ClientConnectionsMetric].(metricdata.Sum[int64]).DataPoints {
+		state, _ := point.Attributes.Value("monoscope.connection.state")
+		if client, _ := point.Attributes.Value("peer.service"); client.AsString() != "inventory" {
+			t.Errorf("Expected the client's outgoing tag as peer.service, got %q", client.AsString())
+		}
+		connections[state.AsString()] = point.Value
+	}
+	if want := map[string]int64{"new": 1, "idle": 2}; !reflect.DeepEqual(connections, want) {
+		t.Errorf("Expected connections %v, got %v", want, connections)
+	}
+	queue := metrics[ClientTimeInQueueMetric].(metricdata.Histogram[float64])
+	if len(queue.DataPoints) != 1 || queue.DataPoints[0].Count != 3 {
+		t.Errorf("Expected 3 time in queue measurements, got %+v", queue.DataPoints)
+	}
+	reuse := metrics[ClientConnectionReuseMetric].(metricdata.Gauge[float64])
+	if len(reuse.DataPoints) != 1 || reuse.DataPoints[0].Value < 0.66 || reuse.DataPoints[0].Value > 0.67 {
+		t.Errorf("Expected a reuse ratio of 2/3, got %+v", reuse.DataPoints)
+	}
+
+	// The ratio covers the requests since the previous collection.
+	if reuse, ok := collect()[ClientConnectionReuseMetric].(metricdata.Gauge[float64]); ok && len(reuse.DataPoints) > 0 {
+		t.Errorf("Expected no reuse ratio without requests since the last collection, got %+v", reuse.DataPoints)
+	}
+}
+
+func TestRedactXML(t *testing.T) {
+	body := []byte(`<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><m:Login xmlns:m="urn:auth"><m:User token="abc">ada</m:User><m:Password>hunter2<b>x</b></m:Password></m:Login></soap:Body></soap:Envelope>`)
+	got := string(RedactXML(body, []string{"$.password", "token"}))
+	want := `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><m:Login xmlns:m="urn:auth"><m:User token="[CLIENT_REDACTED]">ada</m:User><m:Password>[CLIENT_REDACTED]</m:Password></m:Login></soap:Body></soap:Envelope>`
+	if got != want {
+		t.Errorf("Unexpected redaction:\n got %s\nwant %s", got, want)
+	}
+	if got := RedactXML([]byte("<a><b>"), []string{"b"}); got != nil {
+		t.Errorf("Expected malformed XML to be dropped, got %s", got)
+	}
+}
+
+func TestSOAPProfile(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>Invalid account</faultstring></s:Fault></s:Body></s:Envelope>`))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`<Envelope><Body><GetBalance><Account>42</Account></GetBalance></Body></Envelope>`))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("SOAPAction", `"urn:bank/GetBalance"`)
+	client := HTTPClient(context.Background(), WithTracerProvider(tp), WithSOAPProfile(), WithRedactRequestBody("$.account"))
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	attrs := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["rpc.system"] != "soap" || attrs["rpc.method"] != "urn:bank/GetBalance" {
+		t.Errorf("Expected SOAPAction as the operation name, got %q %q", attrs["rpc.system"], attrs["rpc.method"])
+	}
+	if attrs["apitoolkit.soap.fault_code"] != "s:Client" || attrs["apitoolkit.soap.fault_string"] != "Invalid account" {
+		t.Errorf("Expected fault fields, got %q %q", attrs["apitoolkit.soap.fault_code"], attrs["apitoolkit.soap.fault_string"])
+	}
+	if !strings.Contains(attrs["apitoolkit.errors"], SOAPFaultErrorType) {
+		t.Errorf("Expected the fault in the error list, got %s", attrs["apitoolkit.errors"])
+	}
+	reqBody, _ := base64.StdEncoding.DecodeString(attrs["http.request.body"])
+	if !strings.Contains(string(reqBody), "<Account>[CLIENT_REDACTED]</Account>") {
+		t.Errorf("Expected the XML request body to be redacted, got %s", reqBody)
+	}
+}
+
+func TestProviderProfile(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/confirm") {
+			w.WriteHeader(http.StatusPaymentRequired)
+			_, _ = w.Write([]byte(`{"error":{"code":"card_declined","message":"Your card was declined.","type":"card_error"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"pi_123","client_secret":"pi_123_secret_abc","amount":500}`))
+	}))
+	defer server.Close()
+
+	client := HTTPClient(context.Background(), WithTracerProvider(tp), WithProviderProfile("stripe"))
+	for _, path := range []string{"/v1/payment_intents/pi_123", "/v1/payment_intents/pi_123/confirm", "/v1/payment_intents/search"} {
+		resp, err := client.Post(server.URL+path, "application/x-www-form-urlencoded", strings.NewReader("amount=500"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	attrs := make([]map[string]string, len(spans))
+	for i, span := range spans {
+		attrs[i] = map[string]string{}
+		for _, kv := range span.Attributes {
+			attrs[i][string(kv.Key)] = kv.Value.Emit()
+		}
+	}
+	for i, want := range []string{"/v1/payment_intents/{intent}", "/v1/payment_intents/{intent}/confirm", "/v1/payment_intents/search"} {
+		if got := attrs[i]["http.route"]; got != want {
+			t.Errorf("Expected the route template %s, got %s", want, got)
+		}
+		if got := attrs[i]["peer.service"]; got != "stripe" {
+			t.Errorf("Expected the provider as the outgoing tag, got %q", got)
+		}
+	}
+	if got := attrs[0]["http.request.path_params"]; got != `{"intent":"pi_123"}` {
+		t.Errorf("Expected the intent ID as a path parameter, got %s", got)
+	}
+	respBody, _ := base64.StdEncoding.DecodeString(attrs[0]["http.response.body"])
+	if strings.Contains(string(respBody), "secret_abc") || !strings.Contains(string(respBody), `"amount":500`) {
+		t.Errorf("Expected the client secret to be redacted, got %s", respBody)
+	}
+	if attrs[1]["apitoolkit.provider.error_code"] != "card_declined" || attrs[1]["apitoolkit.provider.error_message"] != "Your card was declined." {
+		t.Errorf("Expected Stripe's error code and message, got %q %q", attrs[1]["apitoolkit.provider.error_code"], attrs[1]["apitoolkit.provider.error_message"])
+	}
+	if !strings.Contains(attrs[1]["apitoolkit.errors"], "stripe.Error") {
+		t.Errorf("Expected the provider error in the error list, got %s", attrs[1]["apitoolkit.errors"])
+	}
+
+	params, _, ok := matchPathTemplate("/2010-04-01/Accounts/{account}/Messages/{message}.json", "/2010-04-01/Accounts/AC1/Messages/SM2.json")
+	if !ok || params["account"] != "AC1" || params["message"] != "SM2" {
+		t.Errorf("Expected parameters within segments to match, got %v %v", params, ok)
+	}
+}
+
+func TestOAuthTokenRedaction(t *testing.T) {
+	tokenResponse := []byte(`{"access_token":"at-123","refresh_token":"rt-456","token_type":"Bearer","expires_in":3600}`)
+	jsonHeader := map[string][]string{"Content-Type": {"application/json"}}
+
+	for _, tc := range []struct {
+		name, path, contentType, body string
+		wantRequest                   string
+	}{
+		{"json grant", "/login", "application/json", `{"grant_type":"refresh_token","refresh_token":"rt-456","client_id":"app"}`,
+			`{"client_id":"app","grant_type":"refresh_token","refresh_token":"[CLIENT_REDACTED]"}`},
+		{"form grant", "/auth", "application/x-www-form-urlencoded", "grant_type=client_credentials&client_secret=s3cr3t", RedactionFailedMarker},
+		{"token path", "/oauth2/v1/token", "application/x-www-form-urlencoded", "client_secret=s3cr3t", RedactionFailedMarker},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path+"?client_secret=s3cr3t", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		payload := BuildPayload(GoDefaultSDKType, req, 200, []byte(tc.body), tokenResponse, jsonHeader, nil, tc.path,
+			nil, nil, nil, nil, uuid.Nil, nil, Config{})
+		if string(payload.RequestBody) != tc.wantRequest {
+			t.Errorf("%s: expected request body %s, got %s", tc.name, tc.wantRequest, payload.RequestBody)
+		}
+		if string(payload.ResponseBody) != `{"access_token":"[CLIENT_REDACTED]","expires_in":3600,"refresh_token":"[CLIENT_REDACTED]","token_type":"Bearer"}` {
+			t.Errorf("%s: expected the tokens to be redacted, got %s", tc.name, payload.ResponseBody)
+		}
+		if strings.Contains(payload.RawURL, "s3cr3t") {
+			t.Errorf("%s: expected the client secret query parameter to be redacted, got %s", tc.name, payload.RawURL)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"password":"hunter2"}`))
+	payload := BuildPayload(GoDefaultSDKType, req, 200, []byte(`{"password":"hunter2"}`), tokenResponse, jsonHeader, nil, "/users",
+		nil, nil, nil, nil, uuid.Nil, nil, Config{})
+	if !strings.Contains(string(payload.ResponseBody), "at-123") {
+		t.Errorf("Expected other endpoints to be left to the configured rules, got %s", payload.ResponseBody)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(tokenResponse)
+	}))
+	defer server.Close()
+	client := HTTPClient(context.Background(), WithTracerProvider(tp))
+	resp, err := client.PostForm(server.URL+"/oauth/token", url.Values{"grant_type": {"authorization_code"}, "code": {"c0de"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, tokenResponse) {
+		t.Errorf("Expected the response to reach the caller untouched, got %s", body)
+	}
+	_ = resp.Body.Close()
+	for _, kv := range exporter.GetSpans()[0].Attributes {
+		if value, _ := base64.StdEncoding.DecodeString(kv.Value.Emit()); bytes.Contains(value, []byte("at-123")) || bytes.Contains(value, []byte("c0de")) {
+			t.Errorf("Expected the outgoing token call's credentials to be redacted, got %s=%s", kv.Key, value)
+		}
+	}
+}
+
+func TestBuildPayloadContextStatus(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -1)
+	defer cancelExpired()
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		sdkType        string
+		expectedStatus int
+		disconnected   bool
+		deadline       bool
+	}{
+		{"active request", context.Background(), GoDefaultSDKType, 200, false, false},
+		{"client disconnected", cancelled, GoDefaultSDKType, StatusClientClosedRequest, true, false},
+		{"deadline exceeded", expired, GoDefaultSDKType, http.StatusRequestTimeout, false, true},
+		{"cancelled outgoing call", cancelled, GoOutgoing, 200, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tt.ctx)
+			payload := BuildPayload(tt.sdkType, req, 200, nil, nil, nil, nil, "/",
+				nil, nil, nil, nil, uuid.Nil, nil, Config{})
+			if payload.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, payload.StatusCode)
+			}
+			if payload.ClientDisconnected != tt.disconnected || payload.DeadlineExceeded != tt.deadline {
+				t.Errorf("Unexpected flags: client_disconnected=%v deadline_exceeded=%v",
+					payload.ClientDisconnected, payload.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestNewMessageID(t *testing.T) {
+	if v := NewMessageID(Config{}).Version(); v != 4 {
+		t.Errorf("Expected UUIDv4 by default, got v%d", v)
+	}
+	if v := NewMessageID(Config{UseUUIDv7: true}).Version(); v != 7 {
+		t.Errorf("Expected UUIDv7 when enabled, got v%d", v)
+	}
+	fixed := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	if id := NewMessageID(Config{UseUUIDv7: true, NewMessageID: func() uuid.UUID { return fixed }}); id != fixed {
+		t.Errorf("Expected NewMessageID hook to take precedence, got %s", id)
+	}
+}
+
+func TestTracerPerProject(t *testing.T) {
+	t.Cleanup(func() { _ = Shutdown(context.Background()) })
+
+	billing := Config{ServiceName: "billing", APIKey: "key-billing", Endpoint: "http://localhost:4317"}
+	search := Config{ServiceName: "search", APIKey: "key-search", Endpoint: "http://localhost:4317"}
+
+	billingTP := projectTracerProvider(billing)
+	if billingTP == nil {
+		t.Fatal("Expected a tracer provider for the billing project")
+	}
+	if projectTracerProvider(billing) != billingTP {
+		t.Error("Expected configs with the same API key to share a tracer provider")
+	}
+	if projectTracerProvider(search) == billingTP {
+		t.Error("Expected configs with different API keys to use separate tracer providers")
+	}
+
+	tp := sdktrace.NewTracerProvider()
+	billing.TracerProvider = tp
+	if got := Tracer(billing); got != tp.Tracer("billing") {
+		t.Error("Expected Config.TracerProvider to take precedence over APIKey")
+	}
+
+	// Outgoing calls made while handling a request report to the project of
+	// the request's middleware.
+	exporter := tracetest.NewInMemoryExporter()
+	projectsMu.Lock()
+	projects[projectKey{"key-search", "http://localhost:4317", "search", ""}] = sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String(projectKeyAttribute, "key-search"))),
+	)
+	projectsMu.Unlock()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	req, _ := http.NewRequestWithContext(ContextWithConfig(context.Background(), search), http.MethodGet, server.URL, nil)
+	resp, err := HTTPClient(context.Background()).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected the outgoing span in the search project, got %d spans", len(spans))
+	}
+	if key, _ := spans[0].Resource.Set().Value(projectKeyAttribute); key.AsString() != "key-search" {
+		t.Errorf("Expected the outgoing span to carry the search project's key, got %q", key.AsString())
+	}
+}
+
+func TestStrictRedaction(t *testing.T) {
+	jsonHeader := map[string][]string{"Content-Type": {"application/json"}}
+	xmlHeader := map[string][]string{"Content-Type": {"application/xml"}}
+	tests := []struct {
+		name       string
+		body       string
+		header     map[string][]string
+		redactList []string
+		want       string
+	}{
+		{name: "valid rules", body: `{"password":"x"}`, header: jsonHeader, redactList: []string{"$.password"}, want: `{"password":"[CLIENT_REDACTED]"}`},
+		{name: "path without match", body: `{"name":"x"}`, header: jsonHeader, redactList: []string{"$.password"}, want: `{"name":"x"}`},
+		{name: "invalid expression", body: `{"password":"x"}`, header: jsonHeader, redactList: []string{"$.[password"}, want: RedactionFailedMarker},
+		{name: "unparsable JSON", body: `password=x`, header: jsonHeader, redactList: []string{"$.password"}, want: RedactionFailedMarker},
+		{name: "unclosed XML", body: `<a><password>x</password>`, header: xmlHeader, redactList: []string{"password"}, want: RedactionFailedMarker},
+		{name: "empty body", body: ``, header: jsonHeader, redactList: []string{"$.password"}, want: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactBody([]byte(tt.body), tt.header, tt.redactList, true, nil, "")); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBodyEncryption(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	config := Config{
+		CaptureRequestBody:  true,
+		CaptureResponseBody: true,
+		RedactRequestBody:   []string{"$.password"},
+		BodyEncryptionKey:   &privateKey.PublicKey,
+	}
+	body := `{"password":"hunter2","user":"jane"}`
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	payload := BuildPayload(GoDefaultSDKType, req, 204, []byte(body), nil, nil, nil, "/login",
+		nil, config.RedactRequestBody, nil, nil, uuid.New(), nil, config)
+	_, span := tp.Tracer("test").Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+	span.End()
+
+	attrs := map[string]string{}
+	for _, attr := range exporter.GetSpans()[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.AsString()
+	}
+	if attrs["apitoolkit.body_encryption"] != BodyEncryptionAlgorithm {
+		t.Errorf("Expected the encryption algorithm to be recorded, got %q", attrs["apitoolkit.body_encryption"])
+	}
+	if attrs["http.response.body"] != "" {
+		t.Errorf("Expected the empty response body to stay empty, got %q", attrs["http.response.body"])
+	}
+	envelope, _ := base64.StdEncoding.DecodeString(attrs["http.request.body"])
+	if strings.Contains(string(envelope), "jane") {
+		t.Fatalf("Expected the request body to be encrypted, got %s", envelope)
+	}
+	plaintext, err := DecryptBody(envelope, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt the request body: %v", err)
+	}
+	if want := `{"password":"[CLIENT_REDACTED]","user":"jane"}`; string(plaintext) != want {
+		t.Errorf("Expected decrypted body %s, got %s", want, plaintext)
+	}
+
+	// A body over MaxAttributeValueLength is cut before it is encrypted, so
+	// the envelope still opens.
+	config.MaxAttributeValueLength = 40
+	exporter.Reset()
+	_, span = tp.Tracer("test").Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+	span.End()
+	var truncated []string
+	for _, attr := range exporter.GetSpans()[0].Attributes {
+		switch attr.Key {
+		case "http.request.body":
+			envelope, _ = base64.StdEncoding.DecodeString(attr.Value.AsString())
+		case "apitoolkit.truncated_attributes":
+			truncated = attr.Value.AsStringSlice()
+		}
+	}
+	plaintext, err = DecryptBody(envelope, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt the truncated request body: %v", err)
+	}
+	if want := `{"password":"[CL` + AttributeTruncatedMarker; string(plaintext) != want {
+		t.Errorf("Expected decrypted body %s, got %s", want, plaintext)
+	}
+	if !slices.Equal(truncated, []string{"http.request.body"}) {
+		t.Errorf("Expected the request body to be recorded as truncated, got %v", truncated)
+	}
+}
+
+type fakeGeoResolver map[netip.Addr]GeoLocation
+
+func (r fakeGeoResolver) Resolve(ip netip.Addr) (GeoLocation, error) {
+	return r[ip], nil
+}
+
+func TestBuildPayloadGeo(t *testing.T) {
+	config := Config{GeoResolver: fakeGeoResolver{
+		netip.MustParseAddr("203.0.113.7"):  {Country: "DE", Region: "DE-BY", City: "Munich"},
+		netip.MustParseAddr("198.51.100.2"): {Country: "US"},
+	}}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		want       *GeoLocation
+	}{
+		{name: "remote address", remoteAddr: "203.0.113.7:5555", want: &GeoLocation{Country: "DE", Region: "DE-BY", City: "Munich"}},
+		{name: "forwarded", remoteAddr: "10.0.0.1:5555", header: map[string]string{"X-Forwarded-For": "198.51.100.2, 10.0.0.1"}, want: &GeoLocation{Country: "US"}},
+		{name: "real IP", remoteAddr: "10.0.0.1:5555", header: map[string]string{"X-Real-IP": "198.51.100.2"}, want: &GeoLocation{Country: "US"}},
+		{name: "unknown address", remoteAddr: "10.0.0.1:5555"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/",
+				nil, nil, nil, nil, uuid.New(), nil, config)
+			if !reflect.DeepEqual(payload.Geo, tt.want) {
+				t.Errorf("Expected location %+v, got %+v", tt.want, payload.Geo)
+			}
+		})
+	}
+}
+
+func TestClassifyTraffic(t *testing.T) {
+	tests := []struct {
+		method, path, userAgent string
+		want                    TrafficClass
+	}{
+		{http.MethodGet, "/healthz", "kube-probe/1.29", TrafficProbe},
+		{http.MethodGet, "/", "ELB-HealthChecker/2.0", TrafficProbe},
+		{http.MethodGet, "/readyz/", "curl/8.0", TrafficProbe},
+		{http.MethodPost, "/healthz", "curl/8.0", TrafficHuman},
+		{http.MethodGet, "/products", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", TrafficBot},
+		{http.MethodGet, "/products", "Mozilla/5.0 (X11; Linux x86_64) HeadlessChrome/120.0", TrafficBot},
+		{http.MethodGet, "/products", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15", TrafficHuman},
+	}
+	for _, tt := range tests {
+		if got := ClassifyTraffic(tt.method, tt.path, tt.userAgent); got != tt.want {
+			t.Errorf("ClassifyTraffic(%q, %q, %q) = %q, want %q", tt.method, tt.path, tt.userAgent, got, tt.want)
+		}
+	}
+}
+
+func TestDebugTap(t *testing.T) {
+	shutdown, err := StartDebugTap("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+	server := httptest.NewServer(DebugTapHandler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	reader := bufio.NewReader(res.Body)
+	if line, _ := reader.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("Expected the connected comment, got %q", line)
+	}
+
+	config := Config{CaptureRequestBody: true, RedactRequestBody: []string{"$.password"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	payload := BuildPayload(GoDefaultSDKType, req, 200, []byte(`{"password":"hunter2"}`), nil, nil, nil, "/login",
+		nil, config.RedactRequestBody, nil, nil, uuid.New(), nil, config)
+	_, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		var tapped Payload
+		if err := json.Unmarshal([]byte(data), &tapped); err != nil {
+			t.Fatal(err)
+		}
+		if tapped.URLPath != "/login" || string(tapped.RequestBody) != `{"password":"[CLIENT_REDACTED]"}` {
+			t.Errorf("Expected the redacted /login payload, got %s %s", tapped.URLPath, tapped.RequestBody)
+		}
+		return
+	}
+}
+
+func TestDebugSummary(t *testing.T) {
+	var out bytes.Buffer
+	debugOutput = &out
+	defer func() { debugOutput = os.Stdout }()
+
+	config := Config{Debug: true, CaptureRequestBody: true, RedactRequestBody: []string{"$.password"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	payload := BuildPayload(GoDefaultSDKType, req, 201, []byte(`{"password":"hunter2"}`), nil, nil, nil, "/login",
+		nil, config.RedactRequestBody, nil, nil, uuid.New(), nil, config)
+	_, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+
+	line := out.String()
+	for _, want := range []string{"POST /login 201", "req=32B resp=0B", "redacted=request_body:$.password×1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected summary to contain %q, got %q", want, line)
+		}
+	}
+	if strings.Count(line, "\n") != 1 {
+		t.Errorf("Expected a single line, got %q", line)
+	}
+}
+
+func TestCreateSpanKinds(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	config := Config{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))}
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+
+	tests := []struct {
+		name      string
+		kind      trace.SpanKind
+		sdkType   string
+		messaging *Messaging
+		wantName  string
+		wantAttrs map[string]string
+	}{
+		{name: "server", sdkType: GoDefaultSDKType, wantName: SpanNameHTTP,
+			wantAttrs: map[string]string{"apitoolkit.span_kind": "server"}},
+		{name: "outgoing", sdkType: GoOutgoing, wantName: SpanNameHTTP,
+			wantAttrs: map[string]string{"apitoolkit.span_kind": "client"}},
+		{name: "producer", kind: trace.SpanKindProducer, sdkType: GoDefaultSDKType, wantName: SpanNameMessaging,
+			messaging: &Messaging{System: "kafka", Destination: "orders", MessageID: "m-1"},
+			wantAttrs: map[string]string{
+				"apitoolkit.span_kind":       "producer",
+				"messaging.system":           "kafka",
+				"messaging.destination.name": "orders",
+				"messaging.operation.type":   "send",
+				"messaging.operation.name":   "send",
+				"messaging.message.id":       "m-1",
+			}},
+		{name: "consumer", kind: trace.SpanKindConsumer, sdkType: GoDefaultSDKType, wantName: SpanNameMessaging,
+			messaging: &Messaging{System: "rabbitmq", Destination: "orders", Operation: "ack"},
+			wantAttrs: map[string]string{
+				"apitoolkit.span_kind":     "consumer",
+				"messaging.operation.type": "process",
+				"messaging.operation.name": "ack",
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			kind := tt.kind
+			if kind == trace.SpanKindUnspecified {
+				kind = trace.SpanKindServer
+			}
+			payload := BuildPayload(tt.sdkType, req, 200, nil, nil, nil, nil, "/orders",
+				nil, nil, nil, nil, uuid.New(), nil, config)
+			payload.SpanKind = tt.kind
+			payload.Messaging = tt.messaging
+			_, span := StartSpan(context.Background(), config, kind)
+			CreateSpan(payload, config, span)
+			span.End()
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Expected 1 span, got %d", len(spans))
+			}
+			if spans[0].Name != tt.wantName {
+				t.Errorf("Expected span name %s, got %s", tt.wantName, spans[0].Name)
+			}
+			attrs := map[string]string{}
+			for _, kv := range spans[0].Attributes {
+				attrs[string(kv.Key)] = kv.Value.Emit()
+			}
+			for key, want := range tt.wantAttrs {
+				if attrs[key] != want {
+					t.Errorf("Expected %s=%q, got %q", key, want, attrs[key])
+				}
+			}
+		})
+	}
+}
+
+func TestAttributeLimits(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	config := Config{
+		TracerProvider:          sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		CaptureRequestBody:      true,
+		MaxAttributeValueLength: 40,
+		MaxAttributes:           20,
+	}
+	req := httptest.NewRequest(http.MethodPost, "/upload?q="+strings.Repeat("é", 30), nil)
+	for i := range 10 {
+		req.Header.Set(fmt.Sprintf("X-Header-%d", i), "v")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	body := []byte(`{"data":"` + strings.Repeat("a", 100) + `"}`)
+	payload := BuildPayload(GoDefaultSDKType, req, 200, body, nil, nil, nil, "/upload",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+	_, span := Tracer(config).Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+	span.End()
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range exporter.GetSpans()[0].Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	target := attrs["http.target"].AsString()
+	if len(target) > 40 || !strings.HasSuffix(target, AttributeTruncatedMarker) || !utf8.ValidString(target) {
+		t.Errorf("Expected http.target truncated to 40 valid bytes, got %q", target)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(attrs["http.request.body"].AsString())
+	if err != nil || !strings.HasSuffix(string(decoded), AttributeTruncatedMarker) {
+		t.Errorf("Expected a decodable truncated body, got %q (%v)", decoded, err)
+	}
+	if got := attrs["apitoolkit.truncated_attributes"].AsStringSlice(); !slices.Contains(got, "http.target") || !slices.Contains(got, "http.request.body") {
+		t.Errorf("Expected truncated attributes to list http.target and http.request.body, got %v", got)
+	}
+	if attrs["apitoolkit.dropped_attributes"].AsInt64() == 0 {
+		t.Error("Expected dropped attributes to be recorded")
+	}
+	if _, ok := attrs["apitoolkit.msg_id"]; !ok {
+		t.Error("Expected msg_id to be kept over headers")
+	}
+	if _, ok := attrs["http.request.header.X-Header-9"]; ok {
+		t.Error("Expected the last header to be dropped")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	before := selfMetrics.payloadsExported.Load()
+
+	config := Config{PayloadHook: func(*Payload) *Payload { return nil }}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+	if ApplyPayloadHook(config, &payload) {
+		t.Fatal("Expected the hook to veto the payload")
+	}
+	_, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, Config{}, span)
+
+	out := scrape()
+	for _, want := range []string{
+		"# TYPE monoscope_payloads_built_total counter\n",
+		fmt.Sprintf("monoscope_payloads_exported_total %d\n", before+1),
+		`monoscope_payloads_dropped_total{reason="payload_hook"} `,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	// Each family is described once, ahead of its samples.
+	help, types, samples := map[string]int{}, map[string]int{}, map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			help[fields[2]]++
+		case strings.HasPrefix(line, "# TYPE "):
+			types[fields[2]]++
+		case len(fields) == 2:
+			name, _, _ := strings.Cut(fields[0], "{")
+			if types[name] == 0 {
+				t.Errorf("Expected %s to be described before its samples", name)
+			}
+			samples[name]++
+		default:
+			t.Errorf("Expected a sample or comment line, got %q", line)
+		}
+	}
+	for name, n := range types {
+		if n != 1 || help[name] != 1 {
+			t.Errorf("Expected one HELP and TYPE line for %s, got %d and %d", name, help[name], n)
+		}
+	}
+	for name, want := range map[string]int{"monoscope_payloads_dropped_total": 2, "monoscope_bodies_dropped_total": 2, "monoscope_payloads_built_total": 1} {
+		if got := samples[name]; got != want {
+			t.Errorf("Expected %d samples of %s, got %d", want, name, got)
+		}
+	}
+}
+
+func TestBuildPayloadFromInput(t *testing.T) {
+	config := Config{
+		CaptureRequestBody:  true,
+		CaptureResponseBody: true,
+		RedactRequestBody:   []string{"$.password"},
+		RedactHeaders:       []string{"X-Api-Key"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/users/1", nil)
+	req.Header.Set("X-Api-Key", "secret")
+	reqBody := []byte(`{"password":"hunter2"}`)
+	respHeader := http.Header{"Content-Type": {"application/json"}}
+	msgID := uuid.New()
+
+	got := BuildPayloadFromInput(PayloadInput{
+		Request:        req,
+		RequestBody:    reqBody,
+		ResponseBody:   []byte(`{"id":1}`),
+		StatusCode:     http.StatusCreated,
+		ResponseHeader: respHeader,
+		Route:          "/users/{id}",
+		PathParams:     map[string]string{"id": "1"},
+		MsgID:          msgID,
+		Config:         config,
+	})
+	want := BuildPayload(GoDefaultSDKType, req, http.StatusCreated, reqBody, []byte(`{"id":1}`), respHeader,
+		map[string]string{"id": "1"}, "/users/{id}",
+		config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
+		nil, msgID, nil, config)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected BuildPayloadFromInput to match BuildPayload:\n got %+v\nwant %+v", got, want)
+	}
+
+	defaults := BuildPayloadFromInput(PayloadInput{Request: req, StatusCode: http.StatusOK})
+	if defaults.SdkType != GoDefaultSDKType || defaults.MsgID == uuid.Nil.String() {
+		t.Errorf("Expected a default SDK type and message ID, got %q and %q", defaults.SdkType, defaults.MsgID)
+	}
+}
+
+func TestBuildRawPayload(t *testing.T) {
+	config := Config{
+		RedactRequestBody: []string{"$.password"},
+		TenantFunc: func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		},
+	}
+	ctx := ContextWithAnnotations(context.Background())
+	TagDataSubject(ctx, "user-1")
+	req := httptest.NewRequest(http.MethodPost, "/login?next=%2Fhome", nil).WithContext(ctx)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("User-Agent", "curl/8.0")
+	reqBody := []byte(`{"password":"hunter2"}`)
+
+	// A server that isn't net/http gets the same payload for the same
+	// request, annotations and tenant included.
+	raw := RawRequest{
+		Host:        req.Host,
+		Method:      req.Method,
+		RequestURI:  req.RequestURI,
+		Path:        req.URL.Path,
+		QueryString: req.URL.RawQuery,
+		UserAgent:   req.UserAgent(),
+		RemoteAddr:  req.RemoteAddr,
+		Header:      req.Header,
+		Context:     ctx,
+		Tenant:      "acme",
+	}
+	got := BuildRawPayload(GoFiberSDKType, raw, http.StatusOK, reqBody, nil, nil, nil, "/login",
+		nil, config.RedactRequestBody, nil, nil, uuid.Nil, nil, config)
+	want := BuildPayload(GoFiberSDKType, req, http.StatusOK, reqBody, nil, nil, nil, "/login",
+		nil, config.RedactRequestBody, nil, nil, uuid.Nil, nil, config)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected BuildRawPayload to match BuildPayload:\n got %+v\nwant %+v", got, want)
+	}
+	if got.Tenant != "acme" || !slices.Equal(got.DataSubjects, []string{"user-1"}) || got.MsgID != "" {
+		t.Errorf("Expected the tenant and annotations without a message ID, got %q %v %q", got.Tenant, got.DataSubjects, got.MsgID)
+	}
+}
+
+func TestPayloadSchemaVersion(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set("Content-Type", "application/json")
+	build := func(config Config) map[string]string {
+		exporter := tracetest.NewInMemoryExporter()
+		config.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		config.CaptureRequestBody = true
+		config.RedactRequestBody = []string{"$.password"}
+		payload := BuildPayload(GoDefaultSDKType, req, 200, []byte(`{"password":"hunter2"}`), nil, nil, nil, "/login",
+			nil, config.RedactRequestBody, nil, nil, uuid.New(), nil, config)
+		_, span := Tracer(config).Start(context.Background(), "monoscope.http")
+		CreateSpan(payload, config, span)
+		span.End()
+		attrs := map[string]string{}
+		for _, kv := range exporter.GetSpans()[0].Attributes {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		return attrs
+	}
+
+	current := build(Config{})
+	if current["apitoolkit.schema_version"] != "2" || current["apitoolkit.redactions"] == "" || current["apitoolkit.traffic_class"] == "" {
+		t.Errorf("Expected the current schema with its fields, got %v", current)
+	}
+	v1 := build(Config{PayloadSchemaVersion: PayloadSchemaV1})
+	for _, key := range []string{"apitoolkit.schema_version", "apitoolkit.span_kind", "apitoolkit.redactions", "apitoolkit.traffic_class"} {
+		if _, ok := v1[key]; ok {
+			t.Errorf("Expected %s to be left out of a v1 payload", key)
+		}
+	}
+	if v1["apitoolkit.msg_id"] == "" || v1["http.route"] != "/login" {
+		t.Errorf("Expected v1 payloads to keep the original fields, got %v", v1)
+	}
+
+	payload := Payload{Method: http.MethodGet, TrafficClass: TrafficBot}
+	if down := ConvertPayload(payload, PayloadSchemaV1); down.TrafficClass != "" || down.Method != http.MethodGet || down.SchemaVersion != PayloadSchemaV1 {
+		t.Errorf("Expected the v1 conversion to drop later fields only, got %+v", down)
+	}
+	if up := ConvertPayload(payload, 99); up.SchemaVersion != CurrentPayloadSchemaVersion || up.TrafficClass != TrafficBot {
+		t.Errorf("Expected unknown versions to convert to the current one, got %+v", up)
+	}
+}
+
+func TestBatchExport(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	config := Config{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		BatchExport:    BatchExport{Interval: time.Hour, MaxRecords: 3},
+	}
+
+	ok := Payload{Method: http.MethodGet, URLPath: "/ok", StatusCode: 200, MsgID: "a"}
+	if ShouldExport(config, &ok, time.Millisecond) {
+		t.Error("Expected a successful request to be batched")
+	}
+	failed := Payload{Method: http.MethodGet, URLPath: "/fail", StatusCode: 503}
+	if !ShouldExport(config, &failed, time.Millisecond) {
+		t.Error("Expected a failed request to be exported in full")
+	}
+	forced := Payload{StatusCode: 200, ForceSampled: true}
+	if !ShouldExport(config, &forced, time.Millisecond) {
+		t.Error("Expected a force-sampled request to be exported in full")
+	}
+	if len(exporter.GetSpans()) != 0 {
+		t.Fatalf("Expected no batch span before the interval, got %d", len(exporter.GetSpans()))
+	}
+
+	ShouldExport(config, &ok, time.Millisecond)
+	ShouldExport(config, &ok, 2*time.Millisecond)
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "monoscope.batch" {
+		t.Fatalf("Expected a full batch to be exported on one span, got %v", spans)
+	}
+	attrs := map[string]string{}
+	for _, kv := range spans[0].Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	var records []BatchRecord
+	if err := json.Unmarshal([]byte(attrs["apitoolkit.batch.records"]), &records); err != nil {
+		t.Fatal(err)
+	}
+	if attrs["apitoolkit.batch.count"] != "3" || len(records) != 3 || records[2].Duration != 2*time.Millisecond || records[0].Route != "/ok" {
+		t.Errorf("Expected the batch to hold the three records, got %v", attrs)
+	}
+
+	ShouldExport(config, &ok, time.Millisecond)
+	FlushBatches()
+	if len(exporter.GetSpans()) != 2 {
+		t.Errorf("Expected FlushBatches to export the pending records, got %d spans", len(exporter.GetSpans()))
+	}
+	if !ShouldExport(Config{}, &ok, time.Millisecond) {
+		t.Error("Expected payloads to be exported in full without batch mode")
+	}
+}
+
+func TestRouteTags(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, route string
+		want           bool
+	}{
+		{"/users/*", "/users/{id}", true},
+		{"/users/*", "/users/{id}/orders", false},
+		{"/admin/**", "/admin", true},
+		{"/admin/**", "/admin/users/{id}", true},
+		{"/admin/**", "/administrators", false},
+		{"/api/*/orders", "/api/v1/orders", true},
+	} {
+		if got := MatchRouteGlob(tc.pattern, tc.route); got != tc.want {
+			t.Errorf("MatchRouteGlob(%q, %q) = %v, want %v", tc.pattern, tc.route, got, tc.want)
+		}
+	}
+
+	config := Config{
+		Tags: []string{"prod"},
+		RouteTags: map[string][]string{
+			"/admin/**":   {"admin", "internal"},
+			"/admin/jobs": {"internal", "jobs"},
+			"/api/**":     {"public-api"},
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/admin/jobs",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+	if !slices.Equal(payload.Tags, []string{"prod", "admin", "internal", "jobs"}) {
+		t.Errorf("Expected the global and matching route tags, got %v", payload.Tags)
+	}
+	if !slices.Equal(config.Tags, []string{"prod"}) {
+		t.Errorf("Expected Config.Tags to be left unchanged, got %v", config.Tags)
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	config := Config{
+		CaptureResponseBody: true,
+		SPAFallbackRoutes:   []string{"/**"},
+		SPAFallbackExclude:  []string{"/api/**"},
+		RouteTags:           map[string][]string{SPAFallbackRoute: {"app-shell"}},
+	}
+	for _, tc := range []struct {
+		route, contentType, body string
+		wantRoute, wantBody      string
+	}{
+		{"/dashboard/settings", "text/html; charset=utf-8", "<html></html>", SPAFallbackRoute, ""},
+		{"/assets/app.js", "text/javascript", "app()", SPAFallbackRoute, ""},
+		{"/manifest.json", "application/json", `{"name":"app"}`, SPAFallbackRoute, `{"name":"app"}`},
+		{"/api/users", "application/json", `{"id":1}`, "/api/users", `{"id":1}`},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.route, nil)
+		header := map[string][]string{"Content-Type": {tc.contentType}}
+		payload := BuildPayload(GoDefaultSDKType, req, 200, nil, []byte(tc.body), header, map[string]string{"path": tc.route}, tc.route,
+			nil, nil, nil, nil, uuid.New(), nil, config)
+		if payload.URLPath != tc.wantRoute {
+			t.Errorf("%s: expected route %q, got %q", tc.route, tc.wantRoute, payload.URLPath)
+		}
+		if string(payload.ResponseBody) != tc.wantBody || payload.ResponseBodySkipped != (tc.wantBody == "") {
+			t.Errorf("%s: expected body %q, got %q (skipped %v)", tc.route, tc.wantBody, payload.ResponseBody, payload.ResponseBodySkipped)
+		}
+		if spa := tc.wantRoute == SPAFallbackRoute; spa != (payload.PathParams == nil) || spa != slices.Equal(payload.Tags, []string{"app-shell"}) {
+			t.Errorf("%s: expected SPA hits to drop path params and get route tags, got %v %v", tc.route, payload.PathParams, payload.Tags)
+		}
+	}
+}
+
+func TestApplySLOs(t *testing.T) {
+	config := Config{SLOs: []SLO{
+		{Route: "/orders/**", LatencyThreshold: 200 * time.Millisecond},
+		{Route: "/orders", Method: http.MethodPost, AllowedStatusClasses: []string{"2xx"}},
+	}}
+	violated := func(method, route string, status int, duration time.Duration) *bool {
+		payload := Payload{Method: method, URLPath: route, StatusCode: status}
+		ApplySLOs(config, &payload, duration)
+		return payload.SLOViolated
+	}
+
+	if v := violated(http.MethodGet, "/users", 500, time.Second); v != nil {
+		t.Errorf("Expected no SLO verdict for uncovered routes, got %v", *v)
+	}
+	if v := violated(http.MethodGet, "/orders/{id}", 404, 50*time.Millisecond); v == nil || *v {
+		t.Error("Expected a fast 404 to meet the default status classes")
+	}
+	if v := violated(http.MethodGet, "/orders/{id}", 200, 300*time.Millisecond); v == nil || !*v {
+		t.Error("Expected a slow request to violate the latency threshold")
+	}
+	if v := violated(http.MethodPost, "/orders", 409, 50*time.Millisecond); v == nil || !*v {
+		t.Error("Expected a 409 to violate an objective allowing only 2xx")
+	}
+	if v := violated(http.MethodGet, "/orders", 503, 50*time.Millisecond); v == nil || !*v {
+		t.Error("Expected a 5xx to violate the default status classes")
+	}
+}
+
+func TestCostAttributes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	config := Config{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), CaptureResponseBody: true}
+	bytesBefore := selfMetrics.payloadBytes.Load()
+
+	req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+	body := []byte(`{"rows":"` + strings.Repeat("x", 3000) + `"}`)
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, body, http.Header{"Content-Type": {"application/json"}}, nil, "/reports",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+	_, span := Tracer(config).Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+	span.End()
+
+	attrs := map[string]int64{}
+	var others []attribute.KeyValue
+	for _, kv := range exporter.GetSpans()[0].Attributes {
+		switch kv.Key {
+		case "apitoolkit.payload_size_bytes", "apitoolkit.cost_weight":
+			attrs[string(kv.Key)] = kv.Value.AsInt64()
+		default:
+			others = append(others, kv)
+		}
+	}
+	size := attrs["apitoolkit.payload_size_bytes"]
+	if size != int64(attributesSize(others)) || size < 4000 {
+		t.Errorf("Expected the size of the other attributes, got %d", size)
+	}
+	if weight := attrs["apitoolkit.cost_weight"]; weight != (size+CostUnitBytes-1)/CostUnitBytes {
+		t.Errorf("Expected one cost unit per started KiB of %d bytes, got %d", size, weight)
+	}
+	if got := selfMetrics.payloadBytes.Load() - bytesBefore; got != size {
+		t.Errorf("Expected the self-metrics to count %d bytes, got %d", size, got)
+	}
+}
+
+func TestNDJSONBody(t *testing.T) {
+	config := Config{CaptureRequestBody: true, NDJSONMaxLines: 2}
+	req := httptest.NewRequest(http.MethodPost, "/bulk", nil)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	body := []byte("{\"id\":1,\"token\":\"a\"}\n\n{\"id\":2,\"token\":\"b\"}\n{\"id\":3,\"token\":\"c\"}\n")
+	payload := BuildPayload(GoDefaultSDKType, req, 200, body, nil, nil, nil, "/bulk",
+		nil, []string{"$.token"}, nil, nil, uuid.New(), nil, config)
+
+	want := "{\"id\":1,\"token\":\"[CLIENT_REDACTED]\"}\n{\"id\":2,\"token\":\"[CLIENT_REDACTED]\"}"
+	if string(payload.RequestBody) != want {
+		t.Errorf("Expected the first two lines redacted, got %q", payload.RequestBody)
+	}
+	if payload.RequestBodyLines != 3 {
+		t.Errorf("Expected 3 lines counted, got %d", payload.RequestBodyLines)
+	}
+	if payload.Redactions["request_body:$.token"] != 2 {
+		t.Errorf("Expected each kept line to be redacted, got %v", payload.Redactions)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	payload = BuildPayload(GoDefaultSDKType, req, 200, []byte(`{"id":1}`), nil, nil, nil, "/bulk",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+	if payload.RequestBodyLines != 0 || string(payload.RequestBody) != `{"id":1}` {
+		t.Errorf("Expected JSON bodies to be left alone, got %q and %d lines", payload.RequestBody, payload.RequestBodyLines)
+	}
+}
+
+func TestBinaryBodyDecoding(t *testing.T) {
+	if _, err := decodeBinaryValue([]byte{0xc0}, binaryFormatMsgpack); err != nil {
+		t.Skip("No binary body codecs in this build:", err)
+	}
+	config := Config{CaptureRequestBody: true}
+	encode := func(handle codec.Handle) []byte {
+		var out []byte
+		if err := codec.NewEncoderBytes(&out, handle).Encode(map[string]any{"user": "ada", "password": "hunter2"}); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	build := func(contentType string, body []byte) Payload {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set("Content-Type", contentType)
+		return BuildPayload(GoDefaultSDKType, req, 200, body, nil, nil, nil, "/login",
+			nil, []string{"$.password"}, nil, nil, uuid.New(), nil, config)
+	}
+
+	for contentType, handle := range map[string]codec.Handle{
+		"application/msgpack": &codec.MsgpackHandle{},
+		"application/cbor":    &codec.CborHandle{},
+	} {
+		payload := build(contentType, encode(handle))
+		if string(payload.RequestBody) != `{"password":"[CLIENT_REDACTED]","user":"ada"}` {
+			t.Errorf("Expected the %s body decoded to redacted JSON, got %s", contentType, payload.RequestBody)
+		}
+	}
+	payload := build("application/x-msgpack", []byte{0xc1, 0x00, 0x01})
+	if payload.RequestBodyUndecodedSize != 3 || strings.Contains(string(payload.RequestBody), "\x01") {
+		t.Errorf("Expected an undecodable body to be captured by size only, got %d and %q", payload.RequestBodyUndecodedSize, payload.RequestBody)
+	}
+}
+
+func TestCompressedResponseBody(t *testing.T) {
+	const body = `{"user":"ada","token":"secret"}`
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	_, _ = zw.Write([]byte(body))
+	_ = zw.Close()
+	var bomb bytes.Buffer
+	gw := gzip.NewWriter(&bomb)
+	_, _ = gw.Write(make([]byte, MaxCaptureContentLength+1))
+	_ = gw.Close()
+
+	for _, tc := range []struct {
+		name, encoding   string
+		body             []byte
+		want, wantLayer  string
+		wantUndecodedLen int
+	}{
+		{"deflate inside", "deflate", deflated.Bytes(), `{"token":"[CLIENT_REDACTED]","user":"ada"}`, ResponseCompressionInner, 0},
+		{"gzip outside", "gzip", []byte(body), `{"token":"[CLIENT_REDACTED]","user":"ada"}`, ResponseCompressionOuter, 0},
+		{"brotli inside", "br", []byte{0x1b, 0x03}, "", ResponseCompressionInner, 2},
+		{"gzip bomb", "gzip", bomb.Bytes(), "", ResponseCompressionInner, bomb.Len()},
+	} {
+		header := map[string][]string{"Content-Type": {"application/json"}, "Content-Encoding": {tc.encoding}, "Content-Length": {"9"}}
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		payload := BuildPayload(GoDefaultSDKType, req, 200, nil, tc.body, header, nil, "/me",
+			nil, nil, []string{"$.token"}, nil, uuid.New(), nil, Config{})
+		if tc.want != "" && string(payload.ResponseBody) != tc.want {
+			t.Errorf("%s: expected body %s, got %s", tc.name, tc.want, payload.ResponseBody)
+		}
+		if payload.ResponseCompression != tc.wantLayer || payload.ResponseContentEncoding != tc.encoding || payload.ResponseBodyUndecodedSize != tc.wantUndecodedLen {
+			t.Errorf("%s: expected %s %s with %d bytes undecoded, got %s %s with %d",
+				tc.name, tc.wantLayer, tc.encoding, tc.wantUndecodedLen, payload.ResponseCompression, payload.ResponseContentEncoding, payload.ResponseBodyUndecodedSize)
+		}
+		if _, ok := payload.ResponseHeaders["Content-Encoding"]; ok {
+			t.Errorf("%s: expected Content-Encoding to be dropped from the captured headers", tc.name)
+		}
+		if _, ok := payload.ResponseHeaders["Content-Length"]; ok {
+			t.Errorf("%s: expected the mismatched Content-Length to be dropped from the captured headers", tc.name)
+		}
+	}
+
+	payload := Payload{}
+	ApplyResponseCompression(&payload, map[string][]string{}, map[string][]string{"Content-Encoding": {"gzip"}})
+	if payload.ResponseCompression != ResponseCompressionOuter || payload.ResponseContentEncoding != "gzip" {
+		t.Errorf("Expected Content-Encoding set after the status to mark outer compression, got %+v", payload)
+	}
+}
+
+func TestFramedBodyDecoding(t *testing.T) {
+	frame := func(flags byte, message string) []byte {
+		return append([]byte{flags, 0, 0, 0, byte(len(message))}, message...)
+	}
+	build := func(contentType string, body []byte) Payload {
+		req := httptest.NewRequest(http.MethodPost, "/users.v1.Users/Login", nil)
+		req.Header.Set("Content-Type", contentType)
+		return BuildPayload(GoDefaultSDKType, req, 200, body, nil, nil, nil, "/users.v1.Users/Login",
+			nil, []string{"$.password"}, nil, nil, uuid.New(), nil, Config{CaptureRequestBody: true})
+	}
+
+	connect := append(frame(0, `{"user":"ada","password":"x"}`), frame(0x02, `{}`)...)
+	if got := string(build("application/connect+json", connect).RequestBody); got != `{"password":"[CLIENT_REDACTED]","user":"ada"}` {
+		t.Errorf("Expected the Connect message without its envelope, got %s", got)
+	}
+	stream := append(frame(0, `{"n":1}`), frame(0, `{"n":2}`)...)
+	if got := string(build("application/grpc-web+json", stream).RequestBody); got != `[{"n":1},{"n":2}]` {
+		t.Errorf("Expected several messages as an array, got %s", got)
+	}
+	text := base64.StdEncoding.EncodeToString(append(frame(0, "\x0a\x03ada"), frame(0x80, "grpc-status: 0\r\n")...))
+	if got := string(build("application/grpc-web-text+proto", []byte(text)).RequestBody); got != `"CgNhZGE="` {
+		t.Errorf("Expected the protobuf message as base64, got %s", got)
+	}
+	if payload := build("application/grpc-web+proto", []byte{0, 0, 0, 0, 9, 1}); payload.RequestBodyUndecodedSize != 6 {
+		t.Errorf("Expected a truncated body to be captured by size only, got %d", payload.RequestBodyUndecodedSize)
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	segment := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	token := segment(`{"alg":"HS256"}`) + "." + segment(`{"sub":"user-42","aud":"api","exp":1700000000,"email":"ada@example.com"}`) + ".c2ln"
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/me",
+		nil, nil, nil, nil, uuid.New(), nil, Config{JWTClaims: DefaultJWTClaims})
+	want := map[string]any{"sub": "user-42", "aud": "api", "exp": float64(1700000000)}
+	if fmt.Sprint(payload.JWTClaims) != fmt.Sprint(want) {
+		t.Errorf("Expected the selected claims only, got %v", payload.JWTClaims)
+	}
+	if got := payload.RequestHeaders["Authorization"]; len(got) != 1 || strings.Contains(got[0], token) {
+		t.Errorf("Expected the token to stay redacted, got %v", got)
+	}
+
+	payload = BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/me",
+		nil, nil, nil, nil, uuid.New(), nil, Config{})
+	if payload.JWTClaims != nil {
+		t.Errorf("Expected no claims unless configured, got %v", payload.JWTClaims)
+	}
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	payload = BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/me",
+		nil, nil, nil, nil, uuid.New(), nil, Config{JWTClaims: DefaultJWTClaims})
+	if payload.JWTClaims != nil {
+		t.Errorf("Expected no claims without a bearer token, got %v", payload.JWTClaims)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	value := func(v *int64) string {
+		if v == nil {
+			return "nil"
+		}
+		return fmt.Sprint(*v)
+	}
+	for _, tc := range []struct {
+		name                           string
+		header                         http.Header
+		limit, remaining, reset, retry string
+	}{
+		{"ietf", http.Header{"Ratelimit-Limit": {"100"}, "Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"30"}}, "100", "0", "30", "nil"},
+		{"combined", http.Header{"Ratelimit": {"limit=10, remaining=4, reset=5"}}, "10", "4", "5", "nil"},
+		{"legacy unix reset", http.Header{"X-Ratelimit-Limit": {"60"}, "X-Ratelimit-Reset": {fmt.Sprint(now.Unix() + 90)}}, "60", "nil", "90", "nil"},
+		{"retry-after date", http.Header{"Retry-After": {now.Add(2 * time.Minute).Format(http.TimeFormat)}}, "nil", "nil", "nil", "120"},
+	} {
+		rl := parseRateLimit(tc.header, now)
+		if rl == nil {
+			t.Errorf("%s: expected rate-limit state", tc.name)
+			continue
+		}
+		if got := []string{value(rl.Limit), value(rl.Remaining), value(rl.ResetSeconds), value(rl.RetryAfterSeconds)}; !slices.Equal(got, []string{tc.limit, tc.remaining, tc.reset, tc.retry}) {
+			t.Errorf("%s: got %v", tc.name, got)
+		}
+	}
+	if rl := parseRateLimit(http.Header{"Content-Type": {"text/plain"}}, now); rl != nil {
+		t.Errorf("Expected no rate-limit state without its headers, got %+v", rl)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/search", nil)
+	payload := BuildPayload(GoDefaultSDKType, req, 429, nil, nil, http.Header{"Retry-After": {"7"}}, nil, "/search",
+		nil, nil, nil, nil, uuid.New(), nil, Config{})
+	if payload.RateLimit == nil || value(payload.RateLimit.RetryAfterSeconds) != "7" {
+		t.Errorf("Expected the payload to carry the Retry-After, got %+v", payload.RateLimit)
+	}
+}
+
+func TestCacheInfo(t *testing.T) {
+	info := parseCacheInfo(http.Header{
+		"Cache-Control":   {"public, max-age=60, s-maxage=300"},
+		"Age":             {"400"},
+		"Cf-Cache-Status": {"HIT"},
+	})
+	if info == nil || info.Status != "hit" || *info.MaxAgeSeconds != 300 || *info.AgeSeconds != 400 || !info.Stale {
+		t.Errorf("Expected a stale CDN hit, got %+v", info)
+	}
+	info = parseCacheInfo(http.Header{"X-Cache": {"Miss from cloudfront"}, "Cache-Control": {"max-age=60"}})
+	if info == nil || info.Status != "miss" || *info.MaxAgeSeconds != 60 || info.AgeSeconds != nil || info.Stale {
+		t.Errorf("Expected a fresh miss, got %+v", info)
+	}
+	if info := parseCacheInfo(http.Header{"Content-Type": {"text/plain"}}); info != nil {
+		t.Errorf("Expected no cache info without cache headers, got %+v", info)
+	}
+}
+
+func TestShadowCompare(t *testing.T) {
+	response := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+	}
+	ctx := ContextWithAnnotations(context.Background())
+
+	primary := response(200, `{"total":10,"generated_at":"t1","items":[{"id":"a","price":5}]}`)
+	shadow := response(200, `{"total":12,"generated_at":"t2","items":[{"id":"b","price":5}],"extra":true}`)
+	diffs, err := ShadowCompare(ctx, primary, shadow, "$.generated_at", "$.items[*].id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ShadowDiff{{"$.extra", "null", "true"}, {"$.total", "10", "12"}}
+	if !slices.Equal(diffs, want) {
+		t.Errorf("Expected %v, got %v", want, diffs)
+	}
+	if body, _ := io.ReadAll(primary.Body); !strings.HasPrefix(string(body), `{"total":10`) {
+		t.Errorf("Expected the primary body to remain readable, got %q", body)
+	}
+
+	if diffs, _ := ShadowCompare(ctx, response(200, "ok"), response(500, "ok")); !slices.Equal(diffs, []ShadowDiff{{"status", "200", "500"}}) {
+		t.Errorf("Expected a status mismatch, got %v", diffs)
+	}
+	if _, err := ShadowCompare(ctx, response(200, "ok"), nil); err == nil {
+		t.Error("Expected an error for a missing shadow response")
+	}
+
+	// Bodies are compared up to MaxCaptureContentLength, and stay whole.
+	long := strings.Repeat("a", MaxCaptureContentLength)
+	primary, shadow = response(200, long+"primary"), response(200, long+"shadow")
+	if diffs, err := ShadowCompare(ctx, primary, shadow); err != nil || len(diffs) != 0 {
+		t.Errorf("Expected no differences within the compared bytes, got %v, %v", diffs, err)
+	}
+	if body, _ := io.ReadAll(shadow.Body); !strings.HasSuffix(string(body), "shadow") || len(body) != MaxCaptureContentLength+6 {
+		t.Errorf("Expected the whole shadow body to remain readable, got %d bytes", len(body))
+	}
+
+	var payload Payload
+	ApplyAnnotations(ctx, &payload)
+	if payload.Shadow == nil || payload.Shadow.Compared != 3 || payload.Shadow.Mismatches != 3 || len(payload.Shadow.Diffs) != 3 {
+		t.Errorf("Expected the comparisons on the payload, got %+v", payload.Shadow)
+	}
+
+	// Values on redacted paths, and below them, are recorded redacted.
+	config := Config{CaptureResponseBody: true, RedactResponseBody: []string{"$.token", "$.card"}}
+	ctx = ContextWithConfig(ContextWithAnnotations(context.Background()), config)
+	diffs, _ = ShadowCompare(ctx,
+		response(200, `{"token":"sk_live_1","card":{"number":"4242"},"total":1}`),
+		response(200, `{"token":"sk_live_2","card":{"number":"4000"},"total":1}`))
+	want = []ShadowDiff{
+		{"$.card.number", `"[CLIENT_REDACTED]"`, `"[CLIENT_REDACTED]"`},
+		{"$.token", `"[CLIENT_REDACTED]"`, `"[CLIENT_REDACTED]"`},
+	}
+	if !slices.Equal(diffs, want) {
+		t.Errorf("Expected redacted values, got %v", diffs)
+	}
+
+	// Payloads that don't capture response bodies only record the paths.
+	exporter := tracetest.NewInMemoryExporter()
+	config = Config{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))}
+	payload = Payload{Shadow: &ShadowComparison{Compared: 1, Mismatches: 1, Diffs: []ShadowDiff{{"$.total", "10", "12"}}}}
+	_, span := config.TracerProvider.Tracer("test").Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+	span.End()
+	var recorded string
+	for _, attr := range exporter.GetSpans()[0].Attributes {
+		if attr.Key == "apitoolkit.shadow.diffs" {
+			recorded = attr.Value.AsString()
+		}
+	}
+	if recorded != `[{"path":"$.total"}]` {
+		t.Errorf("Expected the diff's path only, got %s", recorded)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	secret, body := []byte("whsec"), []byte(`{"event":"paid"}`)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	valid := mac.Sum(nil)
+
+	ctx := ContextWithAnnotations(context.Background())
+	if err := VerifyHMAC(ctx, "hmac-sha256", sha256.New, []byte("old"), body, valid); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Expected a signature made with another secret to be rejected, got %v", err)
+	}
+	var payload Payload
+	ApplyAnnotations(ctx, &payload)
+	if payload.Signature == nil || payload.Signature.Valid || payload.Signature.Error != ErrInvalidSignature.Error() ||
+		!slices.Contains(payload.Tags, SignatureFailureTag) {
+		t.Errorf("Expected a failed verification tagged %s, got %+v %v", SignatureFailureTag, payload.Signature, payload.Tags)
+	}
+
+	// A later attempt with the current secret, as during a rotation, makes
+	// the request valid.
+	if err := VerifyHMAC(ctx, "hmac-sha256", sha256.New, secret, body, valid); err != nil {
+		t.Fatal(err)
+	}
+	payload = Payload{}
+	ApplyAnnotations(ctx, &payload)
+	if !payload.Signature.Valid || payload.Signature.Attempts != 2 || payload.Signature.Scheme != "hmac-sha256" {
+		t.Errorf("Expected 2 attempts ending in a valid signature, got %+v", payload.Signature)
+	}
+	if slices.Contains(payload.Tags, SignatureFailureTag) {
+		t.Errorf("Expected a verified request not to be tagged, got %v", payload.Tags)
+	}
+
+	errExpired := errors.New("timestamp outside the tolerance zone")
+	ctx = ContextWithAnnotations(context.Background())
+	if err := VerifySignature(ctx, "stripe-webhook", func() error { return errExpired }); err != errExpired {
+		t.Errorf("Expected the verification's error to be returned, got %v", err)
+	}
+	payload = Payload{}
+	ApplyAnnotations(ctx, &payload)
+	if payload.Signature.Scheme != "stripe-webhook" || payload.Signature.Error != errExpired.Error() {
+		t.Errorf("Expected the failed webhook verification, got %+v", payload.Signature)
+	}
+}
+
+func TestDropFilter(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(NewDropFilter(sdktrace.NewSimpleSpanProcessor(exporter))))
+	tracer := tp.Tracer("test")
+
+	// An instrumentation's span around a request span whose payload is
+	// dropped: the request span and its descendants go, the rest stays.
+	ctx, outer := tracer.Start(context.Background(), "otelhttp")
+	reqCtx, request := tracer.Start(ctx, "monoscope.http")
+	queryCtx, query := tracer.Start(reqCtx, "db.query")
+	_, row := tracer.Start(queryCtx, "db.row")
+	row.End()
+	query.End()
+	DropSpan(request)
+	request.End()
+	_, sibling := tracer.Start(ctx, "cache.get")
+	sibling.End()
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("Expected spans to be held back until the trace's root ends, got %d", len(spans))
+	}
+	outer.End()
+
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+	if !slices.Equal(names, []string{"cache.get", "otelhttp"}) {
+		t.Errorf("Expected only the spans outside the dropped request, got %v", names)
+	}
+
+	// A trace ending more spans than are held back passes them on, which
+	// is reported once.
+	var reported []error
+	defer otel.SetErrorHandler(otel.GetErrorHandler())
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) { reported = append(reported, err) }))
+	exporter.Reset()
+	ctx, root := tracer.Start(context.Background(), "monoscope.http")
+	for range maxPendingSpans + 2 {
+		_, child := tracer.Start(ctx, "db.query")
+		child.End()
+	}
+	if spans := exporter.GetSpans(); len(spans) != 2 || len(reported) != 1 {
+		t.Errorf("Expected the 2 spans over the cap to be passed on and reported once, got %d spans and %v", len(spans), reported)
+	}
+	root.End()
+	if spans := exporter.GetSpans(); len(spans) != maxPendingSpans+3 {
+		t.Errorf("Expected the held back spans to follow with the root, got %d spans", len(spans))
+	}
+}
+
+func TestTenantBudget(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	config := Config{
+		TracerProvider: sdktrace.NewTracerProvider(),
+		Now:            func() time.Time { return now },
+		TenantBudget:   TenantBudget{MaxPayloadsPerMinute: 2, MaxCapturedBytesPerMinute: 10},
+	}
+	payload := func(tenant string) *Payload {
+		return &Payload{Tenant: tenant, RequestBody: []byte(`{"a":1}`)}
+	}
+
+	first, second := payload("noisy"), payload("noisy")
+	if !ShouldExport(config, first, 0) || first.TenantBudgetExceeded || first.RequestBody == nil {
+		t.Errorf("Expected the first payload to be exported with its body, got %+v", first)
+	}
+	if !ShouldExport(config, second, 0) || !second.TenantBudgetExceeded || second.RequestBody != nil {
+		t.Errorf("Expected the second payload to go over the byte budget and lose its body, got %+v", second)
+	}
+	if ShouldExport(config, payload("noisy"), 0) {
+		t.Error("Expected a payload over the tenant's payload budget to be dropped")
+	}
+	forced := payload("noisy")
+	forced.ForceSampled = true
+	if !ShouldExport(config, forced, 0) || forced.RequestBody == nil {
+		t.Errorf("Expected force-sampled payloads to bypass the budget, got %+v", forced)
+	}
+	if !ShouldExport(config, payload("quiet"), 0) || !ShouldExport(config, payload(""), 0) {
+		t.Error("Expected other tenants and untenanted requests to keep their own budgets")
+	}
+
+	now = now.Add(time.Minute)
+	if next := payload("noisy"); !ShouldExport(config, next, 0) || next.TenantBudgetExceeded {
+		t.Errorf("Expected the budget to be renewed the next minute, got %+v", next)
+	}
+}
+
+func TestIdempotencyTrackerBound(t *testing.T) {
+	tracker := newIdempotencyTracker()
+	now := time.Now()
+	for i := range maxIdempotencyEntries + 10 {
+		tracker.track(fmt.Sprint("key-", i), http.MethodPost, "/payments", "msg", now, time.Hour)
+	}
+	if len(tracker.seen) != maxIdempotencyEntries || tracker.order.Len() != maxIdempotencyEntries {
+		t.Errorf("Expected live keys to be capped at %d, got %d", maxIdempotencyEntries, len(tracker.seen))
+	}
+	if attempt, _ := tracker.track("key-0", http.MethodPost, "/payments", "msg", now, time.Hour); attempt != 1 {
+		t.Errorf("Expected the oldest key to have been evicted, got attempt %d", attempt)
+	}
+	last := fmt.Sprint("key-", maxIdempotencyEntries+9)
+	if attempt, _ := tracker.track(last, http.MethodPost, "/payments", "msg", now, time.Hour); attempt != 2 {
+		t.Errorf("Expected the newest key to be kept, got attempt %d", attempt)
+	}
+
+	// Keys whose window ended are dropped as new ones arrive.
+	tracker.track("late", http.MethodPost, "/payments", "msg", now.Add(2*time.Hour), time.Hour)
+	if len(tracker.seen) != 1 {
+		t.Errorf("Expected expired keys to be dropped, %d left", len(tracker.seen))
+	}
+}
+
+func TestTraceState(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	config := Config{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), TraceStateKeys: []string{"dd", "missing"}}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Tracestate", "dd=s:1;o:rum, congo=t61rcWkgMzE")
+	payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/",
+		nil, nil, nil, nil, uuid.New(), nil, config)
+	_, span := Tracer(config).Start(context.Background(), "monoscope.http")
+	CreateSpan(payload, config, span)
+	span.End()
+
+	attrs := map[string]string{}
+	for _, kv := range exporter.GetSpans()[0].Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["apitoolkit.tracestate"] != "dd=s:1;o:rum,congo=t61rcWkgMzE" || attrs["apitoolkit.tracestate.dd"] != "s:1;o:rum" {
+		t.Errorf("Expected the tracestate and its selected member, got %v", attrs)
+	}
+	if _, ok := attrs["apitoolkit.tracestate.congo"]; ok {
+		t.Error("Expected unselected members to stay in the tracestate only")
+	}
+
+	req.Header.Set("Tracestate", "not a tracestate")
+	if payload := BuildPayload(GoDefaultSDKType, req, 200, nil, nil, nil, nil, "/",
+		nil, nil, nil, nil, uuid.New(), nil, config); payload.TraceState != "" {
+		t.Errorf("Expected a malformed tracestate to be ignored, got %q", payload.TraceState)
+	}
+}
+
+func TestInitOTel(t *testing.T) {
+	prevTP, prevMP, prevProp := otel.GetTracerProvider(), otel.GetMeterProvider(), otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetMeterProvider(prevMP)
+		otel.SetTextMapPropagator(prevProp)
+	}()
+
+	shutdown, err := InitOTel(context.Background(), OTelOptions{
+		ServiceName:        "checkout",
+		Endpoint:           "localhost:4317",
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); !ok {
+		t.Errorf("Expected an SDK tracer provider, got %T", otel.GetTracerProvider())
+	}
+	if _, ok := otel.GetMeterProvider().(*sdkmetric.MeterProvider); !ok {
+		t.Errorf("Expected an SDK meter provider, got %T", otel.GetMeterProvider())
+	}
+	if fields := otel.GetTextMapPropagator().Fields(); !slices.Contains(fields, "traceparent") || !slices.Contains(fields, "baggage") {
+		t.Errorf("Expected trace context and baggage propagation, got %v", fields)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = shutdown(ctx)
+}
+
+func TestSimpleJSONPath(t *testing.T) {
+	body := `{"password":"p","user":{"name":"ada","cards":[{"number":"4242"},{"number":"5555"}]},"tokens":["a","b","c"],"meta":{"x":1,"y":2}}`
+	for _, path := range []string{
+		"$.password",
+		"$.user.cards[*].number",
+		"$.user.cards[-1].number",
+		"$['user']['name']",
+		"$.tokens[1]",
+		"$.meta.*",
+		"$.missing.field",
+		"$.user.name.first",
+	} {
+		var want, got any
+		_ = json.Unmarshal([]byte(body), &want)
+		_ = json.Unmarshal([]byte(body), &got)
+		wantN, err := applyJSONPath(want, path, "[CLIENT_REDACTED]")
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		gotN, err := applySimpleJSONPath(got, path, "[CLIENT_REDACTED]")
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if gotN != wantN || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %d replacements %v, want %d %v", path, gotN, got, wantN, want)
+		}
+	}
+
+	for _, path := range []string{"password", "$..password", "$.tokens[0:2]", "$.user[?(@.name)]", "$.user["} {
+		if _, err := applySimpleJSONPath(map[string]any{}, path, nil); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestContextKeys(t *testing.T) {
+	msgID := uuid.New()
+	errorList := []ATError{}
+	ctx := WithErrorList(WithMessageID(context.Background(), msgID), &errorList)
+	if got, ok := MessageIDFrom(ctx); !ok || got != msgID {
+		t.Errorf("Expected message ID %s, got %s", msgID, got)
+	}
+	if ErrorListFrom(ctx) != &errorList {
+		t.Error("Expected the error list to be stored")
+	}
+	if _, ok := MessageIDFrom(context.Background()); ok || ErrorListFrom(context.Background()) != nil {
+		t.Error("Expected nothing in an empty context")
+	}
+
+	// Values stored under the legacy keys are still read, and the legacy
+	// keys still hold the values stored with the accessors.
+	legacy := context.WithValue(context.Background(), CurrentRequestMessageID, msgID)
+	legacy = context.WithValue(legacy, ErrorListCtxKey, &errorList)
+	if got, _ := MessageIDFrom(legacy); got != msgID || ErrorListFrom(legacy) != &errorList {
+		t.Error("Expected values under the legacy keys to be read")
+	}
+	if got, _ := ctx.Value(CurrentRequestMessageID).(uuid.UUID); got != msgID {
+		t.Error("Expected WithMessageID to keep the legacy key working")
+	}
+	if got, _ := ctx.Value(ErrorListCtxKey).(*[]ATError); got != &errorList {
+		t.Error("Expected WithErrorList to keep the legacy key working")
+	}
+
+	ReportError(ctx, errors.New("boom"))
+	if len(errorList) != 1 {
+		t.Errorf("Expected ReportError to record the error, got %d", len(errorList))
+	}
+	if ErrorListFrom(DetachContext(ctx)) != nil {
+		t.Error("Expected a detached context to drop the error list")
+	}
+}
+
+// flushWriter is an http.ResponseWriter that is an http.Flusher but not an
+// http.Hijacker or io.ReaderFrom.
+type flushWriter struct {
+	*httptest.ResponseRecorder
+	flushed bool
+}
+
+func (w *flushWriter) Flush() { w.flushed = true }
+
+func TestStatusRecorder(t *testing.T) {
+	inner := &flushWriter{ResponseRecorder: httptest.NewRecorder()}
+	rec := &StatusRecorder{ResponseWriter: inner}
+	w := rec.Wrap()
+	if _, ok := w.(http.Hijacker); ok {
+		t.Error("Expected no http.Hijacker when the wrapped writer isn't one")
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		t.Error("Expected no io.ReaderFrom when the wrapped writer isn't one")
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("Expected an http.Flusher")
+	}
+	flusher.Flush()
+	if !inner.flushed || !rec.Wrote() || rec.StatusCode() != http.StatusOK {
+		t.Errorf("Expected Flush to pass through with a 200 status, got flushed=%v status=%d", inner.flushed, rec.StatusCode())
+	}
+	w.WriteHeader(http.StatusTeapot)
+	_, _ = w.Write([]byte("hello"))
+	if rec.StatusCode() != http.StatusOK || rec.Written.Load() != 5 || inner.Body.String() != "hello" {
+		t.Errorf("Expected the first status and the bytes written, got %d %d %q", rec.StatusCode(), rec.Written.Load(), inner.Body.String())
+	}
+
+	// A real server's writer is a Flusher, Hijacker and ReaderFrom.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &StatusRecorder{ResponseWriter: w}
+		wrapped := rec.Wrap()
+		_, flusher := wrapped.(http.Flusher)
+		_, hijacker := wrapped.(http.Hijacker)
+		readerFrom, ok := wrapped.(io.ReaderFrom)
+		if !flusher || !hijacker || !ok {
+			t.Errorf("Expected all optional interfaces, got flusher=%v hijacker=%v readerFrom=%v", flusher, hijacker, ok)
+			return
+		}
+		_, _ = readerFrom.ReadFrom(strings.NewReader("copied"))
+		if rec.Written.Load() != 6 || rec.StatusCode() != http.StatusOK {
+			t.Errorf("Expected ReadFrom to be counted, got %d bytes, status %d", rec.Written.Load(), rec.StatusCode())
+		}
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "copied" {
+		t.Errorf("Expected the copied body, got %q", body)
+	}
+}
+
+// retainedCtx stands in for a cache a handler leaks its request context to.
+var retainedCtx context.Context
+
+func TestLeakCheck(t *testing.T) {
+	ctx := context.Background()
+	if got, check := StartLeakCheck(ctx, Config{}, "GET /"); check != nil || got != ctx {
+		t.Fatal("Expected no leak check without DetectLeaks or Debug")
+	}
+
+	config := Config{DetectLeaks: true}
+	leakyCtx, leaky := StartLeakCheck(ctx, config, "GET /leaky")
+	retainedCtx = leakyCtx
+	defer func() { retainedCtx = nil }()
+	TrackLeak(leaky, "response body buffer", &bytes.Buffer{})
+	_, clean := StartLeakCheck(ctx, config, "GET /clean")
+	leaky.End()
+	clean.End()
+	if leaks := CheckLeaks(); slices.ContainsFunc(leaks, func(l string) bool { return strings.Contains(l, "/leaky") }) {
+		t.Fatalf("Expected no leaks before any GC cycle, got %q", leaks)
+	}
+
+	for range leakCheckGCCycles {
+		runtime.GC()
+	}
+	var found []string
+	for _, leak := range CheckLeaks() {
+		if strings.Contains(leak, "/leaky") || strings.Contains(leak, "/clean") {
+			found = append(found, leak)
+		}
+	}
+	if len(found) != 1 || !strings.HasPrefix(found[0], "request context of GET /leaky ") {
+		t.Errorf("Expected only the retained context to be reported, got %q", found)
+	}
+}
+
+func TestInstrumentMultipartReader(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("title", "holiday")
+	file, _ := mw.CreateFormFile("photo", "beach.jpg")
+	_, _ = file.Write(bytes.Repeat([]byte{0xff}, 10000))
+	_ = mw.Close()
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "monoscope.http")
+	uploads := InstrumentMultipartReader(ctx, multipart.NewReader(&body, mw.Boundary()))
+	title, err := uploads.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := io.ReadAll(title); string(value) != "holiday" {
+		t.Errorf("Expected the part to be passed through, got %q", value)
+	}
+	photo, err := uploads.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rest of a part the handler leaves unread still counts.
+	_, _ = photo.Read(make([]byte, 10))
+	if _, err := uploads.NextPart(); err != io.EOF {
+		t.Fatalf("Expected io.EOF after the last part, got %v", err)
+	}
+	uploads.End()
+	span.End()
+
+	events := exporter.GetSpans()[0].Events
+	if len(events) != 3 {
+		t.Fatalf("Expected 2 part events and a completion event, got %d events", len(events))
+	}
+	attrs := func(event sdktrace.Event) map[attribute.Key]attribute.Value {
+		m := map[attribute.Key]attribute.Value{}
+		for _, kv := range event.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	for i, want := range []struct {
+		formName, fileName string
+		size               int64
+	}{{"title", "", 7}, {"photo", "beach.jpg", 10000}} {
+		got := attrs(events[i])
+		if events[i].Name != EventUploadPart || got["apitoolkit.upload.form_name"].AsString() != want.formName ||
+			got["apitoolkit.upload.file_name"].AsString() != want.fileName || got["apitoolkit.upload.part_size"].AsInt64() != want.size {
+			t.Errorf("Expected a %s event for %+v, got %s %v", EventUploadPart, want, events[i].Name, events[i].Attributes)
+		}
+	}
+	complete := attrs(events[2])
+	if events[2].Name != EventUploadComplete || complete["apitoolkit.upload.parts"].AsInt64() != 2 ||
+		complete["apitoolkit.upload.size"].AsInt64() != 10007 {
+		t.Errorf("Expected a %s event for 2 parts of 10007 bytes, got %s %v", EventUploadComplete, events[2].Name, events[2].Attributes)
+	}
+	if _, ok := complete["apitoolkit.upload.error"]; ok {
+		t.Errorf("Expected no error for an upload read to its end, got %v", complete["apitoolkit.upload.error"])
+	}
+}
+
+// emailSenderFunc adapts a function to EmailSender, as gomail.SendFunc does.
+type emailSenderFunc func(from string, to []string, msg io.WriterTo) error
+
+func (f emailSenderFunc) Send(from string, to []string, msg io.WriterTo) error {
+	return f(from, to, msg)
+}
+
+func TestSendEmail(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = tp.Shutdown(context.Background())
+	}()
+	message := bytes.NewBufferString("Subject: Your receipt\r\n\r\nThanks for your order.\r\n")
+	size := int64(message.Len())
+
+	for _, tc := range []struct {
+		name       string
+		err        error
+		wantStatus int64
+	}{
+		{"delivered", nil, 250},
+		{"rejected", &textproto.Error{Code: 550, Msg: "mailbox unavailable"}, 550},
+		{"connection failed", errors.New("dial tcp: connection refused"), 554},
+	} {
+		exporter.Reset()
+		var errorList []ATError
+		ctx := ContextWithConfig(WithErrorList(context.Background(), &errorList), Config{TracerProvider: tp})
+		sender := emailSenderFunc(func(from string, to []string, msg io.WriterTo) error {
+			_, _ = msg.WriteTo(io.Discard)
+			return tc.err
+		})
+		to := []string{"Ada <ada@Example.com>", "bob@example.com", "carol@mail.example.org"}
+		err := SendEmail(ctx, sender, "shop@example.net", to, bytes.NewReader(message.Bytes()),
+			WithEmailTemplate("receipt"), WithEmailServer("smtp.example.net:587"))
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s: expected the sender's error to be returned, got %v", tc.name, err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 || spans[0].Name != SpanNameMessaging || spans[0].SpanKind != trace.SpanKindProducer {
+			t.Fatalf("%s: expected 1 producer span, got %v", tc.name, spans)
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range spans[0].Attributes {
+			attrs[kv.Key] = kv.Value
+			if strings.Contains(kv.Value.Emit(), "ada@") || strings.Contains(kv.Value.Emit(), "bob@") {
+				t.Errorf("%s: expected recipient addresses to be redacted, got %s=%s", tc.name, kv.Key, kv.Value.Emit())
+			}
+		}
+		if got := attrs["apitoolkit.email.recipient_domains"].AsStringSlice(); !slices.Equal(got, []string{"example.com", "mail.example.org"}) {
+			t.Errorf("%s: expected the distinct recipient domains, got %v", tc.name, got)
+		}
+		if attrs["apitoolkit.email.recipients"].AsInt64() != 3 || attrs["apitoolkit.email.size"].AsInt64() != size ||
+			attrs["apitoolkit.email.template_id"].AsString() != "receipt" {
+			t.Errorf("%s: expected 3 recipients, %d bytes and the receipt template, got %v", tc.name, size, spans[0].Attributes)
+		}
+		if got := attrs["http.response.status_code"].AsInt64(); got != tc.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.wantStatus, got)
+		}
+		if got := attrs["messaging.system"].AsString(); got != "smtp" {
+			t.Errorf("%s: expected messaging.system smtp, got %q", tc.name, got)
+		}
+		if (tc.err != nil) != (len(errorList) == 1) {
+			t.Errorf("%s: expected delivery errors to be reported on the request, got %v", tc.name, errorList)
+		}
+	}
+}
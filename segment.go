@@ -0,0 +1,68 @@
+package monoscope
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SegmentTiming is how long a named phase of a request took, see
+// StartSegment.
+type SegmentTiming struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Segment times a phase of a request started with StartSegment.
+type Segment struct {
+	ctx   context.Context
+	span  trace.Span
+	name  string
+	start time.Time
+	once  sync.Once
+}
+
+// StartSegment starts timing the phase of the request in ctx called name,
+// such as "render.user_profile", for work that isn't a database or HTTP call:
+// template rendering, serialization or stages of business logic. The segment
+// is a child span of the span in ctx, and once ended its duration is also
+// listed on the request's payload, see Payload.Segments. The returned context
+// carries the segment's span, so segments started from it nest under it.
+//
+//	ctx, segment := monoscope.StartSegment(ctx, "render.user_profile")
+//	defer segment.End()
+func StartSegment(ctx context.Context, name string) (context.Context, *Segment) {
+	config := configFromContext(ctx)
+	ctx, span := Tracer(config).Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	span.SetAttributes(attribute.String("apitoolkit.segment", name))
+	return ctx, &Segment{ctx: ctx, span: span, name: name, start: Now(config)}
+}
+
+// RecordError marks the segment as failed with err.
+func (s *Segment) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End stops timing the segment. Only the first call has an effect.
+func (s *Segment) End() {
+	s.once.Do(func() {
+		s.span.End()
+		recordSegment(s.ctx, SegmentTiming{Name: s.name, Duration: Now(configFromContext(s.ctx)).Sub(s.start)})
+	})
+}
+
+// recordSegment adds timing to the annotations of ctx's request.
+func recordSegment(ctx context.Context, timing SegmentTiming) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	annotations.segments = append(annotations.segments, timing)
+}
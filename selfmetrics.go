@@ -0,0 +1,81 @@
+package monoscope
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// selfMetrics counts what the SDK itself does, for MetricsHandler.
+var selfMetrics struct {
+	payloadsBuilt      atomic.Int64
+	payloadsVetoed     atomic.Int64
+	payloadsOverBudget atomic.Int64
+	payloadsBatched    atomic.Int64
+	payloadsExported   atomic.Int64
+	payloadBytes       atomic.Int64
+	payloadCostUnits   atomic.Int64
+	bodiesDropped      atomic.Int64
+	bodiesOverBudget   atomic.Int64
+	panicsRecovered    atomic.Int64
+	errorsReported     atomic.Int64
+	debugTapDropped    atomic.Int64
+	debugTapListeners  atomic.Int64
+}
+
+// MetricsHandler returns a handler exposing the SDK's own telemetry in the
+// Prometheus text format, for teams not yet collecting OpenTelemetry
+// metrics: payloads built, dropped, batched and exported, with the size and
+// cost weight of those exported, bodies dropped because they failed to
+// encrypt or were over their tenant's budget, panics recovered, errors
+// reported, and the debug tap's subscribers and dropped events. Counters are
+// process-wide.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetric(w, "monoscope_payloads_built_total", "counter", "Payloads built from requests and responses.",
+			metricSample{value: selfMetrics.payloadsBuilt.Load()})
+		writeMetric(w, "monoscope_payloads_dropped_total", "counter", "Payloads dropped before export.",
+			metricSample{`reason="payload_hook"`, selfMetrics.payloadsVetoed.Load()},
+			metricSample{`reason="tenant_budget"`, selfMetrics.payloadsOverBudget.Load()})
+		writeMetric(w, "monoscope_payloads_batched_total", "counter", "Payloads exported as batch records instead of spans.",
+			metricSample{value: selfMetrics.payloadsBatched.Load()})
+		writeMetric(w, "monoscope_payloads_exported_total", "counter", "Payloads written to spans for export.",
+			metricSample{value: selfMetrics.payloadsExported.Load()})
+		writeMetric(w, "monoscope_payload_bytes_total", "counter", "Estimated serialized size of the exported payloads.",
+			metricSample{value: selfMetrics.payloadBytes.Load()})
+		writeMetric(w, "monoscope_payload_cost_units_total", "counter", "Export cost weight of the exported payloads, one unit per started KiB.",
+			metricSample{value: selfMetrics.payloadCostUnits.Load()})
+		writeMetric(w, "monoscope_bodies_dropped_total", "counter", "Captured bodies dropped instead of exported.",
+			metricSample{`reason="encryption_failed"`, selfMetrics.bodiesDropped.Load()},
+			metricSample{`reason="tenant_budget"`, selfMetrics.bodiesOverBudget.Load()})
+		writeMetric(w, "monoscope_panics_recovered_total", "counter", "Handler panics recovered by the middlewares.",
+			metricSample{value: selfMetrics.panicsRecovered.Load()})
+		writeMetric(w, "monoscope_errors_reported_total", "counter", "Errors reported with ReportError.",
+			metricSample{value: selfMetrics.errorsReported.Load()})
+		writeMetric(w, "monoscope_debug_tap_subscribers", "gauge", "Clients connected to the debug tap.",
+			metricSample{value: selfMetrics.debugTapListeners.Load()})
+		writeMetric(w, "monoscope_debug_tap_dropped_total", "counter", "Payloads the debug tap dropped for slow subscribers.",
+			metricSample{value: selfMetrics.debugTapDropped.Load()})
+	})
+}
+
+// metricSample is one sample of a metric family, with its labels written as
+// in the text format, e.g. `reason="payload_hook"`, or none.
+type metricSample struct {
+	labels string
+	value  int64
+}
+
+// writeMetric writes the metric family name with its HELP and TYPE lines,
+// once, followed by its samples.
+func writeMetric(w http.ResponseWriter, name, kind, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, sample := range samples {
+		if sample.labels == "" {
+			fmt.Fprintf(w, "%s %d\n", name, sample.value)
+		} else {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, sample.labels, sample.value)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package monoscope
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// semanticConventionAttributes returns the standard HTTP attributes for
+// payload, following the stable OpenTelemetry HTTP semantic conventions, so
+// generic OTel backends can render Monoscope spans. The method, status code
+// and route are always emitted by CreateSpan and aren't repeated here.
+func semanticConventionAttributes(payload Payload) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.NetworkProtocolName("http"),
+		semconv.NetworkProtocolVersion(protocolVersion(payload.ProtoMajor, payload.ProtoMinor)),
+	}
+	if u, err := url.ParseRequestURI(payload.RawURL); err == nil {
+		attrs = append(attrs, semconv.URLPath(u.Path))
+		if u.RawQuery != "" {
+			attrs = append(attrs, semconv.URLQuery(u.RawQuery))
+		}
+	}
+	if payload.Host != "" {
+		host, port, err := net.SplitHostPort(payload.Host)
+		if err != nil {
+			host = payload.Host
+		}
+		attrs = append(attrs, semconv.ServerAddress(host))
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, semconv.ServerPort(p))
+		}
+	}
+	if userAgent := http.Header(payload.RequestHeaders).Get("User-Agent"); userAgent != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(userAgent))
+	}
+	if isErrorStatus(payload) {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(strconv.Itoa(payload.StatusCode)))
+	}
+	return attrs
+}
+
+// isErrorStatus reports whether the semantic conventions treat the payload's
+// status code as an error: 5xx for server spans, any 4xx or 5xx for client
+// spans.
+func isErrorStatus(payload Payload) bool {
+	if spanKind(payload) == trace.SpanKindClient {
+		return payload.StatusCode >= http.StatusBadRequest
+	}
+	return payload.StatusCode >= http.StatusInternalServerError
+}
+
+// setSemanticConventionStatus marks span as failed for error status codes.
+func setSemanticConventionStatus(payload Payload, span trace.Span) {
+	if isErrorStatus(payload) {
+		span.SetStatus(codes.Error, "")
+	}
+}
+
+// networkTransport returns the network.transport of a request of the given
+// HTTP major version served on a connection with local address addr: "quic"
+// for HTTP/3, "unix" or "tcp" by addr's network, and empty when unknown.
+func networkTransport(protoMajor int, addr net.Addr) string {
+	if protoMajor == 3 {
+		return "quic"
+	}
+	if addr == nil {
+		return ""
+	}
+	switch addr.Network() {
+	case "unix", "unixpacket":
+		return "unix"
+	case "tcp", "tcp4", "tcp6":
+		return "tcp"
+	}
+	return ""
+}
+
+// urlScheme returns the url.scheme of a request served with or without TLS.
+// Cleartext HTTP/2 (h2c) is "http", like HTTP/1.1 without TLS.
+func urlScheme(tls bool) string {
+	if tls {
+		return "https"
+	}
+	return "http"
+}
+
+// protocolVersion formats an HTTP version as network.protocol.version expects.
+func protocolVersion(major, minor int) string {
+	if minor == 0 && major >= 2 {
+		return strconv.Itoa(major)
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor)
+}
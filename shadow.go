@@ -0,0 +1,216 @@
+package monoscope
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxShadowDiffs is how many differences a request's shadow comparisons
+// record on its payload; further ones are only counted.
+const MaxShadowDiffs = 10
+
+// ShadowDiff is one difference ShadowCompare found, at Path: "status" for the
+// status code, a JSONPath such as "$.items[0].price" in JSON bodies, or "$"
+// for other bodies. Values are redacted as captured response bodies are, and
+// truncated to 256 bytes. They are left out of exported payloads whose
+// response bodies aren't captured.
+type ShadowDiff struct {
+	Path    string `json:"path"`
+	Primary string `json:"primary,omitempty"`
+	Shadow  string `json:"shadow,omitempty"`
+}
+
+// ShadowComparison sums up the shadow comparisons made while handling a
+// request: how many, how many differences they found, and a sample of them.
+type ShadowComparison struct {
+	Compared   int          `json:"compared"`
+	Mismatches int          `json:"mismatches"`
+	Diffs      []ShadowDiff `json:"diffs,omitempty"`
+}
+
+// ShadowCompare diffs the response of a shadow implementation against the
+// primary one for the request being handled in ctx, e.g. a rewritten
+// service's against the current one, and records the differences on its
+// payload, so refactors can be checked against production traffic. Status
+// codes are compared, then bodies: JSON bodies value by value, others byte
+// by byte. Differences at, or below, ignorePaths, such as "$.generated_at"
+// or "$.items[*].id", are left out. The values of the differences are taken
+// from the bodies redacted with the Config.RedactResponseBody rules of the
+// middleware handling ctx, so a secret that differs is reported without its
+// value. Up to MaxCaptureContentLength bytes of both bodies are compared,
+// and the bodies are replaced, so the responses can still be used. It
+// returns the differences found, or an error when a response is missing, as
+// it is when the request that should have returned it failed.
+func ShadowCompare(ctx context.Context, primaryResp, shadowResp *http.Response, ignorePaths ...string) ([]ShadowDiff, error) {
+	if primaryResp == nil || shadowResp == nil {
+		return nil, errors.New("monoscope: ShadowCompare needs both responses")
+	}
+	primaryBody, err := readResponseBody(primaryResp)
+	if err != nil {
+		return nil, err
+	}
+	shadowBody, err := readResponseBody(shadowResp)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []ShadowDiff
+	if primaryResp.StatusCode != shadowResp.StatusCode {
+		diffs = append(diffs, ShadowDiff{"status", strconv.Itoa(primaryResp.StatusCode), strconv.Itoa(shadowResp.StatusCode)})
+	}
+	config := configFromContext(ctx)
+	primaryRedacted := redactBody(primaryBody, primaryResp.Header, config.RedactResponseBody, config.StrictRedaction, nil, "")
+	shadowRedacted := redactBody(shadowBody, shadowResp.Header, config.RedactResponseBody, config.StrictRedaction, nil, "")
+	var primary, shadow any
+	if json.Unmarshal(primaryBody, &primary) == nil && json.Unmarshal(shadowBody, &shadow) == nil {
+		diffJSON("$", primary, shadow, decodeRedacted(primaryRedacted), decodeRedacted(shadowRedacted), &diffs)
+	} else if !bytes.Equal(primaryBody, shadowBody) {
+		diffs = append(diffs, ShadowDiff{"$", string(primaryRedacted), string(shadowRedacted)})
+	}
+	diffs = slices.DeleteFunc(diffs, func(d ShadowDiff) bool { return shadowPathIgnored(d.Path, ignorePaths) })
+	for i := range diffs {
+		diffs[i].Primary, _ = truncateValue(diffs[i].Primary, 256)
+		diffs[i].Shadow, _ = truncateValue(diffs[i].Shadow, 256)
+	}
+	recordShadowComparison(ctx, diffs)
+	return diffs, nil
+}
+
+// readResponseBody reads up to MaxCaptureContentLength bytes of resp's body
+// and replaces it with one replaying them followed by the rest.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	body, replay, _, err := ReadRequestBody(resp.Body)
+	resp.Body = replay
+	return body, err
+}
+
+// decodeRedacted decodes a redacted JSON body, or returns it as a string
+// when redaction failed, so every value diffJSON looks up in it is the
+// marker.
+func decodeRedacted(body []byte) any {
+	var v any
+	if json.Unmarshal(body, &v) != nil {
+		return string(body)
+	}
+	return v
+}
+
+// redactedMember returns the member name of the redacted JSON value node,
+// or node itself when redaction replaced it as a whole.
+func redactedMember(node any, name string) any {
+	if m, ok := node.(map[string]any); ok {
+		return m[name]
+	}
+	return node
+}
+
+// redactedElement returns element i of the redacted JSON value node, or node
+// itself when redaction replaced it as a whole.
+func redactedElement(node any, i int) any {
+	if a, ok := node.([]any); ok {
+		if i < len(a) {
+			return a[i]
+		}
+		return nil
+	}
+	return node
+}
+
+// diffJSON appends the differences between the decoded JSON values primary
+// and shadow at path to diffs, with the values at the same path in their
+// redacted versions, primaryRedacted and shadowRedacted.
+func diffJSON(path string, primary, shadow, primaryRedacted, shadowRedacted any, diffs *[]ShadowDiff) {
+	switch p := primary.(type) {
+	case map[string]any:
+		if s, ok := shadow.(map[string]any); ok {
+			keys := make([]string, 0, len(p)+len(s))
+			for k := range p {
+				keys = append(keys, k)
+			}
+			for k := range s {
+				if _, ok := p[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				diffJSON(path+"."+k, p[k], s[k], redactedMember(primaryRedacted, k), redactedMember(shadowRedacted, k), diffs)
+			}
+			return
+		}
+	case []any:
+		if s, ok := shadow.([]any); ok {
+			for i := range max(len(p), len(s)) {
+				var pv, sv any
+				if i < len(p) {
+					pv = p[i]
+				}
+				if i < len(s) {
+					sv = s[i]
+				}
+				diffJSON(fmt.Sprintf("%s[%d]", path, i), pv, sv, redactedElement(primaryRedacted, i), redactedElement(shadowRedacted, i), diffs)
+			}
+			return
+		}
+	}
+	primaryJSON, _ := json.Marshal(primary)
+	shadowJSON, _ := json.Marshal(shadow)
+	if !bytes.Equal(primaryJSON, shadowJSON) {
+		primaryJSON, _ = json.Marshal(primaryRedacted)
+		shadowJSON, _ = json.Marshal(shadowRedacted)
+		*diffs = append(*diffs, ShadowDiff{path, string(primaryJSON), string(shadowJSON)})
+	}
+}
+
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// shadowPathIgnored reports whether path is at or below one of ignorePaths,
+// where "[*]" matches any array index.
+func shadowPathIgnored(path string, ignorePaths []string) bool {
+	wildcard := arrayIndex.ReplaceAllString(path, "[*]")
+	for _, ignored := range ignorePaths {
+		for _, p := range []string{path, wildcard} {
+			if p == ignored || strings.HasPrefix(p, ignored+".") || strings.HasPrefix(p, ignored+"[") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordShadowComparison adds a comparison that found diffs to the
+// annotations of the request being handled in ctx.
+func recordShadowComparison(ctx context.Context, diffs []ShadowDiff) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if annotations.shadow == nil {
+		annotations.shadow = &ShadowComparison{}
+	}
+	annotations.shadow.Compared++
+	annotations.shadow.Mismatches += len(diffs)
+	room := MaxShadowDiffs - len(annotations.shadow.Diffs)
+	annotations.shadow.Diffs = append(annotations.shadow.Diffs, diffs[:min(room, len(diffs))]...)
+}
+
+// withoutValues returns a copy of c whose diffs carry their paths only.
+func (c ShadowComparison) withoutValues() *ShadowComparison {
+	diffs := make([]ShadowDiff, len(c.Diffs))
+	for i, d := range c.Diffs {
+		diffs[i] = ShadowDiff{Path: d.Path}
+	}
+	c.Diffs = diffs
+	return &c
+}
@@ -0,0 +1,88 @@
+package monoscope
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"time"
+)
+
+// SignatureFailureTag is added to the tags of requests whose signature
+// failed verification, so they can be told apart from other 401s.
+const SignatureFailureTag = "signature-failure"
+
+// ErrInvalidSignature is returned by VerifyHMAC for signatures that don't
+// match.
+var ErrInvalidSignature = errors.New("monoscope: invalid signature")
+
+// SignatureVerification records how the signature of a request was
+// verified, see VerifySignature.
+type SignatureVerification struct {
+	// Scheme names what was verified, e.g. "stripe-webhook" or
+	// "hmac-sha256".
+	Scheme string `json:"scheme"`
+	// Valid is set when a verification succeeded. Attempts counts them all,
+	// e.g. one per secret tried while secrets are being rotated, and
+	// Duration is the time they took together.
+	Valid    bool          `json:"valid"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"duration_ns"`
+	// Error is the error of the last failed verification.
+	Error string `json:"error,omitempty"`
+}
+
+// VerifySignature runs verify, the signature check of the request being
+// handled in ctx, such as a webhook provider's verification function, and
+// records its outcome and duration on the request's payload, see
+// Payload.Signature. Requests with no successful verification are also
+// tagged with SignatureFailureTag. It returns verify's error.
+//
+//	err := monoscope.VerifySignature(r.Context(), "stripe-webhook", func() error {
+//		event, err = webhook.ConstructEvent(body, r.Header.Get("Stripe-Signature"), secret)
+//		return err
+//	})
+func VerifySignature(ctx context.Context, scheme string, verify func() error) error {
+	config := configFromContext(ctx)
+	start := Now(config)
+	err := verify()
+	recordSignatureVerification(ctx, scheme, Now(config).Sub(start), err)
+	return err
+}
+
+// VerifyHMAC checks that signature is the HMAC of message with key, using
+// newHash such as sha256.New, in constant time, and records the
+// verification like VerifySignature. It returns ErrInvalidSignature when
+// the signature doesn't match.
+func VerifyHMAC(ctx context.Context, scheme string, newHash func() hash.Hash, key, message, signature []byte) error {
+	return VerifySignature(ctx, scheme, func() error {
+		mac := hmac.New(newHash, key)
+		mac.Write(message)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	})
+}
+
+// recordSignatureVerification adds a verification to the request in ctx.
+func recordSignatureVerification(ctx context.Context, scheme string, took time.Duration, err error) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return
+	}
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+	if annotations.signature == nil {
+		annotations.signature = &SignatureVerification{}
+	}
+	signature := annotations.signature
+	signature.Scheme = scheme
+	signature.Attempts++
+	signature.Duration += took
+	if err != nil {
+		signature.Error = err.Error()
+	} else {
+		signature.Valid = true
+	}
+}
@@ -0,0 +1,55 @@
+package monoscope
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SLO is a service level objective for the requests of a route. Each payload
+// matching one is stamped with whether it violated it, so Monoscope can
+// compute burn rates without a copy of the objectives.
+type SLO struct {
+	// Route is a route template glob, see MatchRouteGlob.
+	Route string
+	// Method restricts the objective to one HTTP method; empty matches all.
+	Method string
+	// LatencyThreshold is the longest a request may take; zero doesn't
+	// bound latency.
+	LatencyThreshold time.Duration
+	// AllowedStatusClasses are the status classes, like "2xx", that meet the
+	// objective. When empty, every status below 500 does.
+	AllowedStatusClasses []string
+}
+
+// matches reports whether the objective applies to payload.
+func (s SLO) matches(payload Payload) bool {
+	return (s.Method == "" || s.Method == payload.Method) && MatchRouteGlob(s.Route, payload.URLPath)
+}
+
+// violatedBy reports whether a request with payload, taking duration, missed
+// the objective.
+func (s SLO) violatedBy(payload Payload, duration time.Duration) bool {
+	if s.LatencyThreshold > 0 && duration > s.LatencyThreshold {
+		return true
+	}
+	if len(s.AllowedStatusClasses) == 0 {
+		return payload.StatusCode >= 500
+	}
+	return !slices.Contains(s.AllowedStatusClasses, fmt.Sprintf("%dxx", payload.StatusCode/100))
+}
+
+// ApplySLOs sets payload.SLOViolated when any of config.SLOs matches the
+// request: true if the request, taking duration, missed any of them. It is
+// left nil for requests no objective covers.
+func ApplySLOs(config Config, payload *Payload, duration time.Duration) {
+	for _, slo := range config.SLOs {
+		if !slo.matches(*payload) {
+			continue
+		}
+		violated := slo.violatedBy(*payload, duration)
+		if payload.SLOViolated == nil || violated {
+			payload.SLOViolated = &violated
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package monoscope
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Placeholders substituted for volatile payload values by MarshalSnapshot.
+const (
+	SnapshotMessageID  = "<msg-id>"
+	SnapshotStackTrace = "<stack-trace>"
+)
+
+// SnapshotTime is the timestamp substituted for error times by MarshalSnapshot.
+var SnapshotTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// MarshalSnapshot serializes payload deterministically for golden-file tests.
+// Output is indented JSON with struct fields in declaration order and map keys
+// sorted. Values that differ between runs are replaced with placeholders:
+// message and parent IDs become SnapshotMessageID, error and panic timestamps
+// become SnapshotTime, stack traces become SnapshotStackTrace, and the panic
+// goroutine ID and the response write duration become 0. Everything else,
+// including headers and bodies, is kept so new captured fields show up in
+// review as snapshot diffs. Bodies are written as JSON, or as strings when
+// they aren't JSON, rather than base64.
+func MarshalSnapshot(payload Payload) ([]byte, error) {
+	if payload.MsgID != "" {
+		payload.MsgID = SnapshotMessageID
+	}
+	if payload.ParentID != nil {
+		parentID := SnapshotMessageID
+		payload.ParentID = &parentID
+	}
+	if payload.Errors != nil {
+		errs := make([]ATError, len(payload.Errors))
+		for i, e := range payload.Errors {
+			e.When = SnapshotTime
+			if e.StackTrace != "" {
+				e.StackTrace = SnapshotStackTrace
+			}
+			errs[i] = e
+		}
+		payload.Errors = errs
+	}
+	payload.ResponseWriteDuration = 0
+	if payload.Signature != nil {
+		signature := *payload.Signature
+		signature.Duration = 0
+		payload.Signature = &signature
+	}
+	if payload.Panic != nil {
+		panicInfo := *payload.Panic
+		panicInfo.When = SnapshotTime
+		panicInfo.Stack = SnapshotStackTrace
+		panicInfo.GoroutineID = 0
+		payload.Panic = &panicInfo
+	}
+	snapshot := struct {
+		Payload
+		RequestBody  interface{} `json:"request_body"`
+		ResponseBody interface{} `json:"response_body"`
+	}{
+		Payload:      payload,
+		RequestBody:  snapshotBody(payload.RequestBody),
+		ResponseBody: snapshotBody(payload.ResponseBody),
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// snapshotBody renders a captured body readably: JSON bodies are embedded
+// as-is and anything else as a string.
+func snapshotBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
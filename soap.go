@@ -0,0 +1,110 @@
+package monoscope
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SOAPFaultErrorType is the ATError type recorded for SOAP faults parsed by
+// the SOAP capture profile.
+const SOAPFaultErrorType = "soap.Fault"
+
+// WithSOAPProfile enables the capture profile for SOAP upstreams. For SOAP
+// requests it records the SOAPAction as the operation name (rpc.method) and,
+// when the upstream answers with a fault, reports the fault code and string
+// as a structured error. XML bodies are redacted with RedactXML whether or not
+// the profile is enabled.
+func WithSOAPProfile() RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.SOAPProfile = true
+	}
+}
+
+// SOAPAction returns the operation a SOAP request invokes: the SOAPAction
+// header for SOAP 1.1, or the action parameter of the Content-Type for SOAP
+// 1.2. It returns an empty string for requests that aren't SOAP.
+func SOAPAction(header http.Header) string {
+	if action := strings.Trim(header.Get("SOAPAction"), `"`); action != "" {
+		return action
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err == nil && mediaType == "application/soap+xml" {
+		return params["action"]
+	}
+	return ""
+}
+
+// SOAPFault is a fault parsed from a SOAP 1.1 or 1.2 response envelope.
+type SOAPFault struct {
+	Code   string
+	String string
+}
+
+// ParseSOAPFault extracts the fault from a SOAP response body. ok is false
+// when the body doesn't contain a Fault element.
+func ParseSOAPFault(body []byte) (fault SOAPFault, ok bool) {
+	var envelope struct {
+		Body struct {
+			Fault *struct {
+				// SOAP 1.1
+				FaultCode   string `xml:"faultcode"`
+				FaultString string `xml:"faultstring"`
+				// SOAP 1.2
+				Code struct {
+					Value string `xml:"Value"`
+				} `xml:"Code"`
+				Reason struct {
+					Text string `xml:"Text"`
+				} `xml:"Reason"`
+			} `xml:"Fault"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&envelope); err != nil || envelope.Body.Fault == nil {
+		return SOAPFault{}, false
+	}
+	f := envelope.Body.Fault
+	fault = SOAPFault{Code: f.FaultCode, String: f.FaultString}
+	if fault.Code == "" {
+		fault.Code = f.Code.Value
+	}
+	if fault.String == "" {
+		fault.String = f.Reason.Text
+	}
+	return fault, true
+}
+
+// soapProfile applies the SOAP capture profile to an outgoing request's
+// payload and returns the span attributes it adds.
+func soapProfile(req *http.Request, res *http.Response, respBody []byte, when time.Time, payload *Payload) []attribute.KeyValue {
+	action := SOAPAction(req.Header)
+	if action == "" && !IsXMLContent(req.Header) {
+		return nil
+	}
+	attrs := []attribute.KeyValue{attribute.String("rpc.system", "soap")}
+	if action != "" {
+		attrs = append(attrs, attribute.String("rpc.method", action))
+	}
+	if res == nil || res.StatusCode < http.StatusBadRequest || !IsXMLContent(res.Header) {
+		return attrs
+	}
+	if fault, ok := ParseSOAPFault(respBody); ok {
+		payload.Errors = append(payload.Errors, ATError{
+			When:             when,
+			ErrorType:        SOAPFaultErrorType,
+			RootErrorType:    fault.Code,
+			Message:          fault.String,
+			RootErrorMessage: fault.String,
+		})
+		attrs = append(attrs,
+			attribute.String("apitoolkit.soap.fault_code", fault.Code),
+			attribute.String("apitoolkit.soap.fault_string", fault.String),
+		)
+	}
+	return attrs
+}
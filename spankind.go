@@ -0,0 +1,108 @@
+package monoscope
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span names used by StartSpan: HTTP server and client spans keep the name
+// the middlewares and WrapRoundTripper use, messaging spans get their own.
+const (
+	SpanNameHTTP      = "monoscope.http"
+	SpanNameMessaging = "monoscope.messaging"
+)
+
+// Messaging describes the message a PRODUCER or CONSUMER span records.
+type Messaging struct {
+	// System is the messaging system, e.g. "kafka", "rabbitmq" or "aws_sqs".
+	System string `json:"system"`
+	// Destination is the queue or topic the message was sent to or
+	// received from.
+	Destination string `json:"destination"`
+	// Operation is the system-specific operation name, e.g. "publish" or
+	// "ack". When empty, "send" is used for producers and "process" for
+	// consumers.
+	Operation string `json:"operation,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// StartSpan starts a span of kind for a payload that will be passed to
+// CreateSpan, named SpanNameMessaging for producers and consumers and
+// SpanNameHTTP otherwise. Integrations should use it rather than starting
+// spans of their own, so the span kind agrees with the payload's.
+func StartSpan(ctx context.Context, config Config, kind trace.SpanKind) (context.Context, trace.Span) {
+	name := SpanNameHTTP
+	if kind == trace.SpanKindProducer || kind == trace.SpanKindConsumer {
+		name = SpanNameMessaging
+	}
+	return Tracer(config).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// StartServerSpan starts the span of a request reported by a middleware, as
+// a child of the trace context propagated in the request headers carrier.
+// With Config.ReuseExistingSpan set and a server span from another
+// instrumentation such as otelhttp, otelmux or otelfiber already recording
+// in ctx, it returns that span instead, so the request isn't traced twice;
+// owned is then false and the caller must leave ending the span to its owner.
+func StartServerSpan(ctx context.Context, config Config, carrier propagation.TextMapCarrier) (_ context.Context, span trace.Span, owned bool) {
+	if config.ReuseExistingSpan {
+		if existing := trace.SpanFromContext(ctx); isServerSpan(existing) {
+			return ctx, existing, false
+		}
+	}
+	ctx = Propagator(config).Extract(ctx, carrier)
+	ctx, span = StartSpan(ctx, config, trace.SpanKindServer)
+	return ctx, span, true
+}
+
+// isServerSpan reports whether span is recording and, when its kind can be
+// told, a server span.
+func isServerSpan(span trace.Span) bool {
+	if !span.IsRecording() {
+		return false
+	}
+	if ro, ok := span.(sdktrace.ReadOnlySpan); ok {
+		return ro.SpanKind() == trace.SpanKindServer
+	}
+	return true
+}
+
+// spanKind returns the kind of span payload describes: its SpanKind when set,
+// else CLIENT for outgoing requests and SERVER for everything else.
+func spanKind(payload Payload) trace.SpanKind {
+	switch {
+	case payload.SpanKind != trace.SpanKindUnspecified:
+		return payload.SpanKind
+	case payload.SdkType == GoOutgoing:
+		return trace.SpanKindClient
+	default:
+		return trace.SpanKindServer
+	}
+}
+
+// messagingAttributes returns the OpenTelemetry messaging attributes for the
+// message of a PRODUCER or CONSUMER payload.
+func messagingAttributes(kind trace.SpanKind, m *Messaging) []attribute.KeyValue {
+	opType, op := semconv.MessagingOperationTypeProcess, "process"
+	if kind == trace.SpanKindProducer {
+		opType, op = semconv.MessagingOperationTypeSend, "send"
+	}
+	if m.Operation != "" {
+		op = m.Operation
+	}
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(m.System),
+		semconv.MessagingDestinationName(m.Destination),
+		opType,
+		semconv.MessagingOperationName(op),
+	}
+	if m.MessageID != "" {
+		attrs = append(attrs, semconv.MessagingMessageID(m.MessageID))
+	}
+	return attrs
+}
@@ -0,0 +1,56 @@
+package monoscope
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StageTiming is the time a request spent in a middleware stage marked with
+// EnterStage. Duration includes the stages and handler downstream of it;
+// Self is Duration minus the next stage's, the time spent in the layer
+// itself.
+type StageTiming struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+	Self     time.Duration `json:"self_ns"`
+}
+
+// EnterStage records that the request in ctx entered the middleware stage
+// called name, such as "auth" or "rate_limit", and returns the function to
+// call when the request leaves it. The framework packages wrap it as Stage
+// middlewares to place between the layers of a chain; the payload then
+// lists the stages in the order they were entered, see Payload.Stages, and
+// the request span gets a EventStageEnter event per stage. A final stage
+// just before the handler isolates the handler's own time.
+func EnterStage(ctx context.Context, name string) (exit func()) {
+	annotations := annotationsFromContext(ctx)
+	if annotations == nil {
+		return func() {}
+	}
+	config := configFromContext(ctx)
+	trace.SpanFromContext(ctx).AddEvent(EventStageEnter, trace.WithAttributes(attribute.String("apitoolkit.stage", name)))
+	start := Now(config)
+
+	annotations.mu.Lock()
+	i := len(annotations.stages)
+	annotations.stages = append(annotations.stages, StageTiming{Name: name})
+	annotations.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			duration := Now(config).Sub(start)
+			annotations.mu.Lock()
+			defer annotations.mu.Unlock()
+			stage := &annotations.stages[i]
+			stage.Duration, stage.Self = duration, duration
+			if i+1 < len(annotations.stages) {
+				stage.Self -= annotations.stages[i+1].Duration
+			}
+		})
+	}
+}
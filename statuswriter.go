@@ -0,0 +1,203 @@
+package monoscope
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// IsInformationalStatus reports whether code is a 1xx status sent ahead of
+// the final response, such as 103 Early Hints. 101 Switching Protocols is
+// final, as net/http treats it.
+func IsInformationalStatus(code int) bool {
+	return code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols
+}
+
+// informationalResponseEvent records a 1xx response on span.
+func informationalResponseEvent(span trace.Span, code int) {
+	if span != nil {
+		span.AddEvent(EventInformationalResponse, trace.WithAttributes(attribute.Int("http.response.status_code", code)))
+	}
+}
+
+// StatusRecorder records the status code and bytes written of a response
+// without buffering its body. Middlewares hand handlers the writer returned
+// by Wrap when response bodies aren't captured, so handlers type-asserting
+// on http.Flusher, http.Hijacker or io.ReaderFrom behave as they would
+// without the middleware.
+type StatusRecorder struct {
+	http.ResponseWriter
+	// Span, when set, gets an EventResponseFirstByte event when the status
+	// is written, and an EventInformationalResponse event for each 1xx
+	// response sent before it.
+	Span trace.Span
+	// Debug logs superfluous WriteHeader calls, as net/http does.
+	Debug bool
+	// Written counts the bytes written, see ApplyWriteStatus.
+	Written ByteCounter
+
+	statusCode int
+	header     http.Header
+}
+
+// WriteHeader records the first final status code written and passes it
+// on. 1xx informational responses are passed on and recorded as span events
+// without becoming the status. Later calls are ignored, as net/http ignores
+// them.
+func (r *StatusRecorder) WriteHeader(code int) {
+	if r.statusCode != 0 {
+		if r.Debug {
+			log.Printf("APIToolkit: superfluous WriteHeader(%d) call ignored, status %d was already written", code, r.statusCode)
+		}
+		return
+	}
+	if IsInformationalStatus(code) {
+		informationalResponseEvent(r.Span, code)
+		r.ResponseWriter.WriteHeader(code)
+		return
+	}
+	r.statusCode = code
+	r.header = r.ResponseWriter.Header().Clone()
+	if r.Span != nil {
+		r.Span.AddEvent(EventResponseFirstByte)
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes b, sending a 200 status first if none was written.
+func (r *StatusRecorder) Write(b []byte) (int, error) {
+	r.writeDefaultHeader()
+	r.Written.Begin()
+	n, err := r.ResponseWriter.Write(b)
+	r.Written.Record(n, err)
+	return n, err
+}
+
+// Unwrap returns the recorded ResponseWriter, so http.ResponseController can
+// reach the server's writer to set read and write deadlines.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// FlushError flushes the response for http.ResponseController, sending a 200
+// status first if none was written, as flushing the server's writer would.
+func (r *StatusRecorder) FlushError() error {
+	r.writeDefaultHeader()
+	return http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// writeDefaultHeader writes a 200 status if none was written.
+func (r *StatusRecorder) writeDefaultHeader() {
+	if r.statusCode == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+}
+
+// SentHeader returns the response headers as they were when the status was
+// written, which is what the client received: headers changed afterwards
+// aren't sent, except as declared trailers. Before the status is written it
+// returns a copy of the current headers.
+func (r *StatusRecorder) SentHeader() http.Header {
+	if r.header != nil {
+		return r.header
+	}
+	return r.ResponseWriter.Header().Clone()
+}
+
+// Wrote reports whether the status has been written.
+func (r *StatusRecorder) Wrote() bool {
+	return r.statusCode != 0
+}
+
+// StatusCode returns the status written, 200 when the handler wrote none.
+func (r *StatusRecorder) StatusCode() int {
+	if r.statusCode == 0 {
+		return http.StatusOK
+	}
+	return r.statusCode
+}
+
+// Wrap returns r as an http.ResponseWriter implementing http.Flusher,
+// http.Hijacker and io.ReaderFrom exactly when the recorded ResponseWriter
+// does. It always supports http.ResponseController, through Unwrap.
+func (r *StatusRecorder) Wrap() http.ResponseWriter {
+	_, flusher := r.ResponseWriter.(http.Flusher)
+	_, hijacker := r.ResponseWriter.(http.Hijacker)
+	_, readerFrom := r.ResponseWriter.(io.ReaderFrom)
+	f, h, rf := statusFlusher{r}, statusHijacker{r}, statusReaderFrom{r}
+	switch {
+	case flusher && hijacker && readerFrom:
+		return struct {
+			*StatusRecorder
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{r, f, h, rf}
+	case flusher && hijacker:
+		return struct {
+			*StatusRecorder
+			http.Flusher
+			http.Hijacker
+		}{r, f, h}
+	case flusher && readerFrom:
+		return struct {
+			*StatusRecorder
+			http.Flusher
+			io.ReaderFrom
+		}{r, f, rf}
+	case hijacker && readerFrom:
+		return struct {
+			*StatusRecorder
+			http.Hijacker
+			io.ReaderFrom
+		}{r, h, rf}
+	case flusher:
+		return struct {
+			*StatusRecorder
+			http.Flusher
+		}{r, f}
+	case hijacker:
+		return struct {
+			*StatusRecorder
+			http.Hijacker
+		}{r, h}
+	case readerFrom:
+		return struct {
+			*StatusRecorder
+			io.ReaderFrom
+		}{r, rf}
+	}
+	return r
+}
+
+type statusFlusher struct{ r *StatusRecorder }
+
+// Flush sends a 200 status first if none was written, as the underlying
+// Flush would.
+func (f statusFlusher) Flush() {
+	f.r.writeDefaultHeader()
+	f.r.ResponseWriter.(http.Flusher).Flush()
+}
+
+type statusHijacker struct{ r *StatusRecorder }
+
+func (h statusHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.r.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type statusReaderFrom struct{ r *StatusRecorder }
+
+// ReadFrom keeps the underlying writer's ReadFrom, such as sendfile on a
+// plain connection, counting the bytes it copies.
+func (rf statusReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	rf.r.writeDefaultHeader()
+	rf.r.Written.Begin()
+	n, err := rf.r.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	rf.r.Written.Record(int(n), err)
+	return n, err
+}
@@ -0,0 +1,108 @@
+package monoscope
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TenantBudget limits what the requests of each tenant of a multi-tenant
+// API export per minute, so one noisy tenant can't use up the telemetry
+// budget of all the others. Tenants are told apart by Config.TenantFunc.
+// Zero fields are unlimited.
+type TenantBudget struct {
+	// MaxPayloadsPerMinute is how many payloads a tenant's requests export
+	// per minute. Further payloads are dropped, unless force-sampled.
+	MaxPayloadsPerMinute int
+	// MaxCapturedBytesPerMinute is how many bytes of request and response
+	// bodies a tenant's payloads capture per minute. Further payloads are
+	// exported without their bodies, see Payload.TenantBudgetExceeded.
+	MaxCapturedBytesPerMinute int
+}
+
+// resolveTenant returns the tenant config.TenantFunc resolves for req.
+func resolveTenant(config Config, req *http.Request) string {
+	if config.TenantFunc == nil {
+		return ""
+	}
+	return config.TenantFunc(req)
+}
+
+type tenantLimiterKey struct {
+	provider trace.TracerProvider
+	apiKey   string
+}
+
+// tenantLimiters holds a tenantLimiter per destination, so middlewares
+// building a Config per request share their budgets.
+var tenantLimiters sync.Map
+
+func tenantLimiterFor(config Config) *tenantLimiter {
+	key := tenantLimiterKey{config.TracerProvider, config.APIKey}
+	if l, ok := tenantLimiters.Load(key); ok {
+		return l.(*tenantLimiter)
+	}
+	l, _ := tenantLimiters.LoadOrStore(key, &tenantLimiter{windows: map[string]*tenantWindow{}})
+	return l.(*tenantLimiter)
+}
+
+// tenantLimiter counts what each tenant exported in its current window.
+type tenantLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*tenantWindow
+	swept   time.Time
+}
+
+// tenantWindow is what a tenant exported in the minute from start.
+type tenantWindow struct {
+	start    time.Time
+	payloads int
+	bytes    int
+}
+
+// applyTenantBudget charges payload to the budget of its tenant for the
+// current minute. It reports false when the tenant already exported
+// MaxPayloadsPerMinute payloads, and drops the bodies of a payload that
+// would take the tenant over MaxCapturedBytesPerMinute.
+func applyTenantBudget(config Config, payload *Payload) bool {
+	budget := config.TenantBudget
+	if payload.Tenant == "" || budget == (TenantBudget{}) {
+		return true
+	}
+	return tenantLimiterFor(config).charge(payload, budget, Now(config))
+}
+
+func (l *tenantLimiter) charge(payload *Payload, budget TenantBudget, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Forget the tenants that stopped sending requests, once a minute.
+	if now.Sub(l.swept) >= time.Minute {
+		for tenant, w := range l.windows {
+			if now.Sub(w.start) >= time.Minute {
+				delete(l.windows, tenant)
+			}
+		}
+		l.swept = now
+	}
+	w := l.windows[payload.Tenant]
+	if w == nil || now.Sub(w.start) >= time.Minute {
+		w = &tenantWindow{start: now}
+		l.windows[payload.Tenant] = w
+	}
+	if budget.MaxPayloadsPerMinute > 0 && w.payloads >= budget.MaxPayloadsPerMinute && !payload.ForceSampled {
+		selfMetrics.payloadsOverBudget.Add(1)
+		return false
+	}
+	w.payloads++
+	size := len(payload.RequestBody) + len(payload.ResponseBody)
+	if budget.MaxCapturedBytesPerMinute > 0 && size > 0 && w.bytes+size > budget.MaxCapturedBytesPerMinute && !payload.ForceSampled {
+		payload.RequestBody, payload.ResponseBody = nil, nil
+		payload.TenantBudgetExceeded = true
+		selfMetrics.bodiesOverBudget.Add(1)
+		return true
+	}
+	w.bytes += size
+	return true
+}
@@ -0,0 +1,66 @@
+package monoscope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HandlerTimeoutError is reported when a handler doesn't respond within the
+// configured HandlerTimeout.
+type HandlerTimeoutError struct {
+	Method  string
+	Route   string
+	Timeout time.Duration
+	Elapsed time.Duration
+}
+
+func (e *HandlerTimeoutError) Error() string {
+	return fmt.Sprintf("handler for %s %s timed out after %s (limit %s)", e.Method, e.Route, e.Elapsed, e.Timeout)
+}
+
+// ServeWithTimeout serves req with h through http.TimeoutHandler, so h is
+// given at most timeout to respond before the client receives a 503. It
+// reports whether the timeout expired. As with http.TimeoutHandler, the
+// response is buffered until h returns and h's ResponseWriter doesn't support
+// http.Flusher or http.Hijacker.
+//
+// A timed-out h keeps running in its own goroutine, so it reports errors to a
+// private list that is merged into the request's only once h has returned.
+func ServeWithTimeout(w http.ResponseWriter, req *http.Request, h http.Handler, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	errorList := ErrorListFrom(ctx)
+	handlerErrors := []ATError{}
+	ctx = WithErrorList(ctx, &handlerErrors)
+
+	finished := make(chan struct{})
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		h.ServeHTTP(w, r)
+	})
+	rec := &timeoutStatusRecorder{ResponseWriter: w}
+	http.TimeoutHandler(inner, timeout, "").ServeHTTP(rec, req.WithContext(ctx))
+
+	select {
+	case <-finished:
+		if errorList != nil {
+			*errorList = append(*errorList, handlerErrors...)
+		}
+	default:
+	}
+	return rec.status == http.StatusServiceUnavailable && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// timeoutStatusRecorder records the status http.TimeoutHandler responds with.
+type timeoutStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *timeoutStatusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,44 @@
+package monoscope
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// parseTraceState returns the W3C tracestate header of a request, normalized,
+// and the values of the members named in keys, for interop with vendors
+// encoding sampling hints there. Malformed headers are ignored.
+func parseTraceState(header http.Header, keys []string) (string, map[string]string) {
+	value := header.Get("Tracestate")
+	if value == "" {
+		return "", nil
+	}
+	state, err := trace.ParseTraceState(value)
+	if err != nil || state.Len() == 0 {
+		return "", nil
+	}
+	var selected map[string]string
+	for _, key := range keys {
+		if v := state.Get(key); v != "" {
+			if selected == nil {
+				selected = map[string]string{}
+			}
+			selected[key] = v
+		}
+	}
+	return state.String(), selected
+}
+
+// traceStateAttributes returns the attributes recording the incoming
+// tracestate and its selected members.
+func traceStateAttributes(payload Payload) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("apitoolkit.tracestate", payload.TraceState)}
+	for _, key := range slices.Sorted(maps.Keys(payload.TraceStateValues)) {
+		attrs = append(attrs, attribute.String("apitoolkit.tracestate."+key, payload.TraceStateValues[key]))
+	}
+	return attrs
+}
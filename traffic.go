@@ -0,0 +1,77 @@
+package monoscope
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TrafficClass is what kind of client sent a request, see ClassifyTraffic.
+type TrafficClass string
+
+const (
+	TrafficHuman TrafficClass = "human"
+	TrafficBot   TrafficClass = "bot"
+	TrafficProbe TrafficClass = "probe"
+)
+
+// probeUserAgents are User-Agent prefixes of load balancer, orchestrator and
+// uptime monitor health checks.
+var probeUserAgents = []string{
+	"kube-probe/",
+	"elb-healthchecker/",
+	"googlehc/",
+	"consul health check",
+	"blackbox exporter/",
+	"uptimerobot/",
+	"pingdom.com_bot",
+	"statuscake",
+	"amazon-route53-health-check-service",
+	"azure traffic manager endpoint monitor",
+}
+
+// probePaths are the conventional health and readiness endpoints.
+var probePaths = map[string]bool{
+	"/health":      true,
+	"/healthz":     true,
+	"/healthcheck": true,
+	"/_health":     true,
+	"/livez":       true,
+	"/readyz":      true,
+	"/ping":        true,
+	"/-/healthy":   true,
+	"/-/ready":     true,
+}
+
+// botUserAgents are User-Agent substrings of crawlers and automated browsers.
+var botUserAgents = []string{
+	"bot",
+	"crawl",
+	"spider",
+	"slurp",
+	"facebookexternalhit",
+	"headlesschrome",
+	"lighthouse",
+}
+
+// ClassifyTraffic guesses from a request's method, path and User-Agent
+// whether it comes from a health probe, a bot or a human. Probes are known
+// health checker User-Agents, such as kube-probe, and GET or HEAD requests
+// to conventional health endpoints like /healthz; bots are crawlers and
+// automated browsers. Everything else counts as human.
+func ClassifyTraffic(method, path, userAgent string) TrafficClass {
+	ua := strings.ToLower(userAgent)
+	for _, prefix := range probeUserAgents {
+		if strings.HasPrefix(ua, prefix) {
+			return TrafficProbe
+		}
+	}
+	if (method == http.MethodGet || method == http.MethodHead) && probePaths[strings.TrimSuffix(strings.ToLower(path), "/")] {
+		return TrafficProbe
+	}
+	for _, marker := range botUserAgents {
+		if strings.Contains(ua, marker) {
+			return TrafficBot
+		}
+	}
+	return TrafficHuman
+}
@@ -0,0 +1,123 @@
+package monoscope
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MultipartReader is a multipart.Reader recording the upload it reads as
+// span events, see InstrumentMultipartReader. Like multipart.Reader, it is
+// not safe for concurrent use.
+type MultipartReader struct {
+	r      *multipart.Reader
+	span   trace.Span
+	config Config
+	start  time.Time
+
+	part      *MultipartPart
+	parts     int
+	size      int64
+	completed bool
+}
+
+// MultipartPart is a multipart.Part counting the bytes read from it.
+type MultipartPart struct {
+	*multipart.Part
+	start time.Time
+	size  int64
+}
+
+// Read reads from the part, counting the bytes read.
+func (p *MultipartPart) Read(b []byte) (int, error) {
+	n, err := p.Part.Read(b)
+	p.size += int64(n)
+	return n, err
+}
+
+// InstrumentMultipartReader returns r recording the upload on the span in
+// ctx, for services that stream uploads with body capture disabled. Parts
+// are passed through untouched; each gets an EventUploadPart event with its
+// form name, file name, content type, size and read time once the next part
+// is requested, and the upload an EventUploadComplete event with its part
+// count, total size and duration when it ends: when NextPart or NextRawPart
+// returns an error, io.EOF at the end of the upload, or when End is called.
+func InstrumentMultipartReader(ctx context.Context, r *multipart.Reader) *MultipartReader {
+	config := configFromContext(ctx)
+	return &MultipartReader{r: r, span: trace.SpanFromContext(ctx), config: config, start: Now(config)}
+}
+
+// NextPart returns the next part, as multipart.Reader.NextPart does.
+func (r *MultipartReader) NextPart() (*MultipartPart, error) {
+	return r.next(r.r.NextPart)
+}
+
+// NextRawPart returns the next part, as multipart.Reader.NextRawPart does.
+func (r *MultipartReader) NextRawPart() (*MultipartPart, error) {
+	return r.next(r.r.NextRawPart)
+}
+
+func (r *MultipartReader) next(next func() (*multipart.Part, error)) (*MultipartPart, error) {
+	r.endPart(true)
+	part, err := next()
+	if err != nil {
+		r.complete(err)
+		return nil, err
+	}
+	r.part = &MultipartPart{Part: part, start: Now(r.config)}
+	return r.part, nil
+}
+
+// End records the upload as complete if it hasn't ended yet, e.g. when the
+// handler stops before the last part. The current part is recorded with the
+// bytes read from it so far.
+func (r *MultipartReader) End() {
+	r.endPart(false)
+	r.complete(nil)
+}
+
+// endPart records the current part. With drain, what the handler left unread
+// is read first, as multipart.Reader would discard it before the next part,
+// so the recorded size is the whole part's.
+func (r *MultipartReader) endPart(drain bool) {
+	part := r.part
+	if part == nil {
+		return
+	}
+	r.part = nil
+	if drain {
+		_, _ = io.Copy(io.Discard, part)
+	}
+	r.parts++
+	r.size += part.size
+	r.span.AddEvent(EventUploadPart, trace.WithAttributes(
+		attribute.String("apitoolkit.upload.form_name", part.FormName()),
+		attribute.String("apitoolkit.upload.file_name", part.FileName()),
+		attribute.String("apitoolkit.upload.content_type", part.Header.Get("Content-Type")),
+		attribute.Int64("apitoolkit.upload.part_size", part.size),
+		attribute.Int64("apitoolkit.upload.part_duration_ns", int64(Now(r.config).Sub(part.start))),
+	))
+}
+
+// complete records the end of the upload, once. err is the error that ended
+// it, if it wasn't io.EOF.
+func (r *MultipartReader) complete(err error) {
+	if r.completed {
+		return
+	}
+	r.completed = true
+	attrs := []attribute.KeyValue{
+		attribute.Int("apitoolkit.upload.parts", r.parts),
+		attribute.Int64("apitoolkit.upload.size", r.size),
+		attribute.Int64("apitoolkit.upload.duration_ns", int64(Now(r.config).Sub(r.start))),
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		attrs = append(attrs, attribute.String("apitoolkit.upload.error", err.Error()))
+	}
+	r.span.AddEvent(EventUploadComplete, trace.WithAttributes(attrs...))
+}
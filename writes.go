@@ -0,0 +1,83 @@
+package monoscope
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ByteCounter counts the response bytes a middleware's writer delivered,
+// times the writes and records the first write that failed, typically
+// because the client went away. It is safe to read while the handler is
+// still writing.
+type ByteCounter struct {
+	n   atomic.Int64
+	mu  sync.Mutex
+	err error
+	// firstWrite and lastWrite are when the first write started and the
+	// last one ended, in Unix nanoseconds.
+	firstWrite atomic.Int64
+	lastWrite  atomic.Int64
+}
+
+// Begin marks the start of a write of the response body. Writers call it
+// before each write and Record after, so a single long write, such as a
+// file sent with io.Copy, is timed too.
+func (c *ByteCounter) Begin() {
+	if c.firstWrite.Load() == 0 {
+		c.firstWrite.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+// Record records the result of one write of the response body: n bytes
+// delivered and err, if it failed.
+func (c *ByteCounter) Record(n int, err error) {
+	c.n.Add(int64(n))
+	c.lastWrite.Store(time.Now().UnixNano())
+	if err != nil {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Load returns the bytes written so far.
+func (c *ByteCounter) Load() int64 {
+	return c.n.Load()
+}
+
+// Duration returns the time from the start of the first write to the end of
+// the last, 0 before any write completed.
+func (c *ByteCounter) Duration() time.Duration {
+	first, last := c.firstWrite.Load(), c.lastWrite.Load()
+	if first == 0 || last < first {
+		return 0
+	}
+	return time.Duration(last - first)
+}
+
+// Err returns the error of the first failed write, if any.
+func (c *ByteCounter) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// ApplyWriteStatus records on payload the bytes of the response body
+// delivered and how long writing them took, whether or not the body was
+// captured, so the throughput of downloads and streaming endpoints is
+// measurable. When writing failed it also marks payload as aborted with the
+// write error, so the captured body isn't mistaken for what the client
+// received.
+func ApplyWriteStatus(payload *Payload, written *ByteCounter) {
+	payload.ResponseBytesWritten = written.Load()
+	payload.ResponseWriteDuration = written.Duration()
+	err := written.Err()
+	if err == nil {
+		return
+	}
+	payload.ResponseAborted = true
+	payload.Errors = append(payload.Errors, BuildError(err))
+}
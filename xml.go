@@ -0,0 +1,148 @@
+package monoscope
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// IsXMLContent reports whether the Content-Type header describes an XML body,
+// such as text/xml, application/xml or application/soap+xml.
+func IsXMLContent(header map[string][]string) bool {
+	mediaType, _, err := mime.ParseMediaType(http.Header(header).Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// redactBody redacts body with the engine matching its Content-Type: XML
+// bodies go through RedactXML, NDJSON bodies are redacted line by line and
+// everything else goes through RedactJSON. With
+// strict set, a body the redaction rules could not be applied to is replaced
+// with RedactionFailedMarker. Values replaced are counted in audit under
+// target.
+func redactBody(body []byte, header map[string][]string, redactList []string, strict bool, audit redactionAudit, target string) []byte {
+	redact := redactJSON
+	if IsXMLContent(header) {
+		redact = redactXML
+	} else if IsNDJSONContent(header) {
+		redact = redactNDJSON
+	}
+	redacted, err := redact(body, redactList, audit, target)
+	if err != nil && strict {
+		return []byte(RedactionFailedMarker)
+	}
+	return redacted
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// RedactXML replaces the content of elements, and the values of attributes,
+// named in redactList with "[CLIENT_REDACTED]". Entries are matched on their
+// last segment against local names, ignoring namespace prefixes and case, so
+// the JSONPath-style "$.password" used for JSON bodies also redacts
+// <ns:Password>. Documents that fail to parse are dropped rather than
+// captured unredacted.
+func RedactXML(data []byte, redactList []string) []byte {
+	redacted, err := redactXML(data, redactList, nil, "")
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// errUnclosedElement reports an XML document that ends inside an element.
+var errUnclosedElement = errors.New("monoscope: unclosed XML element")
+
+func redactXML(data []byte, redactList []string, audit redactionAudit, target string) ([]byte, error) {
+	if len(redactList) == 0 || len(data) == 0 {
+		return data, nil
+	}
+	// names maps the lowercased local names to redact to their rule.
+	names := make(map[string]string, len(redactList))
+	for _, entry := range redactList {
+		name := strings.TrimRight(entry, "]")
+		if i := strings.LastIndexAny(name, "$./:['"); i >= 0 {
+			name = name[i+1:]
+		}
+		names[strings.ToLower(name)] = entry
+	}
+
+	var out bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth, redactedDepth := 0, 0
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if redactedDepth > 0 {
+				continue
+			}
+			out.WriteString("<" + xmlName(t.Name))
+			for _, attr := range t.Attr {
+				value := attr.Value
+				if rule, ok := names[strings.ToLower(attr.Name.Local)]; ok && attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+					value = "[CLIENT_REDACTED]"
+					audit.fired(target, rule)
+				}
+				out.WriteString(" " + xmlName(attr.Name) + `="` + xmlAttrEscaper.Replace(value) + `"`)
+			}
+			out.WriteString(">")
+			if rule, ok := names[strings.ToLower(t.Name.Local)]; ok {
+				redactedDepth = depth
+				out.WriteString("[CLIENT_REDACTED]")
+				audit.fired(target, rule)
+			}
+		case xml.EndElement:
+			if redactedDepth > 0 && depth > redactedDepth {
+				depth--
+				continue
+			}
+			if depth == redactedDepth {
+				redactedDepth = 0
+			}
+			depth--
+			out.WriteString("</" + xmlName(t.Name) + ">")
+		case xml.CharData:
+			if redactedDepth == 0 {
+				out.WriteString(xmlTextEscaper.Replace(string(t)))
+			}
+		case xml.Comment:
+			if redactedDepth == 0 {
+				out.WriteString("<!--" + string(t) + "-->")
+			}
+		case xml.ProcInst:
+			out.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+		case xml.Directive:
+			out.WriteString("<!" + string(t) + ">")
+		}
+	}
+	if depth != 0 {
+		// RawToken doesn't check that every element is closed.
+		return nil, errUnclosedElement
+	}
+	return out.Bytes(), nil
+}
+
+// xmlName formats a raw token name with its namespace prefix, if any.
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}